@@ -24,6 +24,12 @@ type Agent interface {
 
 type AgentFunc func(ctx context.Context, req *Request, w ResponseWriter) error
 
+// InternalDependent is implemented by the agents which execute other prompts by ExecuteInternal, e.g. refine.
+// The internal dependencies can not be executed directly, and are drawn by ToMarkdown.
+type InternalDependent interface {
+	InternalDependencies() []string
+}
+
 func (f AgentFunc) Execute(ctx context.Context, req *Request, w ResponseWriter) error {
 	return f(ctx, req, w)
 }
@@ -34,6 +40,7 @@ type AgentMux struct {
 	agents            map[string]Agent
 	dependents        map[string][]string
 	toolsDepenedents  map[string][]string
+	internalDeps      map[string][]string
 	remoteTools       map[string]*RemoteTool
 	remoteToolConfigs map[string]RemoteToolConfig
 	remoteToolMu      sync.Mutex
@@ -160,15 +167,25 @@ func NewAgentMux(ctx context.Context, optFns ...NewAgentMuxOption) (*AgentMux, e
 		return nil, err
 	}
 	toolsDepenedents := make(map[string][]string, len(dependents))
+	internalDeps := make(map[string][]string)
 	remoteToolConfigs := make(map[string]RemoteToolConfig, 0)
 	agents := make(map[string]Agent, len(prompts))
 	var defaultAgent string
+	agentCtx := withRemoteToolConfigContext(ctx, o.baseRmoteToolConfig)
 	for name, p := range prompts {
-		agent, err := reg.NewAgent(ctx, p)
+		agent, err := reg.NewAgent(agentCtx, p)
 		if err != nil {
 			return nil, fmt.Errorf("prompt `%s`: %w", name, err)
 		}
 		agents[name] = agent
+		if dependent, ok := agent.(InternalDependent); ok {
+			for _, dep := range dependent.InternalDependencies() {
+				if _, ok := prompts[dep]; !ok {
+					return nil, fmt.Errorf("prompt `%s`: internal dependency `%s` not found", name, dep)
+				}
+				internalDeps[name] = append(internalDeps[name], dep)
+			}
+		}
 		cfg := p.Config()
 		for _, tool := range cfg.Tools {
 			if u, err := url.Parse(tool); err == nil && slices.Contains([]string{"http", "https"}, u.Scheme) {
@@ -210,6 +227,7 @@ func NewAgentMux(ctx context.Context, optFns ...NewAgentMuxOption) (*AgentMux, e
 		dependents:        dependents,
 		logger:            o.logger,
 		toolsDepenedents:  toolsDepenedents,
+		internalDeps:      internalDeps,
 		externalTools:     o.externalTools,
 		remoteToolConfigs: remoteToolConfigs,
 		remoteTools:       make(map[string]*RemoteTool),
@@ -235,6 +253,9 @@ func (mux *AgentMux) validateImpl() error {
 	for name, tools := range mux.toolsDepenedents {
 		merged[name] = append(merged[name], tools...)
 	}
+	for name, deps := range mux.internalDeps {
+		merged[name] = append(merged[name], deps...)
+	}
 	for name, deps := range merged {
 		slices.Sort(deps)
 		merged[name] = slices.Compact(deps)
@@ -290,6 +311,9 @@ func (mux *AgentMux) ToMarkdown() string {
 		for _, tool := range mux.toolsDepenedents[node] {
 			sb.WriteString(fmt.Sprintf("    %s -.->|tool_call| %s\n", nodesAlias[node], nodesAlias[tool]))
 		}
+		for _, dep := range mux.internalDeps[node] {
+			sb.WriteString(fmt.Sprintf("    %s -.->|internal| %s\n", nodesAlias[node], nodesAlias[dep]))
+		}
 	}
 	sb.WriteString("```\n")
 	if len(remoteTools) == 0 {
@@ -309,6 +333,9 @@ func (mux *AgentMux) Execute(ctx context.Context, req *Request, w ResponseWriter
 	if _, err := mux.validateRequest(req); err != nil {
 		return err
 	}
+	if owner, ok := mux.internalOwner(req.Name); ok {
+		return fmt.Errorf("agent `%s` is an internal dependency of `%s`", req.Name, owner)
+	}
 	graph, ok := pickupDAG(req.Name, mux.dependents)
 	if !ok {
 		return fmt.Errorf("agent `%s` not found", req.Name)
@@ -411,6 +438,7 @@ func (mux *AgentMux) executeOne(ctx context.Context, cfg *Config, req *Request,
 	}
 	w.Metadata().MergeInPlace(cfg.ResponseMetadata)
 	mux.logger.DebugContext(ctx, "execute node", "node", node, "metadata", w.Metadata())
+	ctx = context.WithValue(ctx, agentMuxContextKey, mux)
 	if slices.Contains(sinkNodes, node) {
 		if err := agent.Execute(ctx, req, w); err != nil {
 			return nil, fmt.Errorf("execute `%s`: %w", node, err)
@@ -429,6 +457,46 @@ func (mux *AgentMux) executeOne(ctx context.Context, cfg *Config, req *Request,
 	return resp, nil
 }
 
+func (mux *AgentMux) internalOwner(name string) (string, bool) {
+	for _, owner := range slices.Sorted(maps.Keys(mux.internalDeps)) {
+		if slices.Contains(mux.internalDeps[owner], name) {
+			return owner, true
+		}
+	}
+	return "", false
+}
+
+var agentMuxContextKey = contextKey("agent_mux")
+
+// ExecuteInternal executes the internal dependency req.Name alone by the AgentMux which executes the calling agent,
+// with the middlewares, the request metadata and the tools of the prompt, and writes the result to w.
+func ExecuteInternal(ctx context.Context, req *Request, w ResponseWriter) error {
+	mux, ok := ctx.Value(agentMuxContextKey).(*AgentMux)
+	if !ok {
+		return fmt.Errorf("agent `%s`: internal dependency must be executed in AgentMux", req.Name)
+	}
+	if _, ok := mux.internalOwner(req.Name); !ok {
+		return fmt.Errorf("agent `%s` is not an internal dependency", req.Name)
+	}
+	cfg := mux.prompts[req.Name].Config()
+	agent := mux.agents[req.Name]
+	if !*cfg.Enabled {
+		return fmt.Errorf("prompt `%s` is disabled", req.Name)
+	}
+	for _, mw := range mux.middleware {
+		agent = mw(agent)
+	}
+	w.Metadata().MergeInPlace(cfg.ResponseMetadata)
+	mux.logger.DebugContext(ctx, "execute internal node", "node", req.Name)
+	previousResults := req.PreviousResults
+	req = mux.refineRequest(cfg, req)
+	req.PreviousResults = previousResults
+	if err := agent.Execute(ctx, req, w); err != nil {
+		return fmt.Errorf("execute `%s`: %w", req.Name, err)
+	}
+	return nil
+}
+
 func (mux *AgentMux) refineRequest(cfg *Config, req *Request) *Request {
 	if req == nil {
 		return nil
@@ -560,6 +628,9 @@ func (mux *AgentMux) Published() map[string]*Config {
 		if !cfg.Publish {
 			continue
 		}
+		if _, ok := mux.internalOwner(name); ok {
+			continue
+		}
 		cfgs[name] = cfg
 	}
 	return cfgs
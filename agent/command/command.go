@@ -0,0 +1,268 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"mime"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mashiike/estellm"
+)
+
+const (
+	AgentName = "command"
+)
+
+func init() {
+	err := estellm.RegisterAgent(AgentName, NewAgent)
+	if err != nil {
+		panic(fmt.Sprintf("failed to register agent %s: %v", AgentName, err))
+	}
+	err = estellm.SetAgentMarmaidNodeWrapper(AgentName, func(s string) string {
+		return fmt.Sprintf("[[%s]]", s)
+	})
+	if err != nil {
+		panic(fmt.Sprintf("failed to set marmaid node wrapper for agent %s: %v", AgentName, err))
+	}
+}
+
+const (
+	StdinPrompt  = "prompt"
+	StdinPayload = "payload"
+	StdinNone    = "none"
+
+	OutputAuto   = "auto"
+	OutputText   = "text"
+	OutputBinary = "binary"
+
+	// ArgsBlockName is the template block whose rendered lines are appended to args.
+	ArgsBlockName = "args"
+)
+
+type Config struct {
+	Command string            `json:"command"`
+	Args    []string          `json:"args"`
+	Stdin   string            `json:"stdin"`
+	Output  string            `json:"output"`
+	Timeout string            `json:"timeout"`
+	Env     map[string]string `json:"env"`
+	Dir     string            `json:"dir"`
+}
+
+var (
+	ErrCommandNotAllowed = errors.New("command not allowed")
+	ErrEnvNotAllowed     = errors.New("env not allowed")
+)
+
+// isEnvAllowed reports whether the env key can be set by the prompt.
+// PATH and the dynamic loader variables are rejected, because they change what the allowed command runs.
+func isEnvAllowed(key string) bool {
+	key = strings.ToUpper(key)
+	return key != "PATH" && !strings.HasPrefix(key, "LD_") && !strings.HasPrefix(key, "DYLD_")
+}
+
+// isAllowed reports whether the command run in dir is in the allowlist.
+// A command with a path is resolved against dir as exec does, and compared with the entries resolved against the root.
+func isAllowed(allowlist estellm.CommandAllowlist, dir, command string) bool {
+	if !hasPath(command) {
+		for _, entry := range allowlist.Commands {
+			if !hasPath(entry) && entry == command {
+				return true
+			}
+		}
+		return false
+	}
+	path, err := resolvePath(dir, command)
+	if err != nil {
+		return false
+	}
+	for _, entry := range allowlist.Commands {
+		if !hasPath(entry) {
+			continue
+		}
+		allowed, err := resolvePath(allowlist.Root, entry)
+		if err != nil {
+			continue
+		}
+		if allowed == path {
+			return true
+		}
+	}
+	return false
+}
+
+func hasPath(command string) bool {
+	return strings.ContainsRune(command, filepath.Separator) || strings.ContainsRune(command, '/')
+}
+
+// resolvePath returns the absolute path of path relative to base, the current directory if base is empty.
+func resolvePath(base, path string) (string, error) {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(base, path)
+	}
+	return filepath.Abs(path)
+}
+
+const defaultTimeout = 60 * time.Second
+
+type Agent struct {
+	p       *estellm.Prompt
+	cfg     *Config
+	path    string
+	timeout time.Duration
+}
+
+func NewAgent(ctx context.Context, p *estellm.Prompt) (estellm.Agent, error) {
+	var cfg Config
+	if err := p.Config().Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("decode `command` agent config: %w", err)
+	}
+	if cfg.Command == "" {
+		return nil, fmt.Errorf("command is required")
+	}
+	allowlist, _ := estellm.CommandAllowlistFromContext(ctx)
+	if cfg.Dir != "" && !filepath.IsAbs(cfg.Dir) {
+		cfg.Dir = filepath.Join(allowlist.Root, cfg.Dir)
+	}
+	if !isAllowed(allowlist, cfg.Dir, cfg.Command) {
+		return nil, fmt.Errorf("command `%s`: %w, add it to the commands allowlist of the project config", cfg.Command, ErrCommandNotAllowed)
+	}
+	for _, key := range slices.Sorted(maps.Keys(cfg.Env)) {
+		if !isEnvAllowed(key) {
+			return nil, fmt.Errorf("env `%s`: %w", key, ErrEnvNotAllowed)
+		}
+	}
+	switch cfg.Stdin {
+	case "":
+		cfg.Stdin = StdinPrompt
+	case StdinPrompt, StdinPayload, StdinNone:
+	default:
+		return nil, fmt.Errorf("invalid stdin `%s`", cfg.Stdin)
+	}
+	switch cfg.Output {
+	case "":
+		cfg.Output = OutputAuto
+	case OutputAuto, OutputText, OutputBinary:
+	default:
+		return nil, fmt.Errorf("invalid output `%s`", cfg.Output)
+	}
+	a := &Agent{
+		p:       p,
+		cfg:     &cfg,
+		timeout: defaultTimeout,
+	}
+	if cfg.Timeout != "" {
+		d, err := time.ParseDuration(cfg.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("parse timeout: %w", err)
+		}
+		a.timeout = d
+	}
+	path := cfg.Command
+	if hasPath(path) {
+		// the resolved path is run, so that exec does not resolve it again against dir.
+		var err error
+		path, err = resolvePath(cfg.Dir, path)
+		if err != nil {
+			return nil, fmt.Errorf("command `%s`: %w", cfg.Command, err)
+		}
+	}
+	path, err := exec.LookPath(path)
+	if err != nil {
+		return nil, fmt.Errorf("command `%s`: %w", cfg.Command, err)
+	}
+	a.path = path
+	return a, nil
+}
+
+func (a *Agent) Execute(ctx context.Context, req *estellm.Request, w estellm.ResponseWriter) error {
+	args := slices.Clone(a.cfg.Args)
+	if slices.Contains(a.p.Blocks(), ArgsBlockName) {
+		rendered, err := a.p.RenderBlock(ctx, ArgsBlockName, req)
+		if err != nil {
+			return fmt.Errorf("render args: %w", err)
+		}
+		for _, line := range strings.Split(rendered, "\n") {
+			if arg := strings.TrimSpace(line); arg != "" {
+				args = append(args, arg)
+			}
+		}
+	}
+	var stdin io.Reader
+	switch a.cfg.Stdin {
+	case StdinPrompt:
+		rendered, err := a.p.Render(ctx, req)
+		if err != nil {
+			return fmt.Errorf("render prompt: %w", err)
+		}
+		stdin = strings.NewReader(rendered)
+	case StdinPayload:
+		bs, err := json.Marshal(req.Payload)
+		if err != nil {
+			return fmt.Errorf("marshal payload: %w", err)
+		}
+		stdin = bytes.NewReader(bs)
+	}
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, a.path, args...)
+	cmd.Stdin = stdin
+	cmd.Dir = a.cfg.Dir
+	if len(a.cfg.Env) > 0 {
+		cmd.Env = os.Environ()
+		for k, v := range a.cfg.Env {
+			cmd.Env = append(cmd.Env, k+"="+v)
+		}
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	m := w.Metadata()
+	if cmd.ProcessState != nil {
+		m.SetInt64("Command-Exit-Code", int64(cmd.ProcessState.ExitCode()))
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("command `%s`: %w", a.cfg.Command, ctx.Err())
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("command `%s`: %w: %s", a.cfg.Command, err, msg)
+		}
+		return fmt.Errorf("command `%s`: %w", a.cfg.Command, err)
+	}
+	w.WriteRole(estellm.RoleAssistant)
+	if stdout.Len() > 0 {
+		if err := w.WritePart(a.outputPart(stdout.Bytes())); err != nil {
+			return fmt.Errorf("write part: %w", err)
+		}
+	}
+	w.Finish(estellm.FinishReasonEndTurn, "exit 0")
+	return nil
+}
+
+func (a *Agent) outputPart(bs []byte) estellm.ContentPart {
+	switch a.cfg.Output {
+	case OutputText:
+		return estellm.TextPart(string(bs))
+	}
+	mediaType, _, err := mime.ParseMediaType(http.DetectContentType(bs))
+	if err != nil {
+		mediaType = "application/octet-stream"
+	}
+	if a.cfg.Output == OutputAuto && strings.HasPrefix(mediaType, "text/") {
+		return estellm.TextPart(string(bs))
+	}
+	return estellm.BinaryPart(mediaType, bs)
+}
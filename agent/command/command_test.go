@@ -0,0 +1,194 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mashiike/estellm"
+	"github.com/mashiike/estellm/estellmtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsAllowed(t *testing.T) {
+	tests := []struct {
+		name      string
+		allowlist estellm.CommandAllowlist
+		dir       string
+		command   string
+		expected  bool
+	}{
+		{
+			name:      "empty allowlist",
+			allowlist: estellm.CommandAllowlist{},
+			command:   "jq",
+			expected:  false,
+		},
+		{
+			name:      "same name",
+			allowlist: estellm.CommandAllowlist{Commands: []string{"jq"}},
+			command:   "jq",
+			expected:  true,
+		},
+		{
+			name:      "other name",
+			allowlist: estellm.CommandAllowlist{Commands: []string{"jq"}},
+			command:   "sh",
+			expected:  false,
+		},
+		{
+			name:      "name does not match a path",
+			allowlist: estellm.CommandAllowlist{Commands: []string{"jq"}},
+			command:   "./jq",
+			expected:  false,
+		},
+		{
+			name:      "path does not match a name",
+			allowlist: estellm.CommandAllowlist{Commands: []string{"/usr/bin/jq"}},
+			command:   "jq",
+			expected:  false,
+		},
+		{
+			name:      "same absolute path",
+			allowlist: estellm.CommandAllowlist{Commands: []string{"/usr/local/bin/ruff"}},
+			command:   "/usr/local/bin/ruff",
+			expected:  true,
+		},
+		{
+			name:      "absolute path is not resolved against dir",
+			allowlist: estellm.CommandAllowlist{Commands: []string{"/usr/local/bin/ruff"}},
+			dir:       "/tmp",
+			command:   "/usr/local/bin/ruff",
+			expected:  true,
+		},
+		{
+			name:      "relative entry is resolved against root",
+			allowlist: estellm.CommandAllowlist{Root: "/project", Commands: []string{"./bin/tool"}},
+			dir:       "/project",
+			command:   "./bin/tool",
+			expected:  true,
+		},
+		{
+			name:      "relative command is resolved against dir",
+			allowlist: estellm.CommandAllowlist{Root: "/project", Commands: []string{"bin/tool"}},
+			dir:       "/project/sub",
+			command:   "../bin/tool",
+			expected:  true,
+		},
+		{
+			name:      "same relative path in other dir",
+			allowlist: estellm.CommandAllowlist{Root: "/project", Commands: []string{"./bin/tool"}},
+			dir:       "/tmp/evil",
+			command:   "./bin/tool",
+			expected:  false,
+		},
+		{
+			name:      "relative entry matches absolute command",
+			allowlist: estellm.CommandAllowlist{Root: "/project", Commands: []string{"./bin/tool"}},
+			dir:       "/tmp",
+			command:   "/project/bin/tool",
+			expected:  true,
+		},
+		{
+			name:      "escape from dir",
+			allowlist: estellm.CommandAllowlist{Root: "/project", Commands: []string{"./bin/tool"}},
+			dir:       "/project",
+			command:   "./bin/../../bin/tool",
+			expected:  false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.expected, isAllowed(tt.allowlist, tt.dir, tt.command))
+		})
+	}
+}
+
+func newAgentMux(t *testing.T, root string, cfg string) (context.Context, *estellm.AgentMux, error) {
+	t.Helper()
+	ctx := estellm.WithCommandAllowlist(context.Background(), estellm.CommandAllowlist{
+		Root:     root,
+		Commands: []string{"sh", "bin/png.sh"},
+	})
+	mux, err := estellm.NewAgentMux(ctx,
+		estellm.WithPromptsFS(estellmtest.MapFS(map[string]string{
+			"run.md": fmt.Sprintf(`{{ define "config" }}
+{
+  type: "command",
+  %s
+}
+{{ end }}`, cfg),
+		})),
+		estellm.WithIncludesFS(estellmtest.MapFS(nil)),
+	)
+	return ctx, mux, err
+}
+
+func execute(t *testing.T, root string, cfg string) (*estellmtest.ResponseRecorder, error) {
+	t.Helper()
+	ctx, mux, err := newAgentMux(t, root, cfg)
+	require.NoError(t, err)
+	req, err := estellm.NewRequest("run", map[string]any{"name": "cat"})
+	require.NoError(t, err)
+	w := estellmtest.NewResponseRecorder()
+	return w, mux.Execute(ctx, req, w)
+}
+
+func TestExecute__Stdout(t *testing.T) {
+	w, err := execute(t, t.TempDir(), `command: "sh", args: ["-c", "cat"], stdin: "payload",`)
+	require.NoError(t, err)
+	require.Equal(t, `{"name":"cat"}`, w.Text())
+	require.True(t, w.Finished())
+	code, _ := w.Metadata().GetInt64("Command-Exit-Code")
+	require.EqualValues(t, 0, code)
+}
+
+func TestExecute__BinaryOutput(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(root, "bin"), 0o755))
+	script := "#!/bin/sh\nprintf '\\211PNG\\r\\n\\032\\n'\n"
+	require.NoError(t, os.WriteFile(filepath.Join(root, "bin", "png.sh"), []byte(script), 0o755))
+	w, err := execute(t, root, `command: "./png.sh", dir: "bin", stdin: "none",`)
+	require.NoError(t, err)
+	require.Equal(t, []estellm.ContentPart{
+		estellm.BinaryPart("image/png", []byte("\x89PNG\r\n\x1a\n")),
+	}, w.Parts())
+}
+
+func TestExecute__Dir(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(root, "work"), 0o755))
+	w, err := execute(t, root, `command: "sh", args: ["-c", "pwd"], dir: "work", stdin: "none",`)
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(root, "work")+"\n", w.Text())
+}
+
+func TestExecute__NonZeroExit(t *testing.T) {
+	w, err := execute(t, t.TempDir(), `command: "sh", args: ["-c", "echo oops >&2; exit 3"], stdin: "none",`)
+	require.EqualError(t, err, "execute `run`: command `sh`: exit status 3: oops")
+	code, _ := w.Metadata().GetInt64("Command-Exit-Code")
+	require.EqualValues(t, 3, code)
+}
+
+func TestExecute__Timeout(t *testing.T) {
+	start := time.Now()
+	_, err := execute(t, t.TempDir(), `command: "sh", args: ["-c", "exec sleep 10"], stdin: "none", timeout: "100ms",`)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestExecute__Env(t *testing.T) {
+	w, err := execute(t, t.TempDir(), `command: "sh", args: ["-c", "echo $GREETING"], stdin: "none", env: { GREETING: "hello" },`)
+	require.NoError(t, err)
+	require.Equal(t, "hello\n", w.Text())
+
+	for _, key := range []string{"PATH", "LD_PRELOAD", "LD_LIBRARY_PATH", "DYLD_INSERT_LIBRARIES", "ld_preload"} {
+		t.Run(key, func(t *testing.T) {
+			_, _, err := newAgentMux(t, t.TempDir(), fmt.Sprintf(`command: "sh", env: { %s: "/tmp/evil" },`, key))
+			require.ErrorIs(t, err, ErrEnvNotAllowed)
+		})
+	}
+}
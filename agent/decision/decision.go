@@ -7,6 +7,7 @@ import (
 	"text/template"
 
 	"github.com/mashiike/estellm"
+	"github.com/mashiike/estellm/agent/ensemble"
 	"github.com/mashiike/estellm/jsonutil"
 )
 
@@ -36,17 +37,19 @@ func init() {
 }
 
 type Config struct {
-	ModelProvider      string         `json:"model_provider"`
-	ModelID            string         `json:"model_id"`
-	ModelParams        map[string]any `json:"model_params"`
-	FallbackAgent      string         `json:"fallback_agent"`
-	FallbackThreshould *float64       `json:"fallback_threshould"`
+	ModelProvider      string           `json:"model_provider"`
+	ModelID            string           `json:"model_id"`
+	ModelParams        map[string]any   `json:"model_params"`
+	FallbackAgent      string           `json:"fallback_agent"`
+	FallbackThreshould *float64         `json:"fallback_threshould"`
+	Ensemble           *ensemble.Config `json:"ensemble"`
 }
 
 type Agent struct {
 	p             *estellm.Prompt
 	cfg           *Config
 	modelProvider estellm.ModelProvider
+	ensemble      *ensemble.Ensemble
 }
 
 type Output struct {
@@ -70,11 +73,26 @@ func NewAgent(ctx context.Context, p *estellm.Prompt) (estellm.Agent, error) {
 	if err != nil {
 		return nil, fmt.Errorf("model_provider `%s`: %w", cfg.ModelProvider, err)
 	}
-	return &Agent{
+	a := &Agent{
 		p:             p,
 		cfg:           &cfg,
 		modelProvider: modelProvider,
-	}, nil
+	}
+	if cfg.Ensemble != nil {
+		ensembleCfg := *cfg.Ensemble
+		if ensembleCfg.Field == "" {
+			ensembleCfg.Field = "next_agent"
+		}
+		a.ensemble, err = ensemble.New(ctx, ensembleCfg, ensemble.Model{
+			ModelProvider: cfg.ModelProvider,
+			ModelID:       cfg.ModelID,
+			ModelParams:   cfg.ModelParams,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("ensemble: %w", err)
+		}
+	}
+	return a, nil
 }
 
 func (a *Agent) Execute(ctx context.Context, req *estellm.Request, w estellm.ResponseWriter) error {
@@ -90,11 +108,21 @@ func (a *Agent) Execute(ctx context.Context, req *estellm.Request, w estellm.Res
 		Tools:       req.Tools,
 		Metadata:    req.Metadata,
 	}
-	batch := estellm.NewBatchResponseWriter()
-	if err := a.modelProvider.GenerateText(ctx, modelReq, batch); err != nil {
-		return fmt.Errorf("generate text: %w", err)
+	var resp *estellm.Response
+	if a.ensemble != nil {
+		result, err := a.ensemble.Generate(ctx, modelReq)
+		if err != nil {
+			return fmt.Errorf("generate ensemble: %w", err)
+		}
+		result.SetMetadata(w)
+		resp = result.Response
+	} else {
+		batch := estellm.NewBatchResponseWriter()
+		if err := a.modelProvider.GenerateText(ctx, modelReq, batch); err != nil {
+			return fmt.Errorf("generate text: %w", err)
+		}
+		resp = batch.Response()
 	}
-	resp := batch.Response()
 	var output Output
 	if err := jsonutil.UnmarshalFirstJSON([]byte(resp.String()), &output); err != nil {
 		return fmt.Errorf("extruct output: %w", err)
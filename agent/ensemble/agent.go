@@ -0,0 +1,78 @@
+package ensemble
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mashiike/estellm"
+)
+
+const (
+	AgentName = "ensemble"
+)
+
+func init() {
+	err := estellm.RegisterAgent(AgentName, NewAgent)
+	if err != nil {
+		panic(fmt.Sprintf("failed to register agent %s: %v", AgentName, err))
+	}
+}
+
+type AgentConfig struct {
+	Model
+	Config
+}
+
+type Agent struct {
+	p   *estellm.Prompt
+	e   *Ensemble
+	cfg *AgentConfig
+}
+
+func NewAgent(ctx context.Context, p *estellm.Prompt) (estellm.Agent, error) {
+	var cfg AgentConfig
+	if err := p.Config().Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("decode `ensemble` agent config: %w", err)
+	}
+	if len(cfg.Models) == 0 {
+		if cfg.ModelProvider == "" {
+			return nil, fmt.Errorf("model_provider is required")
+		}
+		if cfg.ModelID == "" {
+			return nil, fmt.Errorf("model_id is required")
+		}
+	}
+	e, err := New(ctx, cfg.Config, cfg.Model)
+	if err != nil {
+		return nil, err
+	}
+	return &Agent{
+		p:   p,
+		e:   e,
+		cfg: &cfg,
+	}, nil
+}
+
+func (a *Agent) Execute(ctx context.Context, req *estellm.Request, w estellm.ResponseWriter) error {
+	system, msgs, err := a.p.Decode(ctx, req)
+	if err != nil {
+		return fmt.Errorf("decode prompt: %w", err)
+	}
+	modelReq := &estellm.GenerateTextRequest{
+		System:   system,
+		Messages: msgs,
+		Tools:    req.Tools,
+		Metadata: req.Metadata,
+	}
+	result, err := a.e.Generate(ctx, modelReq)
+	if err != nil {
+		return fmt.Errorf("generate ensemble: %w", err)
+	}
+	result.SetMetadata(w)
+	w.WriteRole(estellm.RoleAssistant)
+	if err := w.WritePart(result.Response.Message.Parts...); err != nil {
+		return fmt.Errorf("write part: %w", err)
+	}
+	w.Finish(result.Response.FinishReason, result.Response.FinishMessage)
+	return nil
+}
@@ -0,0 +1,234 @@
+package ensemble
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"strings"
+	"sync"
+
+	"github.com/mashiike/estellm"
+	"github.com/mashiike/estellm/jsonutil"
+)
+
+const (
+	TieBreakFirst  = "first"
+	TieBreakRandom = "random"
+	TieBreakFail   = "fail"
+
+	DefaultSamples = 5
+)
+
+var ErrTie = errors.New("ensemble votes are tied")
+
+type Model struct {
+	ModelProvider string         `json:"model_provider"`
+	ModelID       string         `json:"model_id"`
+	ModelParams   map[string]any `json:"model_params"`
+}
+
+// Config is the sampling and voting configuration.
+// Samples are assigned to Models in round-robin order.
+type Config struct {
+	Samples  int     `json:"samples"`
+	Models   []Model `json:"models"`
+	Field    string  `json:"field"`
+	TieBreak string  `json:"tie_break"`
+}
+
+type Ensemble struct {
+	cfg       Config
+	providers []estellm.ModelProvider
+}
+
+// New returns an Ensemble. If cfg.Models is empty, the model is used for all samples.
+func New(ctx context.Context, cfg Config, model Model) (*Ensemble, error) {
+	if cfg.Samples == 0 {
+		cfg.Samples = DefaultSamples
+	}
+	if cfg.Samples < 1 {
+		return nil, fmt.Errorf("samples must be positive")
+	}
+	switch cfg.TieBreak {
+	case "":
+		cfg.TieBreak = TieBreakFirst
+	case TieBreakFirst, TieBreakRandom, TieBreakFail:
+	default:
+		return nil, fmt.Errorf("invalid tie_break `%s`", cfg.TieBreak)
+	}
+	if len(cfg.Models) == 0 {
+		cfg.Models = []Model{model}
+	}
+	providers := make([]estellm.ModelProvider, len(cfg.Models))
+	for i, m := range cfg.Models {
+		if m.ModelProvider == "" {
+			m.ModelProvider = model.ModelProvider
+		}
+		if m.ModelProvider == "" {
+			return nil, fmt.Errorf("models[%d]: model_provider is required", i)
+		}
+		if m.ModelID == "" {
+			return nil, fmt.Errorf("models[%d]: model_id is required", i)
+		}
+		if m.ModelParams == nil {
+			m.ModelParams = model.ModelParams
+		}
+		cfg.Models[i] = m
+		p, err := estellm.GetModelProvider(ctx, m.ModelProvider)
+		if err != nil {
+			return nil, fmt.Errorf("models[%d]: model_provider `%s`: %w", i, m.ModelProvider, err)
+		}
+		providers[i] = p
+	}
+	return &Ensemble{
+		cfg:       cfg,
+		providers: providers,
+	}, nil
+}
+
+type Result struct {
+	// Answer is the normalized majority answer.
+	Answer string
+	// Votes is the number of samples per answer.
+	Votes map[string]int
+	// Response is the first sample response with the majority answer.
+	Response *estellm.Response
+	Samples  int
+	Errors   int
+}
+
+func (r *Result) Agreement() float64 {
+	valid := r.Samples - r.Errors
+	if valid == 0 {
+		return 0
+	}
+	return float64(r.Votes[r.Answer]) / float64(valid)
+}
+
+// SetMetadata sets the voting result to the response writer metadata.
+func (r *Result) SetMetadata(w estellm.ResponseWriter) {
+	m := w.Metadata()
+	m.SetString("Ensemble-Answer", r.Answer)
+	if bs, err := json.Marshal(r.Votes); err == nil {
+		m.SetString("Ensemble-Votes", string(bs))
+	}
+	m.SetInt64("Ensemble-Samples", int64(r.Samples))
+	m.SetInt64("Ensemble-Errors", int64(r.Errors))
+	m.SetFloat64("Ensemble-Agreement", r.Agreement())
+}
+
+type sample struct {
+	resp   *estellm.Response
+	answer string
+	err    error
+}
+
+func (e *Ensemble) Generate(ctx context.Context, req *estellm.GenerateTextRequest) (*Result, error) {
+	samples := make([]sample, e.cfg.Samples)
+	var wg sync.WaitGroup
+	for i := range samples {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			idx := i % len(e.cfg.Models)
+			m := e.cfg.Models[idx]
+			sampleReq := *req
+			sampleReq.ModelID = m.ModelID
+			sampleReq.ModelParams = m.ModelParams
+			sampleReq.Metadata = req.Metadata.Clone()
+			batch := estellm.NewBatchResponseWriter()
+			if err := e.providers[idx].GenerateText(ctx, &sampleReq, batch); err != nil {
+				samples[i].err = fmt.Errorf("sample[%d]: %w", i, err)
+				return
+			}
+			resp := batch.Response()
+			answer, err := e.extract(resp)
+			if err != nil {
+				samples[i].err = fmt.Errorf("sample[%d]: %w", i, err)
+				return
+			}
+			samples[i].resp = resp
+			samples[i].answer = answer
+		}()
+	}
+	wg.Wait()
+	result := &Result{
+		Votes:   make(map[string]int),
+		Samples: len(samples),
+	}
+	var errs []error
+	order := make([]string, 0, len(samples))
+	first := make(map[string]*estellm.Response)
+	for _, s := range samples {
+		if s.err != nil {
+			slog.WarnContext(ctx, "ensemble sample failed", "error", s.err)
+			errs = append(errs, s.err)
+			result.Errors++
+			continue
+		}
+		if _, ok := first[s.answer]; !ok {
+			first[s.answer] = s.resp
+			order = append(order, s.answer)
+		}
+		result.Votes[s.answer]++
+	}
+	if len(order) == 0 {
+		return nil, fmt.Errorf("all samples failed: %w", errors.Join(errs...))
+	}
+	var tied []string
+	for _, answer := range order {
+		switch {
+		case len(tied) == 0 || result.Votes[answer] > result.Votes[tied[0]]:
+			tied = []string{answer}
+		case result.Votes[answer] == result.Votes[tied[0]]:
+			tied = append(tied, answer)
+		}
+	}
+	result.Answer = tied[0]
+	if len(tied) > 1 {
+		switch e.cfg.TieBreak {
+		case TieBreakRandom:
+			result.Answer = tied[rand.IntN(len(tied))]
+		case TieBreakFail:
+			return nil, fmt.Errorf("%w: %s", ErrTie, strings.Join(tied, ", "))
+		}
+	}
+	result.Response = first[result.Answer]
+	return result, nil
+}
+
+func (e *Ensemble) extract(resp *estellm.Response) (string, error) {
+	var sb strings.Builder
+	for _, part := range resp.Message.Parts {
+		if part.Type == estellm.PartTypeText {
+			sb.WriteString(part.Text)
+		}
+	}
+	text := sb.String()
+	if e.cfg.Field == "" {
+		return normalize(text), nil
+	}
+	var v any
+	if err := jsonutil.UnmarshalFirstJSON([]byte(text), &v); err != nil {
+		return "", fmt.Errorf("extract json: %w", err)
+	}
+	value, err := lookup(v, e.cfg.Field)
+	if err != nil {
+		return "", err
+	}
+	if s, ok := value.(string); ok {
+		return normalize(s), nil
+	}
+	bs, err := json.Marshal(value)
+	if err != nil {
+		return "", fmt.Errorf("marshal field value: %w", err)
+	}
+	return string(bs), nil
+}
+
+func normalize(s string) string {
+	return strings.ToLower(strings.Join(strings.Fields(s), " "))
+}
@@ -0,0 +1,110 @@
+package ensemble_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/mashiike/estellm"
+	"github.com/mashiike/estellm/agent/ensemble"
+	"github.com/mashiike/estellm/estellmtest"
+	"github.com/stretchr/testify/require"
+)
+
+// generate samples once per model, so that the answer of each sample index is fixed.
+func generate(t *testing.T, cfg ensemble.Config, answers ...estellmtest.Turn) (*ensemble.Result, error) {
+	t.Helper()
+	provider := estellmtest.NewModelProvider()
+	cfg.Samples = len(answers)
+	cfg.Models = nil
+	for i, answer := range answers {
+		modelID := string(rune('a' + i))
+		provider.Enqueue(modelID, answer)
+		cfg.Models = append(cfg.Models, ensemble.Model{ModelID: modelID})
+	}
+	ctx := estellmtest.WithModelProvider(context.Background(), "fake", provider)
+	e, err := ensemble.New(ctx, cfg, ensemble.Model{ModelProvider: "fake"})
+	require.NoError(t, err)
+	result, err := e.Generate(ctx, &estellm.GenerateTextRequest{})
+	estellmtest.RequireAllServed(t, provider)
+	return result, err
+}
+
+func TestEnsemble__Majority(t *testing.T) {
+	result, err := generate(t, ensemble.Config{},
+		estellmtest.TextTurn("Negative"),
+		estellmtest.TextTurn("  Positive\n"),
+		estellmtest.TextTurn("POSITIVE"),
+		estellmtest.TextTurn("neutral"),
+		estellmtest.ErrorTurn(errors.New("boom")),
+	)
+	require.NoError(t, err)
+	require.Equal(t, "positive", result.Answer)
+	require.Equal(t, map[string]int{"negative": 1, "positive": 2, "neutral": 1}, result.Votes)
+	require.Equal(t, []estellm.ContentPart{estellm.TextPart("  Positive\n")}, result.Response.Message.Parts, "the first sample with the answer")
+	require.Equal(t, 5, result.Samples)
+	require.Equal(t, 1, result.Errors)
+	require.Equal(t, 0.5, result.Agreement())
+}
+
+func TestEnsemble__Field(t *testing.T) {
+	result, err := generate(t, ensemble.Config{Field: "$.result.label"},
+		estellmtest.TextTurn(`{"result": {"label": "Very  Good", "score": 1}}`),
+		estellmtest.TextTurn(`Sure: {"result": {"label": "very good"}}`),
+		estellmtest.TextTurn(`{"result": {"label": "bad"}}`),
+		estellmtest.TextTurn(`{"result": {}}`),
+	)
+	require.NoError(t, err)
+	require.Equal(t, "very good", result.Answer)
+	require.Equal(t, map[string]int{"very good": 2, "bad": 1}, result.Votes)
+	require.Equal(t, 1, result.Errors, "the field is missing")
+
+	result, err = generate(t, ensemble.Config{Field: "score"},
+		estellmtest.TextTurn(`{"score": 1}`),
+		estellmtest.TextTurn(`{"score": 2}`),
+		estellmtest.TextTurn(`{"score": 2}`),
+	)
+	require.NoError(t, err)
+	require.Equal(t, "2", result.Answer, "non-string values are compared as JSON")
+}
+
+func TestEnsemble__TieBreak(t *testing.T) {
+	answers := []estellmtest.Turn{
+		estellmtest.TextTurn("b"),
+		estellmtest.TextTurn("a"),
+		estellmtest.TextTurn("a"),
+		estellmtest.TextTurn("c"),
+		estellmtest.TextTurn("b"),
+	}
+	result, err := generate(t, ensemble.Config{}, answers...)
+	require.NoError(t, err)
+	require.Equal(t, "b", result.Answer, "the answer of the earliest sample wins the tie by default")
+
+	result, err = generate(t, ensemble.Config{TieBreak: ensemble.TieBreakRandom}, answers...)
+	require.NoError(t, err)
+	require.Contains(t, []string{"a", "b"}, result.Answer)
+
+	_, err = generate(t, ensemble.Config{TieBreak: ensemble.TieBreakFail}, answers...)
+	require.ErrorIs(t, err, ensemble.ErrTie)
+	require.EqualError(t, err, "ensemble votes are tied: b, a")
+}
+
+func TestEnsemble__AllFailed(t *testing.T) {
+	_, err := generate(t, ensemble.Config{Field: "label"},
+		estellmtest.TextTurn("not json"),
+		estellmtest.ErrorTurn(errors.New("boom")),
+	)
+	require.ErrorContains(t, err, "all samples failed")
+	require.ErrorContains(t, err, "sample[0]: extract json")
+	require.ErrorContains(t, err, "sample[1]:")
+}
+
+func TestNew__InvalidConfig(t *testing.T) {
+	ctx := estellmtest.WithModelProvider(context.Background(), "fake", estellmtest.NewModelProvider())
+	_, err := ensemble.New(ctx, ensemble.Config{Samples: -1}, ensemble.Model{ModelProvider: "fake", ModelID: "a"})
+	require.EqualError(t, err, "samples must be positive")
+	_, err = ensemble.New(ctx, ensemble.Config{TieBreak: "last"}, ensemble.Model{ModelProvider: "fake", ModelID: "a"})
+	require.EqualError(t, err, "invalid tie_break `last`")
+	_, err = ensemble.New(ctx, ensemble.Config{}, ensemble.Model{ModelProvider: "fake"})
+	require.EqualError(t, err, "models[0]: model_id is required")
+}
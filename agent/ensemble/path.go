@@ -0,0 +1,47 @@
+package ensemble
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// lookup returns the value at the path like `$.items[0].name` or `answer`.
+func lookup(v any, path string) (any, error) {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	if path == "" {
+		return v, nil
+	}
+	for _, segment := range strings.Split(path, ".") {
+		name, rest, _ := strings.Cut(segment, "[")
+		if name != "" {
+			obj, ok := v.(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("field `%s`: not an object", name)
+			}
+			if v, ok = obj[name]; !ok {
+				return nil, fmt.Errorf("field `%s`: not found", name)
+			}
+		}
+		for rest != "" {
+			idxStr, after, ok := strings.Cut(rest, "]")
+			if !ok {
+				return nil, fmt.Errorf("invalid path `%s`", path)
+			}
+			idx, err := strconv.Atoi(idxStr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid index `%s`: %w", idxStr, err)
+			}
+			arr, ok := v.([]any)
+			if !ok {
+				return nil, fmt.Errorf("index [%d]: not an array", idx)
+			}
+			if idx < 0 || idx >= len(arr) {
+				return nil, fmt.Errorf("index [%d]: out of range", idx)
+			}
+			v = arr[idx]
+			rest = strings.TrimPrefix(after, "[")
+		}
+	}
+	return v, nil
+}
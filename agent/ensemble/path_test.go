@@ -0,0 +1,58 @@
+package ensemble
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLookup(t *testing.T) {
+	v := map[string]any{
+		"answer": "yes",
+		"result": map[string]any{
+			"label": "positive",
+			"items": []any{
+				map[string]any{"name": "a"},
+				map[string]any{"name": "b", "tags": []any{"x", "y"}},
+			},
+		},
+		"matrix": []any{[]any{1.0, 2.0}, []any{3.0, 4.0}},
+	}
+	tests := []struct {
+		path     string
+		expected any
+		err      string
+	}{
+		{path: "", expected: v},
+		{path: "$", expected: v},
+		{path: "answer", expected: "yes"},
+		{path: "$.answer", expected: "yes"},
+		{path: "$.result.label", expected: "positive"},
+		{path: "result.items[1].name", expected: "b"},
+		{path: "$.result.items[1].tags[0]", expected: "x"},
+		{path: "$.matrix[1][0]", expected: 3.0},
+		{path: "$.missing", err: "field `missing`: not found"},
+		{path: "$.result.missing.label", err: "field `missing`: not found"},
+		{path: "$.answer.label", err: "field `label`: not an object"},
+		{path: "$.answer[0]", err: "index [0]: not an array"},
+		{path: "$.result.items[2]", err: "index [2]: out of range"},
+		{path: "$.result.items[-1]", err: "index [-1]: out of range"},
+		{path: "$.result.items[x]", err: "invalid index `x`"},
+		{path: "$.result.items[0", err: "invalid path `result.items[0`"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			actual, err := lookup(v, tt.path)
+			if tt.err != "" {
+				require.ErrorContains(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, actual)
+		})
+	}
+
+	actual, err := lookup([]any{"first", "second"}, "$[1]")
+	require.NoError(t, err)
+	require.Equal(t, "second", actual)
+}
@@ -0,0 +1,285 @@
+package evaluate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/mashiike/estellm"
+	"github.com/mashiike/estellm/jsonutil"
+)
+
+const (
+	AgentName = "evaluate"
+)
+
+func init() {
+	err := estellm.RegisterAgent(AgentName, NewAgent)
+	if err != nil {
+		panic(fmt.Sprintf("failed to register agent %s: %v", AgentName, err))
+	}
+}
+
+type Config struct {
+	ModelProvider string         `json:"model_provider"`
+	ModelID       string         `json:"model_id"`
+	ModelParams   map[string]any `json:"model_params"`
+	Target        string         `json:"target"`
+	Rubric        []Criterion    `json:"rubric"`
+	PassThreshold *float64       `json:"pass_threshold"`
+	OnPass        string         `json:"on_pass"`
+	OnFail        string         `json:"on_fail"`
+}
+
+type Criterion struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Weight      *float64 `json:"weight"`
+	Min         float64  `json:"min"`
+	Max         *float64 `json:"max"`
+}
+
+const (
+	defaultMaxScore      = 10.0
+	defaultPassThreshold = 0.7
+	// scoreTolerance absorbs the rounding error of the weighted score, so that a score equal to the threshold passes.
+	scoreTolerance = 1e-9
+)
+
+// criterionNameRe restricts the criterion names, which are used in the `Evaluation-Score-<name>` metadata keys.
+var criterionNameRe = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+type Agent struct {
+	p             *estellm.Prompt
+	cfg           *Config
+	modelProvider estellm.ModelProvider
+}
+
+// Output is the evaluation result. Score is the weighted score normalized to 0..1.
+type Output struct {
+	Score    float64           `json:"score"`
+	Passed   bool              `json:"passed"`
+	Criteria []CriterionResult `json:"criteria"`
+	Summary  string            `json:"summary,omitempty"`
+}
+
+type CriterionResult struct {
+	Name      string  `json:"name"`
+	Score     float64 `json:"score"`
+	Min       float64 `json:"min"`
+	Max       float64 `json:"max"`
+	Weight    float64 `json:"weight"`
+	Rationale string  `json:"rationale"`
+}
+
+type judgeOutput struct {
+	Criteria []judgedCriterion `json:"criteria"`
+	Summary  string            `json:"summary"`
+}
+
+type judgedCriterion struct {
+	Name      string  `json:"name"`
+	Score     float64 `json:"score"`
+	Rationale string  `json:"rationale"`
+}
+
+func NewAgent(ctx context.Context, p *estellm.Prompt) (estellm.Agent, error) {
+	var cfg Config
+	promptCfg := p.Config()
+	if err := promptCfg.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("decode `evaluate` agent config: %w", err)
+	}
+	if cfg.ModelProvider == "" {
+		return nil, fmt.Errorf("model_provider is required")
+	}
+	if cfg.ModelID == "" {
+		return nil, fmt.Errorf("model_id is required")
+	}
+	if cfg.Target == "" {
+		return nil, fmt.Errorf("target is required")
+	}
+	if !slices.Contains(promptCfg.DependsOn, cfg.Target) {
+		return nil, fmt.Errorf("target `%s` must be in depends_on", cfg.Target)
+	}
+	if len(cfg.Rubric) == 0 {
+		return nil, fmt.Errorf("rubric is required")
+	}
+	var weights float64
+	for i := range cfg.Rubric {
+		c := &cfg.Rubric[i]
+		if c.Name == "" {
+			return nil, fmt.Errorf("rubric[%d]: name is required", i)
+		}
+		if !criterionNameRe.MatchString(c.Name) {
+			return nil, fmt.Errorf("rubric[%d]: name `%s` must consist of letters, digits, `-` and `_`", i, c.Name)
+		}
+		if slices.ContainsFunc(cfg.Rubric[:i], func(other Criterion) bool { return other.Name == c.Name }) {
+			return nil, fmt.Errorf("rubric[%d]: name `%s` is duplicated", i, c.Name)
+		}
+		if c.Weight == nil {
+			c.Weight = ptr(1.0)
+		}
+		if *c.Weight < 0 {
+			return nil, fmt.Errorf("rubric[%d]: weight must not be negative", i)
+		}
+		if c.Max == nil {
+			c.Max = ptr(defaultMaxScore)
+		}
+		if *c.Max <= c.Min {
+			return nil, fmt.Errorf("rubric[%d]: max must be greater than min", i)
+		}
+		weights += *c.Weight
+	}
+	if weights == 0 {
+		return nil, errors.New("total weight of rubric is zero")
+	}
+	if cfg.PassThreshold == nil {
+		cfg.PassThreshold = ptr(defaultPassThreshold)
+	}
+	dependents := promptCfg.Dependents()
+	for _, next := range []string{cfg.OnPass, cfg.OnFail} {
+		if next != "" && !slices.Contains(dependents, next) {
+			return nil, fmt.Errorf("`%s` is not a dependent agent", next)
+		}
+	}
+	modelProvider, err := estellm.GetModelProvider(ctx, cfg.ModelProvider)
+	if err != nil {
+		return nil, fmt.Errorf("model_provider `%s`: %w", cfg.ModelProvider, err)
+	}
+	return &Agent{
+		p:             p,
+		cfg:           &cfg,
+		modelProvider: modelProvider,
+	}, nil
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func (a *Agent) Execute(ctx context.Context, req *estellm.Request, w estellm.ResponseWriter) error {
+	target, ok := req.PreviousResults[a.cfg.Target]
+	if !ok {
+		return fmt.Errorf("result of target `%s` not found", a.cfg.Target)
+	}
+	instructions, err := a.p.Render(ctx, req)
+	if err != nil {
+		return fmt.Errorf("render prompt: %w", err)
+	}
+	payload, err := json.Marshal(req.Payload)
+	if err != nil {
+		return fmt.Errorf("marshal payload: %w", err)
+	}
+	var user strings.Builder
+	fmt.Fprintf(&user, "<input>\n%s\n</input>\n", payload)
+	fmt.Fprintf(&user, "<output>\n%s</output>\n", target.String())
+	modelReq := &estellm.GenerateTextRequest{
+		ModelID:     a.cfg.ModelID,
+		ModelParams: a.cfg.ModelParams,
+		System:      a.system(strings.TrimSpace(instructions)),
+		Messages: []estellm.Message{
+			{
+				Role:  estellm.RoleUser,
+				Parts: []estellm.ContentPart{estellm.TextPart(user.String())},
+			},
+		},
+		Metadata: req.Metadata,
+	}
+	batch := estellm.NewBatchResponseWriter()
+	if err := a.modelProvider.GenerateText(ctx, modelReq, batch); err != nil {
+		return fmt.Errorf("generate text: %w", err)
+	}
+	var judged judgeOutput
+	if err := jsonutil.UnmarshalFirstJSON([]byte(batch.Response().String()), &judged); err != nil {
+		return fmt.Errorf("extruct output: %w", err)
+	}
+	output, err := a.score(&judged)
+	if err != nil {
+		return err
+	}
+	m := w.Metadata()
+	m.SetFloat64("Evaluation-Score", output.Score)
+	m.SetBool("Evaluation-Passed", output.Passed)
+	for _, c := range output.Criteria {
+		m.SetFloat64("Evaluation-Score-"+c.Name, c.Score)
+	}
+	bs, err := json.Marshal(output)
+	if err != nil {
+		return fmt.Errorf("marshal output: %w", err)
+	}
+	w.WriteRole(estellm.RoleAssistant)
+	if err := w.WritePart(estellm.TextPart(string(bs))); err != nil {
+		return fmt.Errorf("write part: %w", err)
+	}
+	if next, ok := a.nextAgents(output.Passed); ok {
+		estellm.SetNextAgents(w, next...)
+	}
+	w.Finish(estellm.FinishReasonEndTurn, "evaluate")
+	return nil
+}
+
+func (a *Agent) nextAgents(passed bool) ([]string, bool) {
+	if a.cfg.OnPass == "" && a.cfg.OnFail == "" {
+		return nil, false
+	}
+	next, other := a.cfg.OnFail, a.cfg.OnPass
+	if passed {
+		next, other = other, next
+	}
+	if next != "" {
+		return []string{next}, true
+	}
+	return slices.DeleteFunc(a.p.Config().Dependents(), func(name string) bool {
+		return name == other
+	}), true
+}
+
+func (a *Agent) system(instructions string) string {
+	var sb strings.Builder
+	sb.WriteString("You are a strict evaluator. Judge the output in <output> for the input in <input> by each criterion of the rubric.\n")
+	if instructions != "" {
+		fmt.Fprintf(&sb, "<instructions>\n%s\n</instructions>\n", instructions)
+	}
+	sb.WriteString("<rubric>\n")
+	for _, c := range a.cfg.Rubric {
+		fmt.Fprintf(&sb, "  <criterion name=%q min=\"%g\" max=\"%g\">%s</criterion>\n", c.Name, c.Min, *c.Max, c.Description)
+	}
+	sb.WriteString("</rubric>\n")
+	sb.WriteString(`Answer only JSON like {"criteria": [{"name": "<criterion name>", "score": <number between min and max>, "rationale": "<reason>"}], "summary": "<overall comment>"} without any preamble.`)
+	return sb.String()
+}
+
+func (a *Agent) score(judged *judgeOutput) (*Output, error) {
+	output := &Output{
+		Criteria: make([]CriterionResult, 0, len(a.cfg.Rubric)),
+		Summary:  judged.Summary,
+	}
+	var total, weights float64
+	for _, c := range a.cfg.Rubric {
+		idx := slices.IndexFunc(judged.Criteria, func(j judgedCriterion) bool {
+			return j.Name == c.Name
+		})
+		if idx < 0 {
+			return nil, fmt.Errorf("criterion `%s` is not scored", c.Name)
+		}
+		j := judged.Criteria[idx]
+		s := min(max(j.Score, c.Min), *c.Max)
+		output.Criteria = append(output.Criteria, CriterionResult{
+			Name:      c.Name,
+			Score:     s,
+			Min:       c.Min,
+			Max:       *c.Max,
+			Weight:    *c.Weight,
+			Rationale: j.Rationale,
+		})
+		total += *c.Weight * (s - c.Min) / (*c.Max - c.Min)
+		weights += *c.Weight
+	}
+	output.Score = total / weights
+	output.Passed = output.Score >= *a.cfg.PassThreshold-scoreTolerance
+	return output, nil
+}
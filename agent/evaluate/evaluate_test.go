@@ -0,0 +1,153 @@
+package evaluate_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	_ "github.com/mashiike/estellm/agent/constant"
+	"github.com/mashiike/estellm/agent/evaluate"
+
+	"github.com/mashiike/estellm"
+	"github.com/mashiike/estellm/estellmtest"
+	"github.com/stretchr/testify/require"
+)
+
+func newPrompts(threshold float64, rubric string) map[string]string {
+	prompts := map[string]string{
+		"draft.md": `{{ define "config" }}
+{
+  type: "constant",
+}
+{{ end }}
+The answer is 42.`,
+		"judge.md": fmt.Sprintf(`{{ define "config" }}
+{
+  type: "evaluate",
+  model_provider: "fake",
+  model_id: "judge-model",
+  depends_on: ["draft"],
+  target: "draft",
+  rubric: %s,
+  pass_threshold: %g,
+  on_pass: "publish",
+  on_fail: "regenerate",
+}
+{{ end }}
+The answer is for a beginners' guide.`, rubric, threshold),
+	}
+	for _, next := range []string{"publish", "regenerate"} {
+		prompts[next+".md"] = `{{ define "config" }}
+{
+  type: "constant",
+  depends_on: ["judge"],
+}
+{{ end }}
+{{ (ref "judge").result._raw }}`
+	}
+	return prompts
+}
+
+const rubric = `[
+    { name: "accuracy", description: "Facts are correct.", weight: 2, min: 0, max: 10 },
+    { name: "clarity", description: "Easy to read.", max: 5 },
+  ]`
+
+func TestEvaluate(t *testing.T) {
+	cases := []struct {
+		name      string
+		threshold float64
+		passed    bool
+		next      string
+	}{
+		{"passed at the threshold", 0.8, true, "publish"},
+		{"failed", 0.85, false, "regenerate"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			provider := estellmtest.NewModelProvider()
+			provider.Enqueue("judge-model", estellmtest.TextTurn(`{"criteria": [
+				{"name": "clarity", "score": 2, "rationale": "dense"},
+				{"name": "accuracy", "score": 12, "rationale": "correct"}
+			], "summary": "good"}`))
+			ctx := estellmtest.WithModelProvider(context.Background(), "fake", provider)
+			mux := estellmtest.NewAgentMux(t, ctx, estellmtest.MapFS(newPrompts(c.threshold, rubric)))
+			executions := estellmtest.RecordExecutions(mux)
+			w := estellmtest.Execute(t, ctx, mux, "draft", map[string]any{})
+			estellmtest.RequireExecuted(t, executions, "draft", "judge", c.next)
+			estellmtest.RequireAllServed(t, provider)
+
+			var output evaluate.Output
+			require.NoError(t, json.Unmarshal([]byte(w.Text()), &output))
+			// the accuracy score is clamped to max: (2 * 10/10 + 1 * 2/5) / 3
+			require.InDelta(t, 0.8, output.Score, 1e-9)
+			require.Equal(t, c.passed, output.Passed)
+			require.Equal(t, []evaluate.CriterionResult{
+				{Name: "accuracy", Score: 10, Min: 0, Max: 10, Weight: 2, Rationale: "correct"},
+				{Name: "clarity", Score: 2, Min: 0, Max: 5, Weight: 1, Rationale: "dense"},
+			}, output.Criteria)
+			require.Equal(t, "good", output.Summary)
+
+			calls := provider.Calls()
+			require.Contains(t, calls[0].TextRequest.System, "<instructions>\nThe answer is for a beginners' guide.\n</instructions>")
+			require.Contains(t, calls[0].TextRequest.System, `<criterion name="clarity" min="0" max="5">Easy to read.</criterion>`)
+			require.Contains(t, calls[0].TextRequest.Messages[0].Parts[0].Text, "The answer is 42.")
+		})
+	}
+}
+
+func TestEvaluate__Metadata(t *testing.T) {
+	provider := estellmtest.NewModelProvider()
+	provider.Enqueue("judge-model", estellmtest.TextTurn(`{"criteria": [
+		{"name": "accuracy", "score": 7},
+		{"name": "clarity", "score": 5}
+	]}`))
+	ctx := estellmtest.WithModelProvider(context.Background(), "fake", provider)
+	mux := estellmtest.NewAgentMux(t, ctx, estellmtest.MapFS(newPrompts(0.7, rubric)))
+	req, err := estellm.NewRequest("draft", map[string]any{})
+	require.NoError(t, err)
+	req.IncludeDownstream = false
+	draft := estellmtest.NewResponseRecorder()
+	require.NoError(t, mux.Execute(ctx, req, draft))
+
+	req, err = estellm.NewRequest("judge", map[string]any{})
+	require.NoError(t, err)
+	req.IncludeDownstream = false
+	req.PreviousResults = map[string]*estellm.Response{"draft": draft.Response()}
+	w := estellmtest.NewResponseRecorder()
+	require.NoError(t, mux.Execute(ctx, req, w))
+	m := w.Metadata()
+	score, _ := m.GetFloat64("Evaluation-Score")
+	require.InDelta(t, 0.8, score, 1e-9)
+	passed, _ := m.GetBool("Evaluation-Passed")
+	require.True(t, passed)
+	accuracy, _ := m.GetFloat64("Evaluation-Score-accuracy")
+	require.EqualValues(t, 7, accuracy)
+	clarity, _ := m.GetFloat64("Evaluation-Score-clarity")
+	require.EqualValues(t, 5, clarity)
+}
+
+func TestEvaluate__InvalidRubric(t *testing.T) {
+	cases := []struct {
+		name     string
+		rubric   string
+		expected string
+	}{
+		{"zero total weight", `[{ name: "a", weight: 0 }, { name: "b", weight: 0 }]`, "total weight of rubric is zero"},
+		{"negative weight", `[{ name: "a", weight: -1 }]`, "rubric[0]: weight must not be negative"},
+		{"invalid name", `[{ name: "a" }, { name: "Tone: formal" }]`, "rubric[1]: name `Tone: formal` must consist of letters, digits, `-` and `_`"},
+		{"duplicated name", `[{ name: "a" }, { name: "a" }]`, "rubric[1]: name `a` is duplicated"},
+		{"max not greater than min", `[{ name: "a", min: 5, max: 5 }]`, "rubric[0]: max must be greater than min"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := estellmtest.WithModelProvider(context.Background(), "fake", estellmtest.NewModelProvider())
+			_, err := estellm.NewAgentMux(ctx,
+				estellm.WithPromptsFS(estellmtest.MapFS(newPrompts(0.7, c.rubric))),
+				estellm.WithIncludesFS(estellmtest.MapFS(nil)),
+			)
+			require.ErrorContains(t, err, c.expected)
+		})
+	}
+}
@@ -22,6 +22,8 @@ type Config struct {
 	ModelProvider string         `json:"model_provider"`
 	ModelID       string         `json:"model_id"`
 	ModelParams   map[string]any `json:"model_params"`
+	// Models are tried in order on retryable errors, see estellm.ResolveFallbackModels.
+	Models []estellm.FallbackModel `json:"models,omitempty"`
 }
 
 type Agent struct {
@@ -35,6 +37,22 @@ func NewAgent(ctx context.Context, p *estellm.Prompt) (estellm.Agent, error) {
 	if err := p.Config().Decode(&cfg); err != nil {
 		return nil, fmt.Errorf("decode `generate_text` agent config: %w", err)
 	}
+	if len(cfg.Models) > 0 {
+		models, err := estellm.ResolveFallbackModels(cfg.ModelProvider, cfg.ModelID, cfg.Models)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Models = models
+		modelProvider, err := estellm.GetFallbackModelProvider(ctx, cfg.Models)
+		if err != nil {
+			return nil, fmt.Errorf("models: %w", err)
+		}
+		return &Agent{
+			p:             p,
+			cfg:           &cfg,
+			modelProvider: modelProvider,
+		}, nil
+	}
 	if cfg.ModelProvider == "" {
 		return nil, fmt.Errorf("model_provider is required")
 	}
@@ -0,0 +1,98 @@
+package genspeech
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"strings"
+
+	"github.com/mashiike/estellm"
+)
+
+const (
+	AgentName = "generate_speech"
+)
+
+func init() {
+	err := estellm.RegisterAgent(AgentName, NewAgent)
+	if err != nil {
+		panic(fmt.Sprintf("failed to register agent %s: %v", AgentName, err))
+	}
+}
+
+type Config struct {
+	ModelProvider string         `json:"model_provider"`
+	ModelID       string         `json:"model_id"`
+	ModelParams   map[string]any `json:"model_params"`
+}
+
+type Agent struct {
+	p              *estellm.Prompt
+	cfg            *Config
+	speechProvider estellm.SpeechProvider
+}
+
+func NewAgent(ctx context.Context, p *estellm.Prompt) (estellm.Agent, error) {
+	var cfg Config
+	if err := p.Config().Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("decode `generate_speech` agent config: %w", err)
+	}
+	if cfg.ModelProvider == "" {
+		return nil, fmt.Errorf("model_provider is required")
+	}
+	if cfg.ModelID == "" {
+		return nil, fmt.Errorf("model_id is required")
+	}
+	speechProvider, err := estellm.GetSpeechProvider(ctx, cfg.ModelProvider)
+	if err != nil {
+		return nil, err
+	}
+	return &Agent{
+		p:              p,
+		cfg:            &cfg,
+		speechProvider: speechProvider,
+	}, nil
+}
+
+func (a *Agent) Execute(ctx context.Context, req *estellm.Request, w estellm.ResponseWriter) error {
+	system, msgs, err := a.p.Decode(ctx, req)
+	if err != nil {
+		return fmt.Errorf("decode prompt: %w", err)
+	}
+	var texts []string
+	for _, msg := range msgs {
+		for _, part := range msg.Parts {
+			if part.Type == estellm.PartTypeText {
+				texts = append(texts, part.Text)
+			}
+		}
+	}
+	text := strings.TrimSpace(strings.Join(texts, "\n"))
+	if text == "" {
+		return fmt.Errorf("rendered text is empty")
+	}
+	modelReq := &estellm.GenerateSpeechRequest{
+		ModelID:     a.cfg.ModelID,
+		ModelParams: withInstructions(a.cfg.ModelParams, strings.TrimSpace(system)),
+		Text:        text,
+		Metadata:    req.Metadata,
+	}
+	return a.speechProvider.GenerateSpeech(ctx, modelReq, w)
+}
+
+// withInstructions passes the system prompt as `instructions` of model_params, which overrides it.
+// The system prompt is not synthesized, it directs the voice.
+func withInstructions(params map[string]any, system string) map[string]any {
+	if system == "" {
+		return params
+	}
+	if _, ok := params["instructions"]; ok {
+		return params
+	}
+	params = maps.Clone(params)
+	if params == nil {
+		params = make(map[string]any)
+	}
+	params["instructions"] = system
+	return params
+}
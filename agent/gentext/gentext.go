@@ -3,6 +3,7 @@ package gentext
 import (
 	"context"
 	"fmt"
+	"maps"
 
 	"github.com/mashiike/estellm"
 )
@@ -22,6 +23,12 @@ type Config struct {
 	ModelProvider string         `json:"model_provider"`
 	ModelID       string         `json:"model_id"`
 	ModelParams   map[string]any `json:"model_params"`
+	// Models are tried in order on retryable errors, see estellm.ResolveFallbackModels.
+	Models []estellm.FallbackModel `json:"models,omitempty"`
+	// Guardrail is applied to the request by the providers which support guardrails, e.g. bedrock.
+	Guardrail *estellm.GuardrailConfig `json:"guardrail,omitempty"`
+	// MaxToolIterations is passed as `max_tool_iterations` of model_params, which overrides it.
+	MaxToolIterations int `json:"max_tool_iterations,omitempty"`
 }
 
 type Agent struct {
@@ -35,6 +42,41 @@ func NewAgent(ctx context.Context, p *estellm.Prompt) (estellm.Agent, error) {
 	if err := p.Config().Decode(&cfg); err != nil {
 		return nil, fmt.Errorf("decode `generate_text` agent config: %w", err)
 	}
+	if cfg.MaxToolIterations < 0 {
+		return nil, fmt.Errorf("max_tool_iterations must not be negative: %d", cfg.MaxToolIterations)
+	}
+	if cfg.MaxToolIterations > 0 {
+		cfg.ModelParams = withMaxToolIterations(cfg.ModelParams, cfg.MaxToolIterations)
+		for i, m := range cfg.Models {
+			if m.ModelParams != nil {
+				cfg.Models[i].ModelParams = withMaxToolIterations(m.ModelParams, cfg.MaxToolIterations)
+			}
+		}
+	}
+	if cfg.Guardrail != nil {
+		if cfg.Guardrail.Identifier == "" {
+			return nil, fmt.Errorf("guardrail: identifier is required")
+		}
+		if cfg.Guardrail.Version == "" {
+			cfg.Guardrail.Version = "DRAFT"
+		}
+	}
+	if len(cfg.Models) > 0 {
+		models, err := estellm.ResolveFallbackModels(cfg.ModelProvider, cfg.ModelID, cfg.Models)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Models = models
+		modelProvider, err := estellm.GetFallbackModelProvider(ctx, cfg.Models)
+		if err != nil {
+			return nil, fmt.Errorf("models: %w", err)
+		}
+		return &Agent{
+			p:             p,
+			cfg:           &cfg,
+			modelProvider: modelProvider,
+		}, nil
+	}
 	if cfg.ModelProvider == "" {
 		return nil, fmt.Errorf("model_provider is required")
 	}
@@ -52,6 +94,18 @@ func NewAgent(ctx context.Context, p *estellm.Prompt) (estellm.Agent, error) {
 	}, nil
 }
 
+func withMaxToolIterations(params map[string]any, n int) map[string]any {
+	if _, ok := params["max_tool_iterations"]; ok {
+		return params
+	}
+	params = maps.Clone(params)
+	if params == nil {
+		params = make(map[string]any)
+	}
+	params["max_tool_iterations"] = n
+	return params
+}
+
 func (a *Agent) Execute(ctx context.Context, req *estellm.Request, w estellm.ResponseWriter) error {
 	system, msgs, err := a.p.Decode(ctx, req)
 	if err != nil {
@@ -64,6 +118,7 @@ func (a *Agent) Execute(ctx context.Context, req *estellm.Request, w estellm.Res
 		Messages:    msgs,
 		Tools:       req.Tools,
 		Metadata:    req.Metadata,
+		Guardrail:   a.cfg.Guardrail,
 	}
 	return a.modelProvider.GenerateText(ctx, modelReq, w)
 }
@@ -0,0 +1,230 @@
+package httpcall
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mashiike/estellm"
+)
+
+const (
+	AgentName = "http"
+)
+
+func init() {
+	err := estellm.RegisterAgent(AgentName, NewAgent)
+	if err != nil {
+		panic(fmt.Sprintf("failed to register agent %s: %v", AgentName, err))
+	}
+	err = estellm.SetAgentMarmaidNodeWrapper(AgentName, func(s string) string {
+		return fmt.Sprintf("[/%s/]", s)
+	})
+	if err != nil {
+		panic(fmt.Sprintf("failed to set marmaid node wrapper for agent %s: %v", AgentName, err))
+	}
+}
+
+type Config struct {
+	Timeout             string `json:"timeout"`
+	AcceptStatusCodes   []int  `json:"accept_status_codes"`
+	MaxResponseBodySize int64  `json:"max_response_body_size"`
+}
+
+// HTTPRequest is the request rendered by the prompt body.
+type HTTPRequest struct {
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Query   map[string]string `json:"query,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+	RawBody *string           `json:"raw_body,omitempty"`
+}
+
+const (
+	defaultTimeout             = 30 * time.Second
+	defaultMaxResponseBodySize = 10 << 20
+)
+
+type Agent struct {
+	p        *estellm.Prompt
+	cfg      *Config
+	timeout  time.Duration
+	client   *http.Client
+	signer   func(*http.Request, string) (*http.Request, error)
+	validate func(*http.Response, *http.Request) error
+}
+
+func NewAgent(ctx context.Context, p *estellm.Prompt) (estellm.Agent, error) {
+	var cfg Config
+	if err := p.Config().Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("decode `http` agent config: %w", err)
+	}
+	a := &Agent{
+		p:       p,
+		cfg:     &cfg,
+		timeout: defaultTimeout,
+		client:  http.DefaultClient,
+	}
+	if cfg.Timeout != "" {
+		d, err := time.ParseDuration(cfg.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("parse timeout: %w", err)
+		}
+		a.timeout = d
+	}
+	if cfg.MaxResponseBodySize <= 0 {
+		cfg.MaxResponseBodySize = defaultMaxResponseBodySize
+	}
+	if base, ok := estellm.RemoteToolConfigFromContext(ctx); ok {
+		if base.HTTPClient != nil {
+			a.client = base.HTTPClient
+		}
+		a.signer = base.RequestSigner
+		a.validate = base.ResponseValidator
+	}
+	return a, nil
+}
+
+func (a *Agent) Execute(ctx context.Context, req *estellm.Request, w estellm.ResponseWriter) error {
+	rendered, err := a.p.Render(ctx, req)
+	if err != nil {
+		return fmt.Errorf("render prompt: %w", err)
+	}
+	var hr HTTPRequest
+	if err := json.Unmarshal([]byte(strings.TrimSpace(rendered)), &hr); err != nil {
+		return fmt.Errorf("unmarshal rendered request: %w", err)
+	}
+	httpReq, err := a.newHTTPRequest(ctx, &hr)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+	httpReq = httpReq.WithContext(ctx)
+	if a.signer != nil {
+		httpReq, err = a.signer(httpReq, "http/"+a.p.Name())
+		if err != nil {
+			return fmt.Errorf("sign request: %w", err)
+		}
+	}
+	resp, err := a.client.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("do request: %w", err)
+	}
+	defer resp.Body.Close()
+	if a.validate != nil {
+		if err := a.validate(resp, httpReq); err != nil {
+			return fmt.Errorf("validate response: %w", err)
+		}
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, a.cfg.MaxResponseBodySize+1))
+	if err != nil {
+		return fmt.Errorf("read response body: %w", err)
+	}
+	if int64(len(body)) > a.cfg.MaxResponseBodySize {
+		return fmt.Errorf("%s %s: response body exceeds max_response_body_size %d", httpReq.Method, httpReq.URL.Redacted(), a.cfg.MaxResponseBodySize)
+	}
+	m := w.Metadata()
+	m.SetInt64("Http-Status-Code", int64(resp.StatusCode))
+	contentType := resp.Header.Get("Content-Type")
+	if contentType != "" {
+		m.SetString("Http-Content-Type", contentType)
+	}
+	if !a.acceptStatus(resp.StatusCode) {
+		return fmt.Errorf("%s %s: unexpected status %s: %s", httpReq.Method, httpReq.URL.Redacted(), resp.Status, truncate(body, 512))
+	}
+	w.WriteRole(estellm.RoleAssistant)
+	if len(body) > 0 {
+		if err := w.WritePart(bodyToPart(contentType, body)); err != nil {
+			return fmt.Errorf("write part: %w", err)
+		}
+	}
+	w.Finish(estellm.FinishReasonEndTurn, resp.Status)
+	return nil
+}
+
+func (a *Agent) newHTTPRequest(ctx context.Context, hr *HTTPRequest) (*http.Request, error) {
+	if hr.URL == "" {
+		return nil, fmt.Errorf("rendered request: url is required")
+	}
+	u, err := url.Parse(hr.URL)
+	if err != nil {
+		return nil, fmt.Errorf("parse url: %w", err)
+	}
+	if !slices.Contains([]string{"http", "https"}, u.Scheme) {
+		return nil, fmt.Errorf("unsupported url scheme: %s", u.Scheme)
+	}
+	if len(hr.Query) > 0 {
+		q := u.Query()
+		for k, v := range hr.Query {
+			q.Set(k, v)
+		}
+		u.RawQuery = q.Encode()
+	}
+	method := strings.ToUpper(hr.Method)
+	if method == "" {
+		method = http.MethodGet
+	}
+	var body io.Reader
+	var isJSON bool
+	switch {
+	case hr.RawBody != nil:
+		body = strings.NewReader(*hr.RawBody)
+	case len(hr.Body) > 0 && string(hr.Body) != "null":
+		body = bytes.NewReader(hr.Body)
+		isJSON = true
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("new request: %w", err)
+	}
+	if isJSON {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range hr.Headers {
+		httpReq.Header.Set(k, v)
+	}
+	return httpReq, nil
+}
+
+func (a *Agent) acceptStatus(code int) bool {
+	if len(a.cfg.AcceptStatusCodes) > 0 {
+		return slices.Contains(a.cfg.AcceptStatusCodes, code)
+	}
+	return code >= 200 && code < 300
+}
+
+func bodyToPart(contentType string, body []byte) estellm.ContentPart {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType == "" {
+		mediaType, _, _ = mime.ParseMediaType(http.DetectContentType(body))
+	}
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, body); err == nil {
+			return estellm.TextPart(buf.String())
+		}
+		return estellm.TextPart(string(body))
+	case strings.HasPrefix(mediaType, "text/"), mediaType == "application/xml", strings.HasSuffix(mediaType, "+xml"):
+		return estellm.TextPart(string(body))
+	default:
+		return estellm.BinaryPart(mediaType, body)
+	}
+}
+
+func truncate(bs []byte, n int) string {
+	if len(bs) <= n {
+		return string(bs)
+	}
+	return string(bs[:n]) + "..."
+}
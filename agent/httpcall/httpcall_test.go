@@ -0,0 +1,243 @@
+package httpcall_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	_ "github.com/mashiike/estellm/agent/httpcall"
+
+	"github.com/mashiike/estellm"
+	"github.com/mashiike/estellm/estellmtest"
+	"github.com/stretchr/testify/require"
+)
+
+const requestTemplate = `{
+  "method": "{{ .payload.method | default "" }}",
+  "url": "{{ .payload.url | default "" }}",
+  "headers": { "X-Api-Key": "secret" },
+  "query": { "q": {{ .payload.q | default "" | toJson }} },
+{{- if .payload.raw }}
+  "raw_body": {{ .payload.raw | toJson }}
+{{- else }}
+  "body": { "name": {{ .payload.name | toJson }} }
+{{- end }}
+}`
+
+const payloadSchema = `{
+    type: "object",
+    properties: {
+      method: { type: "string" },
+      url: { type: "string" },
+      q: { type: "string" },
+      name: { type: "string" },
+      raw: { type: "string" },
+    },
+  }`
+
+var prompts = estellmtest.MapFS(map[string]string{
+	"call.md": `{{ define "config" }}
+{
+  type: "http",
+  payload_schema: ` + payloadSchema + `,
+}
+{{ end }}
+` + requestTemplate,
+	"limited.md": `{{ define "config" }}
+{
+  type: "http",
+  max_response_body_size: 16,
+  payload_schema: ` + payloadSchema + `,
+}
+{{ end }}
+` + requestTemplate,
+	"lenient.md": `{{ define "config" }}
+{
+  type: "http",
+  accept_status_codes: [200, 404],
+  payload_schema: ` + payloadSchema + `,
+}
+{{ end }}
+` + requestTemplate,
+})
+
+type recorded struct {
+	method      string
+	path        string
+	query       string
+	apiKey      string
+	contentType string
+	body        string
+}
+
+func newServer(t *testing.T) (*httptest.Server, *recorded) {
+	t.Helper()
+	rec := &recorded{}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bs, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		*rec = recorded{
+			method:      r.Method,
+			path:        r.URL.Path,
+			query:       r.URL.RawQuery,
+			apiKey:      r.Header.Get("X-Api-Key"),
+			contentType: r.Header.Get("Content-Type"),
+			body:        string(bs),
+		}
+		switch r.URL.Path {
+		case "/json":
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			io.WriteString(w, "{\n  \"id\": 1,\n  \"name\": \"cat\"\n}\n")
+		case "/problem":
+			w.Header().Set("Content-Type", "application/problem+json")
+			io.WriteString(w, `{ "title": "not found" }`)
+		case "/text":
+			w.Header().Set("Content-Type", "text/plain")
+			io.WriteString(w, "hello")
+		case "/binary":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte{0x89, 'P', 'N', 'G'})
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, "not found")
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+			io.WriteString(w, "boom")
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s, rec
+}
+
+func TestHTTPCall__Request(t *testing.T) {
+	s, rec := newServer(t)
+	ctx := context.Background()
+	mux := estellmtest.NewAgentMux(t, ctx, prompts)
+
+	estellmtest.Execute(t, ctx, mux, "call", map[string]any{
+		"method": "post",
+		"url":    s.URL + "/json",
+		"q":      "a b&c",
+		"name":   `"cat"`,
+	})
+	require.Equal(t, recorded{
+		method:      http.MethodPost,
+		path:        "/json",
+		query:       "q=a+b%26c",
+		apiKey:      "secret",
+		contentType: "application/json",
+		body:        `{ "name": "\"cat\"" }`,
+	}, *rec)
+
+	estellmtest.Execute(t, ctx, mux, "call", map[string]any{
+		"method": "put",
+		"url":    s.URL + "/text",
+		"q":      "x",
+		"raw":    "name=cat",
+	})
+	require.Equal(t, recorded{
+		method: http.MethodPut,
+		path:   "/text",
+		query:  "q=x",
+		apiKey: "secret",
+		body:   "name=cat",
+	}, *rec)
+
+	estellmtest.Execute(t, ctx, mux, "call", map[string]any{
+		"url": s.URL + "/text",
+		"q":   "x",
+	})
+	require.Equal(t, http.MethodGet, rec.method)
+}
+
+func TestHTTPCall__Response(t *testing.T) {
+	s, _ := newServer(t)
+	cases := []struct {
+		name        string
+		path        string
+		expected    estellm.ContentPart
+		contentType string
+	}{
+		{"json is compacted", "/json", estellm.TextPart(`{"id":1,"name":"cat"}`), "application/json; charset=utf-8"},
+		{"json suffix", "/problem", estellm.TextPart(`{"title":"not found"}`), "application/problem+json"},
+		{"text as is", "/text", estellm.TextPart("hello"), "text/plain"},
+		{"binary", "/binary", estellm.BinaryPart("image/png", []byte{0x89, 'P', 'N', 'G'}), "image/png"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := context.Background()
+			mux := estellmtest.NewAgentMux(t, ctx, prompts)
+			w := estellmtest.Execute(t, ctx, mux, "call", map[string]any{"url": s.URL + c.path})
+			require.Equal(t, []estellm.ContentPart{c.expected}, w.Parts())
+			require.True(t, w.Finished())
+			status, _ := w.Metadata().GetInt64("Http-Status-Code")
+			require.EqualValues(t, http.StatusOK, status)
+			require.Equal(t, c.contentType, w.Metadata().GetString("Http-Content-Type"))
+		})
+	}
+}
+
+func TestHTTPCall__Status(t *testing.T) {
+	s, _ := newServer(t)
+	ctx := context.Background()
+	mux := estellmtest.NewAgentMux(t, ctx, prompts)
+
+	req, err := estellm.NewRequest("call", map[string]any{"url": s.URL + "/missing"})
+	require.NoError(t, err)
+	w := estellmtest.NewResponseRecorder()
+	err = mux.Execute(ctx, req, w)
+	require.ErrorContains(t, err, "GET "+s.URL+"/missing?q=: unexpected status 404 Not Found: not found")
+
+	req, err = estellm.NewRequest("call", map[string]any{"url": s.URL + "/error"})
+	require.NoError(t, err)
+	err = mux.Execute(ctx, req, estellmtest.NewResponseRecorder())
+	require.ErrorContains(t, err, "unexpected status 500 Internal Server Error: boom")
+
+	w = estellmtest.Execute(t, ctx, mux, "lenient", map[string]any{"url": s.URL + "/missing"})
+	require.Equal(t, "not found", w.Text())
+	status, _ := w.Metadata().GetInt64("Http-Status-Code")
+	require.EqualValues(t, http.StatusNotFound, status)
+
+	req, err = estellm.NewRequest("lenient", map[string]any{"url": s.URL + "/error"})
+	require.NoError(t, err)
+	err = mux.Execute(ctx, req, estellmtest.NewResponseRecorder())
+	require.ErrorContains(t, err, "unexpected status 500 Internal Server Error")
+}
+
+func TestHTTPCall__InvalidRequest(t *testing.T) {
+	ctx := context.Background()
+	mux := estellmtest.NewAgentMux(t, ctx, prompts)
+	cases := []struct {
+		name     string
+		url      string
+		expected string
+	}{
+		{"missing url", "", "rendered request: url is required"},
+		{"unsupported scheme", "file:///etc/passwd", "unsupported url scheme: file"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req, err := estellm.NewRequest("call", map[string]any{"url": c.url})
+			require.NoError(t, err)
+			err = mux.Execute(ctx, req, estellmtest.NewResponseRecorder())
+			require.ErrorContains(t, err, c.expected)
+		})
+	}
+}
+
+func TestHTTPCall__MaxResponseBodySize(t *testing.T) {
+	s, _ := newServer(t)
+	ctx := context.Background()
+	mux := estellmtest.NewAgentMux(t, ctx, prompts)
+
+	// the JSON body is 30 bytes before compaction.
+	req, err := estellm.NewRequest("limited", map[string]any{"url": s.URL + "/json"})
+	require.NoError(t, err)
+	err = mux.Execute(ctx, req, estellmtest.NewResponseRecorder())
+	require.ErrorContains(t, err, "response body exceeds max_response_body_size 16")
+
+	w := estellmtest.Execute(t, ctx, mux, "limited", map[string]any{"url": s.URL + "/text"})
+	require.Equal(t, "hello", w.Text())
+}
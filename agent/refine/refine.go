@@ -0,0 +1,219 @@
+package refine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/mashiike/estellm"
+	"github.com/mashiike/estellm/jsonutil"
+)
+
+const (
+	AgentName = "refine"
+)
+
+func init() {
+	err := estellm.RegisterAgent(AgentName, NewAgent)
+	if err != nil {
+		panic(fmt.Sprintf("failed to register agent %s: %v", AgentName, err))
+	}
+	err = estellm.SetAgentMarmaidNodeWrapper(AgentName, func(s string) string {
+		return fmt.Sprintf("[[%s]]", s)
+	})
+	if err != nil {
+		panic(fmt.Sprintf("failed to set marmaid node wrapper for agent %s: %v", AgentName, err))
+	}
+}
+
+type Config struct {
+	Generator      string   `json:"generator"`
+	Critic         string   `json:"critic"`
+	MaxIterations  int      `json:"max_iterations"`
+	AcceptField    string   `json:"accept_field"`
+	ScoreField     string   `json:"score_field"`
+	ScoreThreshold *float64 `json:"score_threshold"`
+}
+
+const (
+	defaultMaxIterations = 3
+	defaultAcceptField   = "accept"
+	defaultScoreField    = "score"
+)
+
+type Agent struct {
+	p   *estellm.Prompt
+	cfg *Config
+}
+
+func NewAgent(ctx context.Context, p *estellm.Prompt) (estellm.Agent, error) {
+	var cfg Config
+	if err := p.Config().Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("decode `refine` agent config: %w", err)
+	}
+	if cfg.Generator == "" {
+		return nil, fmt.Errorf("generator is required")
+	}
+	if cfg.Critic == "" {
+		return nil, fmt.Errorf("critic is required")
+	}
+	if cfg.MaxIterations == 0 {
+		cfg.MaxIterations = defaultMaxIterations
+	}
+	if cfg.MaxIterations < 1 {
+		return nil, fmt.Errorf("max_iterations must be positive")
+	}
+	if cfg.AcceptField == "" {
+		cfg.AcceptField = defaultAcceptField
+	}
+	if cfg.ScoreField == "" {
+		cfg.ScoreField = defaultScoreField
+	}
+	for _, name := range []string{cfg.Generator, cfg.Critic} {
+		if name == p.Name() {
+			return nil, fmt.Errorf("prompt `%s` can not refer to itself", name)
+		}
+	}
+	return &Agent{
+		p:   p,
+		cfg: &cfg,
+	}, nil
+}
+
+// InternalDependencies returns the generator and the critic, which are executed through the AgentMux.
+func (a *Agent) InternalDependencies() []string {
+	return []string{a.cfg.Generator, a.cfg.Critic}
+}
+
+// State is the refine state, passed to the generator and critic prompts as the result of this prompt.
+type State struct {
+	Iteration int      `json:"iteration"`
+	Draft     string   `json:"draft,omitempty"`
+	Drafts    []string `json:"drafts"`
+	Critiques []string `json:"critiques"`
+}
+
+type critique struct {
+	raw      string
+	accepted bool
+}
+
+func (a *Agent) Execute(ctx context.Context, req *estellm.Request, w estellm.ResponseWriter) error {
+	state := &State{
+		Drafts:    []string{},
+		Critiques: []string{},
+	}
+	var accepted, streamed bool
+	var draft []estellm.ContentPart
+	for i := 1; i <= a.cfg.MaxIterations; i++ {
+		state.Iteration = i
+		state.Draft = ""
+		// the draft of the last iteration is the result whatever the critic says, so it is streamed.
+		dw := &draftWriter{ResponseWriter: estellm.NewBatchResponseWriter()}
+		if i == a.cfg.MaxIterations {
+			dw.ResponseWriter, streamed = w, true
+		}
+		if err := a.run(ctx, a.cfg.Generator, req, state, dw); err != nil {
+			return fmt.Errorf("iteration %d: generator: %w", i, err)
+		}
+		draft = dw.parts
+		state.Draft = textOf(draft)
+		c, err := a.critique(ctx, req, state)
+		if err != nil {
+			return fmt.Errorf("iteration %d: critic: %w", i, err)
+		}
+		state.Drafts = append(state.Drafts, state.Draft)
+		state.Critiques = append(state.Critiques, c.raw)
+		if c.accepted {
+			accepted = true
+			break
+		}
+		if i == a.cfg.MaxIterations {
+			break
+		}
+		if err := w.WritePart(estellm.ReasoningPart(state.Draft), estellm.ReasoningPart(c.raw)); err != nil {
+			return fmt.Errorf("write part: %w", err)
+		}
+	}
+	m := w.Metadata()
+	if bs, err := json.Marshal(state.Drafts); err == nil {
+		m.SetString("Refine-Drafts", string(bs))
+	}
+	if bs, err := json.Marshal(state.Critiques); err == nil {
+		m.SetString("Refine-Critiques", string(bs))
+	}
+	m.SetInt64("Refine-Iterations", int64(state.Iteration))
+	m.SetBool("Refine-Accepted", accepted)
+	if !streamed {
+		w.WriteRole(estellm.RoleAssistant)
+		parts := slices.DeleteFunc(slices.Clone(draft), func(part estellm.ContentPart) bool {
+			return part.Type == estellm.PartTypeReasoning
+		})
+		if err := w.WritePart(parts...); err != nil {
+			return fmt.Errorf("write part: %w", err)
+		}
+	}
+	w.Finish(estellm.FinishReasonEndTurn, fmt.Sprintf("refined in %d iterations", state.Iteration))
+	return nil
+}
+
+func (a *Agent) critique(ctx context.Context, req *estellm.Request, state *State) (*critique, error) {
+	batch := estellm.NewBatchResponseWriter()
+	if err := a.run(ctx, a.cfg.Critic, req, state, batch); err != nil {
+		return nil, err
+	}
+	c := &critique{raw: textOf(batch.Response().Message.Parts)}
+	var v map[string]any
+	if err := jsonutil.UnmarshalFirstJSON([]byte(c.raw), &v); err != nil {
+		return c, nil
+	}
+	if accepted, ok := v[a.cfg.AcceptField].(bool); ok && accepted {
+		c.accepted = true
+	}
+	if score, ok := v[a.cfg.ScoreField].(float64); ok && a.cfg.ScoreThreshold != nil && score >= *a.cfg.ScoreThreshold {
+		c.accepted = true
+	}
+	return c, nil
+}
+
+func (a *Agent) run(ctx context.Context, name string, req *estellm.Request, state *State, w estellm.ResponseWriter) error {
+	bs, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("marshal state: %w", err)
+	}
+	subReq := req.Clone()
+	subReq.Name = name
+	subReq.PreviousResults[a.p.Name()] = &estellm.Response{
+		Message: estellm.Message{
+			Role:  estellm.RoleAssistant,
+			Parts: []estellm.ContentPart{estellm.TextPart(string(bs))},
+		},
+	}
+	return estellm.ExecuteInternal(ctx, subReq, w)
+}
+
+// draftWriter keeps the parts of the draft for the critic, and leaves the finish to the refine.
+type draftWriter struct {
+	estellm.ResponseWriter
+	parts []estellm.ContentPart
+}
+
+func (w *draftWriter) WritePart(parts ...estellm.ContentPart) error {
+	w.parts = append(w.parts, parts...)
+	return w.ResponseWriter.WritePart(parts...)
+}
+
+func (w *draftWriter) Finish(_ estellm.FinishReason, _ string) error {
+	return nil
+}
+
+func textOf(parts []estellm.ContentPart) string {
+	var text string
+	for _, part := range parts {
+		if part.Type == estellm.PartTypeText {
+			text += part.Text
+		}
+	}
+	return text
+}
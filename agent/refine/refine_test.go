@@ -0,0 +1,143 @@
+package refine_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	_ "github.com/mashiike/estellm/agent/gentext"
+	_ "github.com/mashiike/estellm/agent/refine"
+
+	"github.com/mashiike/estellm"
+	"github.com/mashiike/estellm/estellmtest"
+	"github.com/stretchr/testify/require"
+)
+
+func newPrompts(cfg string) map[string]string {
+	return map[string]string{
+		"essay.md": fmt.Sprintf(`{{ define "config" }}
+{
+  type: "refine",
+  generator: "writer",
+  critic: "critic",
+  %s
+}
+{{ end }}`, cfg),
+		"writer.md": `{{ define "config" }}
+{
+  type: "generate_text",
+  model_provider: "fake",
+  model_id: "writer-model",
+  payload_schema: { type: "object", properties: { topic: { type: "string" } }, required: ["topic"] },
+}
+{{ end }}
+{{- $state := (resolve "essay").result }}
+Write an essay about {{ .payload.topic }}.
+{{- with $state.critiques }}
+<critique>{{ last . }}</critique>
+{{- end }}`,
+		"critic.md": `{{ define "config" }}
+{
+  type: "generate_text",
+  model_provider: "fake",
+  model_id: "critic-model",
+}
+{{ end }}
+{{- $state := (resolve "essay").result }}
+Review the draft: {{ $state.draft }}`,
+	}
+}
+
+func TestRefine(t *testing.T) {
+	cases := []struct {
+		name       string
+		cfg        string
+		critiques  []string
+		iterations int
+		accepted   bool
+	}{
+		{"accepted", `max_iterations: 3,`, []string{`{"accept": false}`, `{"accept": true}`}, 2, true},
+		{"score threshold", `max_iterations: 3, score_threshold: 8,`, []string{`{"score": 5}`, `{"score": 8}`}, 2, true},
+		{"max iterations", `max_iterations: 2,`, []string{`not json`, `{"accept": false, "score": 9}`}, 2, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			provider := estellmtest.NewModelProvider()
+			for i, critique := range c.critiques {
+				provider.Enqueue("writer-model", estellmtest.TextTurn(fmt.Sprintf("draft %d", i+1)))
+				provider.Enqueue("critic-model", estellmtest.TextTurn(critique))
+			}
+			ctx := estellmtest.WithModelProvider(context.Background(), "fake", provider)
+			mux := estellmtest.NewAgentMux(t, ctx, estellmtest.MapFS(newPrompts(c.cfg)))
+			executions := estellmtest.RecordExecutions(mux)
+			w := estellmtest.Execute(t, ctx, mux, "essay", map[string]any{"topic": "cats"})
+			estellmtest.RequireAllServed(t, provider)
+			estellmtest.RequireExecuted(t, executions, "essay", "writer", "critic", "writer", "critic")
+
+			require.Equal(t, fmt.Sprintf("draft %d", c.iterations), w.Text())
+			require.Equal(t, "draft 1"+c.critiques[0], w.Reasoning())
+			m := w.Metadata()
+			iterations, _ := m.GetInt64("Refine-Iterations")
+			require.EqualValues(t, c.iterations, iterations)
+			accepted, _ := m.GetBool("Refine-Accepted")
+			require.Equal(t, c.accepted, accepted)
+			var drafts []string
+			require.NoError(t, json.Unmarshal([]byte(m.GetString("Refine-Drafts")), &drafts))
+			require.Equal(t, []string{"draft 1", "draft 2"}, drafts)
+
+			calls := provider.Calls()
+			require.Contains(t, calls[1].TextRequest.Messages[0].Parts[0].Text, "Review the draft: draft 1")
+			require.Contains(t, calls[2].TextRequest.Messages[0].Parts[0].Text, "<critique>"+c.critiques[0]+"</critique>")
+		})
+	}
+}
+
+// firstWriteRecorder records the number of the model calls when the first text part is written.
+type firstWriteRecorder struct {
+	*estellmtest.ResponseRecorder
+	provider *estellmtest.ModelProvider
+	calls    int
+}
+
+func (w *firstWriteRecorder) WritePart(parts ...estellm.ContentPart) error {
+	if w.calls == 0 {
+		w.calls = len(w.provider.Calls())
+	}
+	return w.ResponseRecorder.WritePart(parts...)
+}
+
+func TestRefine__StreamLastDraft(t *testing.T) {
+	provider := estellmtest.NewModelProvider()
+	provider.Enqueue("writer-model", estellmtest.TextTurn("draft 1"))
+	provider.Enqueue("critic-model", estellmtest.TextTurn(`{"accept": false}`))
+	ctx := estellmtest.WithModelProvider(context.Background(), "fake", provider)
+	mux := estellmtest.NewAgentMux(t, ctx, estellmtest.MapFS(newPrompts(`max_iterations: 1,`)))
+	req, err := estellm.NewRequest("essay", map[string]any{"topic": "cats"})
+	require.NoError(t, err)
+	w := &firstWriteRecorder{ResponseRecorder: estellmtest.NewResponseRecorder(), provider: provider}
+	require.NoError(t, mux.Execute(ctx, req, w))
+	// the last draft is written before the critic is called.
+	require.Equal(t, 1, w.calls)
+	require.Equal(t, "draft 1", w.Text())
+	require.True(t, w.Finished())
+}
+
+func TestRefine__InternalDependencies(t *testing.T) {
+	ctx := estellmtest.WithModelProvider(context.Background(), "fake", estellmtest.NewModelProvider())
+	mux := estellmtest.NewAgentMux(t, ctx, estellmtest.MapFS(newPrompts("")))
+	require.Contains(t, mux.ToMarkdown(), "-.->|internal|")
+
+	req, err := estellm.NewRequest("writer", map[string]any{"topic": "cats"})
+	require.NoError(t, err)
+	err = mux.Execute(ctx, req, estellmtest.NewResponseRecorder())
+	require.EqualError(t, err, "agent `writer` is an internal dependency of `essay`")
+
+	prompts := newPrompts("")
+	delete(prompts, "critic.md")
+	_, err = estellm.NewAgentMux(ctx,
+		estellm.WithPromptsFS(estellmtest.MapFS(prompts)),
+		estellm.WithIncludesFS(estellmtest.MapFS(nil)),
+	)
+	require.ErrorContains(t, err, "prompt `essay`: internal dependency `critic` not found")
+}
@@ -0,0 +1,111 @@
+package retrieve
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/mashiike/estellm"
+	"github.com/mashiike/estellm/retrieval"
+)
+
+const (
+	AgentName = "retrieve"
+)
+
+func init() {
+	err := estellm.RegisterAgent(AgentName, NewAgent)
+	if err != nil {
+		panic(fmt.Sprintf("failed to register agent %s: %v", AgentName, err))
+	}
+	err = estellm.SetAgentMarmaidNodeWrapper(AgentName, func(s string) string {
+		return fmt.Sprintf("[(%s)]", s)
+	})
+	if err != nil {
+		panic(fmt.Sprintf("failed to set marmaid node wrapper for agent %s: %v", AgentName, err))
+	}
+}
+
+type Config struct {
+	Index    string   `json:"index"`
+	TopK     int      `json:"top_k"`
+	MinScore *float64 `json:"min_score"`
+}
+
+type Agent struct {
+	p            *estellm.Prompt
+	cfg          *Config
+	newRetriever func() (*retrieval.Retriever, error)
+}
+
+func NewAgent(ctx context.Context, p *estellm.Prompt) (estellm.Agent, error) {
+	var cfg Config
+	if err := p.Config().Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("decode `retrieve` agent config: %w", err)
+	}
+	if cfg.Index == "" {
+		if path, ok := retrieval.IndexPathFromContext(ctx); ok {
+			cfg.Index = path
+		} else {
+			cfg.Index = resolveIndexPath(ctx, retrieval.DefaultIndexPath)
+		}
+	} else {
+		cfg.Index = resolveIndexPath(ctx, cfg.Index)
+	}
+	if cfg.TopK <= 0 {
+		cfg.TopK = retrieval.DefaultTopK
+	}
+	// the index is loaded lazily, so that prompts can be rendered before `estellm index` is run.
+	newRetriever := sync.OnceValues(func() (*retrieval.Retriever, error) {
+		idx, err := retrieval.LoadIndex(cfg.Index)
+		if err != nil {
+			return nil, fmt.Errorf("load index `%s`: %w", cfg.Index, err)
+		}
+		return retrieval.NewRetriever(context.WithoutCancel(ctx), idx)
+	})
+	return &Agent{
+		p:            p,
+		cfg:          &cfg,
+		newRetriever: newRetriever,
+	}, nil
+}
+
+// resolveIndexPath resolves a relative path against the project root, as --index-path is.
+func resolveIndexPath(ctx context.Context, path string) string {
+	root, ok := retrieval.ProjectRootFromContext(ctx)
+	if !ok || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(root, path)
+}
+
+func (a *Agent) Execute(ctx context.Context, req *estellm.Request, w estellm.ResponseWriter) error {
+	query, err := a.p.Render(ctx, req)
+	if err != nil {
+		return fmt.Errorf("render prompt: %w", err)
+	}
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return fmt.Errorf("rendered query is empty")
+	}
+	r, err := a.newRetriever()
+	if err != nil {
+		return err
+	}
+	results, err := r.Retrieve(ctx, query, a.cfg.TopK)
+	if err != nil {
+		return fmt.Errorf("retrieve: %w", err)
+	}
+	if a.cfg.MinScore != nil {
+		filtered := make([]retrieval.SearchResult, 0, len(results))
+		for _, result := range results {
+			if result.Score >= *a.cfg.MinScore {
+				filtered = append(filtered, result)
+			}
+		}
+		results = filtered
+	}
+	return retrieval.Output{Query: query, Chunks: results}.WriteTo(w)
+}
@@ -0,0 +1,141 @@
+package retrieve_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	_ "github.com/mashiike/estellm/agent/retrieve"
+
+	"github.com/mashiike/estellm"
+	"github.com/mashiike/estellm/estellmtest"
+	"github.com/mashiike/estellm/retrieval"
+	"github.com/stretchr/testify/require"
+)
+
+// keywordEmbedder embeds texts as keyword occurrence vectors.
+type keywordEmbedder struct {
+	estellm.ModelProvider
+	keywords []string
+	queries  []string
+}
+
+func (e *keywordEmbedder) GenerateEmbedding(_ context.Context, req *estellm.GenerateEmbeddingRequest) (*estellm.GenerateEmbeddingResponse, error) {
+	if req.InputType == estellm.EmbeddingInputTypeQuery {
+		e.queries = append(e.queries, req.Inputs...)
+	}
+	resp := &estellm.GenerateEmbeddingResponse{}
+	for _, input := range req.Inputs {
+		vec := make([]float32, len(e.keywords))
+		for i, keyword := range e.keywords {
+			vec[i] = float32(strings.Count(strings.ToLower(input), keyword))
+		}
+		resp.Embeddings = append(resp.Embeddings, vec)
+	}
+	return resp, nil
+}
+
+func newPrompts(cfg string) fstest.MapFS {
+	return estellmtest.MapFS(map[string]string{
+		"search.md": fmt.Sprintf(`{{ define "config" }}
+{
+  type: "retrieve",
+  payload_schema: { type: "object", properties: { question: { type: "string" } } },
+  %s
+}
+{{ end }}
+{{ .payload.question | default "" }}`, cfg),
+	})
+}
+
+func buildIndex(t *testing.T, ctx context.Context, path, prefix string) {
+	t.Helper()
+	indexer := &retrieval.Indexer{
+		ModelProvider: "keyword",
+		ModelID:       "dummy",
+	}
+	idx, err := indexer.Build(ctx, fstest.MapFS{
+		"apple.md":  {Data: []byte("Apple is red.")},
+		"banana.md": {Data: []byte("Banana is yellow.")},
+		"cherry.md": {Data: []byte("Cherry and apple are red.")},
+	}, prefix)
+	require.NoError(t, err)
+	require.NoError(t, idx.Save(path))
+}
+
+func sources(t *testing.T, w *estellmtest.ResponseRecorder) []string {
+	t.Helper()
+	var output retrieval.Output
+	require.NoError(t, json.Unmarshal([]byte(w.Text()), &output))
+	var ret []string
+	for _, chunk := range output.Chunks {
+		ret = append(ret, chunk.Source)
+	}
+	return ret
+}
+
+func TestRetrieve(t *testing.T) {
+	embedder := &keywordEmbedder{keywords: []string{"apple", "banana", "cherry"}}
+	ctx := estellmtest.WithModelProvider(context.Background(), "keyword", embedder)
+	root := t.TempDir()
+	buildIndex(t, ctx, filepath.Join(root, "custom.json"), "docs")
+	ctx = retrieval.WithProjectRoot(ctx, root)
+
+	cases := []struct {
+		name     string
+		cfg      string
+		expected []string
+	}{
+		{"top k", `index: "custom.json", top_k: 2,`, []string{"docs/apple.md", "docs/cherry.md"}},
+		{"min score", `index: "custom.json", min_score: 0.9,`, []string{"docs/apple.md"}},
+		{"absolute index", fmt.Sprintf(`index: %q, top_k: 1,`, filepath.Join(root, "custom.json")), []string{"docs/apple.md"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mux := estellmtest.NewAgentMux(t, ctx, newPrompts(c.cfg))
+			w := estellmtest.Execute(t, ctx, mux, "search", map[string]any{"question": " apple? "})
+			require.Equal(t, c.expected, sources(t, w))
+			require.True(t, w.Finished())
+		})
+	}
+	require.Equal(t, []string{"apple?", "apple?", "apple?"}, embedder.queries)
+}
+
+func TestRetrieve__DefaultIndex(t *testing.T) {
+	ctx := estellmtest.WithModelProvider(context.Background(), "keyword", &keywordEmbedder{keywords: []string{"apple", "banana", "cherry"}})
+	root := t.TempDir()
+	buildIndex(t, ctx, filepath.Join(root, retrieval.DefaultIndexPath), "docs")
+
+	mux := estellmtest.NewAgentMux(t, retrieval.WithProjectRoot(ctx, root), newPrompts(`top_k: 1,`))
+	w := estellmtest.Execute(t, ctx, mux, "search", map[string]any{"question": "banana"})
+	require.Equal(t, []string{"docs/banana.md"}, sources(t, w))
+
+	// the index path from the context is used as is.
+	other := filepath.Join(t.TempDir(), "index.json")
+	buildIndex(t, ctx, other, "other")
+	ctx = retrieval.WithIndexPath(retrieval.WithProjectRoot(ctx, root), other)
+	mux = estellmtest.NewAgentMux(t, ctx, newPrompts(`top_k: 1,`))
+	w = estellmtest.Execute(t, ctx, mux, "search", map[string]any{"question": "cherry"})
+	require.Equal(t, []string{"other/cherry.md"}, sources(t, w))
+}
+
+func TestRetrieve__Errors(t *testing.T) {
+	ctx := estellmtest.WithModelProvider(context.Background(), "keyword", &keywordEmbedder{keywords: []string{"apple"}})
+	root := t.TempDir()
+	ctx = retrieval.WithProjectRoot(ctx, root)
+	mux := estellmtest.NewAgentMux(t, ctx, newPrompts(`index: "missing.json",`))
+
+	req, err := estellm.NewRequest("search", map[string]any{"question": "apple"})
+	require.NoError(t, err)
+	err = mux.Execute(ctx, req, estellmtest.NewResponseRecorder())
+	require.ErrorContains(t, err, "load index `"+filepath.Join(root, "missing.json")+"`")
+
+	req, err = estellm.NewRequest("search", map[string]any{"question": "  "})
+	require.NoError(t, err)
+	err = mux.Execute(ctx, req, estellmtest.NewResponseRecorder())
+	require.ErrorContains(t, err, "rendered query is empty")
+}
@@ -0,0 +1,79 @@
+package summarize
+
+import (
+	"strings"
+	"sync"
+)
+
+// DefaultContextWindow is the context window in tokens for unknown models.
+const DefaultContextWindow = 8000
+
+var (
+	contextWindowsMu sync.RWMutex
+	contextWindows   = map[string]int{
+		"anthropic.claude-3":         200000,
+		"anthropic.claude-opus-4":    200000,
+		"anthropic.claude-sonnet-4":  200000,
+		"anthropic.claude-v2":        100000,
+		"anthropic.claude-instant":   100000,
+		"amazon.nova-micro":          128000,
+		"amazon.nova-lite":           300000,
+		"amazon.nova-pro":            300000,
+		"amazon.nova-premier":        1000000,
+		"amazon.titan-text-premier":  32000,
+		"amazon.titan-text":          8000,
+		"meta.llama3-1":              128000,
+		"meta.llama3-2":              128000,
+		"meta.llama3-3":              128000,
+		"meta.llama3":                8000,
+		"mistral.mistral-large-2407": 128000,
+		"mistral.mistral-large":      32000,
+		"mistral.":                   32000,
+		"cohere.command-r":           128000,
+		"deepseek.r1":                128000,
+		"claude-3":                   200000,
+		"claude-opus-4":              200000,
+		"claude-sonnet-4":            200000,
+		"gpt-4.1":                    1000000,
+		"gpt-4o":                     128000,
+		"gpt-4-turbo":                128000,
+		"gpt-4":                      8192,
+		"gpt-3.5-turbo":              16385,
+		"o1-mini":                    128000,
+		"o1":                         200000,
+		"o3":                         200000,
+		"o4-mini":                    200000,
+		"gemini-1.5":                 1000000,
+		"gemini-2":                   1000000,
+		"llama3.1":                   128000,
+		"llama3.2":                   128000,
+		"llama3":                     8000,
+	}
+)
+
+var crossRegionPrefixes = []string{"us.", "eu.", "apac.", "us-gov.", "global."}
+
+// SetContextWindow sets the context window in tokens for model IDs starting with prefix.
+func SetContextWindow(prefix string, tokens int) {
+	contextWindowsMu.Lock()
+	defer contextWindowsMu.Unlock()
+	contextWindows[prefix] = tokens
+}
+
+// ContextWindow returns the context window in tokens of the model, by the longest matching prefix.
+func ContextWindow(modelID string) int {
+	for _, prefix := range crossRegionPrefixes {
+		modelID = strings.TrimPrefix(modelID, prefix)
+	}
+	contextWindowsMu.RLock()
+	defer contextWindowsMu.RUnlock()
+	var matched string
+	tokens := DefaultContextWindow
+	for prefix, t := range contextWindows {
+		if strings.HasPrefix(modelID, prefix) && len(prefix) > len(matched) {
+			matched = prefix
+			tokens = t
+		}
+	}
+	return tokens
+}
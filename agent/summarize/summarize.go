@@ -0,0 +1,272 @@
+package summarize
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/mashiike/estellm"
+	"github.com/mashiike/estellm/textsplit"
+)
+
+const (
+	AgentName = "summarize"
+
+	MapBlockName    = "map"
+	ReduceBlockName = "reduce"
+)
+
+func init() {
+	err := estellm.RegisterAgent(AgentName, NewAgent)
+	if err != nil {
+		panic(fmt.Sprintf("failed to register agent %s: %v", AgentName, err))
+	}
+}
+
+type Config struct {
+	ModelProvider string         `json:"model_provider"`
+	ModelID       string         `json:"model_id"`
+	ModelParams   map[string]any `json:"model_params"`
+	Source        string         `json:"source"`
+	ChunkTokens   int            `json:"chunk_tokens"`
+	OverlapTokens *int           `json:"overlap_tokens"`
+	Concurrency   int            `json:"concurrency"`
+}
+
+const (
+	defaultConcurrency = 4
+	maxReduceDepth     = 8
+	charsPerToken      = 4
+)
+
+type Agent struct {
+	p             *estellm.Prompt
+	cfg           *Config
+	modelProvider estellm.ModelProvider
+	hasReduce     bool
+}
+
+func NewAgent(ctx context.Context, p *estellm.Prompt) (estellm.Agent, error) {
+	var cfg Config
+	promptCfg := p.Config()
+	if err := promptCfg.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("decode `summarize` agent config: %w", err)
+	}
+	if cfg.ModelProvider == "" {
+		return nil, fmt.Errorf("model_provider is required")
+	}
+	if cfg.ModelID == "" {
+		return nil, fmt.Errorf("model_id is required")
+	}
+	if cfg.Source != "" && !slices.Contains(promptCfg.DependsOn, cfg.Source) {
+		return nil, fmt.Errorf("source `%s` must be in depends_on", cfg.Source)
+	}
+	blocks := p.Blocks()
+	if !slices.Contains(blocks, MapBlockName) {
+		return nil, fmt.Errorf("`%s` block is required", MapBlockName)
+	}
+	if cfg.ChunkTokens == 0 {
+		cfg.ChunkTokens = ContextWindow(cfg.ModelID) / 2
+	}
+	if cfg.ChunkTokens < 0 {
+		return nil, fmt.Errorf("chunk_tokens must be positive")
+	}
+	if cfg.OverlapTokens == nil {
+		overlap := cfg.ChunkTokens / 10
+		cfg.OverlapTokens = &overlap
+	}
+	if *cfg.OverlapTokens < 0 || *cfg.OverlapTokens >= cfg.ChunkTokens {
+		return nil, fmt.Errorf("overlap_tokens must be between 0 and chunk_tokens")
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = defaultConcurrency
+	}
+	modelProvider, err := estellm.GetModelProvider(ctx, cfg.ModelProvider)
+	if err != nil {
+		return nil, fmt.Errorf("model_provider `%s`: %w", cfg.ModelProvider, err)
+	}
+	return &Agent{
+		p:             p,
+		cfg:           &cfg,
+		modelProvider: modelProvider,
+		hasReduce:     slices.Contains(blocks, ReduceBlockName),
+	}, nil
+}
+
+func (a *Agent) Execute(ctx context.Context, req *estellm.Request, w estellm.ResponseWriter) error {
+	input, err := a.input(ctx, req)
+	if err != nil {
+		return err
+	}
+	chunks := textsplit.Split(input, a.cfg.ChunkTokens*charsPerToken, *a.cfg.OverlapTokens*charsPerToken)
+	if len(chunks) == 0 {
+		return errors.New("input is empty")
+	}
+	w.Metadata().SetInt64("Summarize-Chunks", int64(len(chunks)))
+	// the blocks are decoded once before the fan-out, because rendering a block is not safe for concurrent use.
+	mapBlock, err := a.decodeBlock(ctx, req, MapBlockName)
+	if err != nil {
+		return err
+	}
+	if len(chunks) == 1 {
+		w.Metadata().SetInt64("Summarize-Reduce-Depth", 0)
+		return a.generate(ctx, req, mapBlock, document(chunks[0], 0, 1), w)
+	}
+	summaries, err := a.mapChunks(ctx, req, mapBlock, chunks, document)
+	if err != nil {
+		return fmt.Errorf("map: %w", err)
+	}
+	reduceBlock := mapBlock
+	if a.hasReduce {
+		reduceBlock, err = a.decodeBlock(ctx, req, ReduceBlockName)
+		if err != nil {
+			return err
+		}
+	}
+	for depth := 1; ; depth++ {
+		groups := a.group(summaries)
+		if len(groups) == 1 {
+			w.Metadata().SetInt64("Summarize-Reduce-Depth", int64(depth))
+			return a.generate(ctx, req, reduceBlock, groups[0], w)
+		}
+		if depth >= maxReduceDepth {
+			return fmt.Errorf("reduce: too deep, %d summaries remain", len(summaries))
+		}
+		summaries, err = a.mapChunks(ctx, req, reduceBlock, groups, func(s string, _, _ int) string {
+			return s
+		})
+		if err != nil {
+			return fmt.Errorf("reduce depth %d: %w", depth, err)
+		}
+	}
+}
+
+func (a *Agent) input(ctx context.Context, req *estellm.Request) (string, error) {
+	if a.cfg.Source != "" {
+		resp, ok := req.PreviousResults[a.cfg.Source]
+		if !ok {
+			return "", fmt.Errorf("result of source `%s` not found", a.cfg.Source)
+		}
+		return textOf(resp.Message.Parts), nil
+	}
+	_, msgs, err := a.p.Decode(ctx, req)
+	if err != nil {
+		return "", fmt.Errorf("decode prompt: %w", err)
+	}
+	var parts []estellm.ContentPart
+	for _, msg := range msgs {
+		parts = append(parts, msg.Parts...)
+	}
+	return textOf(parts), nil
+}
+
+func textOf(parts []estellm.ContentPart) string {
+	texts := make([]string, 0, len(parts))
+	for _, part := range parts {
+		switch {
+		case part.Type == estellm.PartTypeText:
+			texts = append(texts, part.Text)
+		case part.Type == estellm.PartTypeBinary && strings.HasPrefix(part.MIMEType, "text/"):
+			texts = append(texts, string(part.Data))
+		}
+	}
+	return strings.Join(texts, "\n\n")
+}
+
+func document(chunk string, i, n int) string {
+	return fmt.Sprintf("<document index=\"%d\" total=\"%d\">\n%s\n</document>", i+1, n, chunk)
+}
+
+// group joins summaries into groups that fit in the chunk size.
+func (a *Agent) group(summaries []string) []string {
+	budget := a.cfg.ChunkTokens * charsPerToken
+	var groups []string
+	var sb strings.Builder
+	for i, s := range summaries {
+		entry := fmt.Sprintf("<summary index=\"%d\">\n%s\n</summary>\n", i+1, strings.TrimSpace(s))
+		if sb.Len() > 0 && sb.Len()+len(entry) > budget {
+			groups = append(groups, sb.String())
+			sb.Reset()
+		}
+		sb.WriteString(entry)
+	}
+	if sb.Len() > 0 {
+		groups = append(groups, sb.String())
+	}
+	return groups
+}
+
+func (a *Agent) mapChunks(ctx context.Context, req *estellm.Request, b *block, inputs []string, format func(string, int, int) string) ([]string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make([]string, len(inputs))
+	errs := make([]error, len(inputs))
+	sem := make(chan struct{}, a.cfg.Concurrency)
+	var wg sync.WaitGroup
+	for i, input := range inputs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				errs[i] = ctx.Err()
+				return
+			}
+			defer func() { <-sem }()
+			batch := estellm.NewBatchResponseWriter()
+			if err := a.generate(ctx, req, b, format(input, i, len(inputs)), batch); err != nil {
+				errs[i] = fmt.Errorf("chunk %d: %w", i+1, err)
+				cancel()
+				return
+			}
+			results[i] = textOf(batch.Response().Message.Parts)
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// block is a decoded prompt block, shared by the goroutines of mapChunks without modification.
+type block struct {
+	system string
+	msgs   []estellm.Message
+}
+
+func (a *Agent) decodeBlock(ctx context.Context, req *estellm.Request, name string) (*block, error) {
+	system, msgs, err := a.p.DecodeBlock(ctx, name, req)
+	if err != nil {
+		return nil, fmt.Errorf("decode `%s` block: %w", name, err)
+	}
+	return &block{system: system, msgs: msgs}, nil
+}
+
+// messages returns a copy of the messages with the content appended to the last user message.
+func (b *block) messages(content string) []estellm.Message {
+	msgs := slices.Clone(b.msgs)
+	if n := len(msgs); n > 0 && msgs[n-1].Role == estellm.RoleUser {
+		msgs[n-1].Parts = append(slices.Clone(msgs[n-1].Parts), estellm.TextPart(content))
+		return msgs
+	}
+	return append(msgs, estellm.Message{
+		Role:  estellm.RoleUser,
+		Parts: []estellm.ContentPart{estellm.TextPart(content)},
+	})
+}
+
+func (a *Agent) generate(ctx context.Context, req *estellm.Request, b *block, content string, w estellm.ResponseWriter) error {
+	modelReq := &estellm.GenerateTextRequest{
+		ModelID:     a.cfg.ModelID,
+		ModelParams: a.cfg.ModelParams,
+		System:      b.system,
+		Messages:    b.messages(content),
+		Metadata:    req.Metadata,
+	}
+	return a.modelProvider.GenerateText(ctx, modelReq, w)
+}
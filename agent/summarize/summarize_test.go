@@ -0,0 +1,79 @@
+package summarize_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	_ "github.com/mashiike/estellm/agent/constant"
+	_ "github.com/mashiike/estellm/agent/summarize"
+
+	"github.com/mashiike/estellm"
+	"github.com/mashiike/estellm/estellmtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummarize__RefInMapBlock(t *testing.T) {
+	var lines []string
+	for i := range 8 {
+		lines = append(lines, fmt.Sprintf("line %02d of the long document. %s", i, strings.Repeat("word ", 15)))
+	}
+	prompts := estellmtest.MapFS(map[string]string{
+		"topic.md": `{{ define "config" }}
+{
+  type: "constant",
+}
+{{ end }}
+space exploration`,
+		"summary.md": `{{ define "config" }}
+{
+  type: "summarize",
+  model_provider: "fake",
+  model_id: "summary-model",
+  depends_on: ["topic"],
+  chunk_tokens: 64,
+  overlap_tokens: 0,
+  concurrency: 4,
+}
+{{ end }}
+{{ define "map" }}
+Summarize the document about {{ (ref "topic").result._raw }}.
+{{ end }}
+{{ define "reduce" }}
+Merge the summaries.
+{{ end }}
+<role:user/>` + strings.Join(lines, "\n"),
+	})
+	// 64 tokens are 256 bytes, so two lines fit in a chunk.
+	chunks := len(lines) / 2
+	provider := estellmtest.NewModelProvider()
+	for range chunks {
+		provider.Enqueue("summary-model", estellmtest.TextTurn("partial summary"))
+	}
+	provider.Enqueue("summary-model", estellmtest.TextTurn("final summary"))
+
+	ctx := estellmtest.WithModelProvider(context.Background(), "fake", provider)
+	mux := estellmtest.NewAgentMux(t, ctx, prompts)
+	w := estellmtest.Execute(t, ctx, mux, "topic", map[string]any{})
+
+	require.Equal(t, "final summary", w.Text())
+	estellmtest.RequireAllServed(t, provider)
+	calls := provider.Calls()
+	require.Len(t, calls, chunks+1)
+	for _, call := range calls[:chunks] {
+		msgs := call.TextRequest.Messages
+		require.Len(t, msgs, 1)
+		require.Len(t, msgs[0].Parts, 2, "the chunk is appended to a copy of the block messages")
+		require.Contains(t, msgs[0].Parts[0].Text, "space exploration", "rendered with ref")
+		require.Contains(t, msgs[0].Parts[1].Text, "<document index=")
+	}
+	last := calls[chunks].TextRequest.Messages
+	require.Len(t, last, 1)
+	require.Equal(t, estellm.RoleUser, last[0].Role)
+	require.Contains(t, last[0].Parts[0].Text, "Merge the summaries.")
+	require.Equal(t, chunks, strings.Count(last[0].Parts[1].Text, "<summary index="))
+	summary := w.Response()
+	n, _ := summary.Metadata.GetInt64("Summarize-Chunks")
+	require.EqualValues(t, chunks, n)
+}
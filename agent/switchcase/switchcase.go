@@ -0,0 +1,161 @@
+package switchcase
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/mashiike/estellm"
+	"github.com/mashiike/estellm/jsonutil"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+const (
+	AgentName = "switch"
+)
+
+func init() {
+	err := estellm.RegisterAgent(AgentName, NewAgent)
+	if err != nil {
+		panic(fmt.Sprintf("failed to register agent %s: %v", AgentName, err))
+	}
+	err = estellm.SetAgentMarmaidNodeWrapper(AgentName, func(s string) string {
+		return fmt.Sprintf("{%s}", s)
+	})
+	if err != nil {
+		panic(fmt.Sprintf("failed to set marmaid node wrapper for agent %s: %v", AgentName, err))
+	}
+}
+
+type Config struct {
+	Cases         []Case `json:"cases"`
+	FallbackAgent string `json:"fallback_agent"`
+}
+
+// Case matches when the Jsonnet expression `when` is true, or the value matches `schema`.
+// The value is the payload, or the result of `ref` if set, parsed as JSON if possible.
+type Case struct {
+	When   string         `json:"when"`
+	Schema map[string]any `json:"schema"`
+	Ref    string         `json:"ref"`
+	Target string         `json:"target"`
+}
+
+type Agent struct {
+	p       *estellm.Prompt
+	cfg     *Config
+	schemas []*gojsonschema.Schema
+}
+
+func NewAgent(_ context.Context, p *estellm.Prompt) (estellm.Agent, error) {
+	var cfg Config
+	promptCfg := p.Config()
+	if err := promptCfg.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("decode `switch` agent config: %w", err)
+	}
+	if len(cfg.Cases) == 0 {
+		return nil, errors.New("cases is required")
+	}
+	dependents := promptCfg.Dependents()
+	schemas := make([]*gojsonschema.Schema, len(cfg.Cases))
+	for i, c := range cfg.Cases {
+		if c.Target == "" {
+			return nil, fmt.Errorf("case[%d]: target is required", i)
+		}
+		if !slices.Contains(dependents, c.Target) {
+			return nil, fmt.Errorf("case[%d]: target `%s` is not a dependent agent", i, c.Target)
+		}
+		if (c.When == "") == (c.Schema == nil) {
+			return nil, fmt.Errorf("case[%d]: exactly one of when or schema is required", i)
+		}
+		if c.Ref != "" && !slices.Contains(promptCfg.DependsOn, c.Ref) {
+			return nil, fmt.Errorf("case[%d]: ref `%s` must be in depends_on", i, c.Ref)
+		}
+		if c.Schema != nil {
+			schema, err := gojsonschema.NewSchema(gojsonschema.NewGoLoader(c.Schema))
+			if err != nil {
+				return nil, fmt.Errorf("case[%d]: invalid schema: %w", i, err)
+			}
+			schemas[i] = schema
+		}
+	}
+	if cfg.FallbackAgent != "" && !slices.Contains(dependents, cfg.FallbackAgent) {
+		return nil, fmt.Errorf("fallback_agent `%s` is not a dependent agent", cfg.FallbackAgent)
+	}
+	return &Agent{
+		p:       p,
+		cfg:     &cfg,
+		schemas: schemas,
+	}, nil
+}
+
+func (a *Agent) Execute(ctx context.Context, req *estellm.Request, w estellm.ResponseWriter) error {
+	for i, c := range a.cfg.Cases {
+		matched, err := a.match(ctx, req, i, c)
+		if err != nil {
+			return fmt.Errorf("case[%d]: %w", i, err)
+		}
+		if !matched {
+			continue
+		}
+		w.Metadata().SetInt64("Switch-Case-Index", int64(i))
+		estellm.SetNextAgents(w, c.Target)
+		w.Finish(estellm.FinishReasonEndTurn, "select next agent")
+		return nil
+	}
+	if a.cfg.FallbackAgent == "" {
+		return errors.New("no case matched and fallback_agent is empty")
+	}
+	w.Metadata().SetInt64("Switch-Case-Index", -1)
+	estellm.SetNextAgents(w, a.cfg.FallbackAgent)
+	w.Finish(estellm.FinishReasonEndTurn, "select fallback agent")
+	return nil
+}
+
+func (a *Agent) match(ctx context.Context, req *estellm.Request, i int, c Case) (bool, error) {
+	value, err := a.value(req, c)
+	if err != nil {
+		return false, err
+	}
+	if c.When != "" {
+		bs, err := json.Marshal(value)
+		if err != nil {
+			return false, fmt.Errorf("marshal value: %w", err)
+		}
+		snippet := fmt.Sprintf("function(payload, previous_results) local value = %s; (%s)", bs, c.When)
+		jsonStr, err := a.p.EvaluateJsonnetSnippet(ctx, snippet, req)
+		if err != nil {
+			return false, fmt.Errorf("evaluate when: %w", err)
+		}
+		var matched bool
+		if err := json.Unmarshal([]byte(jsonStr), &matched); err != nil {
+			return false, fmt.Errorf("when must be boolean: %w", err)
+		}
+		return matched, nil
+	}
+	result, err := a.schemas[i].Validate(gojsonschema.NewGoLoader(value))
+	if err != nil {
+		return false, fmt.Errorf("validate schema: %w", err)
+	}
+	return result.Valid(), nil
+}
+
+// value returns the value matched by the case, shared by `when` and `schema`:
+// the payload, or the result of `ref` parsed as JSON, which is the text as is if it is not JSON.
+func (a *Agent) value(req *estellm.Request, c Case) (any, error) {
+	if c.Ref == "" {
+		return req.TemplateData()["payload"], nil
+	}
+	resp, ok := req.PreviousResults[c.Ref]
+	if !ok {
+		return nil, fmt.Errorf("ref `%s`: result not found", c.Ref)
+	}
+	str := resp.String()
+	var v any
+	if err := jsonutil.UnmarshalFirstJSON([]byte(str), &v); err != nil {
+		return str, nil
+	}
+	return v, nil
+}
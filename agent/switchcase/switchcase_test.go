@@ -0,0 +1,112 @@
+package switchcase_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	_ "github.com/mashiike/estellm/agent/constant"
+	_ "github.com/mashiike/estellm/agent/switchcase"
+
+	"github.com/mashiike/estellm"
+	"github.com/mashiike/estellm/estellmtest"
+	"github.com/stretchr/testify/require"
+)
+
+func newPrompts() map[string]string {
+	prompts := map[string]string{
+		"classify.md": `{{ define "config" }}
+{
+  type: "constant",
+  payload_schema: { type: "object", properties: { label: { type: "string" } } },
+}
+{{ end }}
+{"label": "{{ .payload.label }}"}`,
+		"router.md": `{{ define "config" }}
+{
+  type: "switch",
+  depends_on: ["classify"],
+  cases: [
+    { when: "std.get(value, 'kind') == 'bug'", target: "bug" },
+    { schema: { properties: { priority: { enum: ["high"] } }, required: ["priority"] }, target: "escalate" },
+    { ref: "classify", when: "value.label == 'spam'", target: "discard" },
+    { ref: "classify", schema: { properties: { label: { const: "question" } }, required: ["label"] }, target: "answer" },
+  ],
+  fallback_agent: "other",
+}
+{{ end }}`,
+	}
+	for _, target := range []string{"bug", "escalate", "discard", "answer", "other"} {
+		prompts[target+".md"] = fmt.Sprintf(`{{ define "config" }}
+{
+  type: "constant",
+  depends_on: ["router"],
+}
+{{ end }}
+%s`, target)
+	}
+	return prompts
+}
+
+func TestSwitch(t *testing.T) {
+	cases := []struct {
+		name     string
+		payload  map[string]any
+		expected string
+	}{
+		{"when on payload", map[string]any{"kind": "bug", "priority": "high", "label": "spam"}, "bug"},
+		{"schema on payload", map[string]any{"priority": "high", "label": "spam"}, "escalate"},
+		{"when on ref", map[string]any{"priority": "low", "label": "spam"}, "discard"},
+		{"schema on ref", map[string]any{"label": "question"}, "answer"},
+		{"fallback", map[string]any{"label": "other"}, "other"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := context.Background()
+			mux := estellmtest.NewAgentMux(t, ctx, estellmtest.MapFS(newPrompts()))
+			executions := estellmtest.RecordExecutions(mux)
+			w := estellmtest.Execute(t, ctx, mux, "classify", c.payload)
+			require.Equal(t, c.expected, strings.TrimSpace(w.Text()))
+			estellmtest.RequireExecuted(t, executions, "classify", "router", c.expected)
+		})
+	}
+}
+
+func TestSwitch__RefNotFound(t *testing.T) {
+	ctx := context.Background()
+	mux := estellmtest.NewAgentMux(t, ctx, estellmtest.MapFS(newPrompts()))
+	req, err := estellm.NewRequest("router", map[string]any{"label": "spam"})
+	require.NoError(t, err)
+	err = mux.Execute(ctx, req, estellmtest.NewResponseRecorder())
+	require.ErrorContains(t, err, "case[2]: ref `classify`: result not found")
+}
+
+func TestSwitch__InvalidConfig(t *testing.T) {
+	cases := []struct {
+		name     string
+		cases    string
+		expected string
+	}{
+		{"unknown target", `[{ when: "true", target: "unknown" }]`, "case[0]: target `unknown` is not a dependent agent"},
+		{"both when and schema", `[{ when: "true", schema: {}, target: "bug" }]`, "case[0]: exactly one of when or schema is required"},
+		{"ref not in depends_on", `[{ ref: "other", when: "true", target: "bug" }]`, "case[0]: ref `other` must be in depends_on"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			prompts := newPrompts()
+			prompts["router.md"] = fmt.Sprintf(`{{ define "config" }}
+{
+  type: "switch",
+  depends_on: ["classify"],
+  cases: %s,
+}
+{{ end }}`, c.cases)
+			_, err := estellm.NewAgentMux(context.Background(),
+				estellm.WithPromptsFS(estellmtest.MapFS(prompts)),
+				estellm.WithIncludesFS(estellmtest.MapFS(nil)),
+			)
+			require.ErrorContains(t, err, c.expected)
+		})
+	}
+}
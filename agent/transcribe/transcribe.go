@@ -0,0 +1,127 @@
+package transcribe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/mashiike/estellm"
+)
+
+const (
+	AgentName = "transcribe"
+)
+
+func init() {
+	err := estellm.RegisterAgent(AgentName, NewAgent)
+	if err != nil {
+		panic(fmt.Sprintf("failed to register agent %s: %v", AgentName, err))
+	}
+}
+
+type Config struct {
+	ModelProvider string         `json:"model_provider"`
+	ModelID       string         `json:"model_id"`
+	ModelParams   map[string]any `json:"model_params"`
+	Source        string         `json:"source"`
+}
+
+type Agent struct {
+	p                     *estellm.Prompt
+	cfg                   *Config
+	transcriptionProvider estellm.TranscriptionProvider
+}
+
+func NewAgent(ctx context.Context, p *estellm.Prompt) (estellm.Agent, error) {
+	var cfg Config
+	promptCfg := p.Config()
+	if err := promptCfg.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("decode `transcribe` agent config: %w", err)
+	}
+	if cfg.ModelProvider == "" {
+		return nil, fmt.Errorf("model_provider is required")
+	}
+	if cfg.ModelID == "" {
+		return nil, fmt.Errorf("model_id is required")
+	}
+	if cfg.Source != "" && !slices.Contains(promptCfg.DependsOn, cfg.Source) {
+		return nil, fmt.Errorf("source `%s` must be in depends_on", cfg.Source)
+	}
+	transcriptionProvider, err := estellm.GetTranscriptionProvider(ctx, cfg.ModelProvider)
+	if err != nil {
+		return nil, err
+	}
+	return &Agent{
+		p:                     p,
+		cfg:                   &cfg,
+		transcriptionProvider: transcriptionProvider,
+	}, nil
+}
+
+func (a *Agent) Execute(ctx context.Context, req *estellm.Request, w estellm.ResponseWriter) error {
+	parts, err := a.input(ctx, req)
+	if err != nil {
+		return err
+	}
+	var audios []estellm.ContentPart
+	for _, part := range parts {
+		if part.Type != estellm.PartTypeBinary {
+			continue
+		}
+		if strings.HasPrefix(part.MIMEType, "audio/") || strings.HasPrefix(part.MIMEType, "video/") {
+			audios = append(audios, part)
+		}
+	}
+	if len(audios) == 0 {
+		return errors.New("no audio part found")
+	}
+	texts := make([]string, 0, len(audios))
+	for i, audio := range audios {
+		modelReq := &estellm.TranscribeRequest{
+			ModelID:     a.cfg.ModelID,
+			ModelParams: a.cfg.ModelParams,
+			Audio:       audio,
+			Metadata:    req.Metadata,
+		}
+		batch := estellm.NewBatchResponseWriter()
+		if err := a.transcriptionProvider.Transcribe(ctx, modelReq, batch); err != nil {
+			return fmt.Errorf("transcribe audio[%d]: %w", i, err)
+		}
+		resp := batch.Response()
+		w.Metadata().MergeInPlace(resp.Metadata)
+		var sb strings.Builder
+		for _, part := range resp.Message.Parts {
+			if part.Type == estellm.PartTypeText {
+				sb.WriteString(part.Text)
+			}
+		}
+		texts = append(texts, strings.TrimSpace(sb.String()))
+	}
+	w.WriteRole(estellm.RoleAssistant)
+	if err := w.WritePart(estellm.TextPart(strings.Join(texts, "\n\n"))); err != nil {
+		return fmt.Errorf("write part: %w", err)
+	}
+	w.Finish(estellm.FinishReasonEndTurn, "transcribed")
+	return nil
+}
+
+func (a *Agent) input(ctx context.Context, req *estellm.Request) ([]estellm.ContentPart, error) {
+	if a.cfg.Source != "" {
+		resp, ok := req.PreviousResults[a.cfg.Source]
+		if !ok {
+			return nil, fmt.Errorf("result of source `%s` not found", a.cfg.Source)
+		}
+		return resp.Message.Parts, nil
+	}
+	_, msgs, err := a.p.Decode(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("decode prompt: %w", err)
+	}
+	var parts []estellm.ContentPart
+	for _, msg := range msgs {
+		parts = append(parts, msg.Parts...)
+	}
+	return parts, nil
+}
@@ -0,0 +1,46 @@
+package transform
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mashiike/estellm"
+)
+
+const (
+	AgentName = "transform"
+)
+
+func init() {
+	err := estellm.RegisterAgent(AgentName, NewAgent)
+	if err != nil {
+		panic(fmt.Sprintf("failed to register agent %s: %v", AgentName, err))
+	}
+	err = estellm.SetAgentMarmaidNodeWrapper(AgentName, func(s string) string {
+		return fmt.Sprintf("[/%s\\]", s)
+	})
+	if err != nil {
+		panic(fmt.Sprintf("failed to set marmaid node wrapper for agent %s: %v", AgentName, err))
+	}
+}
+
+type Agent struct {
+	p *estellm.Prompt
+}
+
+func NewAgent(_ context.Context, p *estellm.Prompt) (estellm.Agent, error) {
+	return &Agent{p: p}, nil
+}
+
+func (a *Agent) Execute(ctx context.Context, req *estellm.Request, w estellm.ResponseWriter) error {
+	jsonStr, err := a.p.EvaluateJsonnet(ctx, req)
+	if err != nil {
+		return fmt.Errorf("evaluate prompt: %w", err)
+	}
+	w.WriteRole(estellm.RoleAssistant)
+	if err := w.WritePart(estellm.TextPart(jsonStr)); err != nil {
+		return fmt.Errorf("write part: %w", err)
+	}
+	w.Finish(estellm.FinishReasonEndTurn, "evaluate jsonnet")
+	return nil
+}
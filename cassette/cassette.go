@@ -0,0 +1,395 @@
+package cassette
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/mashiike/estellm"
+	"github.com/mashiike/estellm/metadata"
+)
+
+const cassetteVersion = 1
+
+type Mode int
+
+const (
+	// ModeRecord calls the wrapped provider and saves the interactions.
+	ModeRecord Mode = iota
+	// ModeReplay serves the saved interactions without calling the wrapped provider, nor the tools.
+	ModeReplay
+	// ModeReplayWithTools serves the saved interactions as ModeReplay, but calls the tools with the recorded input,
+	// and fails if the result differs from the recorded one.
+	ModeReplayWithTools
+)
+
+var (
+	ErrInteractionNotFound = errors.New("recorded interaction not found")
+	ErrToolResultMismatch  = errors.New("tool result differs from the recording")
+)
+
+// redactedModelParams are not saved in cassettes and not used to match requests.
+var redactedModelParams = []string{"api_key", "endpoint"}
+
+// Cassette is a file of the interactions recorded for the same request.
+type Cassette struct {
+	Version      int           `json:"version"`
+	Request      Request       `json:"request"`
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Request is the part of a model request used to match the interactions.
+type Request struct {
+	Kind        string                   `json:"kind"`
+	ModelID     string                   `json:"model_id"`
+	ModelParams map[string]any           `json:"model_params,omitempty"`
+	System      string                   `json:"system,omitempty"`
+	Messages    []estellm.Message        `json:"messages"`
+	Tools       []string                 `json:"tools,omitempty"`
+	Guardrail   *estellm.GuardrailConfig `json:"guardrail,omitempty"`
+}
+
+type Interaction struct {
+	Events   []Event           `json:"events"`
+	Metadata metadata.Metadata `json:"metadata,omitempty"`
+	Error    string            `json:"error,omitempty"`
+}
+
+const (
+	EventTypeRole     = "role"
+	EventTypePart     = "part"
+	EventTypeToolCall = "tool_call"
+	EventTypeFinish   = "finish"
+)
+
+// Event is a call to the ResponseWriter, or a tool call made by the provider.
+type Event struct {
+	Type          string                `json:"type"`
+	Role          string                `json:"role,omitempty"`
+	Parts         []estellm.ContentPart `json:"parts,omitempty"`
+	ToolName      string                `json:"tool_name,omitempty"`
+	ToolUseID     string                `json:"tool_use_id,omitempty"`
+	ToolInput     any                   `json:"tool_input,omitempty"`
+	ToolResult    []estellm.ContentPart `json:"tool_result,omitempty"`
+	ToolError     string                `json:"tool_error,omitempty"`
+	FinishReason  estellm.FinishReason  `json:"finish_reason,omitempty"`
+	FinishMessage string                `json:"finish_message,omitempty"`
+}
+
+// Recorder records or replays the model provider calls in a directory.
+// The cassette file of a request is named by the hash of the request.
+// The same request can be recorded multiple times, and they are replayed in order.
+type Recorder struct {
+	dir       string
+	mode      Mode
+	mu        sync.Mutex
+	cassettes map[string]*Cassette
+	cursors   map[string]int
+}
+
+func New(dir string, mode Mode) *Recorder {
+	return &Recorder{
+		dir:       dir,
+		mode:      mode,
+		cassettes: make(map[string]*Cassette),
+		cursors:   make(map[string]int),
+	}
+}
+
+// Middleware wraps the model provider, it can be passed to ModelProviderManager.Use.
+// Only GenerateText and GenerateImage are recorded: the embedding, speech and transcription providers
+// are got from the ModelProviderManager without the middlewares, so they are neither recorded nor replayed.
+func (r *Recorder) Middleware(p estellm.ModelProvider) estellm.ModelProvider {
+	return &ModelProvider{recorder: r, provider: p}
+}
+
+type ModelProvider struct {
+	recorder *Recorder
+	provider estellm.ModelProvider
+}
+
+func (p *ModelProvider) GenerateText(ctx context.Context, req *estellm.GenerateTextRequest, w estellm.ResponseWriter) error {
+	creq := newRequest("text", req.ModelID, req.ModelParams, req.System, req.Messages)
+	for _, tool := range req.Tools {
+		creq.Tools = append(creq.Tools, tool.Name())
+	}
+	creq.Guardrail = req.Guardrail
+	if p.recorder.replaying() {
+		return p.recorder.replay(ctx, creq, req.Tools, w)
+	}
+	rw := newRecordingResponseWriter(w)
+	r := *req
+	if len(req.Tools) > 0 {
+		r.Tools = make(estellm.ToolSet, len(req.Tools))
+		for i, tool := range req.Tools {
+			r.Tools[i] = &recordingTool{Tool: tool, w: rw}
+		}
+	}
+	err := p.provider.GenerateText(ctx, &r, rw)
+	return p.recorder.record(creq, rw, err)
+}
+
+func (p *ModelProvider) GenerateImage(ctx context.Context, req *estellm.GenerateImageRequest, w estellm.ResponseWriter) error {
+	creq := newRequest("image", req.ModelID, req.ModelParams, req.System, req.Messages)
+	if p.recorder.replaying() {
+		return p.recorder.replay(ctx, creq, nil, w)
+	}
+	rw := newRecordingResponseWriter(w)
+	err := p.provider.GenerateImage(ctx, req, rw)
+	return p.recorder.record(creq, rw, err)
+}
+
+func newRequest(kind, modelID string, modelParams map[string]any, system string, messages []estellm.Message) Request {
+	params := maps.Clone(modelParams)
+	for _, key := range redactedModelParams {
+		delete(params, key)
+	}
+	if len(params) == 0 {
+		params = nil
+	}
+	return Request{
+		Kind:        kind,
+		ModelID:     modelID,
+		ModelParams: params,
+		System:      system,
+		Messages:    messages,
+	}
+}
+
+// key returns the hash of the request, the request is normalized through JSON before hashing.
+func (req Request) key() (string, error) {
+	bs, err := json.Marshal(req)
+	if err != nil {
+		return "", fmt.Errorf("marshal request: %w", err)
+	}
+	var v any
+	if err := json.Unmarshal(bs, &v); err != nil {
+		return "", fmt.Errorf("unmarshal request: %w", err)
+	}
+	bs, err = json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("marshal request: %w", err)
+	}
+	hash := sha256.Sum256(bs)
+	return hex.EncodeToString(hash[:])[:16], nil
+}
+
+func (r *Recorder) replaying() bool {
+	return r.mode == ModeReplay || r.mode == ModeReplayWithTools
+}
+
+func (r *Recorder) path(key string) string {
+	return filepath.Join(r.dir, key+".json")
+}
+
+func (r *Recorder) record(req Request, rw *recordingResponseWriter, callErr error) error {
+	key, err := req.key()
+	if err != nil {
+		return errors.Join(callErr, err)
+	}
+	interaction := rw.interaction()
+	if callErr != nil {
+		interaction.Error = callErr.Error()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// cassettes of the previous recording are overwritten
+	c, ok := r.cassettes[key]
+	if !ok {
+		c = &Cassette{Version: cassetteVersion, Request: req}
+		r.cassettes[key] = c
+	}
+	c.Interactions = append(c.Interactions, interaction)
+	bs, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return errors.Join(callErr, fmt.Errorf("marshal cassette: %w", err))
+	}
+	if err := os.MkdirAll(r.dir, 0755); err != nil {
+		return errors.Join(callErr, fmt.Errorf("create cassette dir: %w", err))
+	}
+	if err := os.WriteFile(r.path(key), bs, 0644); err != nil {
+		return errors.Join(callErr, fmt.Errorf("write cassette: %w", err))
+	}
+	return callErr
+}
+
+func (r *Recorder) next(req Request) (Interaction, error) {
+	key, err := req.key()
+	if err != nil {
+		return Interaction{}, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, ok := r.cassettes[key]
+	if !ok {
+		bs, err := os.ReadFile(r.path(key))
+		if errors.Is(err, os.ErrNotExist) {
+			return Interaction{}, fmt.Errorf("%w: %s request for model `%s` (%s) is not in %s", ErrInteractionNotFound, req.Kind, req.ModelID, key, r.dir)
+		}
+		if err != nil {
+			return Interaction{}, fmt.Errorf("read cassette: %w", err)
+		}
+		c = &Cassette{}
+		if err := json.Unmarshal(bs, c); err != nil {
+			return Interaction{}, fmt.Errorf("unmarshal cassette %s: %w", r.path(key), err)
+		}
+		r.cassettes[key] = c
+	}
+	cursor := r.cursors[key]
+	if cursor >= len(c.Interactions) {
+		return Interaction{}, fmt.Errorf("%w: %s request for model `%s` (%s) was recorded %d times, but called more", ErrInteractionNotFound, req.Kind, req.ModelID, key, len(c.Interactions))
+	}
+	r.cursors[key] = cursor + 1
+	return c.Interactions[cursor], nil
+}
+
+func (r *Recorder) replay(ctx context.Context, req Request, tools estellm.ToolSet, w estellm.ResponseWriter) error {
+	interaction, err := r.next(req)
+	if err != nil {
+		return err
+	}
+	for _, e := range interaction.Events {
+		switch e.Type {
+		case EventTypeRole:
+			if err := w.WriteRole(e.Role); err != nil {
+				return err
+			}
+		case EventTypePart:
+			if err := w.WritePart(e.Parts...); err != nil {
+				return err
+			}
+		case EventTypeToolCall:
+			if err := r.replayToolCall(ctx, tools, e); err != nil {
+				return err
+			}
+		case EventTypeFinish:
+			if err := w.Finish(e.FinishReason, e.FinishMessage); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unknown cassette event `%s`", e.Type)
+		}
+	}
+	w.Metadata().MergeInPlace(interaction.Metadata)
+	if interaction.Error != "" {
+		return errors.New(interaction.Error)
+	}
+	return nil
+}
+
+// replayToolCall checks the tool of the recorded call exists.
+// In ModeReplayWithTools, it also calls the tool with the recorded input, so that the tools which are agents are also executed,
+// and compares the result with the recorded one.
+func (r *Recorder) replayToolCall(ctx context.Context, tools estellm.ToolSet, e Event) error {
+	for _, tool := range tools {
+		if tool.Name() != e.ToolName {
+			continue
+		}
+		if r.mode != ModeReplayWithTools {
+			return nil
+		}
+		ctx = estellm.WithToolName(ctx, e.ToolName)
+		if e.ToolUseID != "" {
+			ctx = estellm.WithToolUseID(ctx, e.ToolUseID)
+		}
+		tw := &toolResultWriter{ResponseWriter: estellm.NewBatchResponseWriter()}
+		var toolErr string
+		if err := tool.Call(ctx, e.ToolInput, tw); err != nil {
+			toolErr = err.Error()
+		}
+		if toolErr != e.ToolError {
+			return fmt.Errorf("replay tool `%s`: %w: error `%s`, recorded `%s`", e.ToolName, ErrToolResultMismatch, toolErr, e.ToolError)
+		}
+		actual, err := json.Marshal(tw.parts)
+		if err != nil {
+			return fmt.Errorf("replay tool `%s`: marshal result: %w", e.ToolName, err)
+		}
+		expected, err := json.Marshal(e.ToolResult)
+		if err != nil {
+			return fmt.Errorf("replay tool `%s`: marshal recorded result: %w", e.ToolName, err)
+		}
+		if string(actual) != string(expected) {
+			return fmt.Errorf("replay tool `%s`: %w: %s, recorded %s", e.ToolName, ErrToolResultMismatch, actual, expected)
+		}
+		return nil
+	}
+	return fmt.Errorf("replay tool `%s`: tool not found", e.ToolName)
+}
+
+type recordingResponseWriter struct {
+	estellm.ResponseWriter
+	mu     sync.Mutex
+	events []Event
+}
+
+func newRecordingResponseWriter(w estellm.ResponseWriter) *recordingResponseWriter {
+	return &recordingResponseWriter{ResponseWriter: w}
+}
+
+func (w *recordingResponseWriter) append(e Event) int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.events = append(w.events, e)
+	return len(w.events) - 1
+}
+
+func (w *recordingResponseWriter) WriteRole(role string) error {
+	w.append(Event{Type: EventTypeRole, Role: role})
+	return w.ResponseWriter.WriteRole(role)
+}
+
+func (w *recordingResponseWriter) WritePart(parts ...estellm.ContentPart) error {
+	w.append(Event{Type: EventTypePart, Parts: parts})
+	return w.ResponseWriter.WritePart(parts...)
+}
+
+func (w *recordingResponseWriter) Finish(reason estellm.FinishReason, msg string) error {
+	w.append(Event{Type: EventTypeFinish, FinishReason: reason, FinishMessage: msg})
+	return w.ResponseWriter.Finish(reason, msg)
+}
+
+func (w *recordingResponseWriter) interaction() Interaction {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return Interaction{
+		Events:   w.events,
+		Metadata: w.Metadata().Clone(),
+	}
+}
+
+// recordingTool records the tool call at the position where it is started.
+type recordingTool struct {
+	estellm.Tool
+	w *recordingResponseWriter
+}
+
+func (t *recordingTool) Call(ctx context.Context, input any, w estellm.ResponseWriter) error {
+	toolUseID, _ := estellm.ToolUseIDFromContext(ctx)
+	index := t.w.append(Event{Type: EventTypeToolCall, ToolName: t.Name(), ToolUseID: toolUseID, ToolInput: input})
+	tw := &toolResultWriter{ResponseWriter: w}
+	err := t.Tool.Call(ctx, input, tw)
+	t.w.mu.Lock()
+	defer t.w.mu.Unlock()
+	t.w.events[index].ToolResult = tw.parts
+	if err != nil {
+		t.w.events[index].ToolError = err.Error()
+	}
+	return err
+}
+
+type toolResultWriter struct {
+	estellm.ResponseWriter
+	parts []estellm.ContentPart
+}
+
+func (w *toolResultWriter) WritePart(parts ...estellm.ContentPart) error {
+	w.parts = append(w.parts, parts...)
+	return w.ResponseWriter.WritePart(parts...)
+}
@@ -0,0 +1,132 @@
+package cassette_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mashiike/estellm"
+	"github.com/mashiike/estellm/cassette"
+	"github.com/stretchr/testify/require"
+)
+
+type weatherTool struct {
+	inputs []any
+}
+
+func (t *weatherTool) Name() string                { return "get_weather" }
+func (t *weatherTool) Description() string         { return "get the weather" }
+func (t *weatherTool) InputSchema() map[string]any { return map[string]any{"type": "object"} }
+func (t *weatherTool) Call(ctx context.Context, input any, w estellm.ResponseWriter) error {
+	t.inputs = append(t.inputs, input)
+	return w.WritePart(estellm.TextPart("sunny"))
+}
+
+// toolCallingProvider calls the first tool and answers with the result.
+type toolCallingProvider struct {
+	calls int
+}
+
+func (p *toolCallingProvider) GenerateText(ctx context.Context, req *estellm.GenerateTextRequest, w estellm.ResponseWriter) error {
+	p.calls++
+	w.WriteRole(estellm.RoleAssistant)
+	w.WritePart(estellm.ReasoningPart("Need the weather."))
+	tw := estellm.NewBatchResponseWriter()
+	ctx = estellm.WithToolUseID(estellm.WithToolName(ctx, req.Tools[0].Name()), "call_1")
+	if err := req.Tools[0].Call(ctx, map[string]any{"city": "Tokyo"}, tw); err != nil {
+		return err
+	}
+	w.WritePart(estellm.TextPart("It is " + tw.Response().String() + "."))
+	w.Metadata().SetInt64("Usage-Output-Tokens", 10)
+	return w.Finish(estellm.FinishReasonEndTurn, "")
+}
+
+func (p *toolCallingProvider) GenerateImage(_ context.Context, _ *estellm.GenerateImageRequest, _ estellm.ResponseWriter) error {
+	return estellm.ErrNotSupported
+}
+
+func newRequest(tool estellm.Tool) *estellm.GenerateTextRequest {
+	return &estellm.GenerateTextRequest{
+		ModelID:     "model",
+		ModelParams: map[string]any{"temperature": 0.1, "api_key": "secret"},
+		Messages: []estellm.Message{
+			{Role: estellm.RoleUser, Parts: []estellm.ContentPart{estellm.TextPart("How is the weather?")}},
+		},
+		Tools: estellm.ToolSet{tool},
+	}
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	dir := t.TempDir()
+	provider := &toolCallingProvider{}
+	recorded := &weatherTool{}
+	p := cassette.New(dir, cassette.ModeRecord).Middleware(provider)
+	w := estellm.NewBatchResponseWriter()
+	require.NoError(t, p.GenerateText(context.Background(), newRequest(recorded), w))
+	expected := w.Response()
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	bs, err := os.ReadFile(filepath.Join(dir, entries[0].Name()))
+	require.NoError(t, err)
+	require.NotContains(t, string(bs), "secret")
+
+	replayed := &weatherTool{}
+	p = cassette.New(dir, cassette.ModeReplay).Middleware(provider)
+	w = estellm.NewBatchResponseWriter()
+	req := newRequest(replayed)
+	req.ModelParams["api_key"] = "other"
+	require.NoError(t, p.GenerateText(context.Background(), req, w))
+	require.Equal(t, 1, provider.calls)
+	require.Empty(t, replayed.inputs)
+	actual := w.Response()
+	require.Equal(t, expected.Message, actual.Message)
+	require.Equal(t, expected.FinishReason, actual.FinishReason)
+	outputTokens, _ := actual.Metadata.GetInt64("Usage-Output-Tokens")
+	require.EqualValues(t, 10, outputTokens)
+
+	err = p.GenerateText(context.Background(), newRequest(replayed), estellm.NewBatchResponseWriter())
+	require.True(t, errors.Is(err, cassette.ErrInteractionNotFound))
+	require.ErrorContains(t, err, "was recorded 1 times")
+}
+
+func TestReplay__Mismatch(t *testing.T) {
+	provider := &toolCallingProvider{}
+	p := cassette.New(t.TempDir(), cassette.ModeReplay).Middleware(provider)
+	err := p.GenerateText(context.Background(), newRequest(&weatherTool{}), estellm.NewBatchResponseWriter())
+	require.True(t, errors.Is(err, cassette.ErrInteractionNotFound))
+	require.ErrorContains(t, err, "model `model`")
+	require.Zero(t, provider.calls)
+}
+
+type forecastTool struct {
+	weatherTool
+	forecast string
+}
+
+func (t *forecastTool) Call(ctx context.Context, input any, w estellm.ResponseWriter) error {
+	t.inputs = append(t.inputs, input)
+	return w.WritePart(estellm.TextPart(t.forecast))
+}
+
+func TestReplayWithTools(t *testing.T) {
+	dir := t.TempDir()
+	provider := &toolCallingProvider{}
+	p := cassette.New(dir, cassette.ModeRecord).Middleware(provider)
+	require.NoError(t, p.GenerateText(context.Background(), newRequest(&forecastTool{forecast: "sunny"}), estellm.NewBatchResponseWriter()))
+
+	same := &forecastTool{forecast: "sunny"}
+	p = cassette.New(dir, cassette.ModeReplayWithTools).Middleware(provider)
+	require.NoError(t, p.GenerateText(context.Background(), newRequest(same), estellm.NewBatchResponseWriter()))
+	require.Equal(t, []any{map[string]any{"city": "Tokyo"}}, same.inputs)
+
+	changed := &forecastTool{forecast: "rainy"}
+	p = cassette.New(dir, cassette.ModeReplayWithTools).Middleware(provider)
+	err := p.GenerateText(context.Background(), newRequest(changed), estellm.NewBatchResponseWriter())
+	require.ErrorIs(t, err, cassette.ErrToolResultMismatch)
+	require.ErrorContains(t, err, `"text":"rainy"`)
+	require.Equal(t, 1, provider.calls)
+}
@@ -8,31 +8,42 @@ import (
 	"log/slog"
 	"os"
 	"path/filepath"
+	"slices"
 
 	"github.com/alecthomas/kong"
 	"github.com/fatih/color"
 	"github.com/mark3labs/mcp-go/server"
 	"github.com/mashiike/estellm"
+	"github.com/mashiike/estellm/cassette"
 	"github.com/mashiike/estellm/jsonutil"
 	"github.com/mashiike/estellm/mcp"
+	"github.com/mashiike/estellm/retrieval"
 	"github.com/mashiike/slogutils"
 )
 
 type CLI struct {
-	LogFormat string            `help:"Log format" enum:"json,text" default:"json" env:"LOG_FORMAT"`
-	Color     bool              `help:"Enable color output" negatable:"" default:"true"`
-	Debug     bool              `help:"Enable debug mode" env:"DEBUG"`
-	MCPConfig string            `help:"MCP server configuration file path" env:"MCP_CONFIG" default:""`
-	ExtVar    map[string]string `help:"External variables external string values for Jsonnet" env:"EXT_VAR"`
-	ExtCode   map[string]string `help:"External code external string values for Jsonnet" env:"EXT_CODE"`
-	Project   string            `cmd:"" help:"Project directory" default:"./" env:"ESTELLM_PROJECT"`
-	Prompts   string            `cmd:"" help:"Prompts directory" default:"./prompts" env:"ESTELLM_PROMPTS"`
-	Includes  string            `cmd:"" help:"Includes directory" default:"./includes" env:"ESTELLM_INCLUDES"`
-	Exec      ExecOption        `cmd:"" help:"Execute the estellm"`
-	Render    RenderOption      `cmd:"" help:"Render prompt/config the estellm"`
-	Docs      DocsOptoin        `cmd:"" help:"Show agents documentation"`
-	Serve     ServeOption       `cmd:"" help:"Serve agents as MCP(Model Context Protocol) server"`
-	Version   struct{}          `cmd:"" help:"Show version"`
+	LogFormat   string            `help:"Log format" enum:"json,text" default:"json" env:"LOG_FORMAT"`
+	Color       bool              `help:"Enable color output" negatable:"" default:"true"`
+	Debug       bool              `help:"Enable debug mode" env:"DEBUG"`
+	MCPConfig   string            `help:"MCP server configuration file path" env:"MCP_CONFIG" default:""`
+	Config      string            `help:"Project configuration file path" env:"ESTELLM_CONFIG" default:""`
+	ExtVar      map[string]string `help:"External variables external string values for Jsonnet" env:"EXT_VAR"`
+	ExtCode     map[string]string `help:"External code external string values for Jsonnet" env:"EXT_CODE"`
+	Project     string            `cmd:"" help:"Project directory" default:"./" env:"ESTELLM_PROJECT"`
+	Prompts     string            `cmd:"" help:"Prompts directory" default:"./prompts" env:"ESTELLM_PROMPTS"`
+	Includes    string            `cmd:"" help:"Includes directory" default:"./includes" env:"ESTELLM_INCLUDES"`
+	IndexPath   string            `help:"Local vector index file path" default:".estellm/index.json" env:"ESTELLM_INDEX"`
+	Retrieve    bool              `help:"Add the retrieve_documents tool searching the local vector index" env:"ESTELLM_RETRIEVE"`
+	Record      string            `help:"Record model provider calls into the cassette directory" xor:"cassette" placeholder:"DIR" env:"ESTELLM_RECORD"`
+	Replay      string            `help:"Replay model provider calls from the cassette directory" xor:"cassette" placeholder:"DIR" env:"ESTELLM_REPLAY"`
+	ReplayTools bool              `help:"Call the tools again in replay, and fail if the results differ from the cassette" env:"ESTELLM_REPLAY_TOOLS"`
+	Exec        ExecOption        `cmd:"" help:"Execute the estellm"`
+	Render      RenderOption      `cmd:"" help:"Render prompt/config the estellm"`
+	Docs        DocsOptoin        `cmd:"" help:"Show agents documentation"`
+	Serve       ServeOption       `cmd:"" help:"Serve agents as MCP(Model Context Protocol) server"`
+	Index       IndexOption       `cmd:"" help:"Build local vector index for retrieve agents"`
+	Providers   ProvidersOption   `cmd:"" help:"Show model providers"`
+	Version     struct{}          `cmd:"" help:"Show version"`
 }
 
 func newLogger(level slog.Level, format string, c bool) *slog.Logger {
@@ -97,6 +108,25 @@ func (c *CLI) run(ctx context.Context, k *kong.Context, logger *slog.Logger) err
 		fmt.Printf("estellm version %s\n", estellm.Version)
 		return nil
 	}
+	switch cmd {
+	case "index", "index <sources>":
+		return c.runIndex(ctx, logger)
+	}
+	projectConfig, err := c.loadProjectConfig(ctx, logger)
+	if err != nil {
+		return fmt.Errorf("load project config: %w", err)
+	}
+	ctx, err = projectConfig.apply(ctx, c.Project)
+	if err != nil {
+		return fmt.Errorf("apply project config: %w", err)
+	}
+	switch cmd {
+	case "providers list":
+		return c.runProvidersList(ctx)
+	case "providers models <provider>":
+		return c.runProvidersModels(ctx)
+	}
+	ctx = c.applyCassette(ctx)
 	var tools []estellm.Tool
 	mcpMux, ok, err := c.newMCPClientMux(ctx, logger)
 	if err != nil {
@@ -113,6 +143,16 @@ func (c *CLI) run(ctx context.Context, k *kong.Context, logger *slog.Logger) err
 			}
 		}()
 	}
+	indexPath := c.indexPath()
+	ctx = retrieval.WithIndexPath(ctx, indexPath)
+	ctx = retrieval.WithProjectRoot(ctx, c.Project)
+	if c.Retrieve {
+		retrieveTool, err := c.newRetrieveTool(ctx, logger, indexPath)
+		if err != nil {
+			return fmt.Errorf("initialize retrieve tool: %w", err)
+		}
+		tools = append(tools, retrieveTool)
+	}
 	mux, err := c.newAgentMux(ctx, logger, tools)
 	if err != nil {
 		return fmt.Errorf("initialize: %w", err)
@@ -257,6 +297,114 @@ func (c *CLI) newAgentMux(ctx context.Context, logger *slog.Logger, tools []este
 	return estellm.NewAgentMux(ctx, opts...)
 }
 
+func (c *CLI) indexPath() string {
+	if filepath.IsAbs(c.IndexPath) {
+		return c.IndexPath
+	}
+	return filepath.Join(c.Project, c.IndexPath)
+}
+
+func (c *CLI) runIndex(ctx context.Context, logger *slog.Logger) error {
+	indexer := &retrieval.Indexer{
+		ModelProvider: c.Index.ModelProvider,
+		ModelID:       c.Index.ModelID,
+		ChunkSize:     c.Index.ChunkSize,
+		ChunkOverlap:  c.Index.ChunkOverlap,
+		BatchSize:     c.Index.BatchSize,
+		Patterns:      c.Index.Patterns,
+		Logger:        logger,
+	}
+	if c.Index.ModelParams != "" {
+		if err := json.Unmarshal([]byte(c.Index.ModelParams), &indexer.ModelParams); err != nil {
+			return fmt.Errorf("unmarshal model params: %w", err)
+		}
+	}
+	var idx *retrieval.Index
+	for _, source := range c.Index.Sources {
+		dir := source
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(c.Project, source)
+		}
+		if _, err := os.Stat(dir); err != nil {
+			return fmt.Errorf("source directory: %w", err)
+		}
+		logger.InfoContext(ctx, "index source", "source", dir)
+		if idx == nil {
+			var err error
+			idx, err = indexer.Build(ctx, os.DirFS(dir), source)
+			if err != nil {
+				return fmt.Errorf("build index: %w", err)
+			}
+			continue
+		}
+		if err := indexer.Append(ctx, idx, os.DirFS(dir), source); err != nil {
+			return fmt.Errorf("build index: %w", err)
+		}
+	}
+	if idx == nil {
+		return fmt.Errorf("no source directory")
+	}
+	indexPath := c.indexPath()
+	if err := idx.Save(indexPath); err != nil {
+		return fmt.Errorf("save index: %w", err)
+	}
+	logger.InfoContext(ctx, "index saved", "path", indexPath, "chunks", len(idx.Chunks))
+	return nil
+}
+
+func (c *CLI) runProvidersList(ctx context.Context) error {
+	_, m := estellm.WithModelProviderManager(ctx)
+	names := m.List()
+	slices.Sort(names)
+	for _, name := range names {
+		fmt.Println(name)
+	}
+	return nil
+}
+
+func (c *CLI) runProvidersModels(ctx context.Context) error {
+	lister, err := estellm.GetModelLister(ctx, c.Providers.Models.Provider)
+	if err != nil {
+		return err
+	}
+	req := &estellm.ListModelsRequest{}
+	if c.Providers.Models.ModelParams != "" {
+		if err := json.Unmarshal([]byte(c.Providers.Models.ModelParams), &req.ModelParams); err != nil {
+			return fmt.Errorf("unmarshal model params: %w", err)
+		}
+	}
+	models, err := lister.ListModels(ctx, req)
+	if err != nil {
+		return fmt.Errorf("list models: %w", err)
+	}
+	switch c.Providers.Models.OutputFormat {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(models); err != nil {
+			return fmt.Errorf("encode models: %w", err)
+		}
+	default:
+		for _, model := range models {
+			fmt.Println(model.ID)
+		}
+	}
+	return nil
+}
+
+func (c *CLI) newRetrieveTool(ctx context.Context, logger *slog.Logger, indexPath string) (estellm.Tool, error) {
+	logger.InfoContext(ctx, "load index", "path", indexPath)
+	idx, err := retrieval.LoadIndex(indexPath)
+	if err != nil {
+		return nil, err
+	}
+	r, err := retrieval.NewRetriever(ctx, idx)
+	if err != nil {
+		return nil, err
+	}
+	return retrieval.NewTool(retrieval.DefaultToolName, r)
+}
+
 var defaultMCPConfigFiles = []string{
 	"mcp.json",
 	"mcp.jsonnet",
@@ -355,9 +503,31 @@ type RenderOption struct {
 	Jsonnet bool   `help:"if render target is \"config\", render as jsonnet"`
 }
 
+type IndexOption struct {
+	Sources       []string `arg:"" help:"Source directories to index" default:"docs"`
+	ModelProvider string   `help:"Embedding model provider" default:"bedrock" env:"ESTELLM_EMBEDDING_PROVIDER"`
+	ModelID       string   `help:"Embedding model ID" default:"amazon.titan-embed-text-v2:0" env:"ESTELLM_EMBEDDING_MODEL_ID"`
+	ModelParams   string   `help:"Embedding model params as JSON" default:""`
+	Patterns      []string `help:"File name patterns to index" default:"*.md,*.mdx,*.txt"`
+	ChunkSize     int      `help:"Chunk size in characters" default:"1000"`
+	ChunkOverlap  int      `help:"Chunk overlap in characters" default:"200"`
+	BatchSize     int      `help:"Number of chunks per embedding request" default:"32"`
+}
+
 type DocsOptoin struct {
 }
 
+type ProvidersOption struct {
+	List   struct{}              `cmd:"" help:"List registered model providers and model aliases"`
+	Models ProvidersModelsOption `cmd:"" help:"List available models of the model provider"`
+}
+
+type ProvidersModelsOption struct {
+	Provider     string `arg:"" help:"Model provider name"`
+	ModelParams  string `help:"Model params as JSON, e.g. {\"endpoint\": \"http://localhost:11434\"}" default:""`
+	OutputFormat string `help:"Output format" enum:"json,text" default:"text"`
+}
+
 type ServeOption struct {
 	Transport  string `help:"Transport type" enum:"stdio,sse" default:"stdio" required:"" env:"ESTELLM_TRANSPORT" short:"t"`
 	ServerName string `help:"Server name" default:"estellm" env:"ESTELLM_SERVER_NAME"`
@@ -365,3 +535,23 @@ type ServeOption struct {
 	Port       int    `help:"Server port" default:"8080" env:"ESTELLM_SERVER_PORT"`
 	BaseURL    string `help:"Server base URL" default:"" env:"ESTELLM_SERVER_BASE_URL"`
 }
+
+// applyCassette wraps the model providers to record or replay the calls.
+func (c *CLI) applyCassette(ctx context.Context) context.Context {
+	var recorder *cassette.Recorder
+	switch {
+	case c.Record != "":
+		recorder = cassette.New(c.Record, cassette.ModeRecord)
+	case c.Replay != "":
+		mode := cassette.ModeReplay
+		if c.ReplayTools {
+			mode = cassette.ModeReplayWithTools
+		}
+		recorder = cassette.New(c.Replay, mode)
+	default:
+		return ctx
+	}
+	ctx, manager := estellm.WithModelProviderManager(ctx)
+	manager.Use(recorder.Middleware)
+	return ctx
+}
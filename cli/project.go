@@ -0,0 +1,97 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/mashiike/estellm"
+	"github.com/mashiike/estellm/jsonutil"
+)
+
+type ProjectConfig struct {
+	Commands CommandsConfig `json:"commands"`
+	// ModelAliases are registered as model providers that fall back to the models in order.
+	ModelAliases map[string][]estellm.FallbackModel `json:"model_aliases,omitempty"`
+}
+
+type CommandsConfig struct {
+	Allowlist []string `json:"allowlist"`
+}
+
+var defaultProjectConfigFiles = []string{
+	"estellm.json",
+	"estellm.jsonnet",
+}
+
+func (c *CLI) loadProjectConfig(ctx context.Context, logger *slog.Logger) (*ProjectConfig, error) {
+	var paths []string
+	if c.Config != "" {
+		if filepath.IsAbs(c.Config) {
+			paths = append(paths, c.Config)
+		} else {
+			paths = append(paths, filepath.Join(c.Project, c.Config))
+		}
+	} else {
+		for _, f := range defaultProjectConfigFiles {
+			paths = append(paths, filepath.Join(c.Project, f))
+		}
+	}
+	var configPath string
+	for _, p := range paths {
+		if _, err := os.Stat(p); err == nil {
+			configPath = p
+			break
+		}
+	}
+	var config ProjectConfig
+	if configPath == "" {
+		if c.Config != "" {
+			return nil, fmt.Errorf("project config not found: %s", c.Config)
+		}
+		return &config, nil
+	}
+	logger.InfoContext(ctx, "load project config", "config", configPath)
+	vm := jsonutil.MakeVM()
+	for key, value := range c.ExtVar {
+		vm.ExtVar(key, value)
+	}
+	for key, value := range c.ExtCode {
+		vm.ExtCode(key, value)
+	}
+	vm.ExtVar("projectRoot", c.Project)
+	jsonStr, err := vm.EvaluateFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("evaluate jsonnet: %w", err)
+	}
+	if err := json.Unmarshal([]byte(jsonStr), &config); err != nil {
+		return nil, fmt.Errorf("unmarshal config: %w", err)
+	}
+	logger.Debug("parsed project config", "config", config)
+	return &config, nil
+}
+
+func (cfg *ProjectConfig) apply(ctx context.Context, root string) (context.Context, error) {
+	ctx = estellm.WithCommandAllowlist(ctx, estellm.CommandAllowlist{
+		Root:     root,
+		Commands: cfg.Commands.Allowlist,
+	})
+	if len(cfg.ModelAliases) > 0 {
+		var m *estellm.ModelProviderManager
+		ctx, m = estellm.WithModelProviderManager(ctx)
+		for _, name := range slices.Sorted(maps.Keys(cfg.ModelAliases)) {
+			if m.Exists(name) {
+				return nil, fmt.Errorf("model alias `%s`: model provider already exists", name)
+			}
+			if err := m.RegisterFallback(name, cfg.ModelAliases[name]); err != nil {
+				return nil, fmt.Errorf("model_aliases: %w", err)
+			}
+		}
+	}
+	return ctx, nil
+}
@@ -8,13 +8,27 @@ import (
 	"github.com/mashiike/estellm/cli"
 
 	//builtin agents import
+	_ "github.com/mashiike/estellm/agent/command"
 	_ "github.com/mashiike/estellm/agent/constant"
 	_ "github.com/mashiike/estellm/agent/decision"
+	_ "github.com/mashiike/estellm/agent/ensemble"
+	_ "github.com/mashiike/estellm/agent/evaluate"
 	_ "github.com/mashiike/estellm/agent/genimage"
+	_ "github.com/mashiike/estellm/agent/genspeech"
 	_ "github.com/mashiike/estellm/agent/gentext"
+	_ "github.com/mashiike/estellm/agent/httpcall"
+	_ "github.com/mashiike/estellm/agent/refine"
+	_ "github.com/mashiike/estellm/agent/retrieve"
+	_ "github.com/mashiike/estellm/agent/summarize"
+	_ "github.com/mashiike/estellm/agent/switchcase"
+	_ "github.com/mashiike/estellm/agent/transcribe"
+	_ "github.com/mashiike/estellm/agent/transform"
 
 	//builtin providers import
+	_ "github.com/mashiike/estellm/provider/anthropic"
 	_ "github.com/mashiike/estellm/provider/bedrock"
+	_ "github.com/mashiike/estellm/provider/gemini"
+	_ "github.com/mashiike/estellm/provider/ollama"
 	_ "github.com/mashiike/estellm/provider/openai"
 )
 
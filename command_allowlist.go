@@ -0,0 +1,28 @@
+package estellm
+
+import (
+	"context"
+	"slices"
+)
+
+// CommandAllowlist is the commands that the command agents are allowed to run.
+// An entry without a path separator matches the command name exactly,
+// and an entry with a path is resolved against Root (the current directory if empty) and matches the same path.
+type CommandAllowlist struct {
+	Root     string
+	Commands []string
+}
+
+var commandAllowlistContextKey = contextKey("command_allowlist")
+
+// WithCommandAllowlist sets the CommandAllowlist read by the command agents.
+func WithCommandAllowlist(ctx context.Context, allowlist CommandAllowlist) context.Context {
+	allowlist.Commands = slices.Clone(allowlist.Commands)
+	return context.WithValue(ctx, commandAllowlistContextKey, allowlist)
+}
+
+// CommandAllowlistFromContext returns the CommandAllowlist set by WithCommandAllowlist.
+func CommandAllowlistFromContext(ctx context.Context) (CommandAllowlist, bool) {
+	allowlist, ok := ctx.Value(commandAllowlistContextKey).(CommandAllowlist)
+	return allowlist, ok
+}
@@ -28,6 +28,8 @@ type decodeState struct {
 	current              Message
 	lastChangeRole       string
 	lastRoleChangeOffset int64
+	guardQualifiers      []string
+	inGuard              bool
 	result               []Message
 }
 
@@ -76,6 +78,13 @@ func (s *decodeState) decodeToken(t xml.Token, inputOffset int64) error {
 	case xml.CharData:
 		s.textBuffer.WriteString(string(se))
 	case xml.StartElement:
+		if s.inGuard {
+			name := se.Name.Local
+			if se.Name.Space != "" {
+				name = se.Name.Space + ":" + name
+			}
+			return fmt.Errorf("<guard> accepts only text, got <%s>: %w", name, ErrInvalidMessageContent)
+		}
 		switch {
 		case se.Name.Space == "role":
 			if se.Name.Local != RoleUser && se.Name.Local != RoleAssistant {
@@ -106,6 +115,18 @@ func (s *decodeState) decodeToken(t xml.Token, inputOffset int64) error {
 			}
 			s.fulashTextBuffer()
 			s.current.Parts = append(s.current.Parts, part)
+		case se.Name.Space == "" && (se.Name.Local == "cache" || se.Name.Local == "cache_point"):
+			s.fulashTextBuffer()
+			s.current.Parts = append(s.current.Parts, CachePointPart())
+		case se.Name.Space == "" && se.Name.Local == "guard":
+			s.fulashTextBuffer()
+			s.inGuard = true
+			s.guardQualifiers = nil
+			for _, attr := range se.Attr {
+				if attr.Name.Local == "qualifiers" && attr.Value != "" {
+					s.guardQualifiers = strings.Split(attr.Value, ",")
+				}
+			}
 		default:
 			s.enc.EncodeToken(t)
 			s.enc.Flush()
@@ -118,6 +139,15 @@ func (s *decodeState) decodeToken(t xml.Token, inputOffset int64) error {
 			}
 		case se.Name.Local == "binary":
 			// do nothing
+		case se.Name.Space == "" && (se.Name.Local == "cache" || se.Name.Local == "cache_point"):
+			// do nothing
+		case se.Name.Space == "" && se.Name.Local == "guard":
+			s.inGuard = false
+			text := strings.TrimSpace(s.textBuffer.String())
+			s.textBuffer.Reset()
+			if text != "" {
+				s.current.Parts = append(s.current.Parts, GuardContentPart(text, s.guardQualifiers...))
+			}
 		default:
 			s.enc.EncodeToken(t)
 			s.enc.Flush()
@@ -157,11 +187,20 @@ func (s *decodeState) complete() (string, []Message, error) {
 	onlyText := true
 	var systemPromptBuffer strings.Builder
 	for _, part := range s.result[0].Parts {
-		if part.Type != PartTypeText {
+		switch part.Type {
+		case PartTypeText:
+			systemPromptBuffer.WriteString(part.Text)
+		case PartTypeCachePoint:
+			// cache points and guard contents are kept in the system prompt as markup
+			systemPromptBuffer.WriteString(CachePointMarkup)
+		case PartTypeGuardContent:
+			systemPromptBuffer.WriteString(guardMarkup(part))
+		default:
 			onlyText = false
+		}
+		if !onlyText {
 			break
 		}
-		systemPromptBuffer.WriteString(part.Text)
 	}
 	if !onlyText {
 		s.result[0].Role = RoleUser
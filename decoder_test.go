@@ -169,3 +169,90 @@ func TestParseSrcURL(t *testing.T) {
 		})
 	}
 }
+
+func TestMessageDecoder__CachePoint(t *testing.T) {
+	text := `
+this is long system message
+<cache/>
+<role:user/>This is few-shot example.
+<role:assistant/>This is few-shot answer.<cache_point/>
+<role:user/>This is user message.
+	`
+	dec := estellm.NewMessageDecoder(strings.NewReader(text))
+	system, messages, err := dec.Decode()
+	require.NoError(t, err)
+	excepted := []estellm.Message{
+		{
+			Role: estellm.RoleUser,
+			Parts: []estellm.ContentPart{
+				estellm.TextPart("This is few-shot example."),
+			},
+		},
+		{
+			Role: estellm.RoleAssistant,
+			Parts: []estellm.ContentPart{
+				estellm.TextPart("This is few-shot answer."),
+				estellm.CachePointPart(),
+			},
+		},
+		{
+			Role: estellm.RoleUser,
+			Parts: []estellm.ContentPart{
+				estellm.TextPart("This is user message."),
+			},
+		},
+	}
+	require.EqualValues(t, excepted, messages)
+	require.Equal(t, "this is long system message<cache_point/>", system)
+	require.Equal(t, []estellm.ContentPart{
+		estellm.TextPart("this is long system message"),
+		estellm.CachePointPart(),
+	}, estellm.SplitSystemPrompt(system))
+	require.Equal(t, "this is long system message", estellm.PlainSystemPrompt(system))
+}
+
+func TestMessageDecoder__Guard(t *testing.T) {
+	text := `
+Answer with the source. <guard qualifiers="grounding_source">Tokyo is the capital of Japan.</guard>
+<role:user/>Check this: <guard>What is the capital of Japan?</guard>
+	`
+	dec := estellm.NewMessageDecoder(strings.NewReader(text))
+	system, messages, err := dec.Decode()
+	require.NoError(t, err)
+	excepted := []estellm.Message{
+		{
+			Role: estellm.RoleUser,
+			Parts: []estellm.ContentPart{
+				estellm.TextPart("Check this:"),
+				estellm.GuardContentPart("What is the capital of Japan?"),
+			},
+		},
+	}
+	require.EqualValues(t, excepted, messages)
+	require.Equal(t, `Answer with the source.<guard qualifiers="grounding_source">Tokyo is the capital of Japan.</guard>`, system)
+	require.Equal(t, []estellm.ContentPart{
+		estellm.TextPart("Answer with the source."),
+		estellm.GuardContentPart("Tokyo is the capital of Japan.", "grounding_source"),
+	}, estellm.SplitSystemPrompt(system))
+	require.Equal(t, "Answer with the source.\nTokyo is the capital of Japan.", estellm.PlainSystemPrompt(system))
+}
+
+func TestMessageDecoder__NestedGuard(t *testing.T) {
+	cases := []struct {
+		name     string
+		text     string
+		expected string
+	}{
+		{"guard", `<guard>a <guard>b</guard></guard>`, "<guard> accepts only text, got <guard>"},
+		{"cache point", `<guard>a<cache/>b</guard>`, "<guard> accepts only text, got <cache>"},
+		{"role", `<guard>a<role:assistant/>b</guard>`, "<guard> accepts only text, got <role:assistant>"},
+		{"element", `<guard>a <b>b</b></guard>`, "<guard> accepts only text, got <b>"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, _, err := estellm.NewMessageDecoder(strings.NewReader(c.text)).Decode()
+			require.ErrorIs(t, err, estellm.ErrInvalidMessageContent)
+			require.ErrorContains(t, err, c.expected)
+		})
+	}
+}
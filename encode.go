@@ -107,6 +107,10 @@ func (e *MessageEncoder) EncodeContentPart(part ContentPart) error {
 			if err := writeFile(filePath, part.Data); err != nil {
 				return fmt.Errorf("write binary part to file: %w", err)
 			}
+			if strings.HasPrefix(part.MIMEType, "audio/") {
+				fmt.Fprintf(e.w, "[audio](%s)", filePath)
+				return nil
+			}
 			fmt.Fprintf(e.w, "![binary](%s)", filePath)
 			return nil
 		}
@@ -117,6 +121,25 @@ func (e *MessageEncoder) EncodeContentPart(part ContentPart) error {
 		}
 		fmt.Fprintf(e.w, "<binary src=\"%s\"/>", dataURL)
 		return nil
+	case PartTypeCachePoint:
+		if err := e.Flush(); err != nil {
+			return fmt.Errorf("flush on cache point part: %w", err)
+		}
+		if e.textOnly {
+			return nil
+		}
+		fmt.Fprint(e.w, CachePointMarkup)
+		return nil
+	case PartTypeGuardContent:
+		if err := e.Flush(); err != nil {
+			return fmt.Errorf("flush on guard content part: %w", err)
+		}
+		if e.textOnly {
+			fmt.Fprint(e.w, part.Text)
+			return nil
+		}
+		fmt.Fprint(e.w, guardMarkup(part))
+		return nil
 	case PartTypeReasoning:
 		if e.skipReasoning {
 			return nil
@@ -148,8 +171,31 @@ func (e *MessageEncoder) Flush() error {
 	return nil
 }
 
+// FileExtensions maps the MIME types of audio and video parts to the file extensions.
+var FileExtensions = map[string]string{
+	"audio/mpeg":  "mp3",
+	"audio/mp3":   "mp3",
+	"audio/mp4":   "m4a",
+	"audio/m4a":   "m4a",
+	"audio/x-m4a": "m4a",
+	"audio/wav":   "wav",
+	"audio/x-wav": "wav",
+	"audio/wave":  "wav",
+	"audio/webm":  "webm",
+	"audio/ogg":   "ogg",
+	"audio/flac":  "flac",
+	"audio/aac":   "aac",
+	"audio/pcm":   "pcm",
+	"video/mp4":   "mp4",
+	"video/webm":  "webm",
+}
+
 func generateFileName(mimeType string, name string) string {
-	ext := strings.Split(mimeType, "/")[1]
+	mimeType, _, _ = strings.Cut(mimeType, ";")
+	ext, ok := FileExtensions[mimeType]
+	if !ok {
+		ext = strings.Split(mimeType, "/")[1]
+	}
 	if name != "" {
 		name = strings.TrimSuffix(path.Base(name), path.Ext(name))
 	} else {
@@ -201,3 +201,24 @@ func TestMessageEncoder__EncodeBinaryToFile(t *testing.T) {
 what is this?`
 	require.Equal(t, fmt.Sprintf(expected, matches[1]), buf.String())
 }
+
+func TestMessageEncoder__EncodeAudioToFile(t *testing.T) {
+	messages := []estellm.Message{
+		{
+			Role: estellm.RoleAssistant,
+			Parts: []estellm.ContentPart{
+				estellm.BinaryPart("audio/mpeg", []byte{0x49, 0x44, 0x33}),
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	enc := estellm.NewMessageEncoder(&buf)
+	dir := t.TempDir()
+	enc.SetBinaryOutputDir(dir)
+	err := enc.Encode("", messages)
+	require.NoError(t, err)
+
+	re := regexp.MustCompile(`^<role:assistant/>\[audio\]\(` + regexp.QuoteMeta(dir) + `/([a-zA-Z0-9]+\.mp3)\)$`)
+	require.Regexp(t, re, buf.String())
+}
@@ -0,0 +1,92 @@
+// Package estellmtest provides utilities for testing agents and prompts without calling the model providers.
+package estellmtest
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+
+	"github.com/mashiike/estellm"
+	"github.com/stretchr/testify/require"
+)
+
+// MapFS returns a fstest.MapFS of the file contents keyed by path.
+func MapFS(files map[string]string) fstest.MapFS {
+	fsys := make(fstest.MapFS, len(files))
+	for name, content := range files {
+		fsys[name] = &fstest.MapFile{Data: []byte(content)}
+	}
+	return fsys
+}
+
+// WithModelProvider returns a context in which provider is registered as name.
+// The agents must be created by NewAgentMux with the returned context to use the provider.
+func WithModelProvider(ctx context.Context, name string, provider estellm.ModelProvider) context.Context {
+	ctx, manager := estellm.WithModelProviderManager(ctx)
+	if err := manager.Register(name, provider); err != nil {
+		panic(err)
+	}
+	return ctx
+}
+
+// NewAgentMux returns a validated AgentMux of the prompts, the includes are empty unless an option sets them.
+func NewAgentMux(t testing.TB, ctx context.Context, prompts fstest.MapFS, optFns ...estellm.NewAgentMuxOption) *estellm.AgentMux {
+	t.Helper()
+	opts := append([]estellm.NewAgentMuxOption{
+		estellm.WithPromptsFS(prompts),
+		estellm.WithIncludesFS(fstest.MapFS{}),
+	}, optFns...)
+	mux, err := estellm.NewAgentMux(ctx, opts...)
+	require.NoError(t, err, "new agent mux")
+	require.NoError(t, mux.Validate(), "validate agent mux")
+	return mux
+}
+
+// Execute executes the agent with the payload and returns the recorded response.
+func Execute(t testing.TB, ctx context.Context, mux *estellm.AgentMux, name string, payload any) *ResponseRecorder {
+	t.Helper()
+	req, err := estellm.NewRequest(name, payload)
+	require.NoError(t, err)
+	w := NewResponseRecorder()
+	require.NoError(t, mux.Execute(ctx, req, w), "execute `%s`", name)
+	return w
+}
+
+// RequireRendered asserts the rendered prompt of the agent for the payload.
+func RequireRendered(t testing.TB, mux *estellm.AgentMux, name string, payload any, expected string) {
+	t.Helper()
+	req, err := estellm.NewRequest(name, payload)
+	require.NoError(t, err)
+	actual, err := mux.Render(context.Background(), req)
+	require.NoError(t, err, "render `%s`", name)
+	require.Equal(t, expected, actual, "rendered prompt of `%s`", name)
+}
+
+// RequireToolCalls asserts the names and inputs of the tool calls made through the provider in order.
+func RequireToolCalls(t testing.TB, provider *ModelProvider, expected ...ToolCall) {
+	t.Helper()
+	var actual []ToolCall
+	for _, call := range provider.Calls() {
+		for _, toolCall := range call.ToolCalls {
+			require.NoError(t, toolCall.Err, "tool `%s`", toolCall.Name)
+			actual = append(actual, ToolCall{Name: toolCall.Name, Input: toolCall.Input})
+		}
+	}
+	normalized := make([]ToolCall, len(expected))
+	for i, toolCall := range expected {
+		normalized[i] = ToolCall{Name: toolCall.Name, Input: toolCall.Input}
+	}
+	require.Equal(t, normalized, actual, "tool calls")
+}
+
+// RequireExecuted asserts the agents executed in order.
+func RequireExecuted(t testing.TB, recorder *ExecutionRecorder, expected ...string) {
+	t.Helper()
+	require.Equal(t, expected, recorder.Executed(), "executed agents")
+}
+
+// RequireAllServed asserts that all the scripted responses are served.
+func RequireAllServed(t testing.TB, provider *ModelProvider) {
+	t.Helper()
+	require.Zero(t, provider.Remaining(), "scripted responses are not served")
+}
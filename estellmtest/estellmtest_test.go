@@ -0,0 +1,110 @@
+package estellmtest_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	_ "github.com/mashiike/estellm/agent/decision"
+	_ "github.com/mashiike/estellm/agent/gentext"
+
+	"github.com/mashiike/estellm"
+	"github.com/mashiike/estellm/estellmtest"
+	"github.com/stretchr/testify/require"
+)
+
+var prompts = estellmtest.MapFS(map[string]string{
+	"router.md": `{{ define "config" }}
+{
+  type: "decision",
+  model_provider: "fake",
+  model_id: "router-model",
+  payload_schema: { type: "object", properties: { question: { type: "string" } }, required: ["question"] },
+}
+{{ end }}
+Route the question: {{ .payload.question }}
+`,
+	"weather.md": `{{ define "config" }}
+{
+  type: "generate_text",
+  model_provider: "fake",
+  model_id: "chat-model",
+  depends_on: ["router"],
+  tools: ["forecast"],
+  payload_schema: { type: "object", properties: { question: { type: "string" } }, required: ["question"] },
+}
+{{ end }}
+Answer the weather question: {{ .payload.question }}
+`,
+	"smalltalk.md": `{{ define "config" }}
+{
+  type: "generate_text",
+  model_provider: "fake",
+  model_id: "chat-model",
+  depends_on: ["router"],
+  payload_schema: { type: "object", properties: { question: { type: "string" } }, required: ["question"] },
+}
+{{ end }}
+Chat about: {{ .payload.question }}
+`,
+	"forecast.md": `{{ define "config" }}
+{
+  type: "generate_text",
+  model_provider: "fake",
+  model_id: "forecast-model",
+  payload_schema: { type: "object", properties: { city: { type: "string" } }, required: ["city"] },
+}
+{{ end }}
+Forecast for {{ .payload.city }}
+`,
+})
+
+func TestAgentMux(t *testing.T) {
+	provider := estellmtest.NewModelProvider()
+	provider.Enqueue("router-model", estellmtest.TextTurn(`{"next_agent":"weather","reasoning":"weather question","confidence":0.9}`))
+	provider.Enqueue("chat-model",
+		estellmtest.ToolUseTurn("forecast", map[string]any{"city": "Tokyo"}),
+		estellmtest.TextTurn("It will be sunny in Tokyo."),
+	)
+	provider.Enqueue("forecast-model", estellmtest.TextTurn("sunny"))
+
+	ctx := estellmtest.WithModelProvider(context.Background(), "fake", provider)
+	mux := estellmtest.NewAgentMux(t, ctx, prompts)
+	estellmtest.RequireRendered(t, mux, "forecast", map[string]any{"city": "Tokyo"}, "\nForecast for Tokyo\n")
+
+	executions := estellmtest.RecordExecutions(mux)
+	w := estellmtest.Execute(t, ctx, mux, "router", map[string]any{"question": "How is the weather?"})
+
+	require.Equal(t, "It will be sunny in Tokyo.", w.Text())
+	require.True(t, w.Finished())
+	estellmtest.RequireExecuted(t, executions, "router", "weather", "forecast")
+	estellmtest.RequireToolCalls(t, provider, estellmtest.ToolCall{Name: "forecast", Input: map[string]any{"city": "Tokyo"}})
+	estellmtest.RequireAllServed(t, provider)
+
+	calls := provider.Calls()
+	require.Len(t, calls, 3)
+	require.Equal(t, []estellm.ContentPart{estellm.TextPart("sunny")}, calls[1].ToolCalls[0].Result)
+}
+
+func TestModelProvider(t *testing.T) {
+	provider := estellmtest.NewModelProvider()
+	provider.Enqueue("", estellmtest.Turn{
+		Reasoning:    "thinking",
+		Parts:        []estellm.ContentPart{estellm.TextPart("Hel"), estellm.TextPart("lo")},
+		FinishReason: estellm.FinishReasonMaxTokens,
+	})
+	provider.Enqueue("model", estellmtest.ErrorTurn(errors.New("throttled")))
+
+	req := &estellm.GenerateTextRequest{ModelID: "model"}
+	w := estellmtest.NewResponseRecorder()
+	require.EqualError(t, provider.GenerateText(context.Background(), req, w), "throttled")
+	w = estellmtest.NewResponseRecorder()
+	require.NoError(t, provider.GenerateText(context.Background(), req, w))
+	require.Equal(t, "Hello", w.Text())
+	require.Equal(t, "thinking", w.Reasoning())
+	require.Equal(t, []string{estellm.RoleAssistant}, w.Roles())
+	require.Equal(t, estellm.FinishReasonMaxTokens, w.Response().FinishReason)
+
+	err := provider.GenerateText(context.Background(), req, estellmtest.NewResponseRecorder())
+	require.True(t, errors.Is(err, estellmtest.ErrNoScriptedResponse))
+}
@@ -0,0 +1,197 @@
+package estellmtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/mashiike/estellm"
+	"github.com/mashiike/estellm/metadata"
+)
+
+var ErrNoScriptedResponse = errors.New("no scripted response")
+
+// Turn is a model turn of a scripted response.
+// A turn with ToolCalls calls the tools of the request and the next turn follows,
+// the response finishes with FinishReason at the first turn without ToolCalls.
+type Turn struct {
+	Reasoning    string
+	Parts        []estellm.ContentPart
+	ToolCalls    []ToolCall
+	FinishReason estellm.FinishReason
+	Metadata     metadata.Metadata
+	Err          error
+}
+
+// ToolCall is a tool call requested by a turn, Result and Err are filled after the call.
+type ToolCall struct {
+	Name      string
+	ToolUseID string
+	Input     any
+	Result    []estellm.ContentPart
+	Err       error
+}
+
+func TextTurn(text string) Turn {
+	return Turn{
+		Parts:        []estellm.ContentPart{estellm.TextPart(text)},
+		FinishReason: estellm.FinishReasonEndTurn,
+	}
+}
+
+func ToolUseTurn(name string, input any) Turn {
+	return Turn{
+		ToolCalls: []ToolCall{{Name: name, Input: input}},
+	}
+}
+
+func ErrorTurn(err error) Turn {
+	return Turn{Err: err}
+}
+
+// Call is a GenerateText or GenerateImage call made to ModelProvider.
+type Call struct {
+	TextRequest  *estellm.GenerateTextRequest
+	ImageRequest *estellm.GenerateImageRequest
+	ToolCalls    []ToolCall
+}
+
+func (c *Call) ModelID() string {
+	if c.TextRequest != nil {
+		return c.TextRequest.ModelID
+	}
+	return c.ImageRequest.ModelID
+}
+
+// ModelProvider is a fake ModelProvider which serves the scripted responses queued per model ID.
+// Responses queued with the empty model ID are served for any model ID.
+type ModelProvider struct {
+	mu     sync.Mutex
+	queues map[string][][]Turn
+	calls  []*Call
+}
+
+func NewModelProvider() *ModelProvider {
+	return &ModelProvider{
+		queues: make(map[string][][]Turn),
+	}
+}
+
+// Enqueue queues a response of the turns, which is served by a call for the model ID.
+func (p *ModelProvider) Enqueue(modelID string, turns ...Turn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.queues[modelID] = append(p.queues[modelID], turns)
+}
+
+// Calls returns the calls made to the provider in order.
+func (p *ModelProvider) Calls() []*Call {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]*Call(nil), p.calls...)
+}
+
+// Remaining returns the number of the responses not served yet.
+func (p *ModelProvider) Remaining() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var n int
+	for _, q := range p.queues {
+		n += len(q)
+	}
+	return n
+}
+
+func (p *ModelProvider) dequeue(call *Call) ([]Turn, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls = append(p.calls, call)
+	modelID := call.ModelID()
+	for _, key := range []string{modelID, ""} {
+		if q := p.queues[key]; len(q) > 0 {
+			p.queues[key] = q[1:]
+			return q[0], nil
+		}
+	}
+	return nil, fmt.Errorf("%w for model `%s`", ErrNoScriptedResponse, modelID)
+}
+
+func (p *ModelProvider) GenerateText(ctx context.Context, req *estellm.GenerateTextRequest, w estellm.ResponseWriter) error {
+	call := &Call{TextRequest: req}
+	turns, err := p.dequeue(call)
+	if err != nil {
+		return err
+	}
+	executor, err := estellm.NewToolExecutor(req.Tools, req.ModelParams, nil)
+	if err != nil {
+		return err
+	}
+	return p.serve(ctx, call, executor, turns, w)
+}
+
+func (p *ModelProvider) GenerateImage(ctx context.Context, req *estellm.GenerateImageRequest, w estellm.ResponseWriter) error {
+	call := &Call{ImageRequest: req}
+	turns, err := p.dequeue(call)
+	if err != nil {
+		return err
+	}
+	executor, err := estellm.NewToolExecutor(nil, req.ModelParams, nil)
+	if err != nil {
+		return err
+	}
+	return p.serve(ctx, call, executor, turns, w)
+}
+
+func (p *ModelProvider) serve(ctx context.Context, call *Call, executor *estellm.ToolExecutor, turns []Turn, w estellm.ResponseWriter) error {
+	if err := w.WriteRole(estellm.RoleAssistant); err != nil {
+		return err
+	}
+	for i, turn := range turns {
+		executor.NextTurn(w.Metadata())
+		if turn.Err != nil {
+			return turn.Err
+		}
+		w.Metadata().MergeInPlace(turn.Metadata)
+		if turn.Reasoning != "" {
+			if err := w.WritePart(estellm.ReasoningPart(turn.Reasoning)); err != nil {
+				return err
+			}
+		}
+		if len(turn.Parts) > 0 {
+			if err := w.WritePart(turn.Parts...); err != nil {
+				return err
+			}
+		}
+		toolUses := make([]estellm.ToolUse, len(turn.ToolCalls))
+		for j, toolCall := range turn.ToolCalls {
+			if toolCall.ToolUseID == "" {
+				toolCall.ToolUseID = fmt.Sprintf("tooluse_%d_%d", i, j)
+			}
+			toolUses[j] = estellm.ToolUse{ID: toolCall.ToolUseID, Name: toolCall.Name, Input: toolCall.Input}
+		}
+		results, err := executor.Execute(ctx, toolUses)
+		for _, result := range results {
+			toolCall := ToolCall{
+				Name:      result.ToolUse.Name,
+				ToolUseID: result.ToolUse.ID,
+				Input:     result.ToolUse.Input,
+				Err:       result.Err,
+			}
+			if result.Response != nil {
+				toolCall.Result = result.Response.Message.Parts
+			}
+			p.mu.Lock()
+			call.ToolCalls = append(call.ToolCalls, toolCall)
+			p.mu.Unlock()
+		}
+		if err != nil {
+			return err
+		}
+		if len(turn.ToolCalls) > 0 && i < len(turns)-1 {
+			continue
+		}
+		return w.Finish(turn.FinishReason, "")
+	}
+	return nil
+}
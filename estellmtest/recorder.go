@@ -0,0 +1,131 @@
+package estellmtest
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/mashiike/estellm"
+	"github.com/mashiike/estellm/metadata"
+)
+
+// ResponseRecorder is a ResponseWriter which records the writes, like httptest.ResponseRecorder.
+type ResponseRecorder struct {
+	mu       sync.Mutex
+	batch    *estellm.BatchResponseWriter
+	roles    []string
+	parts    []estellm.ContentPart
+	finished int
+}
+
+func NewResponseRecorder() *ResponseRecorder {
+	return &ResponseRecorder{
+		batch: estellm.NewBatchResponseWriter(),
+	}
+}
+
+func (r *ResponseRecorder) Metadata() metadata.Metadata {
+	return r.batch.Metadata()
+}
+
+func (r *ResponseRecorder) WriteRole(role string) error {
+	r.mu.Lock()
+	r.roles = append(r.roles, role)
+	r.mu.Unlock()
+	return r.batch.WriteRole(role)
+}
+
+func (r *ResponseRecorder) WritePart(parts ...estellm.ContentPart) error {
+	r.mu.Lock()
+	r.parts = append(r.parts, parts...)
+	r.mu.Unlock()
+	return r.batch.WritePart(parts...)
+}
+
+func (r *ResponseRecorder) Finish(reason estellm.FinishReason, msg string) error {
+	r.mu.Lock()
+	r.finished++
+	r.mu.Unlock()
+	return r.batch.Finish(reason, msg)
+}
+
+// Response returns the response merged as BatchResponseWriter does.
+func (r *ResponseRecorder) Response() *estellm.Response {
+	return r.batch.Response()
+}
+
+// Parts returns the written parts as they are, without merging.
+func (r *ResponseRecorder) Parts() []estellm.ContentPart {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]estellm.ContentPart(nil), r.parts...)
+}
+
+// Text returns the concatenated text parts.
+func (r *ResponseRecorder) Text() string {
+	return r.join(estellm.PartTypeText)
+}
+
+// Reasoning returns the concatenated reasoning parts.
+func (r *ResponseRecorder) Reasoning() string {
+	return r.join(estellm.PartTypeReasoning)
+}
+
+func (r *ResponseRecorder) join(partType string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var sb strings.Builder
+	for _, part := range r.parts {
+		if part.Type == partType {
+			sb.WriteString(part.Text)
+		}
+	}
+	return sb.String()
+}
+
+func (r *ResponseRecorder) Roles() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.roles...)
+}
+
+// Finished reports whether Finish is called.
+func (r *ResponseRecorder) Finished() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.finished > 0
+}
+
+// ExecutionRecorder records the agents executed by AgentMux in order.
+type ExecutionRecorder struct {
+	mu    sync.Mutex
+	names []string
+}
+
+// RecordExecutions adds a middleware to the mux which records the executed agents.
+func RecordExecutions(mux *estellm.AgentMux) *ExecutionRecorder {
+	r := &ExecutionRecorder{}
+	mux.Use(r.Middleware)
+	return r
+}
+
+func (r *ExecutionRecorder) Middleware(next estellm.Agent) estellm.Agent {
+	return estellm.AgentFunc(func(ctx context.Context, req *estellm.Request, w estellm.ResponseWriter) error {
+		r.mu.Lock()
+		r.names = append(r.names, req.Name)
+		r.mu.Unlock()
+		return next.Execute(ctx, req, w)
+	})
+}
+
+func (r *ExecutionRecorder) Executed() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.names...)
+}
+
+func (r *ExecutionRecorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.names = nil
+}
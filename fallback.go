@@ -0,0 +1,259 @@
+package estellm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/mashiike/estellm/metadata"
+)
+
+// FallbackModel is a candidate model of FallbackModelProvider.
+// `provider` is accepted as an alias of `model_provider`.
+type FallbackModel struct {
+	ModelProvider string         `json:"model_provider"`
+	ModelID       string         `json:"model_id,omitempty"`
+	ModelParams   map[string]any `json:"model_params,omitempty"`
+}
+
+func (m *FallbackModel) UnmarshalJSON(data []byte) error {
+	type alias FallbackModel
+	var v struct {
+		alias
+		Provider string `json:"provider"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*m = FallbackModel(v.alias)
+	if m.ModelProvider == "" {
+		m.ModelProvider = v.Provider
+	}
+	return nil
+}
+
+// FallbackModelProvider tries the models in order while the errors are retryable.
+// A model is not retried once it has written a part or called a tool, because the output is already streamed
+// or the tool may have side effects.
+type FallbackModelProvider struct {
+	models    []FallbackModel
+	providers []ModelProvider
+}
+
+func NewFallbackModelProvider(models []FallbackModel, providers []ModelProvider) (*FallbackModelProvider, error) {
+	if len(models) == 0 {
+		return nil, errors.New("fallback models are empty")
+	}
+	if len(models) != len(providers) {
+		return nil, fmt.Errorf("fallback models and providers length mismatch: %d != %d", len(models), len(providers))
+	}
+	return &FallbackModelProvider{
+		models:    models,
+		providers: providers,
+	}, nil
+}
+
+// RegisterFallback registers a FallbackModelProvider of the registered providers as name.
+func (m *ModelProviderManager) RegisterFallback(name string, models []FallbackModel) error {
+	p, err := m.newFallbackModelProvider(models)
+	if err != nil {
+		return fmt.Errorf("fallback `%s`: %w", name, err)
+	}
+	return m.Register(name, p)
+}
+
+func (m *ModelProviderManager) newFallbackModelProvider(models []FallbackModel) (*FallbackModelProvider, error) {
+	providers := make([]ModelProvider, len(models))
+	for i, model := range models {
+		if model.ModelProvider == "" {
+			return nil, fmt.Errorf("models[%d]: model_provider is required", i)
+		}
+		p, err := m.Get(model.ModelProvider)
+		if err != nil {
+			return nil, fmt.Errorf("models[%d]: %w", i, err)
+		}
+		providers[i] = p
+	}
+	return NewFallbackModelProvider(models, providers)
+}
+
+// GetFallbackModelProvider returns a FallbackModelProvider of the models with the model provider middlewares applied.
+func GetFallbackModelProvider(ctx context.Context, models []FallbackModel) (ModelProvider, error) {
+	manager, ok := modelProviderManagerFromContext(ctx)
+	if !ok {
+		manager = globalModelProviderManager
+	}
+	p, err := manager.newFallbackModelProvider(models)
+	if err != nil {
+		return nil, err
+	}
+	return manager.wrap(p), nil
+}
+
+// ResolveFallbackModels returns the models of an agent config with model_provider and model_id of the agent as the defaults.
+func ResolveFallbackModels(modelProvider string, modelID string, models []FallbackModel) ([]FallbackModel, error) {
+	resolved := make([]FallbackModel, len(models))
+	for i, m := range models {
+		if m.ModelProvider == "" {
+			m.ModelProvider = modelProvider
+		}
+		if m.ModelID == "" {
+			m.ModelID = modelID
+		}
+		if m.ModelID == "" {
+			return nil, fmt.Errorf("models[%d]: model_id is required", i)
+		}
+		resolved[i] = m
+	}
+	return resolved, nil
+}
+
+func (p *FallbackModelProvider) GenerateText(ctx context.Context, req *GenerateTextRequest, w ResponseWriter) error {
+	return p.fallback(ctx, w, req.ModelID, req.ModelParams, func(provider ModelProvider, modelID string, modelParams map[string]any, fw *fallbackResponseWriter) error {
+		r := *req
+		r.ModelID, r.ModelParams = modelID, modelParams
+		if len(req.Tools) > 0 {
+			r.Tools = make(ToolSet, len(req.Tools))
+			for i, tool := range req.Tools {
+				r.Tools[i] = &fallbackTool{Tool: tool, w: fw}
+			}
+		}
+		return provider.GenerateText(ctx, &r, fw)
+	})
+}
+
+func (p *FallbackModelProvider) GenerateImage(ctx context.Context, req *GenerateImageRequest, w ResponseWriter) error {
+	return p.fallback(ctx, w, req.ModelID, req.ModelParams, func(provider ModelProvider, modelID string, modelParams map[string]any, fw *fallbackResponseWriter) error {
+		r := *req
+		r.ModelID, r.ModelParams = modelID, modelParams
+		return provider.GenerateImage(ctx, &r, fw)
+	})
+}
+
+// resolve returns the model ID and params of the candidate, the request values are used if they are not set.
+func (p *FallbackModelProvider) resolve(model FallbackModel, modelID string, modelParams map[string]any) (string, map[string]any) {
+	if model.ModelID != "" {
+		modelID = model.ModelID
+	}
+	if model.ModelParams != nil {
+		modelParams = model.ModelParams
+	}
+	return modelID, modelParams
+}
+
+func (p *FallbackModelProvider) fallback(ctx context.Context, w ResponseWriter, modelID string, modelParams map[string]any, fn func(ModelProvider, string, map[string]any, *fallbackResponseWriter) error) error {
+	var errs []error
+	for i, model := range p.models {
+		id, params := p.resolve(model, modelID, modelParams)
+		fw := newFallbackResponseWriter(w)
+		err := fn(p.providers[i], id, params, fw)
+		if err == nil {
+			m := w.Metadata()
+			m.MergeInPlace(fw.metadata)
+			m.SetString("Model-Provider", model.ModelProvider)
+			if id != "" && !fw.metadata.Has("Model-Id") {
+				m.SetString("Model-Id", id)
+			}
+			m.SetInt64("Fallback-Attempts", int64(i+1))
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("models[%d] `%s`: %w", i, model.ModelProvider, err))
+		if fw.used.Load() || ctx.Err() != nil || !IsRetryableError(err) {
+			break
+		}
+		if i+1 < len(p.models) {
+			slog.WarnContext(ctx, "model provider failed, fallback to the next model", "model_provider", model.ModelProvider, "model_id", id, "error", err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// fallbackResponseWriter is the writer of an attempt.
+// The metadata of the attempt is kept apart and merged only when it succeeds,
+// and used reports that the attempt has streamed a part or called a tool, so it can not be retried.
+type fallbackResponseWriter struct {
+	ResponseWriter
+	metadata metadata.Metadata
+	used     atomic.Bool
+}
+
+func newFallbackResponseWriter(w ResponseWriter) *fallbackResponseWriter {
+	return &fallbackResponseWriter{
+		ResponseWriter: w,
+		metadata:       w.Metadata().Clone(),
+	}
+}
+
+func (w *fallbackResponseWriter) Metadata() metadata.Metadata {
+	return w.metadata
+}
+
+func (w *fallbackResponseWriter) WritePart(parts ...ContentPart) error {
+	if len(parts) > 0 {
+		w.used.Store(true)
+	}
+	return w.ResponseWriter.WritePart(parts...)
+}
+
+func (w *fallbackResponseWriter) Finish(reason FinishReason, msg string) error {
+	w.used.Store(true)
+	w.ResponseWriter.Metadata().MergeInPlace(w.metadata)
+	return w.ResponseWriter.Finish(reason, msg)
+}
+
+// fallbackTool marks the attempt as used when the tool is called, because the tool may have side effects.
+type fallbackTool struct {
+	Tool
+	w *fallbackResponseWriter
+}
+
+func (t *fallbackTool) Call(ctx context.Context, input any, w ResponseWriter) error {
+	t.w.used.Store(true)
+	return t.Tool.Call(ctx, input, w)
+}
+
+// retryableErrorCodes are the error codes of AWS services which are worth retrying on another model.
+var retryableErrorCodes = []string{
+	"ThrottlingException",
+	"ServiceUnavailableException",
+	"ModelNotReadyException",
+	"ModelTimeoutException",
+	"InternalServerException",
+}
+
+// IsRetryableError reports whether err is a throttling, server side or timeout error.
+func IsRetryableError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var statusErr interface{ HTTPStatusCode() int }
+	if errors.As(err, &statusErr) {
+		code := statusErr.HTTPStatusCode()
+		if code == http.StatusTooManyRequests || code == http.StatusRequestTimeout || code >= http.StatusInternalServerError {
+			return true
+		}
+	}
+	var codeErr interface{ ErrorCode() string }
+	if errors.As(err, &codeErr) {
+		code := codeErr.ErrorCode()
+		for _, c := range retryableErrorCodes {
+			if strings.EqualFold(code, c) {
+				return true
+			}
+		}
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return false
+}
@@ -0,0 +1,179 @@
+package estellm_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/mashiike/estellm"
+	"github.com/stretchr/testify/require"
+)
+
+type statusError struct {
+	statusCode int
+}
+
+func (e *statusError) Error() string       { return fmt.Sprintf("status %d", e.statusCode) }
+func (e *statusError) HTTPStatusCode() int { return e.statusCode }
+
+type codeError struct {
+	code string
+}
+
+func (e *codeError) Error() string     { return e.code }
+func (e *codeError) ErrorCode() string { return e.code }
+
+// scriptedProvider writes metadata, calls the first tool if callTool and writes partial before returning err.
+type scriptedProvider struct {
+	metadata map[string]string
+	callTool bool
+	partial  string
+	err      error
+	text     string
+	reqs     []*estellm.GenerateTextRequest
+}
+
+func (p *scriptedProvider) GenerateText(ctx context.Context, req *estellm.GenerateTextRequest, w estellm.ResponseWriter) error {
+	p.reqs = append(p.reqs, req)
+	for k, v := range p.metadata {
+		w.Metadata().SetString(k, v)
+	}
+	if p.callTool {
+		if err := req.Tools[0].Call(ctx, map[string]any{}, estellm.NewBatchResponseWriter()); err != nil {
+			return err
+		}
+	}
+	if p.partial != "" {
+		w.WritePart(estellm.TextPart(p.partial))
+	}
+	if p.err != nil {
+		return p.err
+	}
+	w.WritePart(estellm.TextPart(p.text))
+	return w.Finish(estellm.FinishReasonEndTurn, "")
+}
+
+func (p *scriptedProvider) GenerateImage(_ context.Context, _ *estellm.GenerateImageRequest, _ estellm.ResponseWriter) error {
+	return estellm.ErrNotSupported
+}
+
+func TestIsRetryableError(t *testing.T) {
+	cases := []struct {
+		err      error
+		expected bool
+	}{
+		{nil, false},
+		{errors.New("boom"), false},
+		{&statusError{429}, true},
+		{&statusError{503}, true},
+		{&statusError{529}, true},
+		{&statusError{400}, false},
+		{fmt.Errorf("wrapped: %w", &statusError{500}), true},
+		{&codeError{"ThrottlingException"}, true},
+		{&codeError{"ValidationException"}, false},
+		{context.DeadlineExceeded, true},
+		{context.Canceled, false},
+	}
+	for _, c := range cases {
+		require.Equal(t, c.expected, estellm.IsRetryableError(c.err), "%v", c.err)
+	}
+}
+
+func TestFallbackModelProvider(t *testing.T) {
+	ctx, manager := estellm.WithModelProviderManager(context.Background())
+	primary := &scriptedProvider{
+		metadata: map[string]string{"Openai-Request-Id": "req-a", "Model-Id": "model-a-2025"},
+		err:      fmt.Errorf("converse stream: %w", &statusError{429}),
+	}
+	secondary := &scriptedProvider{text: "Hello"}
+	require.NoError(t, manager.Register("primary", primary))
+	require.NoError(t, manager.Register("secondary", secondary))
+	var models []estellm.FallbackModel
+	require.NoError(t, json.Unmarshal([]byte(`[
+		{"model_provider":"primary","model_id":"model-a"},
+		{"provider":"secondary","model_id":"model-b","model_params":{"temperature":0.1}}
+	]`), &models))
+	require.NoError(t, manager.RegisterFallback("chat", models))
+
+	p, err := estellm.GetModelProvider(ctx, "chat")
+	require.NoError(t, err)
+	w := estellm.NewBatchResponseWriter()
+	req := &estellm.GenerateTextRequest{
+		ModelID:     "ignored",
+		ModelParams: map[string]any{"max_tokens": 100},
+	}
+	w.Metadata().SetString("Trace-Id", "trace")
+	require.NoError(t, p.GenerateText(ctx, req, w))
+
+	require.Equal(t, "model-a", primary.reqs[0].ModelID)
+	require.Equal(t, map[string]any{"max_tokens": 100}, primary.reqs[0].ModelParams)
+	require.Equal(t, "model-b", secondary.reqs[0].ModelID)
+	require.Equal(t, map[string]any{"temperature": 0.1}, secondary.reqs[0].ModelParams)
+	resp := w.Response()
+	require.Equal(t, []estellm.ContentPart{estellm.TextPart("Hello")}, resp.Message.Parts)
+	require.Equal(t, "secondary", resp.Metadata.GetString("Model-Provider"))
+	require.Equal(t, "model-b", resp.Metadata.GetString("Model-Id"))
+	attempts, _ := resp.Metadata.GetInt64("Fallback-Attempts")
+	require.EqualValues(t, 2, attempts)
+	require.Equal(t, "trace", resp.Metadata.GetString("Trace-Id"))
+	require.False(t, resp.Metadata.Has("Openai-Request-Id"), "the metadata of the failed attempt is dropped")
+}
+
+func TestFallbackModelProvider__NoRetry(t *testing.T) {
+	cases := []struct {
+		name    string
+		primary *scriptedProvider
+	}{
+		{"not retryable", &scriptedProvider{err: &statusError{400}}},
+		{"already streamed", &scriptedProvider{partial: "Hel", err: &statusError{503}}},
+		{"tool called", &scriptedProvider{callTool: true, err: &statusError{503}}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			secondary := &scriptedProvider{text: "Hello"}
+			p, err := estellm.NewFallbackModelProvider(
+				[]estellm.FallbackModel{{ModelProvider: "primary"}, {ModelProvider: "secondary"}},
+				[]estellm.ModelProvider{c.primary, secondary},
+			)
+			require.NoError(t, err)
+			req := &estellm.GenerateTextRequest{ModelID: "model", Tools: estellm.ToolSet{&executorTestTool{name: "tool"}}}
+			err = p.GenerateText(context.Background(), req, estellm.NewBatchResponseWriter())
+			var statusErr *statusError
+			require.True(t, errors.As(err, &statusErr))
+			require.Empty(t, secondary.reqs)
+		})
+	}
+}
+
+func TestFallbackModelProvider__AllFailed(t *testing.T) {
+	p, err := estellm.NewFallbackModelProvider(
+		[]estellm.FallbackModel{{ModelProvider: "primary"}, {ModelProvider: "secondary"}},
+		[]estellm.ModelProvider{
+			&scriptedProvider{err: &statusError{429}},
+			&scriptedProvider{err: &codeError{"ServiceUnavailableException"}},
+		},
+	)
+	require.NoError(t, err)
+	err = p.GenerateText(context.Background(), &estellm.GenerateTextRequest{ModelID: "model"}, estellm.NewBatchResponseWriter())
+	require.ErrorContains(t, err, "models[0] `primary`: status 429")
+	require.ErrorContains(t, err, "models[1] `secondary`: ServiceUnavailableException")
+}
+
+func TestResolveFallbackModels(t *testing.T) {
+	models, err := estellm.ResolveFallbackModels("bedrock", "model-a", []estellm.FallbackModel{
+		{},
+		{ModelProvider: "openai", ModelID: "model-b"},
+		{ModelProvider: "openai"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []estellm.FallbackModel{
+		{ModelProvider: "bedrock", ModelID: "model-a"},
+		{ModelProvider: "openai", ModelID: "model-b"},
+		{ModelProvider: "openai", ModelID: "model-a"},
+	}, models)
+
+	_, err = estellm.ResolveFallbackModels("bedrock", "", []estellm.FallbackModel{{ModelID: "model-a"}, {}})
+	require.EqualError(t, err, "models[1]: model_id is required")
+}
@@ -122,6 +122,7 @@ func (l *Loader) load(_ context.Context, fsys fs.FS, promptPath string) (*Prompt
 		tmpl:        tmpl,
 		preRendered: preRendered,
 		reg:         l.reg,
+		makeVM:      l.makeVM,
 	}
 	return p, nil
 }
@@ -1,6 +1,11 @@
 package estellm
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
 
 const (
 	RoleUser      = "user"
@@ -18,17 +23,24 @@ type Message struct {
 }
 
 const (
-	PartTypeText      = "text"
-	PartTypeBinary    = "binary"
-	PartTypeReasoning = "reasoning"
+	PartTypeText         = "text"
+	PartTypeBinary       = "binary"
+	PartTypeReasoning    = "reasoning"
+	PartTypeCachePoint   = "cache_point"
+	PartTypeGuardContent = "guard_content"
 )
 
+// CachePointMarkup marks a cache point, it is kept as is in the system prompt.
+const CachePointMarkup = "<cache_point/>"
+
 type ContentPart struct {
 	Type     string `json:"type"`
 	Text     string `json:"text,omitempty"`
 	Name     string `json:"name,omitempty"`
 	MIMEType string `json:"mime_type,omitempty"`
 	Data     []byte `json:"data,omitempty"`
+	// Qualifiers of the guard content part, such as `grounding_source`, `query` and `guard_content`.
+	Qualifiers []string `json:"qualifiers,omitempty"`
 }
 
 func TextPart(text string) ContentPart {
@@ -39,6 +51,16 @@ func ReasoningPart(text string) ContentPart {
 	return ContentPart{Type: PartTypeReasoning, Text: text}
 }
 
+// CachePointPart marks that the prompt up to this point can be cached by the model provider.
+func CachePointPart() ContentPart {
+	return ContentPart{Type: PartTypeCachePoint}
+}
+
+// GuardContentPart marks the text to be evaluated by the guardrail.
+func GuardContentPart(text string, qualifiers ...string) ContentPart {
+	return ContentPart{Type: PartTypeGuardContent, Text: text, Qualifiers: qualifiers}
+}
+
 func BinaryPart(mimeType string, data []byte) ContentPart {
 	return ContentPart{Type: PartTypeBinary, MIMEType: mimeType, Data: data}
 }
@@ -48,3 +70,55 @@ func BinaryPartWithName(mimeType, name string, data []byte) ContentPart {
 	part.Name = name
 	return part
 }
+
+var systemMarkupRe = regexp.MustCompile(`(?s)<cache/>|<cache_point/>|<guard(?:\s+qualifiers="([^"]*)")?\s*>(.*?)</guard>`)
+
+// SplitSystemPrompt splits the system prompt into the text, cache point and guard content parts.
+// The guard content is flat text, the decoder rejects markup inside <guard>.
+func SplitSystemPrompt(system string) []ContentPart {
+	var parts []ContentPart
+	appendText := func(text string) {
+		if text = strings.TrimSpace(text); text != "" {
+			parts = append(parts, TextPart(text))
+		}
+	}
+	last := 0
+	for _, m := range systemMarkupRe.FindAllStringSubmatchIndex(system, -1) {
+		appendText(system[last:m[0]])
+		last = m[1]
+		if m[4] < 0 {
+			parts = append(parts, CachePointPart())
+			continue
+		}
+		var qualifiers []string
+		if m[2] >= 0 && m[3] > m[2] {
+			qualifiers = strings.Split(system[m[2]:m[3]], ",")
+		}
+		parts = append(parts, GuardContentPart(strings.TrimSpace(system[m[4]:m[5]]), qualifiers...))
+	}
+	appendText(system[last:])
+	return parts
+}
+
+// PlainSystemPrompt removes the cache points and the guard markup from the system prompt,
+// for the model providers without prompt caching and guardrails.
+func PlainSystemPrompt(system string) string {
+	if !systemMarkupRe.MatchString(system) {
+		return system
+	}
+	var texts []string
+	for _, part := range SplitSystemPrompt(system) {
+		if part.Type == PartTypeText || part.Type == PartTypeGuardContent {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// guardMarkup returns the markup of the guard content part.
+func guardMarkup(part ContentPart) string {
+	if len(part.Qualifiers) > 0 {
+		return fmt.Sprintf(`<guard qualifiers="%s">%s</guard>`, strings.Join(part.Qualifiers, ","), part.Text)
+	}
+	return "<guard>" + part.Text + "</guard>"
+}
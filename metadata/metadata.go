@@ -218,6 +218,26 @@ func SetTotalTokens(metadata Metadata, tokens int64) {
 	metadata.SetInt64("Usage-Total-Tokens", tokens)
 }
 
+func SetReasoningTokens(metadata Metadata, tokens int64) {
+	metadata.SetInt64("Usage-Reasoning-Tokens", tokens)
+}
+
+func SetCacheReadInputTokens(metadata Metadata, tokens int64) {
+	metadata.SetInt64("Usage-Cache-Read-Input-Tokens", tokens)
+}
+
+func SetCacheWriteInputTokens(metadata Metadata, tokens int64) {
+	metadata.SetInt64("Usage-Cache-Write-Input-Tokens", tokens)
+}
+
+func SetModelTurns(metadata Metadata, turns int64) {
+	metadata.SetInt64("Model-Turns", turns)
+}
+
+func SetToolCalls(metadata Metadata, calls int64) {
+	metadata.SetInt64("Tool-Calls", calls)
+}
+
 func GetInputTokens(metadata Metadata) (int64, bool) {
 	return metadata.GetInt64("Usage-Input-Tokens")
 }
@@ -229,3 +249,23 @@ func GetOutputTokens(metadata Metadata) (int64, bool) {
 func GetTotalTokens(metadata Metadata) (int64, bool) {
 	return metadata.GetInt64("Usage-Total-Tokens")
 }
+
+func GetReasoningTokens(metadata Metadata) (int64, bool) {
+	return metadata.GetInt64("Usage-Reasoning-Tokens")
+}
+
+func GetCacheReadInputTokens(metadata Metadata) (int64, bool) {
+	return metadata.GetInt64("Usage-Cache-Read-Input-Tokens")
+}
+
+func GetCacheWriteInputTokens(metadata Metadata) (int64, bool) {
+	return metadata.GetInt64("Usage-Cache-Write-Input-Tokens")
+}
+
+func GetModelTurns(metadata Metadata) (int64, bool) {
+	return metadata.GetInt64("Model-Turns")
+}
+
+func GetToolCalls(metadata Metadata) (int64, bool) {
+	return metadata.GetInt64("Tool-Calls")
+}
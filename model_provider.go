@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"slices"
+	"strings"
 	"sync"
 
 	"github.com/mashiike/estellm/metadata"
@@ -14,8 +16,30 @@ type GenerateTextRequest struct {
 	ModelID     string            `json:"model_id"`
 	ModelParams map[string]any    `json:"model_params"`
 	System      string            `json:"system"`
-	Messages    []Message         `json:"messages"`
-	Tools       ToolSet           `json:"tools"`
+	// SystemParts is the system prompt split into the text, cache point and guard content parts.
+	// The model providers got by GetModelProvider receive it with System as the plain text, if the system prompt has markup.
+	SystemParts []ContentPart    `json:"system_parts,omitempty"`
+	Messages    []Message        `json:"messages"`
+	Tools       ToolSet          `json:"tools"`
+	Guardrail   *GuardrailConfig `json:"guardrail,omitempty"`
+}
+
+// SystemPromptParts returns SystemParts, or System split into the parts if it is not set.
+func (r *GenerateTextRequest) SystemPromptParts() []ContentPart {
+	if r.SystemParts != nil {
+		return r.SystemParts
+	}
+	return SplitSystemPrompt(r.System)
+}
+
+// GuardrailConfig is the guardrail applied to the model input and output, it is supported by bedrock.
+type GuardrailConfig struct {
+	Identifier string `json:"identifier"`
+	Version    string `json:"version"`
+	// Trace is `enabled`, `enabled_full` or `disabled`.
+	Trace string `json:"trace,omitempty"`
+	// StreamProcessingMode is `sync` or `async`.
+	StreamProcessingMode string `json:"stream_processing_mode,omitempty"`
 }
 
 type GenerateImageRequest struct {
@@ -26,11 +50,115 @@ type GenerateImageRequest struct {
 	Messages    []Message         `json:"messages"`
 }
 
+const (
+	ImageModeGenerate  = "generate"
+	ImageModeEdit      = "edit"
+	ImageModeVariation = "variation"
+
+	// ImageMaskPartName is the name of the binary part used as the mask of image editing.
+	ImageMaskPartName = "mask"
+)
+
+// ImageMode returns the `mode` in model params, ImageModeGenerate by default.
+func (r *GenerateImageRequest) ImageMode() string {
+	if mode, ok := r.ModelParams["mode"].(string); ok && mode != "" {
+		return mode
+	}
+	return ImageModeGenerate
+}
+
+// InputImages returns the image binary parts in the messages.
+// The part named ImageMaskPartName is returned as the mask, not as an image.
+func (r *GenerateImageRequest) InputImages() ([]ContentPart, *ContentPart) {
+	var images []ContentPart
+	var mask *ContentPart
+	for _, msg := range r.Messages {
+		for _, part := range msg.Parts {
+			if part.Type != PartTypeBinary || !strings.HasPrefix(part.MIMEType, "image/") {
+				continue
+			}
+			if part.Name == ImageMaskPartName {
+				mask = &part
+				continue
+			}
+			images = append(images, part)
+		}
+	}
+	return images, mask
+}
+
 type ModelProvider interface {
 	GenerateText(ctx context.Context, req *GenerateTextRequest, w ResponseWriter) error
 	GenerateImage(ctx context.Context, req *GenerateImageRequest, w ResponseWriter) error
 }
 
+// EmbeddingInputType tells the providers that embed documents and queries differently what the inputs are.
+type EmbeddingInputType string
+
+const (
+	EmbeddingInputTypeDocument EmbeddingInputType = "document"
+	EmbeddingInputTypeQuery    EmbeddingInputType = "query"
+)
+
+type GenerateEmbeddingRequest struct {
+	Metadata    metadata.Metadata  `json:"metadata"`
+	ModelID     string             `json:"model_id"`
+	ModelParams map[string]any     `json:"model_params"`
+	InputType   EmbeddingInputType `json:"input_type,omitempty"`
+	Inputs      []string           `json:"inputs"`
+}
+
+type GenerateEmbeddingResponse struct {
+	Metadata   metadata.Metadata `json:"metadata"`
+	Embeddings [][]float32       `json:"embeddings"`
+}
+
+// EmbeddingProvider is an optional interface for model providers that can embed texts.
+type EmbeddingProvider interface {
+	GenerateEmbedding(ctx context.Context, req *GenerateEmbeddingRequest) (*GenerateEmbeddingResponse, error)
+}
+
+type GenerateSpeechRequest struct {
+	Metadata    metadata.Metadata `json:"metadata"`
+	ModelID     string            `json:"model_id"`
+	ModelParams map[string]any    `json:"model_params"`
+	Text        string            `json:"text"`
+}
+
+// SpeechProvider is an optional interface for model providers that can synthesize speech.
+// The audio is written to the ResponseWriter as a binary part with an audio MIME type.
+type SpeechProvider interface {
+	GenerateSpeech(ctx context.Context, req *GenerateSpeechRequest, w ResponseWriter) error
+}
+
+type TranscribeRequest struct {
+	Metadata    metadata.Metadata `json:"metadata"`
+	ModelID     string            `json:"model_id"`
+	ModelParams map[string]any    `json:"model_params"`
+	Audio       ContentPart       `json:"audio"`
+}
+
+// TranscriptionProvider is an optional interface for model providers that can transcribe audio.
+// The transcript is written to the ResponseWriter as a text part.
+type TranscriptionProvider interface {
+	Transcribe(ctx context.Context, req *TranscribeRequest, w ResponseWriter) error
+}
+
+type ListModelsRequest struct {
+	ModelParams map[string]any `json:"model_params"`
+}
+
+type ModelInfo struct {
+	ID          string         `json:"id"`
+	Description string         `json:"description,omitempty"`
+	Details     map[string]any `json:"details,omitempty"`
+}
+
+// ModelLister is an optional interface for model providers that can list the available models.
+type ModelLister interface {
+	ListModels(ctx context.Context, req *ListModelsRequest) ([]ModelInfo, error)
+}
+
 type ModelProviderManager struct {
 	mu          sync.RWMutex
 	providers   map[string]ModelProvider
@@ -40,6 +168,7 @@ type ModelProviderManager struct {
 var (
 	ErrModelProviderNameEmpty = errors.New("model provider name is empty")
 	ErrModelNotFound          = errors.New("model not found")
+	ErrNotSupported           = errors.New("not supported")
 )
 
 func (m *ModelProviderManager) Register(name string, provider ModelProvider) error {
@@ -86,6 +215,7 @@ func (m *ModelProviderManager) Clone() *ModelProviderManager {
 	for name, provider := range m.providers {
 		clone.providers[name] = provider
 	}
+	clone.middlewares = slices.Clone(m.middlewares)
 	return clone
 }
 
@@ -135,10 +265,79 @@ func GetModelProvider(ctx context.Context, name string) (ModelProvider, error) {
 	if err != nil {
 		return nil, fmt.Errorf("model provider `%s`: %w", name, err)
 	}
-	for _, middleware := range manager.middlewares {
+	return manager.wrap(modelProvider), nil
+}
+
+// wrap applies the system prompt conversion and the middlewares to the model provider.
+func (m *ModelProviderManager) wrap(modelProvider ModelProvider) ModelProvider {
+	modelProvider = systemPromptModelProvider{ModelProvider: modelProvider}
+	for _, middleware := range m.middlewares {
 		modelProvider = middleware(modelProvider)
 	}
-	return modelProvider, nil
+	return modelProvider
+}
+
+// systemPromptModelProvider moves the cache points and the guard contents in the system prompt markup to SystemParts,
+// so that the model providers never see the markup in System.
+type systemPromptModelProvider struct {
+	ModelProvider
+}
+
+func (p systemPromptModelProvider) GenerateText(ctx context.Context, req *GenerateTextRequest, w ResponseWriter) error {
+	if req.SystemParts == nil && systemMarkupRe.MatchString(req.System) {
+		r := *req
+		r.SystemParts = SplitSystemPrompt(req.System)
+		r.System = PlainSystemPrompt(req.System)
+		req = &r
+	}
+	return p.ModelProvider.GenerateText(ctx, req, w)
+}
+
+func (p systemPromptModelProvider) GenerateImage(ctx context.Context, req *GenerateImageRequest, w ResponseWriter) error {
+	if systemMarkupRe.MatchString(req.System) {
+		r := *req
+		r.System = PlainSystemPrompt(req.System)
+		req = &r
+	}
+	return p.ModelProvider.GenerateImage(ctx, req, w)
+}
+
+// GetEmbeddingProvider returns the model provider registered as name if it supports embeddings.
+// Model provider middlewares are not applied, because they wrap only the ModelProvider interface.
+func GetEmbeddingProvider(ctx context.Context, name string) (EmbeddingProvider, error) {
+	return getOptionalModelProvider[EmbeddingProvider](ctx, name, "embedding")
+}
+
+// GetSpeechProvider returns the model provider registered as name if it supports speech synthesis.
+func GetSpeechProvider(ctx context.Context, name string) (SpeechProvider, error) {
+	return getOptionalModelProvider[SpeechProvider](ctx, name, "speech")
+}
+
+// GetTranscriptionProvider returns the model provider registered as name if it supports transcription.
+func GetTranscriptionProvider(ctx context.Context, name string) (TranscriptionProvider, error) {
+	return getOptionalModelProvider[TranscriptionProvider](ctx, name, "transcription")
+}
+
+// GetModelLister returns the model provider registered as name if it can list models.
+func GetModelLister(ctx context.Context, name string) (ModelLister, error) {
+	return getOptionalModelProvider[ModelLister](ctx, name, "model listing")
+}
+
+func getOptionalModelProvider[T any](ctx context.Context, name string, feature string) (T, error) {
+	var zero T
+	manager, ok := modelProviderManagerFromContext(ctx)
+	if !ok {
+		manager = globalModelProviderManager
+	}
+	modelProvider, err := manager.Get(name)
+	if err != nil {
+		return zero, fmt.Errorf("model provider `%s`: %w", name, err)
+	}
+	provider, ok := modelProvider.(T)
+	if !ok {
+		return zero, fmt.Errorf("model provider `%s`: %s %w", name, feature, ErrNotSupported)
+	}
+	return provider, nil
 }
 
 func UserModelProviderMiddlewares(middlewares ...func(ModelProvider) ModelProvider) {
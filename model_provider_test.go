@@ -0,0 +1,64 @@
+package estellm_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mashiike/estellm"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateImageRequest__InputImages(t *testing.T) {
+	req := &estellm.GenerateImageRequest{
+		ModelParams: map[string]any{
+			"mode": estellm.ImageModeEdit,
+		},
+		Messages: []estellm.Message{
+			{
+				Role: estellm.RoleUser,
+				Parts: []estellm.ContentPart{
+					estellm.TextPart("make the sky blue"),
+					estellm.BinaryPart("image/png", []byte("image")),
+					estellm.BinaryPartWithName("image/png", estellm.ImageMaskPartName, []byte("mask")),
+					estellm.BinaryPart("text/plain", []byte("not image")),
+				},
+			},
+		},
+	}
+	require.Equal(t, estellm.ImageModeEdit, req.ImageMode())
+	images, mask := req.InputImages()
+	require.Len(t, images, 1)
+	require.Equal(t, []byte("image"), images[0].Data)
+	require.NotNil(t, mask)
+	require.Equal(t, []byte("mask"), mask.Data)
+
+	req.ModelParams = nil
+	require.Equal(t, estellm.ImageModeGenerate, req.ImageMode())
+}
+
+func TestGetModelProvider__SystemPrompt(t *testing.T) {
+	provider := &scriptedProvider{text: "ok"}
+	ctx, manager := estellm.WithModelProviderManager(context.Background())
+	require.NoError(t, manager.Register("scripted", provider))
+	p, err := estellm.GetModelProvider(ctx, "scripted")
+	require.NoError(t, err)
+
+	req := &estellm.GenerateTextRequest{
+		System: `Answer with the source.<cache_point/><guard qualifiers="grounding_source">The sky is blue.</guard>`,
+	}
+	require.NoError(t, p.GenerateText(ctx, req, estellm.NewBatchResponseWriter()))
+	require.Equal(t, "Answer with the source.\nThe sky is blue.", provider.reqs[0].System)
+	expected := []estellm.ContentPart{
+		estellm.TextPart("Answer with the source."),
+		estellm.CachePointPart(),
+		estellm.GuardContentPart("The sky is blue.", "grounding_source"),
+	}
+	require.Equal(t, expected, provider.reqs[0].SystemParts)
+	require.Equal(t, expected, provider.reqs[0].SystemPromptParts())
+	require.Nil(t, req.SystemParts, "the request of the caller is not modified")
+
+	req = &estellm.GenerateTextRequest{System: "You are a helpful assistant."}
+	require.NoError(t, p.GenerateText(ctx, req, estellm.NewBatchResponseWriter()))
+	require.Same(t, req, provider.reqs[1])
+	require.Equal(t, []estellm.ContentPart{estellm.TextPart("You are a helpful assistant.")}, provider.reqs[1].SystemPromptParts())
+}
@@ -2,12 +2,15 @@ package estellm
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"path"
 	"slices"
 	"strings"
 	"text/template"
 
+	"github.com/google/go-jsonnet"
+	"github.com/mashiike/estellm/jsonutil"
 	"github.com/xeipuuv/gojsonschema"
 )
 
@@ -17,6 +20,7 @@ type Prompt struct {
 	preRendered    string
 	relatedPrompts map[string]*Prompt
 	reg            *Registry
+	makeVM         func() *jsonnet.VM
 }
 
 func (p *Prompt) Name() string {
@@ -108,6 +112,65 @@ func (p *Prompt) DecodeBlock(ctx context.Context, blockName string, req *Request
 	return dec.Decode()
 }
 
+// EvaluateJsonnet renders the prompt and evaluates it as Jsonnet.
+// If the result is a function, it is called with `payload` and `previous_results` as top-level arguments.
+func (p *Prompt) EvaluateJsonnet(ctx context.Context, req *Request) (string, error) {
+	return p.EvaluateJsonnetBlock(ctx, path.Base(p.cfg.PromptPath), req)
+}
+
+func (p *Prompt) EvaluateJsonnetBlock(ctx context.Context, blockName string, req *Request) (string, error) {
+	snippet, err := p.RenderBlock(ctx, blockName, req)
+	if err != nil {
+		return "", fmt.Errorf("render block: %w", err)
+	}
+	return p.EvaluateJsonnetSnippet(ctx, snippet, req)
+}
+
+// EvaluateJsonnetSnippet evaluates the snippet with the same VM and top-level arguments as EvaluateJsonnet.
+func (p *Prompt) EvaluateJsonnetSnippet(_ context.Context, snippet string, req *Request) (string, error) {
+	var vm *jsonnet.VM
+	if p.makeVM != nil {
+		vm = p.makeVM()
+	} else {
+		vm = jsonutil.MakeVM()
+	}
+	data := req.TemplateData()
+	payload, err := json.Marshal(data["payload"])
+	if err != nil {
+		return "", fmt.Errorf("marshal payload: %w", err)
+	}
+	previousResults := make(map[string]any, len(req.PreviousResults))
+	for name, resp := range req.PreviousResults {
+		previousResults[name] = resp.templateData()
+	}
+	previous, err := json.Marshal(previousResults)
+	if err != nil {
+		return "", fmt.Errorf("marshal previous results: %w", err)
+	}
+	vm.TLACode("payload", string(payload))
+	vm.TLACode("previous_results", string(previous))
+	jsonStr, err := vm.EvaluateAnonymousSnippet(p.cfg.PromptPath, snippet)
+	if err != nil {
+		return "", fmt.Errorf("evaluate jsonnet: %w", err)
+	}
+	return jsonStr, nil
+}
+
+// RelatedPrompt returns the other prompt loaded together with this prompt.
+func (p *Prompt) RelatedPrompt(name string) (*Prompt, bool) {
+	related, ok := p.relatedPrompts[name]
+	return related, ok
+}
+
+// NewAgent creates the agent for this prompt with the registry used on loading.
+func (p *Prompt) NewAgent(ctx context.Context) (Agent, error) {
+	reg := p.reg
+	if reg == nil {
+		reg = defaultRegistory
+	}
+	return reg.NewAgent(ctx, p)
+}
+
 func (p *Prompt) SetRelatedPrompts(prompts map[string]*Prompt) {
 	p.relatedPrompts = prompts
 }
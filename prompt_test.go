@@ -0,0 +1,54 @@
+package estellm_test
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+
+	"github.com/mashiike/estellm"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrompt_EvaluateJsonnet(t *testing.T) {
+	loader := estellm.NewLoader()
+	loader.Includes(fstest.MapFS{
+		"util.libsonnet": &fstest.MapFile{
+			Data: []byte(`{ double(x): x * 2 }`),
+		},
+	})
+	loader.ExtVars(map[string]string{"env": "test"})
+	ctx := context.Background()
+	p, err := loader.Load(ctx, fstest.MapFS{
+		"merge.md": &fstest.MapFile{
+			Data: []byte(`{{ define "config" }}
+{
+  type: "transform",
+  depends_on: ["items"],
+}
+{{ end }}
+local util = import '@includes/util.libsonnet';
+function(payload, previous_results) {
+  env: std.extVar('env'),
+  count: util.double(payload.count),
+  names: [item.name for item in previous_results.items.items if item.enabled],
+}
+`),
+		},
+	}, "merge.md")
+	require.NoError(t, err)
+	req, err := estellm.NewRequest("merge", map[string]any{"count": 2})
+	require.NoError(t, err)
+	req.PreviousResults = map[string]*estellm.Response{
+		"items": {
+			Message: estellm.Message{
+				Role: estellm.RoleAssistant,
+				Parts: []estellm.ContentPart{
+					estellm.TextPart(`{"items":[{"name":"a","enabled":true},{"name":"b","enabled":false}]}`),
+				},
+			},
+		},
+	}
+	actual, err := p.EvaluateJsonnet(ctx, req)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"env":"test","count":4,"names":["a"]}`, actual)
+}
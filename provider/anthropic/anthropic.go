@@ -0,0 +1,568 @@
+package anthropic
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/mashiike/estellm"
+	"github.com/mashiike/estellm/jsonutil"
+	"github.com/mashiike/estellm/metadata"
+)
+
+func init() {
+	// Register the provider
+	estellm.RegisterModelProvider("anthropic", &ModelProvider{})
+}
+
+const (
+	DefaultEndpoint  = "https://api.anthropic.com"
+	APIVersion       = "2023-06-01"
+	DefaultMaxTokens = 4096
+)
+
+type ModelProvider struct {
+	init       sync.Once
+	httpClient *http.Client
+	apiKey     string
+	endpoint   string
+}
+
+func NewWithHTTPClient(client *http.Client) *ModelProvider {
+	return &ModelProvider{httpClient: client}
+}
+
+func (p *ModelProvider) SetHTTPClient(client *http.Client) {
+	p.httpClient = client
+}
+
+func (p *ModelProvider) initClient() {
+	p.init.Do(func() {
+		if p.httpClient == nil {
+			p.httpClient = http.DefaultClient
+		}
+		p.apiKey = os.Getenv("ANTHROPIC_API_KEY")
+		p.endpoint = os.Getenv("ANTHROPIC_BASE_URL")
+		if p.endpoint == "" {
+			p.endpoint = DefaultEndpoint
+		}
+	})
+}
+
+type client struct {
+	httpClient *http.Client
+	apiKey     string
+	endpoint   string
+}
+
+func (p *ModelProvider) newClient(modelParams map[string]any) (*client, error) {
+	p.initClient()
+	c := &client{
+		httpClient: p.httpClient,
+		apiKey:     p.apiKey,
+		endpoint:   p.endpoint,
+	}
+	if endpoint, ok := modelParams["endpoint"].(string); ok && endpoint != "" {
+		c.endpoint = endpoint
+	}
+	if apiKey, ok := modelParams["api_key"].(string); ok && apiKey != "" {
+		c.apiKey = apiKey
+	}
+	if c.apiKey == "" {
+		return nil, errors.New("missing ANTHROPIC_API_KEY")
+	}
+	return c, nil
+}
+
+func (c *client) createMessageStream(ctx context.Context, input *MessagesRequest) (*http.Response, error) {
+	bs, err := json.Marshal(input)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(c.endpoint, "/")+"/v1/messages", bytes.NewReader(bs))
+	if err != nil {
+		return nil, fmt.Errorf("new request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("X-Api-Key", c.apiKey)
+	req.Header.Set("Anthropic-Version", APIVersion)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("do request: %w", err)
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		slog.DebugContext(ctx, "messages request", "model", input.Model, "request", string(bs))
+		return nil, newAPIError(resp)
+	}
+	return resp, nil
+}
+
+func newAPIError(resp *http.Response) error {
+	apiErr := &APIError{
+		StatusCode: resp.StatusCode,
+		Type:       "unknown_error",
+		Message:    resp.Status,
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return apiErr
+	}
+	var errResp ErrorResponse
+	if err := json.Unmarshal(body, &errResp); err != nil || errResp.Error.Type == "" {
+		if len(body) > 0 {
+			apiErr.Message = string(body)
+		}
+		return apiErr
+	}
+	apiErr.Type = errResp.Error.Type
+	apiErr.Message = errResp.Error.Message
+	return apiErr
+}
+
+// errorTypeStatusCodes maps the error types sent as stream events to HTTP status codes.
+var errorTypeStatusCodes = map[string]int{
+	"invalid_request_error": http.StatusBadRequest,
+	"authentication_error":  http.StatusUnauthorized,
+	"permission_error":      http.StatusForbidden,
+	"not_found_error":       http.StatusNotFound,
+	"request_too_large":     http.StatusRequestEntityTooLarge,
+	"rate_limit_error":      http.StatusTooManyRequests,
+	"api_error":             http.StatusInternalServerError,
+	"overloaded_error":      529,
+}
+
+var (
+	toolNameRe = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+)
+
+func NormalizeToolName(input string) string {
+	normalized := toolNameRe.ReplaceAllString(input, "_")
+	normalized = strings.Trim(normalized, "_")
+	if len(normalized) > 64 {
+		normalized = normalized[:64]
+	}
+	if normalized == "" {
+		hash := sha256.Sum256([]byte(input))
+		hashStr := hex.EncodeToString(hash[:])
+		return "tool_" + hashStr[:8]
+	}
+	return normalized
+}
+
+func (p *ModelProvider) GenerateText(ctx context.Context, req *estellm.GenerateTextRequest, w estellm.ResponseWriter) error {
+	c, err := p.newClient(req.ModelParams)
+	if err != nil {
+		return fmt.Errorf("failed to create anthropic client: %w", err)
+	}
+	input := &MessagesRequest{
+		MaxTokens: DefaultMaxTokens,
+	}
+	if err := jsonutil.Remarshal(req.ModelParams, input); err != nil {
+		return fmt.Errorf("remarshal messages request: %w", err)
+	}
+	input.Model = req.ModelID
+	input.System = systemPrompt(req)
+	input.Stream = true
+	input.Messages = make([]Message, 0, len(req.Messages))
+	for _, msg := range req.Messages {
+		var aMsg Message
+		switch msg.Role {
+		case estellm.RoleUser, estellm.RoleAssistant:
+			aMsg.Role = msg.Role
+		default:
+			return estellm.ErrInvalidMessageRole
+		}
+		for _, part := range msg.Parts {
+			switch part.Type {
+			case estellm.PartTypeText, estellm.PartTypeGuardContent:
+				aMsg.Content = append(aMsg.Content, ContentBlock{
+					Type: "text",
+					Text: part.Text,
+				})
+			case estellm.PartTypeBinary:
+				cb, err := binaryContentBlock(part)
+				if err != nil {
+					return err
+				}
+				aMsg.Content = append(aMsg.Content, cb)
+			case estellm.PartTypeReasoning:
+				// thinking blocks can not be replayed without the signature.
+				continue
+			case estellm.PartTypeCachePoint:
+				if len(aMsg.Content) > 0 {
+					aMsg.Content[len(aMsg.Content)-1].CacheControl = ephemeralCacheControl()
+				}
+			default:
+				return fmt.Errorf("unsupported content type: %s", part.Type)
+			}
+		}
+		if len(aMsg.Content) == 0 {
+			continue
+		}
+		input.Messages = append(input.Messages, aMsg)
+	}
+	if len(req.Tools) > 0 {
+		input.Tools = make([]Tool, 0, len(req.Tools))
+		for _, tool := range req.Tools {
+			slog.Debug("tool spec", "name", tool.Name(), "description", tool.Description(), "input_schema", tool.InputSchema())
+			input.Tools = append(input.Tools, Tool{
+				Name:        NormalizeToolName(tool.Name()),
+				Description: tool.Description(),
+				InputSchema: tool.InputSchema(),
+			})
+		}
+		if cacheTools, _ := req.ModelParams["cache_tools"].(bool); cacheTools {
+			input.Tools[len(input.Tools)-1].CacheControl = ephemeralCacheControl()
+		}
+	}
+	executor, err := estellm.NewToolExecutor(req.Tools, req.ModelParams, NormalizeToolName)
+	if err != nil {
+		return err
+	}
+	return p.generateTextMultiTurn(ctx, c, input, w, executor)
+}
+
+// systemPrompt returns the system prompt as text blocks if it has cache points.
+func systemPrompt(req *estellm.GenerateTextRequest) any {
+	parts := req.SystemPromptParts()
+	if !slices.ContainsFunc(parts, func(part estellm.ContentPart) bool {
+		return part.Type == estellm.PartTypeCachePoint
+	}) {
+		return estellm.PlainSystemPrompt(req.System)
+	}
+	var blocks []ContentBlock
+	for _, part := range parts {
+		switch part.Type {
+		case estellm.PartTypeText, estellm.PartTypeGuardContent:
+			blocks = append(blocks, ContentBlock{Type: "text", Text: part.Text})
+		case estellm.PartTypeCachePoint:
+			if len(blocks) > 0 {
+				blocks[len(blocks)-1].CacheControl = ephemeralCacheControl()
+			}
+		}
+	}
+	return blocks
+}
+
+func binaryContentBlock(part estellm.ContentPart) (ContentBlock, error) {
+	mediaType, _, err := mime.ParseMediaType(part.MIMEType)
+	if err != nil {
+		return ContentBlock{}, fmt.Errorf("parse media type: %w", err)
+	}
+	switch {
+	case strings.HasPrefix(mediaType, "image/"):
+		switch mediaType {
+		case "image/jpeg", "image/png", "image/gif", "image/webp":
+		default:
+			return ContentBlock{}, fmt.Errorf("unsupported image format: %s", mediaType)
+		}
+		return ContentBlock{
+			Type: "image",
+			Source: &Source{
+				Type:      "base64",
+				MediaType: mediaType,
+				Data:      base64.StdEncoding.EncodeToString(part.Data),
+			},
+		}, nil
+	case strings.HasPrefix(mediaType, "text/"):
+		return ContentBlock{
+			Type: "text",
+			Text: string(part.Data),
+		}, nil
+	case mediaType == "application/pdf":
+		return ContentBlock{
+			Type: "document",
+			Source: &Source{
+				Type:      "base64",
+				MediaType: mediaType,
+				Data:      base64.StdEncoding.EncodeToString(part.Data),
+			},
+		}, nil
+	default:
+		return ContentBlock{}, fmt.Errorf("unsupported binary content type: %s", mediaType)
+	}
+}
+
+func (p *ModelProvider) generateTextMultiTurn(ctx context.Context, c *client, input *MessagesRequest, w estellm.ResponseWriter, executor *estellm.ToolExecutor) error {
+	var inputTokens int64
+	var outputTokens int64
+	var cacheReadInputTokens int64
+	var cacheWriteInputTokens int64
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		executor.NextTurn(w.Metadata())
+		resp, err := c.createMessageStream(ctx, input)
+		if err != nil {
+			return fmt.Errorf("create message: %w", err)
+		}
+		m := w.Metadata()
+		for k, v := range resp.Header {
+			if strings.HasPrefix(k, "Anthropic-") || k == "Request-Id" {
+				m.SetStrings(k, v)
+			}
+		}
+		msg, stopReason, usage, err := readMessageStream(ctx, resp.Body, w)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("read stream: %w", err)
+		}
+		inputTokens += usage.InputTokens + usage.CacheCreationInputTokens + usage.CacheReadInputTokens
+		outputTokens += usage.OutputTokens
+		cacheReadInputTokens += usage.CacheReadInputTokens
+		cacheWriteInputTokens += usage.CacheCreationInputTokens
+		metadata.SetInputTokens(m, inputTokens)
+		if cacheReadInputTokens > 0 || cacheWriteInputTokens > 0 {
+			metadata.SetCacheReadInputTokens(m, cacheReadInputTokens)
+			metadata.SetCacheWriteInputTokens(m, cacheWriteInputTokens)
+		}
+		metadata.SetOutputTokens(m, outputTokens)
+		metadata.SetTotalTokens(m, inputTokens+outputTokens)
+		switch stopReason {
+		case "end_turn", "pause_turn":
+			w.Finish(estellm.FinishReasonEndTurn, stopReason)
+			return nil
+		case "max_tokens":
+			w.Finish(estellm.FinishReasonMaxTokens, stopReason)
+			return nil
+		case "stop_sequence":
+			w.Finish(estellm.FinishReasonStopSequence, stopReason)
+			return nil
+		case "refusal":
+			w.Finish(estellm.FinishReasonContentFiltered, stopReason)
+			return nil
+		case "tool_use":
+			if executor.Exhausted() {
+				// the model calls the tools even in the final turn.
+				w.Finish(estellm.FinishReasonEndTurn, "max_tool_iterations")
+				return nil
+			}
+		default:
+			return fmt.Errorf("unsupported stop reason: %s", stopReason)
+		}
+		input.Messages = append(input.Messages, msg)
+		toolUses := make([]estellm.ToolUse, 0, len(msg.Content))
+		for _, cb := range msg.Content {
+			if cb.Type != "tool_use" {
+				continue
+			}
+			toolUses = append(toolUses, estellm.ToolUse{
+				ID:    cb.ID,
+				Name:  cb.Name,
+				Input: cb.Input,
+			})
+		}
+		if len(toolUses) == 0 {
+			return errors.New("tool use not found")
+		}
+		results, err := executor.Execute(ctx, toolUses)
+		if err != nil {
+			return err
+		}
+		toolResultMsg := Message{
+			Role: estellm.RoleUser,
+		}
+		for _, result := range results {
+			toolResultMsg.Content = append(toolResultMsg.Content, newToolResult(result))
+		}
+		if executor.Exhausted() {
+			input.ToolChoice = &ToolChoice{Type: "none"}
+			toolResultMsg.Content = append(toolResultMsg.Content, ContentBlock{
+				Type: "text",
+				Text: estellm.FinalTurnInstruction,
+			})
+		}
+		slog.DebugContext(ctx, "tool result", "message", toolResultMsg)
+		input.Messages = append(input.Messages, toolResultMsg)
+	}
+}
+
+// readMessageStream reads the server-sent events of the Messages API.
+// Text and thinking deltas are written to w, and the assembled assistant message is returned for the next turn.
+func readMessageStream(ctx context.Context, r io.Reader, w estellm.ResponseWriter) (Message, string, Usage, error) {
+	msg := Message{Role: estellm.RoleAssistant}
+	var usage Usage
+	var stopReason string
+	var current *ContentBlock
+	var toolInputBuilder strings.Builder
+	reader := bufio.NewReader(r)
+	var data strings.Builder
+	for {
+		select {
+		case <-ctx.Done():
+			return msg, "", usage, ctx.Err()
+		default:
+		}
+		line, err := reader.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return msg, "", usage, err
+		}
+		eof := errors.Is(err, io.EOF)
+		line = strings.TrimRight(line, "\r\n")
+		if after, ok := strings.CutPrefix(line, "data:"); ok {
+			data.WriteString(strings.TrimPrefix(after, " "))
+		}
+		if line != "" && !eof {
+			continue
+		}
+		if data.Len() == 0 {
+			if eof {
+				return msg, stopReason, usage, nil
+			}
+			continue
+		}
+		var event StreamEvent
+		if err := json.Unmarshal([]byte(data.String()), &event); err != nil {
+			return msg, "", usage, fmt.Errorf("unmarshal event: %w", err)
+		}
+		data.Reset()
+		switch event.Type {
+		case "message_start":
+			if event.Message != nil {
+				usage = event.Message.Usage
+			}
+			w.WriteRole(estellm.RoleAssistant)
+		case "content_block_start":
+			if event.ContentBlock == nil {
+				continue
+			}
+			cb := *event.ContentBlock
+			current = &cb
+			toolInputBuilder.Reset()
+			switch cb.Type {
+			case "text":
+				if cb.Text != "" {
+					w.WritePart(estellm.TextPart(cb.Text))
+				}
+			case "thinking":
+				if cb.Thinking != "" {
+					w.WritePart(estellm.ReasoningPart(cb.Thinking))
+				}
+			}
+		case "content_block_delta":
+			if current == nil || event.Delta == nil {
+				continue
+			}
+			switch event.Delta.Type {
+			case "text_delta":
+				current.Text += event.Delta.Text
+				w.WritePart(estellm.TextPart(event.Delta.Text))
+			case "thinking_delta":
+				current.Thinking += event.Delta.Thinking
+				w.WritePart(estellm.ReasoningPart(event.Delta.Thinking))
+			case "signature_delta":
+				current.Signature += event.Delta.Signature
+			case "input_json_delta":
+				toolInputBuilder.WriteString(event.Delta.PartialJSON)
+			default:
+				slog.DebugContext(ctx, "unknown delta", "type", event.Delta.Type)
+			}
+		case "content_block_stop":
+			if current == nil {
+				continue
+			}
+			if current.Type == "tool_use" {
+				if toolInputBuilder.Len() > 0 {
+					current.Input = json.RawMessage(toolInputBuilder.String())
+				}
+				if len(current.Input) == 0 {
+					current.Input = json.RawMessage("{}")
+				}
+			}
+			msg.Content = append(msg.Content, *current)
+			current = nil
+		case "message_delta":
+			if event.Delta != nil && event.Delta.StopReason != "" {
+				stopReason = event.Delta.StopReason
+			}
+			if event.Usage != nil {
+				usage.OutputTokens = event.Usage.OutputTokens
+			}
+		case "message_stop":
+			return msg, stopReason, usage, nil
+		case "error":
+			apiErr := &APIError{Type: "unknown_error"}
+			if event.Error != nil {
+				apiErr.Type = event.Error.Type
+				apiErr.Message = event.Error.Message
+			}
+			apiErr.StatusCode = errorTypeStatusCodes[apiErr.Type]
+			return msg, "", usage, apiErr
+		case "ping":
+		default:
+			slog.DebugContext(ctx, "unknown event", "type", event.Type)
+		}
+		if eof {
+			return msg, stopReason, usage, nil
+		}
+	}
+}
+
+func newToolResult(result estellm.ToolResult) ContentBlock {
+	if result.Err != nil {
+		return newToolResultWithError(result.ToolUse.ID, result.Err)
+	}
+	return newToolResultWithResponse(result.ToolUse.ID, result.Response)
+}
+
+func newToolResultWithError(toolUseID string, err error) ContentBlock {
+	return ContentBlock{
+		Type:      "tool_result",
+		ToolUseID: toolUseID,
+		IsError:   true,
+		Content: []ContentBlock{
+			{
+				Type: "text",
+				Text: fmt.Sprintf("error: %s", err),
+			},
+		},
+	}
+}
+
+func newToolResultWithResponse(toolUseID string, response *estellm.Response) ContentBlock {
+	content := make([]ContentBlock, 0, len(response.Message.Parts))
+	for _, part := range response.Message.Parts {
+		switch part.Type {
+		case estellm.PartTypeText:
+			content = append(content, ContentBlock{
+				Type: "text",
+				Text: part.Text,
+			})
+		case estellm.PartTypeBinary:
+			cb, err := binaryContentBlock(part)
+			if err != nil {
+				return newToolResultWithError(toolUseID, fmt.Errorf("tool result: %w", err))
+			}
+			content = append(content, cb)
+		}
+	}
+	return ContentBlock{
+		Type:      "tool_result",
+		ToolUseID: toolUseID,
+		Content:   content,
+	}
+}
+
+func (p *ModelProvider) GenerateImage(_ context.Context, _ *estellm.GenerateImageRequest, _ estellm.ResponseWriter) error {
+	return fmt.Errorf("anthropic image generation: %w", estellm.ErrNotSupported)
+}
@@ -0,0 +1,300 @@
+package anthropic_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mashiike/estellm"
+	"github.com/mashiike/estellm/metadata"
+	"github.com/mashiike/estellm/provider/anthropic"
+	"github.com/stretchr/testify/require"
+)
+
+type stubServer struct {
+	*httptest.Server
+	mu        sync.Mutex
+	requests  []map[string]any
+	headers   []http.Header
+	responses []string
+	status    int
+}
+
+func newStubServer(t *testing.T, responses ...string) *stubServer {
+	t.Helper()
+	s := &stubServer{responses: responses, status: http.StatusOK}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		require.Equal(t, "/v1/messages", r.URL.Path)
+		bs, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		var body map[string]any
+		require.NoError(t, json.Unmarshal(bs, &body))
+		s.requests = append(s.requests, body)
+		s.headers = append(s.headers, r.Header.Clone())
+		if len(s.responses) == 0 {
+			t.Fatal("unexpected request")
+		}
+		resp := s.responses[0]
+		s.responses = s.responses[1:]
+		if s.status != http.StatusOK {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(s.status)
+			io.WriteString(w, resp)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Request-Id", "req_123")
+		io.WriteString(w, resp)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func sse(events ...string) string {
+	var sb strings.Builder
+	for _, event := range events {
+		var v struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal([]byte(event), &v); err != nil {
+			panic(err)
+		}
+		fmt.Fprintf(&sb, "event: %s\ndata: %s\n\n", v.Type, event)
+	}
+	return sb.String()
+}
+
+func newRequest(endpoint string, parts ...estellm.ContentPart) *estellm.GenerateTextRequest {
+	return &estellm.GenerateTextRequest{
+		ModelID: "claude-sonnet-4-20250514",
+		ModelParams: map[string]any{
+			"endpoint": endpoint,
+			"api_key":  "test-key",
+		},
+		System: "You are a helpful assistant.",
+		Messages: []estellm.Message{
+			{
+				Role:  estellm.RoleUser,
+				Parts: parts,
+			},
+		},
+	}
+}
+
+func TestGenerateText(t *testing.T) {
+	s := newStubServer(t, sse(
+		`{"type":"message_start","message":{"id":"msg_1","role":"assistant","model":"claude-sonnet-4-20250514","usage":{"input_tokens":10,"output_tokens":1}}}`,
+		`{"type":"content_block_start","index":0,"content_block":{"type":"thinking","thinking":""}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"thinking_delta","thinking":"Let me think."}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"signature_delta","signature":"sig"}}`,
+		`{"type":"content_block_stop","index":0}`,
+		`{"type":"content_block_start","index":1,"content_block":{"type":"text","text":""}}`,
+		`{"type":"ping"}`,
+		`{"type":"content_block_delta","index":1,"delta":{"type":"text_delta","text":"Hello"}}`,
+		`{"type":"content_block_delta","index":1,"delta":{"type":"text_delta","text":", world"}}`,
+		`{"type":"content_block_stop","index":1}`,
+		`{"type":"message_delta","delta":{"stop_reason":"max_tokens"},"usage":{"output_tokens":5}}`,
+		`{"type":"message_stop"}`,
+	))
+	p := &anthropic.ModelProvider{}
+	req := newRequest(s.URL,
+		estellm.TextPart("Hi"),
+		estellm.BinaryPart("image/png", []byte("png")),
+		estellm.BinaryPart("application/pdf", []byte("pdf")),
+	)
+	req.ModelParams["max_tokens"] = 1024
+	req.ModelParams["thinking"] = map[string]any{"type": "enabled", "budget_tokens": 512}
+	w := estellm.NewBatchResponseWriter()
+	require.NoError(t, p.GenerateText(context.Background(), req, w))
+
+	require.Len(t, s.requests, 1)
+	require.Equal(t, "test-key", s.headers[0].Get("X-Api-Key"))
+	require.Equal(t, anthropic.APIVersion, s.headers[0].Get("Anthropic-Version"))
+	bs, err := json.Marshal(s.requests[0])
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"model": "claude-sonnet-4-20250514",
+		"max_tokens": 1024,
+		"system": "You are a helpful assistant.",
+		"stream": true,
+		"thinking": {"type":"enabled","budget_tokens":512},
+		"messages": [
+			{
+				"role": "user",
+				"content": [
+					{"type":"text","text":"Hi"},
+					{"type":"image","source":{"type":"base64","media_type":"image/png","data":"cG5n"}},
+					{"type":"document","source":{"type":"base64","media_type":"application/pdf","data":"cGRm"}}
+				]
+			}
+		]
+	}`, string(bs))
+
+	resp := w.Response()
+	require.Equal(t, estellm.FinishReasonMaxTokens, resp.FinishReason)
+	require.Equal(t, []estellm.ContentPart{
+		estellm.ReasoningPart("Let me think."),
+		estellm.TextPart("Hello, world"),
+	}, resp.Message.Parts)
+	inputTokens, _ := metadata.GetInputTokens(resp.Metadata)
+	outputTokens, _ := metadata.GetOutputTokens(resp.Metadata)
+	require.EqualValues(t, 10, inputTokens)
+	require.EqualValues(t, 5, outputTokens)
+	require.Equal(t, "req_123", resp.Metadata.GetString("Request-Id"))
+}
+
+type weatherTool struct {
+	inputs []any
+}
+
+func (t *weatherTool) Name() string        { return "get weather" }
+func (t *weatherTool) Description() string { return "get the weather" }
+func (t *weatherTool) InputSchema() map[string]any {
+	return map[string]any{"type": "object", "properties": map[string]any{"city": map[string]any{"type": "string"}}}
+}
+func (t *weatherTool) Call(_ context.Context, input any, w estellm.ResponseWriter) error {
+	t.inputs = append(t.inputs, input)
+	return w.WritePart(estellm.TextPart("sunny"))
+}
+
+func TestGenerateText__ToolUse(t *testing.T) {
+	s := newStubServer(t,
+		sse(
+			`{"type":"message_start","message":{"id":"msg_1","role":"assistant","usage":{"input_tokens":20,"output_tokens":1}}}`,
+			`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Checking."}}`,
+			`{"type":"content_block_stop","index":0}`,
+			`{"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_1","name":"get_weather","input":{}}}`,
+			`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"city\":"}}`,
+			`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"\"Tokyo\"}"}}`,
+			`{"type":"content_block_stop","index":1}`,
+			`{"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":15}}`,
+			`{"type":"message_stop"}`,
+		),
+		sse(
+			`{"type":"message_start","message":{"id":"msg_2","role":"assistant","usage":{"input_tokens":40,"output_tokens":1}}}`,
+			`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":" It is sunny."}}`,
+			`{"type":"content_block_stop","index":0}`,
+			`{"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":6}}`,
+			`{"type":"message_stop"}`,
+		),
+	)
+	tool := &weatherTool{}
+	p := &anthropic.ModelProvider{}
+	req := newRequest(s.URL, estellm.TextPart("How is the weather in Tokyo?"))
+	req.Tools = estellm.ToolSet{tool}
+	w := estellm.NewBatchResponseWriter()
+	require.NoError(t, p.GenerateText(context.Background(), req, w))
+
+	require.Equal(t, []any{map[string]any{"city": "Tokyo"}}, tool.inputs)
+	require.Len(t, s.requests, 2)
+	require.Equal(t, []any{
+		map[string]any{
+			"name":         "get_weather",
+			"description":  "get the weather",
+			"input_schema": tool.InputSchema(),
+		},
+	}, s.requests[0]["tools"])
+	bs, err := json.Marshal(s.requests[1]["messages"])
+	require.NoError(t, err)
+	require.JSONEq(t, `[
+		{"role":"user","content":[{"type":"text","text":"How is the weather in Tokyo?"}]},
+		{"role":"assistant","content":[
+			{"type":"text","text":"Checking."},
+			{"type":"tool_use","id":"toolu_1","name":"get_weather","input":{"city":"Tokyo"}}
+		]},
+		{"role":"user","content":[
+			{"type":"tool_result","tool_use_id":"toolu_1","content":[{"type":"text","text":"sunny"}]}
+		]}
+	]`, string(bs))
+
+	resp := w.Response()
+	require.Equal(t, estellm.FinishReasonEndTurn, resp.FinishReason)
+	require.Equal(t, []estellm.ContentPart{estellm.TextPart("Checking. It is sunny.")}, resp.Message.Parts)
+	inputTokens, _ := metadata.GetInputTokens(resp.Metadata)
+	outputTokens, _ := metadata.GetOutputTokens(resp.Metadata)
+	totalTokens, _ := metadata.GetTotalTokens(resp.Metadata)
+	require.EqualValues(t, 60, inputTokens)
+	require.EqualValues(t, 21, outputTokens)
+	require.EqualValues(t, 81, totalTokens)
+}
+
+func TestGenerateText__APIError(t *testing.T) {
+	s := newStubServer(t, `{"type":"error","error":{"type":"rate_limit_error","message":"slow down"}}`)
+	s.status = http.StatusTooManyRequests
+	p := &anthropic.ModelProvider{}
+	err := p.GenerateText(context.Background(), newRequest(s.URL, estellm.TextPart("Hi")), estellm.NewBatchResponseWriter())
+	var apiErr *anthropic.APIError
+	require.True(t, errors.As(err, &apiErr))
+	require.Equal(t, http.StatusTooManyRequests, apiErr.HTTPStatusCode())
+	require.Equal(t, "rate_limit_error", apiErr.Type)
+	require.Equal(t, "slow down", apiErr.Message)
+}
+
+func TestGenerateText__StreamError(t *testing.T) {
+	s := newStubServer(t, sse(
+		`{"type":"message_start","message":{"id":"msg_1","role":"assistant","usage":{"input_tokens":10,"output_tokens":1}}}`,
+		`{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`,
+	))
+	p := &anthropic.ModelProvider{}
+	err := p.GenerateText(context.Background(), newRequest(s.URL, estellm.TextPart("Hi")), estellm.NewBatchResponseWriter())
+	var apiErr *anthropic.APIError
+	require.True(t, errors.As(err, &apiErr))
+	require.Equal(t, 529, apiErr.HTTPStatusCode())
+}
+
+func TestGenerateText__Refusal(t *testing.T) {
+	s := newStubServer(t, sse(
+		`{"type":"message_start","message":{"id":"msg_1","role":"assistant","usage":{"input_tokens":10,"output_tokens":1}}}`,
+		`{"type":"message_delta","delta":{"stop_reason":"refusal"},"usage":{"output_tokens":1}}`,
+		`{"type":"message_stop"}`,
+	))
+	p := &anthropic.ModelProvider{}
+	w := estellm.NewBatchResponseWriter()
+	require.NoError(t, p.GenerateText(context.Background(), newRequest(s.URL, estellm.TextPart("Hi")), w))
+	require.Equal(t, estellm.FinishReasonContentFiltered, w.Response().FinishReason)
+}
+
+func TestGenerateText__CachePoint(t *testing.T) {
+	s := newStubServer(t, sse(
+		`{"type":"message_start","message":{"id":"msg_1","role":"assistant","usage":{"input_tokens":10,"output_tokens":1,"cache_creation_input_tokens":24,"cache_read_input_tokens":1000}}}`,
+		`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hello"}}`,
+		`{"type":"content_block_stop","index":0}`,
+		`{"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":5}}`,
+		`{"type":"message_stop"}`,
+	))
+	p := &anthropic.ModelProvider{}
+	req := newRequest(s.URL,
+		estellm.TextPart("long example"),
+		estellm.CachePointPart(),
+		estellm.TextPart("Hi"),
+	)
+	req.System += estellm.CachePointMarkup
+	w := estellm.NewBatchResponseWriter()
+	require.NoError(t, p.GenerateText(context.Background(), req, w))
+
+	bs, err := json.Marshal(s.requests[0]["system"])
+	require.NoError(t, err)
+	require.JSONEq(t, `[{"type":"text","text":"You are a helpful assistant.","cache_control":{"type":"ephemeral"}}]`, string(bs))
+	bs, err = json.Marshal(s.requests[0]["messages"])
+	require.NoError(t, err)
+	require.JSONEq(t, `[{"role":"user","content":[
+		{"type":"text","text":"long example","cache_control":{"type":"ephemeral"}},
+		{"type":"text","text":"Hi"}
+	]}]`, string(bs))
+	cacheRead, _ := metadata.GetCacheReadInputTokens(w.Response().Metadata)
+	cacheWrite, _ := metadata.GetCacheWriteInputTokens(w.Response().Metadata)
+	require.EqualValues(t, 1000, cacheRead)
+	require.EqualValues(t, 24, cacheWrite)
+}
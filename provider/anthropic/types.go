@@ -0,0 +1,145 @@
+package anthropic
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// https://docs.anthropic.com/en/api/messages
+type MessagesRequest struct {
+	Model         string         `json:"model"`
+	MaxTokens     int            `json:"max_tokens"`
+	System        any            `json:"system,omitempty"` // string, or []ContentBlock with cache_control
+	Messages      []Message      `json:"messages"`
+	Tools         []Tool         `json:"tools,omitempty"`
+	ToolChoice    *ToolChoice    `json:"tool_choice,omitempty"`
+	Stream        bool           `json:"stream"`
+	Temperature   *float64       `json:"temperature,omitempty"`
+	TopP          *float64       `json:"top_p,omitempty"`
+	TopK          *int           `json:"top_k,omitempty"`
+	StopSequences []string       `json:"stop_sequences,omitempty"`
+	Thinking      *Thinking      `json:"thinking,omitempty"`
+	Metadata      map[string]any `json:"metadata,omitempty"`
+}
+
+type ToolChoice struct {
+	Type string `json:"type"`
+}
+
+type Thinking struct {
+	Type         string `json:"type"`
+	BudgetTokens int    `json:"budget_tokens,omitempty"`
+}
+
+type Message struct {
+	Role    string         `json:"role"`
+	Content []ContentBlock `json:"content"`
+}
+
+type ContentBlock struct {
+	Type string `json:"type"`
+
+	// text
+	Text string `json:"text,omitempty"`
+
+	// image, document
+	Source *Source `json:"source,omitempty"`
+
+	// tool_use
+	ID    string          `json:"id,omitempty"`
+	Name  string          `json:"name,omitempty"`
+	Input json.RawMessage `json:"input,omitempty"`
+
+	// tool_result
+	ToolUseID string         `json:"tool_use_id,omitempty"`
+	Content   []ContentBlock `json:"content,omitempty"`
+	IsError   bool           `json:"is_error,omitempty"`
+
+	// thinking, redacted_thinking
+	Thinking  string `json:"thinking,omitempty"`
+	Signature string `json:"signature,omitempty"`
+	Data      string `json:"data,omitempty"`
+
+	CacheControl *CacheControl `json:"cache_control,omitempty"`
+}
+
+type CacheControl struct {
+	Type string `json:"type"`
+}
+
+func ephemeralCacheControl() *CacheControl {
+	return &CacheControl{Type: "ephemeral"}
+}
+
+type Source struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type"`
+	Data      string `json:"data"`
+}
+
+type Tool struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	InputSchema map[string]any `json:"input_schema"`
+
+	CacheControl *CacheControl `json:"cache_control,omitempty"`
+}
+
+type Usage struct {
+	InputTokens              int64 `json:"input_tokens"`
+	OutputTokens             int64 `json:"output_tokens"`
+	CacheCreationInputTokens int64 `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int64 `json:"cache_read_input_tokens"`
+}
+
+type StreamEvent struct {
+	Type         string        `json:"type"`
+	Index        int           `json:"index"`
+	Message      *EventMessage `json:"message,omitempty"`
+	ContentBlock *ContentBlock `json:"content_block,omitempty"`
+	Delta        *EventDelta   `json:"delta,omitempty"`
+	Usage        *Usage        `json:"usage,omitempty"`
+	Error        *ErrorDetail  `json:"error,omitempty"`
+}
+
+type EventMessage struct {
+	ID    string `json:"id"`
+	Model string `json:"model"`
+	Role  string `json:"role"`
+	Usage Usage  `json:"usage"`
+}
+
+type EventDelta struct {
+	Type         string `json:"type"`
+	Text         string `json:"text"`
+	Thinking     string `json:"thinking"`
+	Signature    string `json:"signature"`
+	PartialJSON  string `json:"partial_json"`
+	StopReason   string `json:"stop_reason"`
+	StopSequence string `json:"stop_sequence"`
+}
+
+type ErrorDetail struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+type ErrorResponse struct {
+	Type  string      `json:"type"`
+	Error ErrorDetail `json:"error"`
+}
+
+// APIError is returned when the Messages API responds with an error.
+type APIError struct {
+	StatusCode int
+	Type       string
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("anthropic api error: status %d, %s: %s", e.StatusCode, e.Type, e.Message)
+}
+
+func (e *APIError) HTTPStatusCode() int {
+	return e.StatusCode
+}
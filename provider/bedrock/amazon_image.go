@@ -0,0 +1,162 @@
+package bedrock
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/mashiike/estellm"
+	"github.com/mashiike/estellm/jsonutil"
+)
+
+// https://docs.aws.amazon.com/bedrock/latest/userguide/model-parameters-titan-image.html
+// Nova Canvas accepts the same request shape.
+type AmazonImageRequest struct {
+	TaskType              string                       `json:"taskType"`
+	TextToImageParams     *AmazonTextToImageParams     `json:"textToImageParams,omitempty"`
+	ImageVariationParams  *AmazonImageVariationParams  `json:"imageVariationParams,omitempty"`
+	InPaintingParams      *AmazonInPaintingParams      `json:"inPaintingParams,omitempty"`
+	ImageGenerationConfig *AmazonImageGenerationConfig `json:"imageGenerationConfig,omitempty"`
+}
+
+type AmazonTextToImageParams struct {
+	Text            string  `json:"text"`
+	NegativeText    string  `json:"negativeText,omitempty"`
+	ConditionImage  string  `json:"conditionImage,omitempty"`
+	ControlMode     string  `json:"controlMode,omitempty"`
+	ControlStrength float64 `json:"controlStrength,omitempty"`
+}
+
+type AmazonImageVariationParams struct {
+	Text               string   `json:"text,omitempty"`
+	NegativeText       string   `json:"negativeText,omitempty"`
+	Images             []string `json:"images"`
+	SimilarityStrength float64  `json:"similarityStrength,omitempty"`
+}
+
+type AmazonInPaintingParams struct {
+	Image        string `json:"image"`
+	Text         string `json:"text,omitempty"`
+	NegativeText string `json:"negativeText,omitempty"`
+	MaskPrompt   string `json:"maskPrompt,omitempty"`
+	MaskImage    string `json:"maskImage,omitempty"`
+}
+
+type AmazonImageGenerationConfig struct {
+	NumberOfImages int     `json:"numberOfImages,omitempty"`
+	Height         int     `json:"height,omitempty"`
+	Width          int     `json:"width,omitempty"`
+	CFGScale       float64 `json:"cfgScale,omitempty"`
+	Seed           *int64  `json:"seed,omitempty"`
+	Quality        string  `json:"quality,omitempty"`
+}
+
+type AmazonImageResponse struct {
+	Images []string `json:"images"`
+	Error  string   `json:"error"`
+}
+
+// AmazonImageModelParams is the model_params for Titan Image Generator and Nova Canvas.
+type AmazonImageModelParams struct {
+	NegativeText       string  `json:"negative_text,omitempty"`
+	NumberOfImages     int     `json:"number_of_images,omitempty"`
+	Height             int     `json:"height,omitempty"`
+	Width              int     `json:"width,omitempty"`
+	CFGScale           float64 `json:"cfg_scale,omitempty"`
+	Seed               *int64  `json:"seed,omitempty"`
+	Quality            string  `json:"quality,omitempty"`
+	ControlMode        string  `json:"control_mode,omitempty"`
+	ControlStrength    float64 `json:"control_strength,omitempty"`
+	SimilarityStrength float64 `json:"similarity_strength,omitempty"`
+	MaskPrompt         string  `json:"mask_prompt,omitempty"`
+}
+
+func isAmazonImageModel(modelID string) bool {
+	return strings.Contains(modelID, "amazon.titan-image-generator") || strings.Contains(modelID, "amazon.nova-canvas")
+}
+
+func (p *ModelProvider) generateImageAmazon(ctx context.Context, req *estellm.GenerateImageRequest, prompt string, w estellm.ResponseWriter) error {
+	var params AmazonImageModelParams
+	if err := jsonutil.Remarshal(req.ModelParams, &params); err != nil {
+		return fmt.Errorf("remarshal image request: %w", err)
+	}
+	jsonPrompt := parseImagePrompt(prompt)
+	negativeText := params.NegativeText
+	if negativeText == "" {
+		negativeText = jsonPrompt.NegativePrompt
+	}
+	imageReq := &AmazonImageRequest{
+		ImageGenerationConfig: &AmazonImageGenerationConfig{
+			NumberOfImages: params.NumberOfImages,
+			Height:         params.Height,
+			Width:          params.Width,
+			CFGScale:       params.CFGScale,
+			Seed:           params.Seed,
+			Quality:        params.Quality,
+		},
+	}
+	images, mask := req.InputImages()
+	switch mode := req.ImageMode(); mode {
+	case estellm.ImageModeGenerate:
+		imageReq.TaskType = "TEXT_IMAGE"
+		imageReq.TextToImageParams = &AmazonTextToImageParams{
+			Text:         jsonPrompt.Prompt,
+			NegativeText: negativeText,
+		}
+		if len(images) > 0 {
+			imageReq.TextToImageParams.ConditionImage = base64.StdEncoding.EncodeToString(images[0].Data)
+			imageReq.TextToImageParams.ControlMode = params.ControlMode
+			imageReq.TextToImageParams.ControlStrength = params.ControlStrength
+		}
+	case estellm.ImageModeVariation:
+		if len(images) == 0 {
+			return fmt.Errorf("image %s requires an input image", mode)
+		}
+		imageReq.TaskType = "IMAGE_VARIATION"
+		imageReq.ImageVariationParams = &AmazonImageVariationParams{
+			Text:               jsonPrompt.Prompt,
+			NegativeText:       negativeText,
+			SimilarityStrength: params.SimilarityStrength,
+		}
+		for _, image := range images {
+			imageReq.ImageVariationParams.Images = append(imageReq.ImageVariationParams.Images, base64.StdEncoding.EncodeToString(image.Data))
+		}
+	case estellm.ImageModeEdit:
+		if len(images) == 0 {
+			return fmt.Errorf("image %s requires an input image", mode)
+		}
+		if mask == nil && params.MaskPrompt == "" {
+			return fmt.Errorf("image %s requires a mask image or mask_prompt", mode)
+		}
+		imageReq.TaskType = "INPAINTING"
+		imageReq.InPaintingParams = &AmazonInPaintingParams{
+			Image:        base64.StdEncoding.EncodeToString(images[0].Data),
+			Text:         jsonPrompt.Prompt,
+			NegativeText: negativeText,
+			MaskPrompt:   params.MaskPrompt,
+		}
+		if mask != nil {
+			imageReq.InPaintingParams.MaskImage = base64.StdEncoding.EncodeToString(mask.Data)
+		}
+	default:
+		return fmt.Errorf("image mode `%s`: %w", mode, estellm.ErrNotSupported)
+	}
+	w.WritePart(estellm.TextPart(fmt.Sprintf("<prompt type=\"provided\">%s</prompt>", prompt)))
+	var resp AmazonImageResponse
+	if err := p.invokeModelJSON(ctx, req.ModelID, imageReq, &resp, w.Metadata()); err != nil {
+		return err
+	}
+	if resp.Error != "" {
+		return fmt.Errorf("failed to generate image: %s", resp.Error)
+	}
+	for _, image := range resp.Images {
+		data, err := base64.StdEncoding.DecodeString(image)
+		if err != nil {
+			return fmt.Errorf("failed to decode image: %w", err)
+		}
+		w.WritePart(estellm.BinaryPart("image/png", data))
+	}
+	w.Finish(estellm.FinishReasonEndTurn, fmt.Sprintf("generate %d images", len(resp.Images)))
+	return nil
+}
@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"context"
 	"crypto/sha256"
-	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
@@ -79,13 +78,25 @@ func (p *ModelProvider) GenerateText(ctx context.Context, req *estellm.GenerateT
 	input := &bedrockruntime.ConverseStreamInput{
 		ModelId: aws.String(req.ModelID),
 	}
-	if req.System != "" {
-		input.System = []types.SystemContentBlock{
-			&types.SystemContentBlockMemberText{
-				Value: req.System,
-			},
+	for _, part := range req.SystemPromptParts() {
+		switch part.Type {
+		case estellm.PartTypeText:
+			input.System = append(input.System, &types.SystemContentBlockMemberText{
+				Value: part.Text,
+			})
+		case estellm.PartTypeCachePoint:
+			input.System = append(input.System, &types.SystemContentBlockMemberCachePoint{
+				Value: defaultCachePoint,
+			})
+		case estellm.PartTypeGuardContent:
+			input.System = append(input.System, &types.SystemContentBlockMemberGuardContent{
+				Value: guardContentBlock(part),
+			})
 		}
 	}
+	if req.Guardrail != nil {
+		input.GuardrailConfig = guardrailConfig(req.Guardrail)
+	}
 	documentCount := 0
 	for _, msg := range req.Messages {
 		var tMsg types.Message
@@ -149,6 +160,17 @@ func (p *ModelProvider) GenerateText(ctx context.Context, req *estellm.GenerateT
 				default:
 					return fmt.Errorf("unsupported binary content type: %s", mediaType)
 				}
+			case estellm.PartTypeReasoning:
+				// reasoning parts carry no signature, so they cannot be replayed to the model
+				continue
+			case estellm.PartTypeCachePoint:
+				tMsg.Content = append(tMsg.Content, &types.ContentBlockMemberCachePoint{
+					Value: defaultCachePoint,
+				})
+			case estellm.PartTypeGuardContent:
+				tMsg.Content = append(tMsg.Content, &types.ContentBlockMemberGuardContent{
+					Value: guardContentBlock(part),
+				})
 			default:
 				return fmt.Errorf("unsupported content type: %s", part.Type)
 			}
@@ -178,6 +200,22 @@ func (p *ModelProvider) GenerateText(ctx context.Context, req *estellm.GenerateT
 		input.InferenceConfig.StopSequences = stopWords
 		delete(params, "stop_words")
 	}
+	if thinking, ok := req.ModelParams["thinking"]; ok {
+		cfg, err := thinkingConfig(thinking)
+		if err != nil {
+			return err
+		}
+		if cfg == nil {
+			delete(params, "thinking")
+		} else {
+			params["thinking"] = cfg
+		}
+	}
+	cacheTools, _ := params["cache_tools"].(bool)
+	delete(params, "cache_tools")
+	for _, key := range estellm.ToolExecutorModelParams {
+		delete(params, key)
+	}
 	if len(params) > 0 {
 		input.AdditionalModelRequestFields = document.NewLazyDocument(params)
 	}
@@ -203,8 +241,70 @@ func (p *ModelProvider) GenerateText(ctx context.Context, req *estellm.GenerateT
 				},
 			})
 		}
+		if cacheTools {
+			input.ToolConfig.Tools = append(input.ToolConfig.Tools, &types.ToolMemberCachePoint{
+				Value: defaultCachePoint,
+			})
+		}
+	}
+	executor, err := estellm.NewToolExecutor(req.Tools, req.ModelParams, NormalizeToolName)
+	if err != nil {
+		return err
+	}
+	return p.generateTextMultiTurn(ctx, input, w, executor)
+}
+
+var defaultCachePoint = types.CachePointBlock{Type: types.CachePointTypeDefault}
+
+func guardContentBlock(part estellm.ContentPart) types.GuardrailConverseContentBlock {
+	block := types.GuardrailConverseTextBlock{
+		Text: aws.String(part.Text),
+	}
+	for _, q := range part.Qualifiers {
+		block.Qualifiers = append(block.Qualifiers, types.GuardrailConverseContentQualifier(strings.TrimSpace(q)))
+	}
+	return &types.GuardrailConverseContentBlockMemberText{Value: block}
+}
+
+func guardrailConfig(cfg *estellm.GuardrailConfig) *types.GuardrailStreamConfiguration {
+	gc := &types.GuardrailStreamConfiguration{
+		GuardrailIdentifier:  aws.String(cfg.Identifier),
+		GuardrailVersion:     aws.String(cfg.Version),
+		Trace:                types.GuardrailTrace(cfg.Trace),
+		StreamProcessingMode: types.GuardrailStreamProcessingMode(cfg.StreamProcessingMode),
+	}
+	if gc.Trace == "" {
+		gc.Trace = types.GuardrailTraceEnabled
+	}
+	return gc
+}
+
+const defaultThinkingBudgetTokens = 4096
+
+// thinkingConfig converts the `thinking` model param into the Anthropic extended thinking config.
+// a number is used as budget_tokens, true enables thinking with the default budget.
+func thinkingConfig(v any) (map[string]any, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case bool:
+		if !v {
+			return nil, nil
+		}
+		return map[string]any{"type": "enabled", "budget_tokens": defaultThinkingBudgetTokens}, nil
+	case map[string]any:
+		cfg := maps.Clone(v)
+		if _, ok := cfg["type"]; !ok {
+			cfg["type"] = "enabled"
+		}
+		return cfg, nil
+	default:
+		budget := toNumber[int64](v)
+		if budget <= 0 {
+			return nil, fmt.Errorf("invalid thinking budget: %v", v)
+		}
+		return map[string]any{"type": "enabled", "budget_tokens": budget}, nil
 	}
-	return p.generateTextMultiTurn(ctx, input, w, req.Tools)
 }
 
 var (
@@ -225,14 +325,20 @@ func NormalizeToolName(input string) string {
 	return normalized
 }
 
-func (p *ModelProvider) generateTextMultiTurn(ctx context.Context, input *bedrockruntime.ConverseStreamInput, w estellm.ResponseWriter, tools estellm.ToolSet) error {
+func (p *ModelProvider) generateTextMultiTurn(ctx context.Context, input *bedrockruntime.ConverseStreamInput, w estellm.ResponseWriter, executor *estellm.ToolExecutor) error {
 	slog.DebugContext(ctx, "converse stream", "input", input)
+	var inputTokens int64
+	var outputTokens int64
+	var totalTokens int64
+	var cacheReadInputTokens int64
+	var cacheWriteInputTokens int64
 	for {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
 		default:
 		}
+		executor.NextTurn(w.Metadata())
 		slog.DebugContext(ctx, "call converse stream")
 		output, err := p.client.ConverseStream(ctx, input)
 		if err != nil {
@@ -242,10 +348,9 @@ func (p *ModelProvider) generateTextMultiTurn(ctx context.Context, input *bedroc
 		var msg types.Message
 		var currentContent types.ContentBlock
 		var toolInputBuilder bytes.Buffer
-		var inputTokens int64
-		var outputTokens int64
-		var totalTokens int64
-		for o := range output.GetStream().Events() {
+		var stopped, toolUse bool
+		stream := output.GetStream()
+		for o := range stream.Events() {
 			switch v := o.(type) {
 			case *types.ConverseStreamOutputMemberContentBlockStart:
 				cb, err := processContentBlockStart(ctx, v, w, &toolInputBuilder)
@@ -270,6 +375,7 @@ func (p *ModelProvider) generateTextMultiTurn(ctx context.Context, input *bedroc
 							Input: document.NewLazyDocument(input),
 						},
 					})
+					toolInputBuilder.Reset()
 				}
 				if currentContent != nil {
 					msg.Content = append(msg.Content, currentContent)
@@ -296,50 +402,72 @@ func (p *ModelProvider) generateTextMultiTurn(ctx context.Context, input *bedroc
 						totalTokens += int64(*v.Value.Usage.TotalTokens)
 						metadata.SetTotalTokens(m, totalTokens)
 					}
+					if v.Value.Usage.CacheReadInputTokens != nil {
+						cacheReadInputTokens += int64(*v.Value.Usage.CacheReadInputTokens)
+						metadata.SetCacheReadInputTokens(m, cacheReadInputTokens)
+					}
+					if v.Value.Usage.CacheWriteInputTokens != nil {
+						cacheWriteInputTokens += int64(*v.Value.Usage.CacheWriteInputTokens)
+						metadata.SetCacheWriteInputTokens(m, cacheWriteInputTokens)
+					}
 				}
 				slog.DebugContext(ctx, "metadata updated", "value", v.Value)
 			case *types.ConverseStreamOutputMemberMessageStop:
 				slog.Debug("message complete", "message", msg)
-				toolUse, err := processMessageStop(ctx, v, w)
+				toolUse, err = processMessageStop(ctx, v, w)
 				if err != nil {
 					return fmt.Errorf("process message stop: %w", err)
 				}
-				if !toolUse {
-					return nil
-				}
+				// the metadata event with the usage follows the message stop event
+				stopped = true
 			default:
 				slog.DebugContext(ctx, "unknown event", "type", fmt.Sprintf("%T", o))
 			}
 		}
+		stream.Close()
+		if err := stream.Err(); err != nil {
+			return fmt.Errorf("converse stream: %w", err)
+		}
+		if !stopped {
+			return errors.New("converse stream: closed before the message stop")
+		}
+		if !toolUse {
+			return nil
+		}
+		if executor.Exhausted() {
+			// the model calls the tools even in the final turn.
+			w.Finish(estellm.FinishReasonEndTurn, "max_tool_iterations")
+			return nil
+		}
 		input.Messages = append(input.Messages, msg)
 		toolUses, err := extructToolUse(msg)
 		if err != nil {
 			return fmt.Errorf("extract tool use: %w", err)
 		}
 		slog.DebugContext(ctx, "tool use", "tool_uses", toolUses)
+		results, err := executor.Execute(ctx, toolUses)
+		if err != nil {
+			return err
+		}
 		toolResultMsg := types.Message{
 			Role: types.ConversationRoleUser,
 		}
-		for _, toolUse := range toolUses {
-			cb, err := toolCall(ctx, tools, toolUse.toolUseID, toolUse.toolName, toolUse.input)
-			if err != nil {
-				cb = newToolResultWithError(toolUse.toolUseID, err)
-			}
-			toolResultMsg.Content = append(toolResultMsg.Content, cb)
+		for _, result := range results {
+			toolResultMsg.Content = append(toolResultMsg.Content, newToolResult(result))
+		}
+		if executor.Exhausted() {
+			// converse has no tool choice to disable the tools, so the model is instructed.
+			toolResultMsg.Content = append(toolResultMsg.Content, &types.ContentBlockMemberText{
+				Value: estellm.FinalTurnInstruction,
+			})
 		}
 		slog.DebugContext(ctx, "tool result", "message", toolResultMsg)
 		input.Messages = append(input.Messages, toolResultMsg)
 	}
 }
 
-type toolUse struct {
-	toolUseID string
-	toolName  string
-	input     any
-}
-
-func extructToolUse(msg types.Message) ([]toolUse, error) {
-	toolUses := make([]toolUse, 0, len(msg.Content))
+func extructToolUse(msg types.Message) ([]estellm.ToolUse, error) {
+	toolUses := make([]estellm.ToolUse, 0, len(msg.Content))
 	for _, cb := range msg.Content {
 		if cb, ok := cb.(*types.ContentBlockMemberToolUse); ok {
 			bs, err := cb.Value.Input.MarshalSmithyDocument()
@@ -350,10 +478,10 @@ func extructToolUse(msg types.Message) ([]toolUse, error) {
 			if err := json.Unmarshal(bs, &input); err != nil {
 				return nil, fmt.Errorf("unmarshal tool input: %w", err)
 			}
-			toolUses = append(toolUses, toolUse{
-				toolUseID: *cb.Value.ToolUseId,
-				toolName:  *cb.Value.Name,
-				input:     input,
+			toolUses = append(toolUses, estellm.ToolUse{
+				ID:    *cb.Value.ToolUseId,
+				Name:  *cb.Value.Name,
+				Input: input,
 			})
 		}
 	}
@@ -363,6 +491,13 @@ func extructToolUse(msg types.Message) ([]toolUse, error) {
 	return toolUses, nil
 }
 
+func newToolResult(result estellm.ToolResult) types.ContentBlock {
+	if result.Err != nil {
+		return newToolResultWithError(result.ToolUse.ID, result.Err)
+	}
+	return newToolResultWithResponse(result.ToolUse.ID, result.Response)
+}
+
 func newToolResultWithError(toolUseID string, err error) types.ContentBlock {
 	return &types.ContentBlockMemberToolResult{
 		Value: types.ToolResultBlock{
@@ -451,21 +586,6 @@ func newToolResultWithResponse(toolUseID string, response *estellm.Response) typ
 	}
 }
 
-func toolCall(ctx context.Context, tools estellm.ToolSet, toolUseID string, toolName string, input any) (types.ContentBlock, error) {
-	for _, tool := range tools {
-		if NormalizeToolName(tool.Name()) == toolName {
-			w := estellm.NewBatchResponseWriter()
-			ctx = estellm.WithToolName(ctx, tool.Name())
-			ctx = estellm.WithToolUseID(ctx, toolUseID)
-			if err := tool.Call(ctx, input, w); err != nil {
-				return newToolResultWithError(toolUseID, err), nil
-			}
-			return newToolResultWithResponse(toolUseID, w.Response()), nil
-		}
-	}
-	return nil, fmt.Errorf("tool not found: %s", toolName)
-}
-
 func mergeContentBlock(a, b types.ContentBlock) types.ContentBlock {
 	if a == nil {
 		return b
@@ -479,13 +599,23 @@ func mergeContentBlock(a, b types.ContentBlock) types.ContentBlock {
 		}
 	case *types.ContentBlockMemberReasoningContent:
 		if b, ok := b.(*types.ContentBlockMemberReasoningContent); ok {
+			if ar, ok := a.Value.(*types.ReasoningContentBlockMemberRedactedContent); ok {
+				if br, ok := b.Value.(*types.ReasoningContentBlockMemberRedactedContent); ok {
+					return &types.ContentBlockMemberReasoningContent{
+						Value: &types.ReasoningContentBlockMemberRedactedContent{
+							Value: append(bytes.Clone(ar.Value), br.Value...),
+						},
+					}
+				}
+				return a
+			}
 			ac, ok := a.Value.(*types.ReasoningContentBlockMemberReasoningText)
 			if !ok {
 				return b
 			}
 			bc, ok := b.Value.(*types.ReasoningContentBlockMemberReasoningText)
 			if !ok {
-				return b
+				return a
 			}
 			return &types.ContentBlockMemberReasoningContent{
 				Value: &types.ReasoningContentBlockMemberReasoningText{
@@ -523,6 +653,9 @@ func setToMetadata(v types.ConverseStreamMetadataEvent, m metadata.Metadata) {
 			}
 		}
 		if v.Trace.Guardrail != nil {
+			if v.Trace.Guardrail.ActionReason != nil {
+				m.SetString("Guardrail-Action-Reason", *v.Trace.Guardrail.ActionReason)
+			}
 			if v.Trace.Guardrail.InputAssessment != nil {
 				if bs, err := json.Marshal(v.Trace.Guardrail.InputAssessment); err == nil {
 					m.SetString("Guardrail-Input-Assessment", string(bs))
@@ -643,90 +776,78 @@ func processContentBlockDelta(_ context.Context, v *types.ConverseStreamOutputMe
 	}
 }
 
-func (p *ModelProvider) GenerateImage(ctx context.Context, req *estellm.GenerateImageRequest, w estellm.ResponseWriter) error {
+func (p *ModelProvider) GenerateEmbedding(ctx context.Context, req *estellm.GenerateEmbeddingRequest) (*estellm.GenerateEmbeddingResponse, error) {
 	if err := p.initClient(); err != nil {
-		return err
+		return nil, err
 	}
-	imageReq := &StableDiffusionXLRequset{}
-	if err := jsonutil.Remarshal(req.ModelParams, imageReq); err != nil {
-		return fmt.Errorf("remarshal image request: %w", err)
-	}
-	var sb strings.Builder
-	enc := estellm.NewMessageEncoder(&sb)
-	enc.SkipReasoning()
-	enc.TextOnly()
-	enc.NoRole()
-	if err := enc.Encode(req.System, req.Messages); err != nil {
-		return fmt.Errorf("encode messages: %w", err)
-	}
-	prompt := strings.TrimSpace(sb.String())
-	var jsonPrompt StableDiffusionXLJSONPrompt
-	if err := jsonutil.UnmarshalFirstJSON([]byte(prompt), &jsonPrompt); err != nil {
-		return fmt.Errorf("unmarshal prompt: %w", err)
-	}
-	if jsonPrompt.Prompt == "" && jsonPrompt.NegativePrompt == "" {
-		imageReq.TextPrompts = []StableDiffusionXLTextPrompt{
-			{
-				Text:   prompt,
-				Weight: 1,
-			},
-		}
-	} else {
-		if jsonPrompt.Prompt != "" {
-			imageReq.TextPrompts = []StableDiffusionXLTextPrompt{
-				{
-					Text:   jsonPrompt.Prompt,
-					Weight: 1,
-				},
+	resp := &estellm.GenerateEmbeddingResponse{
+		Metadata:   make(metadata.Metadata),
+		Embeddings: make([][]float32, 0, len(req.Inputs)),
+	}
+	if isCohereEmbeddingModel(req.ModelID) {
+		for start := 0; start < len(req.Inputs); start += cohereEmbeddingMaxTexts {
+			end := min(start+cohereEmbeddingMaxTexts, len(req.Inputs))
+			embedReq := CohereEmbeddingRequest{
+				InputType: "search_document",
 			}
+			if err := jsonutil.Remarshal(req.ModelParams, &embedReq); err != nil {
+				return nil, fmt.Errorf("remarshal embedding request: %w", err)
+			}
+			switch req.InputType {
+			case estellm.EmbeddingInputTypeDocument:
+				embedReq.InputType = "search_document"
+			case estellm.EmbeddingInputTypeQuery:
+				embedReq.InputType = "search_query"
+			}
+			embedReq.Texts = req.Inputs[start:end]
+			var embedResp CohereEmbeddingResponse
+			if err := p.invokeModelJSON(ctx, req.ModelID, embedReq, &embedResp, resp.Metadata); err != nil {
+				return nil, err
+			}
+			if len(embedResp.Embeddings) != end-start {
+				return nil, fmt.Errorf("embedding count mismatch: expected %d, got %d", end-start, len(embedResp.Embeddings))
+			}
+			resp.Embeddings = append(resp.Embeddings, embedResp.Embeddings...)
 		}
-		if jsonPrompt.NegativePrompt != "" {
-			imageReq.TextPrompts = append(imageReq.TextPrompts, StableDiffusionXLTextPrompt{
-				Text:   jsonPrompt.NegativePrompt,
-				Weight: -1,
-			})
-		}
+		return resp, nil
 	}
-	if imageReq.StylePreset == "" && jsonPrompt.StylePreset != "" {
-		imageReq.StylePreset = jsonPrompt.StylePreset
+	var inputTokens int64
+	for _, text := range req.Inputs {
+		var embedReq TitanEmbeddingRequest
+		if err := jsonutil.Remarshal(req.ModelParams, &embedReq); err != nil {
+			return nil, fmt.Errorf("remarshal embedding request: %w", err)
+		}
+		embedReq.InputText = text
+		var embedResp TitanEmbeddingResponse
+		if err := p.invokeModelJSON(ctx, req.ModelID, embedReq, &embedResp, resp.Metadata); err != nil {
+			return nil, err
+		}
+		inputTokens += embedResp.InputTextTokenCount
+		resp.Embeddings = append(resp.Embeddings, embedResp.Embedding)
 	}
-	bs, err := json.Marshal(imageReq)
+	metadata.SetInputTokens(resp.Metadata, inputTokens)
+	return resp, nil
+}
+
+func (p *ModelProvider) invokeModelJSON(ctx context.Context, modelID string, in any, out any, m metadata.Metadata) error {
+	bs, err := json.Marshal(in)
 	if err != nil {
 		return fmt.Errorf("marshal request: %w", err)
 	}
-	w.WritePart(estellm.TextPart(fmt.Sprintf("<prompt type=\"provided\">%s</prompt>", prompt)))
 	output, err := p.client.InvokeModel(ctx, &bedrockruntime.InvokeModelInput{
-		ModelId:     aws.String(req.ModelID),
+		ModelId:     aws.String(modelID),
 		Body:        bs,
 		ContentType: aws.String("application/json"),
 		Accept:      aws.String("application/json"),
 	})
 	if err != nil {
-		slog.DebugContext(ctx, "invoke model request", "model_id", req.ModelID, "request", string(bs))
+		slog.DebugContext(ctx, "invoke model request", "model_id", modelID, "request", string(bs))
 		return fmt.Errorf("invoke model: %w", err)
 	}
-	resultMetadataToEstellmMetadata(output.ResultMetadata, w.Metadata())
-	var resp StableDiffusionXLResponse
-	if err := json.Unmarshal(output.Body, &resp); err != nil {
+	resultMetadataToEstellmMetadata(output.ResultMetadata, m)
+	if err := json.Unmarshal(output.Body, out); err != nil {
 		return fmt.Errorf("failed to unmarshal response: %w", err)
 	}
-	if resp.Result != "success" {
-		return fmt.Errorf("failed to generate image: %s", resp.Result)
-	}
-	for _, artifact := range resp.Artifacts {
-		if artifact.FinishReason == "ERROR" {
-			return fmt.Errorf("failed to generate image: %s", artifact.FinishReason)
-		}
-		if artifact.FinishReason == "CONTENT_FILTERED" {
-			w.WritePart(estellm.TextPart("<warn>generated image is content filtered,image might be blurred.</warn>"))
-		}
-		data, err := base64.StdEncoding.DecodeString(artifact.Base64)
-		if err != nil {
-			return fmt.Errorf("failed to decode image: %w", err)
-		}
-		w.WritePart(estellm.BinaryPart("image/png", data))
-	}
-	w.Finish(estellm.FinishReasonEndTurn, fmt.Sprintf("generate %d images", len(resp.Artifacts)))
 	return nil
 }
 
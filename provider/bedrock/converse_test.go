@@ -0,0 +1,355 @@
+package bedrock_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/protocol/eventstream"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+	"github.com/mashiike/estellm"
+	"github.com/mashiike/estellm/metadata"
+	"github.com/mashiike/estellm/provider/bedrock"
+	"github.com/stretchr/testify/require"
+)
+
+type streamEvent struct {
+	eventType string
+	payload   string
+}
+
+type converseServer struct {
+	*httptest.Server
+	mu        sync.Mutex
+	paths     []string
+	requests  []map[string]any
+	responses [][]streamEvent
+}
+
+func newConverseServer(t *testing.T, responses ...[]streamEvent) *converseServer {
+	t.Helper()
+	s := &converseServer{responses: responses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.paths = append(s.paths, r.Method+" "+r.URL.Path)
+		bs, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		var body map[string]any
+		require.NoError(t, json.Unmarshal(bs, &body))
+		s.requests = append(s.requests, body)
+		if len(s.responses) == 0 {
+			t.Fatal("unexpected request")
+		}
+		events := s.responses[0]
+		s.responses = s.responses[1:]
+		var buf bytes.Buffer
+		enc := eventstream.NewEncoder()
+		for _, e := range events {
+			msg := eventstream.Message{Payload: []byte(e.payload)}
+			msg.Headers.Set(":message-type", eventstream.StringValue("event"))
+			msg.Headers.Set(":event-type", eventstream.StringValue(e.eventType))
+			msg.Headers.Set(":content-type", eventstream.StringValue("application/json"))
+			require.NoError(t, enc.Encode(&buf, msg))
+		}
+		w.Header().Set("Content-Type", "application/vnd.amazon.eventstream")
+		w.WriteHeader(http.StatusOK)
+		w.Write(buf.Bytes())
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *converseServer) client() *bedrockruntime.Client {
+	return bedrockruntime.New(bedrockruntime.Options{
+		BaseEndpoint: aws.String(s.URL),
+		Region:       "us-east-1",
+		Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "SECRET"}, nil
+		}),
+	})
+}
+
+type weatherTool struct {
+	inputs []any
+}
+
+func (t *weatherTool) Name() string        { return "get weather" }
+func (t *weatherTool) Description() string { return "get the weather" }
+func (t *weatherTool) InputSchema() map[string]any {
+	return map[string]any{"type": "object", "properties": map[string]any{"city": map[string]any{"type": "string"}}}
+}
+func (t *weatherTool) Call(_ context.Context, input any, w estellm.ResponseWriter) error {
+	t.inputs = append(t.inputs, input)
+	return w.WritePart(estellm.TextPart("sunny"))
+}
+
+func TestGenerateText__ReasoningWithToolUse(t *testing.T) {
+	s := newConverseServer(t,
+		[]streamEvent{
+			{"messageStart", `{"role":"assistant"}`},
+			{"contentBlockDelta", `{"contentBlockIndex":0,"delta":{"reasoningContent":{"text":"I should "}}}`},
+			{"contentBlockDelta", `{"contentBlockIndex":0,"delta":{"reasoningContent":{"text":"check the weather."}}}`},
+			{"contentBlockDelta", `{"contentBlockIndex":0,"delta":{"reasoningContent":{"signature":"sig-1"}}}`},
+			{"contentBlockStop", `{"contentBlockIndex":0}`},
+			{"contentBlockStart", `{"contentBlockIndex":1,"start":{"toolUse":{"toolUseId":"tooluse-1","name":"get_weather"}}}`},
+			{"contentBlockDelta", `{"contentBlockIndex":1,"delta":{"toolUse":{"input":"{\"city\":"}}}`},
+			{"contentBlockDelta", `{"contentBlockIndex":1,"delta":{"toolUse":{"input":"\"Tokyo\"}"}}}`},
+			{"contentBlockStop", `{"contentBlockIndex":1}`},
+			{"messageStop", `{"stopReason":"tool_use"}`},
+			{"metadata", `{"usage":{"inputTokens":20,"outputTokens":10,"totalTokens":30},"metrics":{"latencyMs":100}}`},
+		},
+		[]streamEvent{
+			{"messageStart", `{"role":"assistant"}`},
+			{"contentBlockDelta", `{"contentBlockIndex":0,"delta":{"reasoningContent":{"redactedContent":"cmVk"}}}`},
+			{"contentBlockStop", `{"contentBlockIndex":0}`},
+			{"contentBlockDelta", `{"contentBlockIndex":1,"delta":{"text":"It is sunny."}}`},
+			{"contentBlockStop", `{"contentBlockIndex":1}`},
+			{"messageStop", `{"stopReason":"end_turn"}`},
+			{"metadata", `{"usage":{"inputTokens":40,"outputTokens":5,"totalTokens":45},"metrics":{"latencyMs":100}}`},
+		},
+	)
+	tool := &weatherTool{}
+	p := bedrock.NewWithClient(s.client())
+	req := &estellm.GenerateTextRequest{
+		ModelID: "anthropic.claude-3-7-sonnet-20250219-v1:0",
+		ModelParams: map[string]any{
+			"max_tokens": 8192,
+			"thinking":   2048,
+		},
+		Messages: []estellm.Message{
+			{
+				Role:  estellm.RoleUser,
+				Parts: []estellm.ContentPart{estellm.TextPart("How is the weather in Tokyo?")},
+			},
+			{
+				Role: estellm.RoleAssistant,
+				Parts: []estellm.ContentPart{
+					estellm.ReasoningPart("previous thought"),
+					estellm.TextPart("Let me check."),
+				},
+			},
+			{
+				Role:  estellm.RoleUser,
+				Parts: []estellm.ContentPart{estellm.TextPart("Please.")},
+			},
+		},
+		Tools: estellm.ToolSet{tool},
+	}
+	w := estellm.NewBatchResponseWriter()
+	require.NoError(t, p.GenerateText(context.Background(), req, w))
+
+	require.Equal(t, []any{map[string]any{"city": "Tokyo"}}, tool.inputs)
+	require.Equal(t, []string{
+		"POST /model/anthropic.claude-3-7-sonnet-20250219-v1:0/converse-stream",
+		"POST /model/anthropic.claude-3-7-sonnet-20250219-v1:0/converse-stream",
+	}, s.paths)
+	bs, err := json.Marshal(s.requests[0]["additionalModelRequestFields"])
+	require.NoError(t, err)
+	require.JSONEq(t, `{"thinking":{"type":"enabled","budget_tokens":2048}}`, string(bs))
+	bs, err = json.Marshal(s.requests[1]["messages"])
+	require.NoError(t, err)
+	require.JSONEq(t, `[
+		{"role":"user","content":[{"text":"How is the weather in Tokyo?"}]},
+		{"role":"assistant","content":[{"text":"Let me check."}]},
+		{"role":"user","content":[{"text":"Please."}]},
+		{"role":"assistant","content":[
+			{"reasoningContent":{"reasoningText":{"text":"I should check the weather.","signature":"sig-1"}}},
+			{"toolUse":{"toolUseId":"tooluse-1","name":"get_weather","input":{"city":"Tokyo"}}}
+		]},
+		{"role":"user","content":[
+			{"toolResult":{"toolUseId":"tooluse-1","content":[{"text":"sunny"}],"status":"success"}}
+		]}
+	]`, string(bs))
+
+	resp := w.Response()
+	require.Equal(t, estellm.FinishReasonEndTurn, resp.FinishReason)
+	require.Equal(t, []estellm.ContentPart{
+		estellm.ReasoningPart("I should check the weather."),
+		estellm.TextPart("It is sunny."),
+	}, resp.Message.Parts)
+}
+
+func TestGenerateText__ThinkingParam(t *testing.T) {
+	cases := []struct {
+		name     string
+		thinking any
+		expected string
+	}{
+		{"bool", true, `{"thinking":{"type":"enabled","budget_tokens":4096}}`},
+		{"map", map[string]any{"budget_tokens": 1024}, `{"thinking":{"type":"enabled","budget_tokens":1024}}`},
+		{"disabled", false, `null`},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := newConverseServer(t, []streamEvent{
+				{"messageStart", `{"role":"assistant"}`},
+				{"contentBlockDelta", `{"contentBlockIndex":0,"delta":{"text":"Hi"}}`},
+				{"contentBlockStop", `{"contentBlockIndex":0}`},
+				{"messageStop", `{"stopReason":"end_turn"}`},
+			})
+			p := bedrock.NewWithClient(s.client())
+			req := &estellm.GenerateTextRequest{
+				ModelID:     "anthropic.claude-3-7-sonnet-20250219-v1:0",
+				ModelParams: map[string]any{"thinking": c.thinking},
+				Messages: []estellm.Message{
+					{Role: estellm.RoleUser, Parts: []estellm.ContentPart{estellm.TextPart("Hi")}},
+				},
+			}
+			require.NoError(t, p.GenerateText(context.Background(), req, estellm.NewBatchResponseWriter()))
+			bs, err := json.Marshal(s.requests[0]["additionalModelRequestFields"])
+			require.NoError(t, err)
+			require.JSONEq(t, c.expected, string(bs))
+		})
+	}
+}
+
+func TestGenerateText__CachePoint(t *testing.T) {
+	s := newConverseServer(t, []streamEvent{
+		{"messageStart", `{"role":"assistant"}`},
+		{"contentBlockDelta", `{"contentBlockIndex":0,"delta":{"text":"Hi"}}`},
+		{"contentBlockStop", `{"contentBlockIndex":0}`},
+		{"messageStop", `{"stopReason":"end_turn"}`},
+		{"metadata", `{"usage":{"inputTokens":10,"outputTokens":2,"totalTokens":1036,"cacheReadInputTokens":1000,"cacheWriteInputTokens":24},"metrics":{"latencyMs":100}}`},
+	})
+	p := bedrock.NewWithClient(s.client())
+	req := &estellm.GenerateTextRequest{
+		ModelID:     "anthropic.claude-3-7-sonnet-20250219-v1:0",
+		ModelParams: map[string]any{"cache_tools": true},
+		System:      "You are a helpful assistant." + estellm.CachePointMarkup,
+		Messages: []estellm.Message{
+			{
+				Role: estellm.RoleUser,
+				Parts: []estellm.ContentPart{
+					estellm.TextPart("long example"),
+					estellm.CachePointPart(),
+					estellm.TextPart("Hi"),
+				},
+			},
+		},
+		Tools: estellm.ToolSet{&weatherTool{}},
+	}
+	w := estellm.NewBatchResponseWriter()
+	require.NoError(t, p.GenerateText(context.Background(), req, w))
+
+	body := s.requests[0]
+	require.NotContains(t, body, "additionalModelRequestFields")
+	bs, err := json.Marshal(body["system"])
+	require.NoError(t, err)
+	require.JSONEq(t, `[{"text":"You are a helpful assistant."},{"cachePoint":{"type":"default"}}]`, string(bs))
+	bs, err = json.Marshal(body["messages"])
+	require.NoError(t, err)
+	require.JSONEq(t, `[{"role":"user","content":[{"text":"long example"},{"cachePoint":{"type":"default"}},{"text":"Hi"}]}]`, string(bs))
+	tools := body["toolConfig"].(map[string]any)["tools"].([]any)
+	require.Len(t, tools, 2)
+	require.Equal(t, map[string]any{"cachePoint": map[string]any{"type": "default"}}, tools[1])
+
+	resp := w.Response()
+	cacheRead, _ := metadata.GetCacheReadInputTokens(resp.Metadata)
+	cacheWrite, _ := metadata.GetCacheWriteInputTokens(resp.Metadata)
+	require.EqualValues(t, 1000, cacheRead)
+	require.EqualValues(t, 24, cacheWrite)
+}
+
+func TestGenerateText__Guardrail(t *testing.T) {
+	s := newConverseServer(t, []streamEvent{
+		{"messageStart", `{"role":"assistant"}`},
+		{"contentBlockDelta", `{"contentBlockIndex":0,"delta":{"text":"Sorry, I can't answer."}}`},
+		{"contentBlockStop", `{"contentBlockIndex":0}`},
+		{"messageStop", `{"stopReason":"guardrail_intervened"}`},
+		{"metadata", `{"usage":{"inputTokens":10,"outputTokens":2,"totalTokens":12},"metrics":{"latencyMs":100},"trace":{"guardrail":{"actionReason":"Guardrail blocked."}}}`},
+	})
+	p := bedrock.NewWithClient(s.client())
+	req := &estellm.GenerateTextRequest{
+		ModelID: "anthropic.claude-3-7-sonnet-20250219-v1:0",
+		System:  `Answer with the source.<guard qualifiers="grounding_source">The sky is blue.</guard>`,
+		Messages: []estellm.Message{
+			{
+				Role:  estellm.RoleUser,
+				Parts: []estellm.ContentPart{estellm.GuardContentPart("What color is the sky?", "query")},
+			},
+		},
+		Guardrail: &estellm.GuardrailConfig{
+			Identifier:           "gr-123",
+			Version:              "1",
+			StreamProcessingMode: "async",
+		},
+	}
+	w := estellm.NewBatchResponseWriter()
+	require.NoError(t, p.GenerateText(context.Background(), req, w))
+
+	body := s.requests[0]
+	bs, err := json.Marshal(body["guardrailConfig"])
+	require.NoError(t, err)
+	require.JSONEq(t, `{"guardrailIdentifier":"gr-123","guardrailVersion":"1","trace":"enabled","streamProcessingMode":"async"}`, string(bs))
+	bs, err = json.Marshal(body["system"])
+	require.NoError(t, err)
+	require.JSONEq(t, `[
+		{"text":"Answer with the source."},
+		{"guardContent":{"text":{"text":"The sky is blue.","qualifiers":["grounding_source"]}}}
+	]`, string(bs))
+	bs, err = json.Marshal(body["messages"])
+	require.NoError(t, err)
+	require.JSONEq(t, `[{"role":"user","content":[{"guardContent":{"text":{"text":"What color is the sky?","qualifiers":["query"]}}}]}]`, string(bs))
+
+	resp := w.Response()
+	require.Equal(t, estellm.FinishReasonGuardrailIntervened, resp.FinishReason)
+	require.Equal(t, "Guardrail blocked.", resp.Metadata.GetString("Guardrail-Action-Reason"))
+}
+
+func TestGenerateText__MaxToolIterations(t *testing.T) {
+	toolUseTurn := func(id string) []streamEvent {
+		return []streamEvent{
+			{"messageStart", `{"role":"assistant"}`},
+			{"contentBlockStart", `{"contentBlockIndex":0,"start":{"toolUse":{"toolUseId":"` + id + `","name":"get_weather"}}}`},
+			{"contentBlockDelta", `{"contentBlockIndex":0,"delta":{"toolUse":{"input":"{\"city\":\"Tokyo\"}"}}}`},
+			{"contentBlockStop", `{"contentBlockIndex":0}`},
+			{"messageStop", `{"stopReason":"tool_use"}`},
+		}
+	}
+	s := newConverseServer(t,
+		toolUseTurn("tooluse-1"),
+		toolUseTurn("tooluse-2"),
+		[]streamEvent{
+			{"messageStart", `{"role":"assistant"}`},
+			{"contentBlockDelta", `{"contentBlockIndex":0,"delta":{"text":"It is sunny."}}`},
+			{"contentBlockStop", `{"contentBlockIndex":0}`},
+			{"messageStop", `{"stopReason":"end_turn"}`},
+		},
+	)
+	tool := &weatherTool{}
+	p := bedrock.NewWithClient(s.client())
+	req := &estellm.GenerateTextRequest{
+		ModelID:     "anthropic.claude-3-7-sonnet-20250219-v1:0",
+		ModelParams: map[string]any{"max_tool_iterations": 5},
+		Messages: []estellm.Message{
+			{Role: estellm.RoleUser, Parts: []estellm.ContentPart{estellm.TextPart("How is the weather in Tokyo?")}},
+		},
+		Tools: estellm.ToolSet{tool},
+	}
+	w := estellm.NewBatchResponseWriter()
+	require.NoError(t, p.GenerateText(context.Background(), req, w))
+
+	require.Len(t, tool.inputs, 2)
+	require.Len(t, s.requests, 3)
+	require.NotContains(t, s.requests[0], "additionalModelRequestFields")
+	bs, err := json.Marshal(s.requests[2]["messages"].([]any)[4])
+	require.NoError(t, err)
+	require.JSONEq(t, `{"role":"user","content":[
+		{"toolResult":{"toolUseId":"tooluse-2","content":[{"text":"sunny"}],"status":"success"}},
+		{"text":"`+estellm.FinalTurnInstruction+`"}
+	]}`, string(bs), "the repeated call ends the tool iterations")
+
+	resp := w.Response()
+	require.Equal(t, "It is sunny.\n", resp.String())
+	turns, _ := metadata.GetModelTurns(resp.Metadata)
+	calls, _ := metadata.GetToolCalls(resp.Metadata)
+	require.EqualValues(t, 3, turns)
+	require.EqualValues(t, 2, calls)
+}
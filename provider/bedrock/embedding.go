@@ -0,0 +1,34 @@
+package bedrock
+
+import "strings"
+
+// https://docs.aws.amazon.com/bedrock/latest/userguide/model-parameters-titan-embed-text.html
+type TitanEmbeddingRequest struct {
+	InputText  string `json:"inputText"`
+	Dimensions int    `json:"dimensions,omitempty"`
+	Normalize  *bool  `json:"normalize,omitempty"`
+}
+
+type TitanEmbeddingResponse struct {
+	Embedding           []float32 `json:"embedding"`
+	InputTextTokenCount int64     `json:"inputTextTokenCount"`
+}
+
+// https://docs.aws.amazon.com/bedrock/latest/userguide/model-parameters-embed.html
+type CohereEmbeddingRequest struct {
+	Texts     []string `json:"texts"`
+	InputType string   `json:"input_type"`
+	Truncate  string   `json:"truncate,omitempty"`
+}
+
+type CohereEmbeddingResponse struct {
+	ID         string      `json:"id"`
+	Embeddings [][]float32 `json:"embeddings"`
+	Texts      []string    `json:"texts"`
+}
+
+const cohereEmbeddingMaxTexts = 96
+
+func isCohereEmbeddingModel(modelID string) bool {
+	return strings.Contains(modelID, "cohere.embed")
+}
@@ -0,0 +1,43 @@
+package bedrock_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mashiike/estellm"
+	"github.com/mashiike/estellm/provider/bedrock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateEmbedding__CohereInputType(t *testing.T) {
+	client := &fakeClient{
+		body: []byte(`{"id":"1","embeddings":[[0.1,0.2]]}`),
+	}
+	p := bedrock.NewWithClient(client)
+	cases := []struct {
+		name      string
+		inputType estellm.EmbeddingInputType
+		params    map[string]any
+		expected  string
+	}{
+		{"default", "", nil, "search_document"},
+		{"params", "", map[string]any{"input_type": "classification"}, "classification"},
+		{"document", estellm.EmbeddingInputTypeDocument, map[string]any{"truncate": "END"}, "search_document"},
+		{"query", estellm.EmbeddingInputTypeQuery, map[string]any{"input_type": "search_document"}, "search_query"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			resp, err := p.GenerateEmbedding(context.Background(), &estellm.GenerateEmbeddingRequest{
+				ModelID:     "cohere.embed-multilingual-v3",
+				ModelParams: c.params,
+				InputType:   c.inputType,
+				Inputs:      []string{"a cat"},
+			})
+			require.NoError(t, err)
+			require.Equal(t, [][]float32{{0.1, 0.2}}, resp.Embeddings)
+			body := client.lastRequest(t)
+			require.Equal(t, c.expected, body["input_type"])
+			require.Equal(t, []any{"a cat"}, body["texts"])
+		})
+	}
+}
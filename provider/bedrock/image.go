@@ -0,0 +1,49 @@
+package bedrock
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/mashiike/estellm"
+	"github.com/mashiike/estellm/jsonutil"
+)
+
+func (p *ModelProvider) GenerateImage(ctx context.Context, req *estellm.GenerateImageRequest, w estellm.ResponseWriter) error {
+	if err := p.initClient(); err != nil {
+		return err
+	}
+	var sb strings.Builder
+	enc := estellm.NewMessageEncoder(&sb)
+	enc.SkipReasoning()
+	enc.TextOnly()
+	enc.NoRole()
+	if err := enc.Encode(req.System, req.Messages); err != nil {
+		return fmt.Errorf("encode messages: %w", err)
+	}
+	prompt := strings.TrimSpace(sb.String())
+	switch {
+	case isStableDiffusionXLModel(req.ModelID):
+		return p.generateImageSDXL(ctx, req, prompt, w)
+	case isAmazonImageModel(req.ModelID):
+		return p.generateImageAmazon(ctx, req, prompt, w)
+	default:
+		return fmt.Errorf("image model `%s`: %w", req.ModelID, estellm.ErrNotSupported)
+	}
+}
+
+type imagePrompt struct {
+	Prompt         string `json:"prompt"`
+	NegativePrompt string `json:"negative_prompt"`
+	StylePreset    string `json:"style_preset"`
+}
+
+// parseImagePrompt reads a JSON prompt like {"prompt": "...", "negative_prompt": "..."}.
+// If the prompt is not JSON, it is used as the positive prompt as is.
+func parseImagePrompt(prompt string) imagePrompt {
+	var p imagePrompt
+	if err := jsonutil.UnmarshalFirstJSON([]byte(prompt), &p); err != nil || (p.Prompt == "" && p.NegativePrompt == "") {
+		return imagePrompt{Prompt: prompt}
+	}
+	return p
+}
@@ -0,0 +1,180 @@
+package bedrock_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+	"github.com/mashiike/estellm"
+	"github.com/mashiike/estellm/provider/bedrock"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeClient struct {
+	bedrock.Client
+	inputs []*bedrockruntime.InvokeModelInput
+	body   []byte
+}
+
+func (c *fakeClient) InvokeModel(_ context.Context, input *bedrockruntime.InvokeModelInput, _ ...func(*bedrockruntime.Options)) (*bedrockruntime.InvokeModelOutput, error) {
+	c.inputs = append(c.inputs, input)
+	return &bedrockruntime.InvokeModelOutput{Body: c.body}, nil
+}
+
+func (c *fakeClient) lastRequest(t *testing.T) map[string]any {
+	t.Helper()
+	require.NotEmpty(t, c.inputs)
+	var v map[string]any
+	require.NoError(t, json.Unmarshal(c.inputs[len(c.inputs)-1].Body, &v))
+	return v
+}
+
+func b64(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+func newImageRequest(modelID string, params map[string]any, parts ...estellm.ContentPart) *estellm.GenerateImageRequest {
+	return &estellm.GenerateImageRequest{
+		ModelID:     modelID,
+		ModelParams: params,
+		Messages: []estellm.Message{
+			{
+				Role:  estellm.RoleUser,
+				Parts: parts,
+			},
+		},
+	}
+}
+
+func TestGenerateImage__StableDiffusionXL(t *testing.T) {
+	client := &fakeClient{
+		body: []byte(`{"result":"success","artifacts":[{"seed":1,"base64":"` + b64("png") + `","finishReason":"SUCCESS"}]}`),
+	}
+	p := bedrock.NewWithClient(client)
+	w := estellm.NewBatchResponseWriter()
+	req := newImageRequest("stability.stable-diffusion-xl-v1", map[string]any{"seed": 42},
+		estellm.TextPart(`{"prompt":"a cat","negative_prompt":"a dog"}`),
+	)
+	require.NoError(t, p.GenerateImage(context.Background(), req, w))
+	require.JSONEq(t, `{
+		"text_prompts": [{"text":"a cat","weight":1},{"text":"a dog","weight":-1}],
+		"seed": 42
+	}`, string(client.inputs[0].Body))
+	resp := w.Response()
+	require.Len(t, resp.Message.Parts, 2)
+	require.Equal(t, []byte("png"), resp.Message.Parts[1].Data)
+
+	req.ModelParams = map[string]any{"seed": 0}
+	require.NoError(t, p.GenerateImage(context.Background(), req, estellm.NewBatchResponseWriter()))
+	require.Equal(t, float64(0), client.lastRequest(t)["seed"])
+
+	req.ModelParams = map[string]any{}
+	require.NoError(t, p.GenerateImage(context.Background(), req, estellm.NewBatchResponseWriter()))
+	require.NotContains(t, client.lastRequest(t), "seed")
+}
+
+func TestGenerateImage__TitanTextToImage(t *testing.T) {
+	client := &fakeClient{
+		body: []byte(`{"images":["` + b64("png1") + `","` + b64("png2") + `"]}`),
+	}
+	p := bedrock.NewWithClient(client)
+	w := estellm.NewBatchResponseWriter()
+	req := newImageRequest("amazon.titan-image-generator-v2:0", map[string]any{
+		"number_of_images": 2,
+		"width":            512,
+		"height":           768,
+		"seed":             7,
+		"cfg_scale":        8.0,
+	}, estellm.TextPart(`{"prompt":"a cat","negative_prompt":"blurry"}`))
+	require.NoError(t, p.GenerateImage(context.Background(), req, w))
+	require.Equal(t, "amazon.titan-image-generator-v2:0", *client.inputs[0].ModelId)
+	require.JSONEq(t, `{
+		"taskType": "TEXT_IMAGE",
+		"textToImageParams": {"text":"a cat","negativeText":"blurry"},
+		"imageGenerationConfig": {"numberOfImages":2,"width":512,"height":768,"seed":7,"cfgScale":8}
+	}`, string(client.inputs[0].Body))
+	resp := w.Response()
+	require.Len(t, resp.Message.Parts, 3)
+	require.Equal(t, []byte("png1"), resp.Message.Parts[1].Data)
+	require.Equal(t, []byte("png2"), resp.Message.Parts[2].Data)
+
+	req.ModelParams = map[string]any{"seed": 0}
+	require.NoError(t, p.GenerateImage(context.Background(), req, estellm.NewBatchResponseWriter()))
+	require.Equal(t, map[string]any{"seed": float64(0)}, client.lastRequest(t)["imageGenerationConfig"])
+}
+
+func TestGenerateImage__NovaCanvasConditionImage(t *testing.T) {
+	client := &fakeClient{
+		body: []byte(`{"images":["` + b64("png") + `"]}`),
+	}
+	p := bedrock.NewWithClient(client)
+	w := estellm.NewBatchResponseWriter()
+	req := newImageRequest("us.amazon.nova-canvas-v1:0", map[string]any{
+		"negative_text":    "text",
+		"control_mode":     "CANNY_EDGE",
+		"control_strength": 0.5,
+	},
+		estellm.TextPart("a house in the forest"),
+		estellm.BinaryPart("image/png", []byte("sketch")),
+	)
+	require.NoError(t, p.GenerateImage(context.Background(), req, w))
+	body := client.lastRequest(t)
+	require.Equal(t, "TEXT_IMAGE", body["taskType"])
+	require.Equal(t, map[string]any{
+		"text":            "a house in the forest",
+		"negativeText":    "text",
+		"conditionImage":  b64("sketch"),
+		"controlMode":     "CANNY_EDGE",
+		"controlStrength": 0.5,
+	}, body["textToImageParams"])
+}
+
+func TestGenerateImage__TitanInpainting(t *testing.T) {
+	client := &fakeClient{
+		body: []byte(`{"images":["` + b64("png") + `"]}`),
+	}
+	p := bedrock.NewWithClient(client)
+	req := newImageRequest("amazon.titan-image-generator-v1", map[string]any{
+		"mode": estellm.ImageModeEdit,
+	},
+		estellm.TextPart("a red door"),
+		estellm.BinaryPart("image/png", []byte("image")),
+	)
+	err := p.GenerateImage(context.Background(), req, estellm.NewBatchResponseWriter())
+	require.ErrorContains(t, err, "requires a mask image or mask_prompt")
+	require.Empty(t, client.inputs)
+
+	req.Messages[0].Parts = append(req.Messages[0].Parts, estellm.BinaryPartWithName("image/png", estellm.ImageMaskPartName, []byte("mask")))
+	require.NoError(t, p.GenerateImage(context.Background(), req, estellm.NewBatchResponseWriter()))
+	body := client.lastRequest(t)
+	require.Equal(t, "INPAINTING", body["taskType"])
+	require.Equal(t, map[string]any{
+		"image":     b64("image"),
+		"text":      "a red door",
+		"maskImage": b64("mask"),
+	}, body["inPaintingParams"])
+}
+
+func TestGenerateImage__ErrorResponse(t *testing.T) {
+	client := &fakeClient{
+		body: []byte(`{"images":[],"error":"content blocked"}`),
+	}
+	p := bedrock.NewWithClient(client)
+	req := newImageRequest("amazon.nova-canvas-v1:0", nil, estellm.TextPart("a cat"))
+	err := p.GenerateImage(context.Background(), req, estellm.NewBatchResponseWriter())
+	require.ErrorContains(t, err, "content blocked")
+}
+
+func TestGenerateImage__UnsupportedModel(t *testing.T) {
+	client := &fakeClient{}
+	p := bedrock.NewWithClient(client)
+	req := newImageRequest("anthropic.claude-3-haiku-20240307-v1:0", nil, estellm.TextPart("a cat"))
+	err := p.GenerateImage(context.Background(), req, estellm.NewBatchResponseWriter())
+	require.Error(t, err)
+	require.True(t, errors.Is(err, estellm.ErrNotSupported))
+	require.Contains(t, err.Error(), "anthropic.claude-3-haiku-20240307-v1:0")
+	require.Empty(t, client.inputs)
+}
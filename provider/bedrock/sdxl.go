@@ -1,6 +1,15 @@
 package bedrock
 
-import "encoding/json"
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/mashiike/estellm"
+	"github.com/mashiike/estellm/jsonutil"
+)
 
 // https://docs.aws.amazon.com/ja_jp/bedrock/latest/userguide/model-parameters-diffusion-1-0-text-image.html
 type StableDiffusionXLRequset struct {
@@ -8,12 +17,19 @@ type StableDiffusionXLRequset struct {
 	Height      int                           `json:"height,omitempty"`
 	Width       int                           `json:"width,omitempty"`
 	StylePreset string                        `json:"style_preset,omitempty"`
-	Seed        int64                         `json:"seed,omitempty"`
+	Seed        *int64                        `json:"seed,omitempty"`
 	CFGScale    float64                       `json:"cfg_scale,omitempty"`
 	Steps       int                           `json:"steps,omitempty"`
 	Sampler     string                        `json:"sampler,omitempty"`
 	Samples     int                           `json:"samples,omitempty"`
 	Extra       json.RawMessage               `json:"extra,omitempty"`
+
+	// image-to-image and masking
+	InitImage     string  `json:"init_image,omitempty"`
+	InitImageMode string  `json:"init_image_mode,omitempty"`
+	ImageStrength float64 `json:"image_strength,omitempty"`
+	MaskSource    string  `json:"mask_source,omitempty"`
+	MaskImage     string  `json:"mask_image,omitempty"`
 }
 
 type StableDiffusionXLTextPrompt struct {
@@ -32,8 +48,71 @@ type StableDiffusionXLArtifact struct {
 	FinishReason string `json:"finishReason"`
 }
 
-type StableDiffusionXLJSONPrompt struct {
-	Prompt         string `json:"prompt"`
-	NegativePrompt string `json:"negative_prompt"`
-	StylePreset    string `json:"style_preset"`
+func isStableDiffusionXLModel(modelID string) bool {
+	return strings.Contains(modelID, "stability.stable-diffusion-xl")
+}
+
+func (p *ModelProvider) generateImageSDXL(ctx context.Context, req *estellm.GenerateImageRequest, prompt string, w estellm.ResponseWriter) error {
+	imageReq := &StableDiffusionXLRequset{}
+	if err := jsonutil.Remarshal(req.ModelParams, imageReq); err != nil {
+		return fmt.Errorf("remarshal image request: %w", err)
+	}
+	jsonPrompt := parseImagePrompt(prompt)
+	if jsonPrompt.Prompt != "" {
+		imageReq.TextPrompts = []StableDiffusionXLTextPrompt{
+			{
+				Text:   jsonPrompt.Prompt,
+				Weight: 1,
+			},
+		}
+	}
+	if jsonPrompt.NegativePrompt != "" {
+		imageReq.TextPrompts = append(imageReq.TextPrompts, StableDiffusionXLTextPrompt{
+			Text:   jsonPrompt.NegativePrompt,
+			Weight: -1,
+		})
+	}
+	if imageReq.StylePreset == "" && jsonPrompt.StylePreset != "" {
+		imageReq.StylePreset = jsonPrompt.StylePreset
+	}
+	switch mode := req.ImageMode(); mode {
+	case estellm.ImageModeGenerate:
+	case estellm.ImageModeEdit:
+		images, mask := req.InputImages()
+		if len(images) == 0 {
+			return fmt.Errorf("image %s requires an input image", mode)
+		}
+		imageReq.InitImage = base64.StdEncoding.EncodeToString(images[0].Data)
+		if mask != nil {
+			imageReq.MaskImage = base64.StdEncoding.EncodeToString(mask.Data)
+			if imageReq.MaskSource == "" {
+				imageReq.MaskSource = "MASK_IMAGE_BLACK"
+			}
+		}
+	default:
+		return fmt.Errorf("image mode `%s`: %w", mode, estellm.ErrNotSupported)
+	}
+	w.WritePart(estellm.TextPart(fmt.Sprintf("<prompt type=\"provided\">%s</prompt>", prompt)))
+	var resp StableDiffusionXLResponse
+	if err := p.invokeModelJSON(ctx, req.ModelID, imageReq, &resp, w.Metadata()); err != nil {
+		return err
+	}
+	if resp.Result != "success" {
+		return fmt.Errorf("failed to generate image: %s", resp.Result)
+	}
+	for _, artifact := range resp.Artifacts {
+		if artifact.FinishReason == "ERROR" {
+			return fmt.Errorf("failed to generate image: %s", artifact.FinishReason)
+		}
+		if artifact.FinishReason == "CONTENT_FILTERED" {
+			w.WritePart(estellm.TextPart("<warn>generated image is content filtered,image might be blurred.</warn>"))
+		}
+		data, err := base64.StdEncoding.DecodeString(artifact.Base64)
+		if err != nil {
+			return fmt.Errorf("failed to decode image: %w", err)
+		}
+		w.WritePart(estellm.BinaryPart("image/png", data))
+	}
+	w.Finish(estellm.FinishReasonEndTurn, fmt.Sprintf("generate %d images", len(resp.Artifacts)))
+	return nil
 }
@@ -0,0 +1,527 @@
+package gemini
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/mashiike/estellm"
+	"github.com/mashiike/estellm/jsonutil"
+	"github.com/mashiike/estellm/metadata"
+)
+
+func init() {
+	// Register the provider
+	estellm.RegisterModelProvider("gemini", &ModelProvider{})
+}
+
+const (
+	DefaultEndpoint = "https://generativelanguage.googleapis.com/v1beta"
+)
+
+type ModelProvider struct {
+	init       sync.Once
+	httpClient *http.Client
+	apiKey     string
+	endpoint   string
+}
+
+func NewWithHTTPClient(client *http.Client) *ModelProvider {
+	return &ModelProvider{httpClient: client}
+}
+
+func (p *ModelProvider) SetHTTPClient(client *http.Client) {
+	p.httpClient = client
+}
+
+func (p *ModelProvider) initClient() {
+	p.init.Do(func() {
+		if p.httpClient == nil {
+			p.httpClient = http.DefaultClient
+		}
+		p.apiKey = os.Getenv("GEMINI_API_KEY")
+		if p.apiKey == "" {
+			p.apiKey = os.Getenv("GOOGLE_API_KEY")
+		}
+		p.endpoint = os.Getenv("GEMINI_BASE_URL")
+		if p.endpoint == "" {
+			p.endpoint = DefaultEndpoint
+		}
+	})
+}
+
+type client struct {
+	httpClient *http.Client
+	apiKey     string
+	endpoint   string
+}
+
+func (p *ModelProvider) newClient(modelParams map[string]any) (*client, error) {
+	p.initClient()
+	c := &client{
+		httpClient: p.httpClient,
+		apiKey:     p.apiKey,
+		endpoint:   p.endpoint,
+	}
+	if endpoint, ok := modelParams["endpoint"].(string); ok && endpoint != "" {
+		c.endpoint = endpoint
+	}
+	if apiKey, ok := modelParams["api_key"].(string); ok && apiKey != "" {
+		c.apiKey = apiKey
+	}
+	if c.apiKey == "" {
+		return nil, errors.New("missing GEMINI_API_KEY")
+	}
+	return c, nil
+}
+
+// do posts the request to `models/{model}:{method}`.
+func (c *client) do(ctx context.Context, model, method string, query url.Values, in any) (*http.Response, error) {
+	bs, err := json.Marshal(in)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request: %w", err)
+	}
+	u := fmt.Sprintf("%s/models/%s:%s", strings.TrimRight(c.endpoint, "/"), url.PathEscape(strings.TrimPrefix(model, "models/")), method)
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(bs))
+	if err != nil {
+		return nil, fmt.Errorf("new request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Goog-Api-Key", c.apiKey)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("do request: %w", err)
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		slog.DebugContext(ctx, "gemini request", "model", model, "method", method, "request", string(bs))
+		return nil, newAPIError(resp)
+	}
+	return resp, nil
+}
+
+func (c *client) doJSON(ctx context.Context, model, method string, in any, out any) error {
+	resp, err := c.do(ctx, model, method, nil, in)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	return nil
+}
+
+func newAPIError(resp *http.Response) error {
+	apiErr := &APIError{
+		StatusCode: resp.StatusCode,
+		Status:     http.StatusText(resp.StatusCode),
+		Message:    resp.Status,
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return apiErr
+	}
+	var errResp ErrorResponse
+	if err := json.Unmarshal(body, &errResp); err != nil || errResp.Error.Message == "" {
+		if len(body) > 0 {
+			apiErr.Message = string(body)
+		}
+		return apiErr
+	}
+	if errResp.Error.Status != "" {
+		apiErr.Status = errResp.Error.Status
+	}
+	apiErr.Message = errResp.Error.Message
+	return apiErr
+}
+
+// ModelParams is the model_params for the gemini provider.
+type ModelParams struct {
+	Temperature      *float64        `json:"temperature,omitempty"`
+	TopP             *float64        `json:"top_p,omitempty"`
+	TopK             *int            `json:"top_k,omitempty"`
+	MaxTokens        int             `json:"max_tokens,omitempty"`
+	MaxOutputTokens  int             `json:"max_output_tokens,omitempty"`
+	StopSequences    []string        `json:"stop_sequences,omitempty"`
+	ResponseMIMEType string          `json:"response_mime_type,omitempty"`
+	ResponseSchema   map[string]any  `json:"response_schema,omitempty"`
+	Seed             *int64          `json:"seed,omitempty"`
+	ThinkingBudget   *int            `json:"thinking_budget,omitempty"`
+	IncludeThoughts  bool            `json:"include_thoughts,omitempty"`
+	SafetySettings   []SafetySetting `json:"safety_settings,omitempty"`
+}
+
+func (params *ModelParams) generationConfig() *GenerationConfig {
+	cfg := &GenerationConfig{
+		Temperature:      params.Temperature,
+		TopP:             params.TopP,
+		TopK:             params.TopK,
+		MaxOutputTokens:  params.MaxOutputTokens,
+		StopSequences:    params.StopSequences,
+		ResponseMIMEType: params.ResponseMIMEType,
+		ResponseSchema:   params.ResponseSchema,
+		Seed:             params.Seed,
+	}
+	if cfg.MaxOutputTokens == 0 {
+		cfg.MaxOutputTokens = params.MaxTokens
+	}
+	if params.ThinkingBudget != nil || params.IncludeThoughts {
+		cfg.ThinkingConfig = &ThinkingConfig{
+			ThinkingBudget:  params.ThinkingBudget,
+			IncludeThoughts: params.IncludeThoughts,
+		}
+	}
+	return cfg
+}
+
+var (
+	toolNameRe = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+)
+
+func NormalizeToolName(input string) string {
+	normalized := toolNameRe.ReplaceAllString(input, "_")
+	normalized = strings.Trim(normalized, "_")
+	if len(normalized) > 64 {
+		normalized = normalized[:64]
+	}
+	if normalized == "" {
+		hash := sha256.Sum256([]byte(input))
+		hashStr := hex.EncodeToString(hash[:])
+		return "tool_" + hashStr[:8]
+	}
+	return normalized
+}
+
+func newContents(system string, messages []estellm.Message) (*Content, []Content, error) {
+	var systemInstruction *Content
+	if system != "" {
+		systemInstruction = &Content{
+			Parts: []Part{{Text: estellm.PlainSystemPrompt(system)}},
+		}
+	}
+	contents := make([]Content, 0, len(messages))
+	for _, msg := range messages {
+		var content Content
+		switch msg.Role {
+		case estellm.RoleUser:
+			content.Role = "user"
+		case estellm.RoleAssistant:
+			content.Role = "model"
+		default:
+			return nil, nil, estellm.ErrInvalidMessageRole
+		}
+		for _, part := range msg.Parts {
+			switch part.Type {
+			case estellm.PartTypeText, estellm.PartTypeGuardContent:
+				content.Parts = append(content.Parts, Part{Text: part.Text})
+			case estellm.PartTypeBinary:
+				p, err := binaryPart(part)
+				if err != nil {
+					return nil, nil, err
+				}
+				content.Parts = append(content.Parts, p)
+			case estellm.PartTypeReasoning:
+				// thoughts are generated by the model, not sent back.
+				continue
+			case estellm.PartTypeCachePoint:
+				// gemini caches the prompt implicitly.
+				continue
+			default:
+				return nil, nil, fmt.Errorf("unsupported content type: %s", part.Type)
+			}
+		}
+		if len(content.Parts) == 0 {
+			continue
+		}
+		contents = append(contents, content)
+	}
+	return systemInstruction, contents, nil
+}
+
+func binaryPart(part estellm.ContentPart) (Part, error) {
+	mediaType, _, err := mime.ParseMediaType(part.MIMEType)
+	if err != nil {
+		return Part{}, fmt.Errorf("parse media type: %w", err)
+	}
+	switch {
+	case strings.HasPrefix(mediaType, "text/"):
+		return Part{Text: string(part.Data)}, nil
+	case strings.HasPrefix(mediaType, "image/"),
+		strings.HasPrefix(mediaType, "audio/"),
+		strings.HasPrefix(mediaType, "video/"),
+		mediaType == "application/pdf":
+		return Part{
+			InlineData: &Blob{
+				MIMEType: mediaType,
+				Data:     part.Data,
+			},
+		}, nil
+	default:
+		return Part{}, fmt.Errorf("unsupported binary content type: %s", mediaType)
+	}
+}
+
+func (p *ModelProvider) GenerateText(ctx context.Context, req *estellm.GenerateTextRequest, w estellm.ResponseWriter) error {
+	c, err := p.newClient(req.ModelParams)
+	if err != nil {
+		return fmt.Errorf("failed to create gemini client: %w", err)
+	}
+	var params ModelParams
+	if err := jsonutil.Remarshal(req.ModelParams, &params); err != nil {
+		return fmt.Errorf("remarshal model params: %w", err)
+	}
+	input := &GenerateContentRequest{
+		GenerationConfig: params.generationConfig(),
+		SafetySettings:   params.SafetySettings,
+	}
+	input.SystemInstruction, input.Contents, err = newContents(req.System, req.Messages)
+	if err != nil {
+		return err
+	}
+	if len(req.Tools) > 0 {
+		decls := make([]FunctionDeclaration, 0, len(req.Tools))
+		for _, tool := range req.Tools {
+			slog.Debug("tool spec", "name", tool.Name(), "description", tool.Description(), "input_schema", tool.InputSchema())
+			decls = append(decls, FunctionDeclaration{
+				Name:                 NormalizeToolName(tool.Name()),
+				Description:          tool.Description(),
+				ParametersJSONSchema: tool.InputSchema(),
+			})
+		}
+		input.Tools = []Tool{{FunctionDeclarations: decls}}
+	}
+	executor, err := estellm.NewToolExecutor(req.Tools, req.ModelParams, NormalizeToolName)
+	if err != nil {
+		return err
+	}
+	return p.generateTextMultiTurn(ctx, c, req.ModelID, input, w, executor)
+}
+
+func (p *ModelProvider) generateTextMultiTurn(ctx context.Context, c *client, model string, input *GenerateContentRequest, w estellm.ResponseWriter, executor *estellm.ToolExecutor) error {
+	var inputTokens int64
+	var outputTokens int64
+	var totalTokens int64
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		executor.NextTurn(w.Metadata())
+		resp, err := c.do(ctx, model, "streamGenerateContent", url.Values{"alt": []string{"sse"}}, input)
+		if err != nil {
+			return fmt.Errorf("stream generate content: %w", err)
+		}
+		w.WriteRole(estellm.RoleAssistant)
+		content, finishReason, usage, err := readContentStream(ctx, resp.Body, w)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("read stream: %w", err)
+		}
+		m := w.Metadata()
+		if usage != nil {
+			inputTokens += usage.PromptTokenCount
+			outputTokens += usage.CandidatesTokenCount + usage.ThoughtsTokenCount
+			totalTokens += usage.TotalTokenCount
+			metadata.SetInputTokens(m, inputTokens)
+			metadata.SetOutputTokens(m, outputTokens)
+			metadata.SetTotalTokens(m, totalTokens)
+		}
+		functionCalls := make([]*FunctionCall, 0)
+		toolUses := make([]estellm.ToolUse, 0)
+		for _, part := range content.Parts {
+			if part.FunctionCall != nil {
+				functionCalls = append(functionCalls, part.FunctionCall)
+				toolUses = append(toolUses, estellm.ToolUse{
+					ID:    part.FunctionCall.ID,
+					Name:  part.FunctionCall.Name,
+					Input: part.FunctionCall.Args,
+				})
+			}
+		}
+		if len(functionCalls) == 0 {
+			finish(w, finishReason)
+			return nil
+		}
+		if executor.Exhausted() {
+			// the model calls the tools even in the final turn.
+			w.Finish(estellm.FinishReasonEndTurn, "max_tool_iterations")
+			return nil
+		}
+		input.Contents = append(input.Contents, content)
+		results, err := executor.Execute(ctx, toolUses)
+		if err != nil {
+			return err
+		}
+		toolResult := Content{
+			Role: "user",
+		}
+		for i, result := range results {
+			toolResult.Parts = append(toolResult.Parts, newToolResult(functionCalls[i], result)...)
+		}
+		if executor.Exhausted() {
+			input.ToolConfig = &ToolConfig{
+				FunctionCallingConfig: &FunctionCallingConfig{Mode: "NONE"},
+			}
+			toolResult.Parts = append(toolResult.Parts, Part{Text: estellm.FinalTurnInstruction})
+		}
+		slog.DebugContext(ctx, "tool result", "content", toolResult)
+		input.Contents = append(input.Contents, toolResult)
+	}
+}
+
+func finish(w estellm.ResponseWriter, reason string) {
+	switch reason {
+	case "STOP", "":
+		w.Finish(estellm.FinishReasonEndTurn, reason)
+	case "MAX_TOKENS":
+		w.Finish(estellm.FinishReasonMaxTokens, reason)
+	case "SAFETY", "RECITATION", "BLOCKLIST", "PROHIBITED_CONTENT", "SPII", "IMAGE_SAFETY", "IMAGE_PROHIBITED_CONTENT":
+		w.Finish(estellm.FinishReasonContentFiltered, reason)
+	default:
+		w.Finish(estellm.FinishReasonEndTurn, reason)
+	}
+}
+
+// readContentStream reads the server-sent events of streamGenerateContent.
+// Only the first candidate is used. The parts are merged into the content for the next turn.
+func readContentStream(ctx context.Context, r io.Reader, w estellm.ResponseWriter) (Content, string, *UsageMetadata, error) {
+	content := Content{Role: "model"}
+	var finishReason string
+	var usage *UsageMetadata
+	reader := bufio.NewReader(r)
+	for {
+		select {
+		case <-ctx.Done():
+			return content, "", usage, ctx.Err()
+		default:
+		}
+		line, err := reader.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return content, "", usage, err
+		}
+		eof := errors.Is(err, io.EOF)
+		if data, ok := strings.CutPrefix(strings.TrimSpace(line), "data:"); ok {
+			var chunk GenerateContentResponse
+			if err := json.Unmarshal([]byte(strings.TrimSpace(data)), &chunk); err != nil {
+				return content, "", usage, fmt.Errorf("unmarshal chunk: %w", err)
+			}
+			if chunk.UsageMetadata != nil {
+				usage = chunk.UsageMetadata
+			}
+			if chunk.PromptFeedback != nil && chunk.PromptFeedback.BlockReason != "" {
+				finishReason = chunk.PromptFeedback.BlockReason
+			}
+			if len(chunk.Candidates) > 0 {
+				candidate := chunk.Candidates[0]
+				if candidate.FinishReason != "" {
+					finishReason = candidate.FinishReason
+				}
+				if candidate.Content != nil {
+					for _, part := range candidate.Content.Parts {
+						if err := writePart(w, part); err != nil {
+							return content, "", usage, err
+						}
+						content.Parts = appendPart(content.Parts, part)
+					}
+				}
+			}
+		}
+		if eof {
+			return content, finishReason, usage, nil
+		}
+	}
+}
+
+func writePart(w estellm.ResponseWriter, part Part) error {
+	switch {
+	case part.Text != "" && part.Thought:
+		return w.WritePart(estellm.ReasoningPart(part.Text))
+	case part.Text != "":
+		return w.WritePart(estellm.TextPart(part.Text))
+	case part.InlineData != nil:
+		return w.WritePart(estellm.BinaryPart(part.InlineData.MIMEType, part.InlineData.Data))
+	}
+	return nil
+}
+
+// appendPart merges consecutive text parts of the same kind, so that the content sent back is compact.
+func appendPart(parts []Part, part Part) []Part {
+	if len(parts) > 0 {
+		last := &parts[len(parts)-1]
+		if part.Text != "" && last.Text != "" && last.Thought == part.Thought && last.FunctionCall == nil && part.ThoughtSignature == "" {
+			last.Text += part.Text
+			return parts
+		}
+	}
+	return append(parts, part)
+}
+
+func newToolResult(call *FunctionCall, result estellm.ToolResult) []Part {
+	if result.Err != nil {
+		return []Part{newToolResultWithError(call, result.Err)}
+	}
+	return newToolResultWithResponse(call, result.Response)
+}
+
+func newToolResultWithError(call *FunctionCall, err error) Part {
+	return Part{
+		FunctionResponse: &FunctionResponse{
+			ID:   call.ID,
+			Name: call.Name,
+			Response: map[string]any{
+				"error": err.Error(),
+			},
+		},
+	}
+}
+
+// newToolResultWithResponse returns the function response part and the binary parts of the tool result.
+func newToolResultWithResponse(call *FunctionCall, response *estellm.Response) []Part {
+	var sb strings.Builder
+	parts := make([]Part, 1, len(response.Message.Parts)+1)
+	for _, part := range response.Message.Parts {
+		switch part.Type {
+		case estellm.PartTypeText:
+			sb.WriteString(part.Text)
+		case estellm.PartTypeBinary:
+			p, err := binaryPart(part)
+			if err != nil {
+				return []Part{newToolResultWithError(call, fmt.Errorf("tool result: %w", err))}
+			}
+			if p.Text != "" {
+				sb.WriteString(p.Text)
+				continue
+			}
+			parts = append(parts, p)
+		}
+	}
+	parts[0] = Part{
+		FunctionResponse: &FunctionResponse{
+			ID:   call.ID,
+			Name: call.Name,
+			Response: map[string]any{
+				"content": sb.String(),
+			},
+		},
+	}
+	return parts
+}
@@ -0,0 +1,306 @@
+package gemini_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mashiike/estellm"
+	"github.com/mashiike/estellm/metadata"
+	"github.com/mashiike/estellm/provider/gemini"
+	"github.com/stretchr/testify/require"
+)
+
+type stubResponse struct {
+	status int
+	body   string
+}
+
+type stubServer struct {
+	*httptest.Server
+	mu        sync.Mutex
+	paths     []string
+	requests  []map[string]any
+	headers   []http.Header
+	responses []stubResponse
+}
+
+func newStubServer(t *testing.T, responses ...stubResponse) *stubServer {
+	t.Helper()
+	s := &stubServer{responses: responses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		bs, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		var body map[string]any
+		require.NoError(t, json.Unmarshal(bs, &body))
+		s.paths = append(s.paths, r.URL.RequestURI())
+		s.requests = append(s.requests, body)
+		s.headers = append(s.headers, r.Header.Clone())
+		if len(s.responses) == 0 {
+			t.Fatal("unexpected request")
+		}
+		resp := s.responses[0]
+		s.responses = s.responses[1:]
+		if resp.status == 0 {
+			resp.status = http.StatusOK
+		}
+		if r.URL.Query().Get("alt") == "sse" && resp.status == http.StatusOK {
+			w.Header().Set("Content-Type", "text/event-stream")
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+		}
+		w.WriteHeader(resp.status)
+		io.WriteString(w, resp.body)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func sse(chunks ...string) stubResponse {
+	var sb strings.Builder
+	for _, chunk := range chunks {
+		fmt.Fprintf(&sb, "data: %s\r\n\r\n", chunk)
+	}
+	return stubResponse{body: sb.String()}
+}
+
+func newRequest(endpoint string, parts ...estellm.ContentPart) *estellm.GenerateTextRequest {
+	return &estellm.GenerateTextRequest{
+		ModelID: "gemini-2.5-flash",
+		ModelParams: map[string]any{
+			"endpoint": endpoint,
+			"api_key":  "test-key",
+		},
+		System: "You are a helpful assistant.",
+		Messages: []estellm.Message{
+			{
+				Role:  estellm.RoleUser,
+				Parts: parts,
+			},
+		},
+	}
+}
+
+func TestGenerateText(t *testing.T) {
+	s := newStubServer(t, sse(
+		`{"candidates":[{"content":{"role":"model","parts":[{"text":"Thinking...","thought":true}]},"index":0}]}`,
+		`{"candidates":[{"content":{"role":"model","parts":[{"text":"Hello"}]},"index":0}]}`,
+		`{"candidates":[{"content":{"role":"model","parts":[{"text":", world"}]},"finishReason":"MAX_TOKENS","index":0}],"usageMetadata":{"promptTokenCount":10,"candidatesTokenCount":4,"thoughtsTokenCount":2,"totalTokenCount":16}}`,
+	))
+	p := &gemini.ModelProvider{}
+	req := newRequest(s.URL,
+		estellm.TextPart("Hi"),
+		estellm.BinaryPart("image/png", []byte("png")),
+		estellm.BinaryPart("application/pdf", []byte("pdf")),
+	)
+	req.ModelParams["max_tokens"] = 256
+	req.ModelParams["temperature"] = 0.5
+	req.ModelParams["include_thoughts"] = true
+	w := estellm.NewBatchResponseWriter()
+	require.NoError(t, p.GenerateText(context.Background(), req, w))
+
+	require.Equal(t, []string{"/models/gemini-2.5-flash:streamGenerateContent?alt=sse"}, s.paths)
+	require.Equal(t, "test-key", s.headers[0].Get("X-Goog-Api-Key"))
+	bs, err := json.Marshal(s.requests[0])
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"systemInstruction": {"parts":[{"text":"You are a helpful assistant."}]},
+		"contents": [
+			{
+				"role": "user",
+				"parts": [
+					{"text":"Hi"},
+					{"inlineData":{"mimeType":"image/png","data":"cG5n"}},
+					{"inlineData":{"mimeType":"application/pdf","data":"cGRm"}}
+				]
+			}
+		],
+		"generationConfig": {
+			"maxOutputTokens": 256,
+			"temperature": 0.5,
+			"thinkingConfig": {"includeThoughts": true}
+		}
+	}`, string(bs))
+
+	resp := w.Response()
+	require.Equal(t, estellm.FinishReasonMaxTokens, resp.FinishReason)
+	require.Equal(t, []estellm.ContentPart{
+		estellm.ReasoningPart("Thinking..."),
+		estellm.TextPart("Hello, world"),
+	}, resp.Message.Parts)
+	inputTokens, _ := metadata.GetInputTokens(resp.Metadata)
+	outputTokens, _ := metadata.GetOutputTokens(resp.Metadata)
+	totalTokens, _ := metadata.GetTotalTokens(resp.Metadata)
+	require.EqualValues(t, 10, inputTokens)
+	require.EqualValues(t, 6, outputTokens)
+	require.EqualValues(t, 16, totalTokens)
+}
+
+type weatherTool struct {
+	inputs []any
+}
+
+func (t *weatherTool) Name() string        { return "get weather" }
+func (t *weatherTool) Description() string { return "get the weather" }
+func (t *weatherTool) InputSchema() map[string]any {
+	return map[string]any{"type": "object", "properties": map[string]any{"city": map[string]any{"type": "string"}}}
+}
+func (t *weatherTool) Call(_ context.Context, input any, w estellm.ResponseWriter) error {
+	t.inputs = append(t.inputs, input)
+	return w.WritePart(estellm.TextPart("sunny"))
+}
+
+func TestGenerateText__FunctionCall(t *testing.T) {
+	s := newStubServer(t,
+		sse(
+			`{"candidates":[{"content":{"role":"model","parts":[{"functionCall":{"name":"get_weather","args":{"city":"Tokyo"}},"thoughtSignature":"sig"}]},"finishReason":"STOP","index":0}],"usageMetadata":{"promptTokenCount":20,"candidatesTokenCount":5,"totalTokenCount":25}}`,
+		),
+		sse(
+			`{"candidates":[{"content":{"role":"model","parts":[{"text":"It is sunny."}]},"finishReason":"STOP","index":0}],"usageMetadata":{"promptTokenCount":30,"candidatesTokenCount":4,"totalTokenCount":34}}`,
+		),
+	)
+	tool := &weatherTool{}
+	p := &gemini.ModelProvider{}
+	req := newRequest(s.URL, estellm.TextPart("How is the weather in Tokyo?"))
+	req.Tools = estellm.ToolSet{tool}
+	w := estellm.NewBatchResponseWriter()
+	require.NoError(t, p.GenerateText(context.Background(), req, w))
+
+	require.Equal(t, []any{map[string]any{"city": "Tokyo"}}, tool.inputs)
+	require.Len(t, s.requests, 2)
+	bs, err := json.Marshal(s.requests[0]["tools"])
+	require.NoError(t, err)
+	require.JSONEq(t, `[{"functionDeclarations":[{
+		"name": "get_weather",
+		"description": "get the weather",
+		"parametersJsonSchema": {"type":"object","properties":{"city":{"type":"string"}}}
+	}]}]`, string(bs))
+	bs, err = json.Marshal(s.requests[1]["contents"])
+	require.NoError(t, err)
+	require.JSONEq(t, `[
+		{"role":"user","parts":[{"text":"How is the weather in Tokyo?"}]},
+		{"role":"model","parts":[{"functionCall":{"name":"get_weather","args":{"city":"Tokyo"}},"thoughtSignature":"sig"}]},
+		{"role":"user","parts":[{"functionResponse":{"name":"get_weather","response":{"content":"sunny"}}}]}
+	]`, string(bs))
+
+	resp := w.Response()
+	require.Equal(t, estellm.FinishReasonEndTurn, resp.FinishReason)
+	require.Equal(t, []estellm.ContentPart{estellm.TextPart("It is sunny.")}, resp.Message.Parts)
+	totalTokens, _ := metadata.GetTotalTokens(resp.Metadata)
+	require.EqualValues(t, 59, totalTokens)
+}
+
+func TestGenerateText__Safety(t *testing.T) {
+	cases := []struct {
+		name  string
+		chunk string
+	}{
+		{
+			name:  "candidate",
+			chunk: `{"candidates":[{"finishReason":"SAFETY","index":0}]}`,
+		},
+		{
+			name:  "prompt feedback",
+			chunk: `{"promptFeedback":{"blockReason":"PROHIBITED_CONTENT"}}`,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := newStubServer(t, sse(c.chunk))
+			p := &gemini.ModelProvider{}
+			w := estellm.NewBatchResponseWriter()
+			require.NoError(t, p.GenerateText(context.Background(), newRequest(s.URL, estellm.TextPart("Hi")), w))
+			require.Equal(t, estellm.FinishReasonContentFiltered, w.Response().FinishReason)
+		})
+	}
+}
+
+func TestGenerateText__APIError(t *testing.T) {
+	s := newStubServer(t, stubResponse{
+		status: http.StatusTooManyRequests,
+		body:   `{"error":{"code":429,"message":"quota exceeded","status":"RESOURCE_EXHAUSTED"}}`,
+	})
+	p := &gemini.ModelProvider{}
+	err := p.GenerateText(context.Background(), newRequest(s.URL, estellm.TextPart("Hi")), estellm.NewBatchResponseWriter())
+	var apiErr *gemini.APIError
+	require.True(t, errors.As(err, &apiErr))
+	require.Equal(t, http.StatusTooManyRequests, apiErr.HTTPStatusCode())
+	require.Equal(t, "RESOURCE_EXHAUSTED", apiErr.Status)
+	require.Equal(t, "quota exceeded", apiErr.Message)
+}
+
+func TestGenerateImage(t *testing.T) {
+	s := newStubServer(t, stubResponse{
+		body: `{"candidates":[{"content":{"role":"model","parts":[{"text":"Here it is."},{"inlineData":{"mimeType":"image/png","data":"cG5n"}}]},"finishReason":"STOP","index":0}]}`,
+	})
+	p := &gemini.ModelProvider{}
+	req := &estellm.GenerateImageRequest{
+		ModelID:     "gemini-2.5-flash-image",
+		ModelParams: map[string]any{"endpoint": s.URL, "api_key": "test-key"},
+		Messages: []estellm.Message{
+			{
+				Role: estellm.RoleUser,
+				Parts: []estellm.ContentPart{
+					estellm.TextPart("Make the sky blue."),
+					estellm.BinaryPart("image/jpeg", []byte("jpeg")),
+				},
+			},
+		},
+	}
+	w := estellm.NewBatchResponseWriter()
+	require.NoError(t, p.GenerateImage(context.Background(), req, w))
+	require.Equal(t, []string{"/models/gemini-2.5-flash-image:generateContent"}, s.paths)
+	bs, err := json.Marshal(s.requests[0])
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"contents": [{"role":"user","parts":[{"text":"Make the sky blue."},{"inlineData":{"mimeType":"image/jpeg","data":"anBlZw=="}}]}],
+		"generationConfig": {"responseModalities":["TEXT","IMAGE"]}
+	}`, string(bs))
+	require.Equal(t, []estellm.ContentPart{
+		estellm.TextPart("Here it is."),
+		estellm.BinaryPart("image/png", []byte("png")),
+	}, w.Response().Message.Parts)
+}
+
+func TestGenerateImage__Imagen(t *testing.T) {
+	s := newStubServer(t, stubResponse{
+		body: `{"predictions":[{"bytesBase64Encoded":"cG5n","mimeType":"image/png"}]}`,
+	})
+	p := &gemini.ModelProvider{}
+	req := &estellm.GenerateImageRequest{
+		ModelID: "imagen-4.0-generate-001",
+		ModelParams: map[string]any{
+			"endpoint":         s.URL,
+			"api_key":          "test-key",
+			"number_of_images": 1,
+			"aspect_ratio":     "16:9",
+		},
+		Messages: []estellm.Message{
+			{
+				Role:  estellm.RoleUser,
+				Parts: []estellm.ContentPart{estellm.TextPart("a cat")},
+			},
+		},
+	}
+	w := estellm.NewBatchResponseWriter()
+	require.NoError(t, p.GenerateImage(context.Background(), req, w))
+	require.Equal(t, []string{"/models/imagen-4.0-generate-001:predict"}, s.paths)
+	bs, err := json.Marshal(s.requests[0])
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"instances": [{"prompt":"a cat"}],
+		"parameters": {"sampleCount":1,"aspectRatio":"16:9"}
+	}`, string(bs))
+	parts := w.Response().Message.Parts
+	require.Len(t, parts, 2)
+	require.Equal(t, []byte("png"), parts[1].Data)
+}
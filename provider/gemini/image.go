@@ -0,0 +1,144 @@
+package gemini
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/mashiike/estellm"
+	"github.com/mashiike/estellm/jsonutil"
+	"github.com/mashiike/estellm/metadata"
+)
+
+// ImagenParams is the model_params for Imagen models.
+type ImagenParams struct {
+	NumberOfImages   int    `json:"number_of_images,omitempty"`
+	AspectRatio      string `json:"aspect_ratio,omitempty"`
+	NegativePrompt   string `json:"negative_prompt,omitempty"`
+	Seed             *int64 `json:"seed,omitempty"`
+	PersonGeneration string `json:"person_generation,omitempty"`
+}
+
+func isImagenModel(modelID string) bool {
+	return strings.HasPrefix(strings.TrimPrefix(modelID, "models/"), "imagen-")
+}
+
+func (p *ModelProvider) GenerateImage(ctx context.Context, req *estellm.GenerateImageRequest, w estellm.ResponseWriter) error {
+	c, err := p.newClient(req.ModelParams)
+	if err != nil {
+		return fmt.Errorf("failed to create gemini client: %w", err)
+	}
+	if isImagenModel(req.ModelID) {
+		return p.generateImageImagen(ctx, c, req, w)
+	}
+	var params ModelParams
+	if err := jsonutil.Remarshal(req.ModelParams, &params); err != nil {
+		return fmt.Errorf("remarshal model params: %w", err)
+	}
+	input := &GenerateContentRequest{
+		GenerationConfig: params.generationConfig(),
+		SafetySettings:   params.SafetySettings,
+	}
+	input.GenerationConfig.ResponseModalities = []string{"TEXT", "IMAGE"}
+	input.SystemInstruction, input.Contents, err = newContents(req.System, req.Messages)
+	if err != nil {
+		return err
+	}
+	var output GenerateContentResponse
+	if err := c.doJSON(ctx, req.ModelID, "generateContent", input, &output); err != nil {
+		return fmt.Errorf("generate content: %w", err)
+	}
+	m := w.Metadata()
+	if output.UsageMetadata != nil {
+		metadata.SetInputTokens(m, output.UsageMetadata.PromptTokenCount)
+		metadata.SetOutputTokens(m, output.UsageMetadata.CandidatesTokenCount+output.UsageMetadata.ThoughtsTokenCount)
+		metadata.SetTotalTokens(m, output.UsageMetadata.TotalTokenCount)
+	}
+	if output.PromptFeedback != nil && output.PromptFeedback.BlockReason != "" {
+		finish(w, output.PromptFeedback.BlockReason)
+		return nil
+	}
+	if len(output.Candidates) == 0 {
+		w.Finish(estellm.FinishReasonEndTurn, "no candidates")
+		return nil
+	}
+	w.WriteRole(estellm.RoleAssistant)
+	candidate := output.Candidates[0]
+	if candidate.Content != nil {
+		for _, part := range candidate.Content.Parts {
+			if err := writePart(w, part); err != nil {
+				return fmt.Errorf("write part: %w", err)
+			}
+		}
+	}
+	finish(w, candidate.FinishReason)
+	return nil
+}
+
+func (p *ModelProvider) generateImageImagen(ctx context.Context, c *client, req *estellm.GenerateImageRequest, w estellm.ResponseWriter) error {
+	if mode := req.ImageMode(); mode != estellm.ImageModeGenerate {
+		return fmt.Errorf("image mode `%s` with imagen: %w", mode, estellm.ErrNotSupported)
+	}
+	var params ImagenParams
+	if err := jsonutil.Remarshal(req.ModelParams, &params); err != nil {
+		return fmt.Errorf("remarshal image request: %w", err)
+	}
+	var sb strings.Builder
+	enc := estellm.NewMessageEncoder(&sb)
+	enc.SkipReasoning()
+	enc.TextOnly()
+	enc.NoRole()
+	if err := enc.Encode(req.System, req.Messages); err != nil {
+		return fmt.Errorf("encode messages: %w", err)
+	}
+	prompt := strings.TrimSpace(sb.String())
+	input := &PredictRequest{
+		Instances:  []PredictInstance{{Prompt: prompt}},
+		Parameters: map[string]any{},
+	}
+	if params.NumberOfImages > 0 {
+		input.Parameters["sampleCount"] = params.NumberOfImages
+	}
+	if params.AspectRatio != "" {
+		input.Parameters["aspectRatio"] = params.AspectRatio
+	}
+	if params.NegativePrompt != "" {
+		input.Parameters["negativePrompt"] = params.NegativePrompt
+	}
+	if params.Seed != nil {
+		input.Parameters["seed"] = *params.Seed
+	}
+	if params.PersonGeneration != "" {
+		input.Parameters["personGeneration"] = params.PersonGeneration
+	}
+	w.WritePart(estellm.TextPart(fmt.Sprintf("<prompt type=\"provided\">%s</prompt>", prompt)))
+	var output PredictResponse
+	if err := c.doJSON(ctx, req.ModelID, "predict", input, &output); err != nil {
+		return fmt.Errorf("predict: %w", err)
+	}
+	var filtered int
+	for _, prediction := range output.Predictions {
+		if prediction.BytesBase64Encoded == "" {
+			if prediction.RAIFilteredReason != "" {
+				filtered++
+			}
+			continue
+		}
+		data, err := base64.StdEncoding.DecodeString(prediction.BytesBase64Encoded)
+		if err != nil {
+			return fmt.Errorf("failed to decode image: %w", err)
+		}
+		mimeType := prediction.MIMEType
+		if mimeType == "" {
+			mimeType = "image/png"
+		}
+		w.WritePart(estellm.BinaryPart(mimeType, data))
+	}
+	if filtered > 0 && filtered == len(output.Predictions) {
+		w.Finish(estellm.FinishReasonContentFiltered, "all images are filtered")
+		return nil
+	}
+	w.Finish(estellm.FinishReasonEndTurn, fmt.Sprintf("generate %d images", len(output.Predictions)-filtered))
+	return nil
+}
@@ -0,0 +1,483 @@
+package ollama
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"mime"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/mashiike/estellm"
+	"github.com/mashiike/estellm/jsonutil"
+	"github.com/mashiike/estellm/metadata"
+)
+
+func init() {
+	// Register the provider
+	estellm.RegisterModelProvider("ollama", &ModelProvider{})
+}
+
+const (
+	DefaultEndpoint = "http://localhost:11434"
+)
+
+type ModelProvider struct {
+	init       sync.Once
+	httpClient *http.Client
+	endpoint   string
+}
+
+func NewWithHTTPClient(client *http.Client) *ModelProvider {
+	return &ModelProvider{httpClient: client}
+}
+
+func (p *ModelProvider) SetHTTPClient(client *http.Client) {
+	p.httpClient = client
+}
+
+func (p *ModelProvider) initClient() {
+	p.init.Do(func() {
+		if p.httpClient == nil {
+			p.httpClient = http.DefaultClient
+		}
+		p.endpoint = DefaultEndpoint
+		if host := os.Getenv("OLLAMA_HOST"); host != "" {
+			p.endpoint = normalizeEndpoint(host)
+		}
+	})
+}
+
+// normalizeEndpoint accepts OLLAMA_HOST style values like `0.0.0.0:11434`.
+func normalizeEndpoint(host string) string {
+	if !strings.Contains(host, "://") {
+		host = "http://" + host
+	}
+	return strings.TrimRight(host, "/")
+}
+
+type client struct {
+	httpClient *http.Client
+	endpoint   string
+}
+
+func (p *ModelProvider) newClient(modelParams map[string]any) *client {
+	p.initClient()
+	c := &client{
+		httpClient: p.httpClient,
+		endpoint:   p.endpoint,
+	}
+	if endpoint, ok := modelParams["endpoint"].(string); ok && endpoint != "" {
+		c.endpoint = normalizeEndpoint(endpoint)
+	}
+	return c
+}
+
+func (c *client) do(ctx context.Context, method, path string, in any) (*http.Response, error) {
+	var body io.Reader
+	if in != nil {
+		bs, err := json.Marshal(in)
+		if err != nil {
+			return nil, fmt.Errorf("marshal request: %w", err)
+		}
+		body = bytes.NewReader(bs)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.endpoint+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("new request: %w", err)
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("do request: %w", err)
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		return nil, newAPIError(resp)
+	}
+	return resp, nil
+}
+
+func newAPIError(resp *http.Response) *APIError {
+	apiErr := &APIError{
+		StatusCode: resp.StatusCode,
+		Message:    resp.Status,
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return apiErr
+	}
+	var errResp struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(body, &errResp); err == nil && errResp.Error != "" {
+		apiErr.Message = errResp.Error
+	} else if len(body) > 0 {
+		apiErr.Message = string(body)
+	}
+	return apiErr
+}
+
+// modelNotFound turns the 404 of a missing model into ErrModelNotFound with a hint, instead of pulling the model.
+func (c *client) modelNotFound(model string, err error) error {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: `%s` is not available on %s, run `ollama pull %s`: %w", estellm.ErrModelNotFound, model, c.endpoint, model, err)
+	}
+	return err
+}
+
+// ModelParams is the model_params for the ollama provider.
+// `options` is passed as is, and the other parameters are merged into it.
+type ModelParams struct {
+	Options       map[string]any `json:"options,omitempty"`
+	Format        any            `json:"format,omitempty"`
+	Think         any            `json:"think,omitempty"`
+	KeepAlive     string         `json:"keep_alive,omitempty"`
+	Temperature   *float64       `json:"temperature,omitempty"`
+	MaxTokens     *int           `json:"max_tokens,omitempty"`
+	StopSequences []string       `json:"stop_sequences,omitempty"`
+}
+
+func (params *ModelParams) options() map[string]any {
+	options := make(map[string]any, len(params.Options)+3)
+	maps.Copy(options, params.Options)
+	if params.Temperature != nil {
+		options["temperature"] = *params.Temperature
+	}
+	if params.MaxTokens != nil {
+		options["num_predict"] = *params.MaxTokens
+	}
+	if len(params.StopSequences) > 0 {
+		options["stop"] = params.StopSequences
+	}
+	if len(options) == 0 {
+		return nil
+	}
+	return options
+}
+
+var (
+	toolNameRe = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+)
+
+func NormalizeToolName(input string) string {
+	normalized := toolNameRe.ReplaceAllString(input, "_")
+	normalized = strings.Trim(normalized, "_")
+	if len(normalized) > 64 {
+		normalized = normalized[:64]
+	}
+	if normalized == "" {
+		hash := sha256.Sum256([]byte(input))
+		hashStr := hex.EncodeToString(hash[:])
+		return "tool_" + hashStr[:8]
+	}
+	return normalized
+}
+
+func (p *ModelProvider) GenerateText(ctx context.Context, req *estellm.GenerateTextRequest, w estellm.ResponseWriter) error {
+	c := p.newClient(req.ModelParams)
+	var params ModelParams
+	if err := jsonutil.Remarshal(req.ModelParams, &params); err != nil {
+		return fmt.Errorf("remarshal model params: %w", err)
+	}
+	input := &ChatRequest{
+		Model:     req.ModelID,
+		Stream:    true,
+		Format:    params.Format,
+		Think:     params.Think,
+		KeepAlive: params.KeepAlive,
+		Options:   params.options(),
+	}
+	input.Messages = make([]Message, 0, len(req.Messages)+1)
+	if req.System != "" {
+		input.Messages = append(input.Messages, Message{
+			Role:    "system",
+			Content: estellm.PlainSystemPrompt(req.System),
+		})
+	}
+	for _, msg := range req.Messages {
+		var oMsg Message
+		switch msg.Role {
+		case estellm.RoleUser, estellm.RoleAssistant:
+			oMsg.Role = msg.Role
+		default:
+			return estellm.ErrInvalidMessageRole
+		}
+		var sb strings.Builder
+		for _, part := range msg.Parts {
+			switch part.Type {
+			case estellm.PartTypeText, estellm.PartTypeGuardContent:
+				sb.WriteString(part.Text)
+			case estellm.PartTypeBinary:
+				text, image, err := binaryPart(part)
+				if err != nil {
+					return err
+				}
+				sb.WriteString(text)
+				if image != nil {
+					oMsg.Images = append(oMsg.Images, image)
+				}
+			case estellm.PartTypeReasoning, estellm.PartTypeCachePoint:
+				continue
+			default:
+				return fmt.Errorf("unsupported content type: %s", part.Type)
+			}
+		}
+		oMsg.Content = sb.String()
+		input.Messages = append(input.Messages, oMsg)
+	}
+	if len(req.Tools) > 0 {
+		input.Tools = make([]Tool, 0, len(req.Tools))
+		for _, tool := range req.Tools {
+			slog.Debug("tool spec", "name", tool.Name(), "description", tool.Description(), "input_schema", tool.InputSchema())
+			input.Tools = append(input.Tools, Tool{
+				Type: "function",
+				Function: Function{
+					Name:        NormalizeToolName(tool.Name()),
+					Description: tool.Description(),
+					Parameters:  tool.InputSchema(),
+				},
+			})
+		}
+	}
+	executor, err := estellm.NewToolExecutor(req.Tools, req.ModelParams, NormalizeToolName)
+	if err != nil {
+		return err
+	}
+	return p.generateTextMultiTurn(ctx, c, input, w, executor)
+}
+
+// binaryPart returns text for text/* parts, and image data for image parts.
+func binaryPart(part estellm.ContentPart) (string, []byte, error) {
+	mediaType, _, err := mime.ParseMediaType(part.MIMEType)
+	if err != nil {
+		return "", nil, fmt.Errorf("parse media type: %w", err)
+	}
+	switch {
+	case strings.HasPrefix(mediaType, "image/"):
+		return "", part.Data, nil
+	case strings.HasPrefix(mediaType, "text/"):
+		return string(part.Data), nil, nil
+	default:
+		return "", nil, fmt.Errorf("unsupported binary content type: %s", mediaType)
+	}
+}
+
+func (p *ModelProvider) generateTextMultiTurn(ctx context.Context, c *client, input *ChatRequest, w estellm.ResponseWriter, executor *estellm.ToolExecutor) error {
+	var inputTokens int64
+	var outputTokens int64
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		executor.NextTurn(w.Metadata())
+		resp, err := c.do(ctx, http.MethodPost, "/api/chat", input)
+		if err != nil {
+			return fmt.Errorf("chat: %w", c.modelNotFound(input.Model, err))
+		}
+		w.WriteRole(estellm.RoleAssistant)
+		msg, last, err := readChatStream(ctx, resp.Body, w)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("read stream: %w", err)
+		}
+		m := w.Metadata()
+		inputTokens += last.PromptEvalCount
+		outputTokens += last.EvalCount
+		metadata.SetInputTokens(m, inputTokens)
+		metadata.SetOutputTokens(m, outputTokens)
+		metadata.SetTotalTokens(m, inputTokens+outputTokens)
+		if len(msg.ToolCalls) == 0 {
+			switch last.DoneReason {
+			case "length":
+				w.Finish(estellm.FinishReasonMaxTokens, last.DoneReason)
+			default:
+				w.Finish(estellm.FinishReasonEndTurn, last.DoneReason)
+			}
+			return nil
+		}
+		if executor.Exhausted() {
+			// the model calls the tools even in the final turn.
+			w.Finish(estellm.FinishReasonEndTurn, "max_tool_iterations")
+			return nil
+		}
+		// thinking is not sent back, some servers reject it in the history.
+		msg.Thinking = ""
+		input.Messages = append(input.Messages, msg)
+		toolUses := make([]estellm.ToolUse, 0, len(msg.ToolCalls))
+		for i, call := range msg.ToolCalls {
+			toolUses = append(toolUses, estellm.ToolUse{
+				ID:    toolCallID(call, i),
+				Name:  call.Function.Name,
+				Input: toolArguments(call.Function.Arguments),
+			})
+		}
+		results, err := executor.Execute(ctx, toolUses)
+		if err != nil {
+			return err
+		}
+		for i, result := range results {
+			input.Messages = append(input.Messages, newToolResult(msg.ToolCalls[i], result))
+		}
+		if executor.Exhausted() {
+			// ollama has no tool choice, the tools are removed from the final turn.
+			input.Tools = nil
+			input.Messages = append(input.Messages, Message{
+				Role:    estellm.RoleUser,
+				Content: estellm.FinalTurnInstruction,
+			})
+		}
+	}
+}
+
+// readChatStream reads the newline delimited JSON stream of /api/chat.
+// Content and thinking are written to w, and tool calls are collected into the returned message.
+func readChatStream(ctx context.Context, r io.Reader, w estellm.ResponseWriter) (Message, ChatResponse, error) {
+	msg := Message{Role: estellm.RoleAssistant}
+	var content strings.Builder
+	var last ChatResponse
+	reader := bufio.NewReader(r)
+	for {
+		select {
+		case <-ctx.Done():
+			return msg, last, ctx.Err()
+		default:
+		}
+		line, err := reader.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return msg, last, err
+		}
+		eof := errors.Is(err, io.EOF)
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			var chunk ChatResponse
+			if err := json.Unmarshal(line, &chunk); err != nil {
+				return msg, last, fmt.Errorf("unmarshal chunk: %w", err)
+			}
+			if chunk.Error != "" {
+				return msg, last, &APIError{StatusCode: http.StatusInternalServerError, Message: chunk.Error}
+			}
+			if chunk.Message.Thinking != "" {
+				w.WritePart(estellm.ReasoningPart(chunk.Message.Thinking))
+			}
+			if chunk.Message.Content != "" {
+				w.WritePart(estellm.TextPart(chunk.Message.Content))
+				content.WriteString(chunk.Message.Content)
+			}
+			msg.ToolCalls = append(msg.ToolCalls, chunk.Message.ToolCalls...)
+			if chunk.Done {
+				last = chunk
+				break
+			}
+		}
+		if eof {
+			break
+		}
+	}
+	msg.Content = content.String()
+	return msg, last, nil
+}
+
+func toolCallID(call ToolCall, index int) string {
+	if call.ID != "" {
+		return call.ID
+	}
+	return fmt.Sprintf("call_%d", index)
+}
+
+// toolArguments unwraps the arguments, which some models return as a JSON encoded string.
+func toolArguments(raw json.RawMessage) json.RawMessage {
+	var str string
+	if err := json.Unmarshal(raw, &str); err == nil {
+		return json.RawMessage(str)
+	}
+	return raw
+}
+
+func newToolResult(call ToolCall, result estellm.ToolResult) Message {
+	if result.Err != nil {
+		return newToolResultWithError(call, result.Err)
+	}
+	return newToolResultWithResponse(call, result.Response)
+}
+
+func newToolResultWithError(call ToolCall, err error) Message {
+	return Message{
+		Role:     "tool",
+		ToolName: call.Function.Name,
+		Content:  fmt.Sprintf("error: %s", err),
+	}
+}
+
+func newToolResultWithResponse(call ToolCall, response *estellm.Response) Message {
+	msg := Message{
+		Role:     "tool",
+		ToolName: call.Function.Name,
+	}
+	var sb strings.Builder
+	for _, part := range response.Message.Parts {
+		switch part.Type {
+		case estellm.PartTypeText:
+			sb.WriteString(part.Text)
+		case estellm.PartTypeBinary:
+			text, image, err := binaryPart(part)
+			if err != nil {
+				return newToolResultWithError(call, fmt.Errorf("tool result: %w", err))
+			}
+			sb.WriteString(text)
+			if image != nil {
+				msg.Images = append(msg.Images, image)
+			}
+		}
+	}
+	msg.Content = sb.String()
+	return msg
+}
+
+func (p *ModelProvider) GenerateImage(_ context.Context, _ *estellm.GenerateImageRequest, _ estellm.ResponseWriter) error {
+	return fmt.Errorf("ollama image generation: %w", estellm.ErrNotSupported)
+}
+
+func (p *ModelProvider) ListModels(ctx context.Context, req *estellm.ListModelsRequest) ([]estellm.ModelInfo, error) {
+	c := p.newClient(req.ModelParams)
+	resp, err := c.do(ctx, http.MethodGet, "/api/tags", nil)
+	if err != nil {
+		return nil, fmt.Errorf("get tags: %w", err)
+	}
+	defer resp.Body.Close()
+	var tags TagsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	models := make([]estellm.ModelInfo, 0, len(tags.Models))
+	for _, model := range tags.Models {
+		details := make(map[string]any, len(model.Details)+2)
+		maps.Copy(details, model.Details)
+		details["size"] = model.Size
+		details["modified_at"] = model.ModifiedAt
+		id := model.Model
+		if id == "" {
+			id = model.Name
+		}
+		models = append(models, estellm.ModelInfo{
+			ID:      id,
+			Details: details,
+		})
+	}
+	return models, nil
+}
@@ -0,0 +1,235 @@
+package ollama_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mashiike/estellm"
+	"github.com/mashiike/estellm/metadata"
+	"github.com/mashiike/estellm/provider/ollama"
+	"github.com/stretchr/testify/require"
+)
+
+type stubResponse struct {
+	status int
+	body   string
+}
+
+type stubServer struct {
+	*httptest.Server
+	mu        sync.Mutex
+	paths     []string
+	requests  []map[string]any
+	responses []stubResponse
+}
+
+func newStubServer(t *testing.T, responses ...stubResponse) *stubServer {
+	t.Helper()
+	s := &stubServer{responses: responses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.paths = append(s.paths, r.Method+" "+r.URL.Path)
+		if r.Method == http.MethodPost {
+			bs, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			var body map[string]any
+			require.NoError(t, json.Unmarshal(bs, &body))
+			s.requests = append(s.requests, body)
+		}
+		if len(s.responses) == 0 {
+			t.Fatal("unexpected request")
+		}
+		resp := s.responses[0]
+		s.responses = s.responses[1:]
+		if resp.status == 0 {
+			resp.status = http.StatusOK
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(resp.status)
+		io.WriteString(w, resp.body)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func ndjson(lines ...string) stubResponse {
+	return stubResponse{body: strings.Join(lines, "\n") + "\n"}
+}
+
+func newRequest(endpoint string, parts ...estellm.ContentPart) *estellm.GenerateTextRequest {
+	return &estellm.GenerateTextRequest{
+		ModelID: "llama3.2",
+		ModelParams: map[string]any{
+			"endpoint": endpoint,
+		},
+		System: "You are a helpful assistant.",
+		Messages: []estellm.Message{
+			{
+				Role:  estellm.RoleUser,
+				Parts: parts,
+			},
+		},
+	}
+}
+
+func TestGenerateText(t *testing.T) {
+	s := newStubServer(t, ndjson(
+		`{"model":"llama3.2","message":{"role":"assistant","content":"","thinking":"Hmm."},"done":false}`,
+		`{"model":"llama3.2","message":{"role":"assistant","content":"Hello"},"done":false}`,
+		`{"model":"llama3.2","message":{"role":"assistant","content":", world"},"done":false}`,
+		`{"model":"llama3.2","message":{"role":"assistant","content":""},"done":true,"done_reason":"length","prompt_eval_count":12,"eval_count":3}`,
+	))
+	p := &ollama.ModelProvider{}
+	req := newRequest(s.URL,
+		estellm.TextPart("What is this?"),
+		estellm.BinaryPart("image/png", []byte("png")),
+	)
+	req.ModelParams["temperature"] = 0.2
+	req.ModelParams["max_tokens"] = 64
+	req.ModelParams["options"] = map[string]any{"num_ctx": 8192}
+	req.ModelParams["think"] = true
+	w := estellm.NewBatchResponseWriter()
+	require.NoError(t, p.GenerateText(context.Background(), req, w))
+
+	require.Equal(t, []string{"POST /api/chat"}, s.paths)
+	bs, err := json.Marshal(s.requests[0])
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"model": "llama3.2",
+		"stream": true,
+		"think": true,
+		"options": {"temperature":0.2,"num_predict":64,"num_ctx":8192},
+		"messages": [
+			{"role":"system","content":"You are a helpful assistant."},
+			{"role":"user","content":"What is this?","images":["cG5n"]}
+		]
+	}`, string(bs))
+
+	resp := w.Response()
+	require.Equal(t, estellm.FinishReasonMaxTokens, resp.FinishReason)
+	require.Equal(t, []estellm.ContentPart{
+		estellm.ReasoningPart("Hmm."),
+		estellm.TextPart("Hello, world"),
+	}, resp.Message.Parts)
+	inputTokens, _ := metadata.GetInputTokens(resp.Metadata)
+	outputTokens, _ := metadata.GetOutputTokens(resp.Metadata)
+	require.EqualValues(t, 12, inputTokens)
+	require.EqualValues(t, 3, outputTokens)
+}
+
+type weatherTool struct {
+	inputs []any
+}
+
+func (t *weatherTool) Name() string        { return "get weather" }
+func (t *weatherTool) Description() string { return "get the weather" }
+func (t *weatherTool) InputSchema() map[string]any {
+	return map[string]any{"type": "object", "properties": map[string]any{"city": map[string]any{"type": "string"}}}
+}
+func (t *weatherTool) Call(_ context.Context, input any, w estellm.ResponseWriter) error {
+	t.inputs = append(t.inputs, input)
+	return w.WritePart(estellm.TextPart("sunny"))
+}
+
+func TestGenerateText__ToolCalls(t *testing.T) {
+	s := newStubServer(t,
+		ndjson(
+			`{"model":"llama3.2","message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"get_weather","arguments":{"city":"Tokyo"}}}]},"done":false}`,
+			`{"model":"llama3.2","message":{"role":"assistant","content":""},"done":true,"done_reason":"stop","prompt_eval_count":20,"eval_count":10}`,
+		),
+		ndjson(
+			`{"model":"llama3.2","message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"get_weather","arguments":"{\"city\":\"Osaka\"}"}}]},"done":false}`,
+			`{"model":"llama3.2","message":{"role":"assistant","content":""},"done":true,"done_reason":"stop","prompt_eval_count":30,"eval_count":10}`,
+		),
+		ndjson(
+			`{"model":"llama3.2","message":{"role":"assistant","content":"Both are sunny."},"done":false}`,
+			`{"model":"llama3.2","message":{"role":"assistant","content":""},"done":true,"done_reason":"stop","prompt_eval_count":40,"eval_count":5}`,
+		),
+	)
+	tool := &weatherTool{}
+	p := &ollama.ModelProvider{}
+	req := newRequest(s.URL, estellm.TextPart("How is the weather in Tokyo and Osaka?"))
+	req.Tools = estellm.ToolSet{tool}
+	w := estellm.NewBatchResponseWriter()
+	require.NoError(t, p.GenerateText(context.Background(), req, w))
+
+	require.Equal(t, []any{
+		map[string]any{"city": "Tokyo"},
+		map[string]any{"city": "Osaka"},
+	}, tool.inputs)
+	require.Len(t, s.requests, 3)
+	bs, err := json.Marshal(s.requests[0]["tools"])
+	require.NoError(t, err)
+	require.JSONEq(t, `[{"type":"function","function":{
+		"name": "get_weather",
+		"description": "get the weather",
+		"parameters": {"type":"object","properties":{"city":{"type":"string"}}}
+	}}]`, string(bs))
+	bs, err = json.Marshal(s.requests[1]["messages"])
+	require.NoError(t, err)
+	require.JSONEq(t, `[
+		{"role":"system","content":"You are a helpful assistant."},
+		{"role":"user","content":"How is the weather in Tokyo and Osaka?"},
+		{"role":"assistant","content":"","tool_calls":[{"function":{"name":"get_weather","arguments":{"city":"Tokyo"}}}]},
+		{"role":"tool","content":"sunny","tool_name":"get_weather"}
+	]`, string(bs))
+
+	resp := w.Response()
+	require.Equal(t, estellm.FinishReasonEndTurn, resp.FinishReason)
+	require.Equal(t, []estellm.ContentPart{estellm.TextPart("Both are sunny.")}, resp.Message.Parts)
+	totalTokens, _ := metadata.GetTotalTokens(resp.Metadata)
+	require.EqualValues(t, 115, totalTokens)
+}
+
+func TestGenerateText__ModelNotFound(t *testing.T) {
+	s := newStubServer(t, stubResponse{
+		status: http.StatusNotFound,
+		body:   `{"error":"model \"llama3.2\" not found, try pulling it first"}`,
+	})
+	p := &ollama.ModelProvider{}
+	err := p.GenerateText(context.Background(), newRequest(s.URL, estellm.TextPart("Hi")), estellm.NewBatchResponseWriter())
+	require.ErrorIs(t, err, estellm.ErrModelNotFound)
+	require.ErrorContains(t, err, "run `ollama pull llama3.2`")
+	var apiErr *ollama.APIError
+	require.True(t, errors.As(err, &apiErr))
+	require.Equal(t, http.StatusNotFound, apiErr.HTTPStatusCode())
+	require.Equal(t, []string{"POST /api/chat"}, s.paths)
+}
+
+func TestGenerateText__StreamError(t *testing.T) {
+	s := newStubServer(t, ndjson(
+		`{"model":"llama3.2","message":{"role":"assistant","content":"Hel"},"done":false}`,
+		`{"error":"an error was encountered while running the model"}`,
+	))
+	p := &ollama.ModelProvider{}
+	err := p.GenerateText(context.Background(), newRequest(s.URL, estellm.TextPart("Hi")), estellm.NewBatchResponseWriter())
+	require.ErrorContains(t, err, "an error was encountered while running the model")
+}
+
+func TestListModels(t *testing.T) {
+	s := newStubServer(t, stubResponse{
+		body: `{"models":[
+			{"name":"llama3.2:latest","model":"llama3.2:latest","modified_at":"2025-01-01T00:00:00Z","size":2019393189,"details":{"family":"llama","parameter_size":"3.2B"}},
+			{"name":"qwen3:8b","modified_at":"2025-02-01T00:00:00Z","size":5225388164,"details":{"family":"qwen3"}}
+		]}`,
+	})
+	p := &ollama.ModelProvider{}
+	var lister estellm.ModelLister = p
+	models, err := lister.ListModels(context.Background(), &estellm.ListModelsRequest{
+		ModelParams: map[string]any{"endpoint": s.URL},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"GET /api/tags"}, s.paths)
+	require.Len(t, models, 2)
+	require.Equal(t, "llama3.2:latest", models[0].ID)
+	require.Equal(t, "llama", models[0].Details["family"])
+	require.Equal(t, "qwen3:8b", models[1].ID)
+}
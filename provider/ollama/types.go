@@ -0,0 +1,89 @@
+package ollama
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// https://github.com/ollama/ollama/blob/main/docs/api.md#generate-a-chat-completion
+type ChatRequest struct {
+	Model     string         `json:"model"`
+	Messages  []Message      `json:"messages"`
+	Tools     []Tool         `json:"tools,omitempty"`
+	Stream    bool           `json:"stream"`
+	Format    any            `json:"format,omitempty"`
+	Think     any            `json:"think,omitempty"`
+	KeepAlive string         `json:"keep_alive,omitempty"`
+	Options   map[string]any `json:"options,omitempty"`
+}
+
+type Message struct {
+	Role      string     `json:"role"`
+	Content   string     `json:"content"`
+	Thinking  string     `json:"thinking,omitempty"`
+	Images    [][]byte   `json:"images,omitempty"`
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	ToolName  string     `json:"tool_name,omitempty"`
+}
+
+type ToolCall struct {
+	ID       string       `json:"id,omitempty"`
+	Function FunctionCall `json:"function"`
+}
+
+type FunctionCall struct {
+	Index     int             `json:"index,omitempty"`
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+type Tool struct {
+	Type     string   `json:"type"`
+	Function Function `json:"function"`
+}
+
+type Function struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Parameters  map[string]any `json:"parameters"`
+}
+
+type ChatResponse struct {
+	Model           string  `json:"model"`
+	CreatedAt       string  `json:"created_at"`
+	Message         Message `json:"message"`
+	Done            bool    `json:"done"`
+	DoneReason      string  `json:"done_reason,omitempty"`
+	TotalDuration   int64   `json:"total_duration,omitempty"`
+	PromptEvalCount int64   `json:"prompt_eval_count,omitempty"`
+	EvalCount       int64   `json:"eval_count,omitempty"`
+	Error           string  `json:"error,omitempty"`
+}
+
+type TagsResponse struct {
+	Models []ModelSummary `json:"models"`
+}
+
+type ModelSummary struct {
+	Name       string         `json:"name"`
+	Model      string         `json:"model"`
+	ModifiedAt time.Time      `json:"modified_at"`
+	Size       int64          `json:"size"`
+	Digest     string         `json:"digest"`
+	Details    map[string]any `json:"details"`
+}
+
+// APIError is returned when the Ollama server responds with an error.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("ollama api error: status %d: %s", e.StatusCode, e.Message)
+}
+
+func (e *APIError) HTTPStatusCode() int {
+	return e.StatusCode
+}
@@ -0,0 +1,129 @@
+package openai
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/mashiike/estellm"
+	"github.com/mashiike/estellm/jsonutil"
+	"github.com/sashabaranov/go-openai"
+)
+
+// SpeechClient is an optional interface for clients that support the text-to-speech API.
+type SpeechClient interface {
+	CreateSpeech(ctx context.Context, request openai.CreateSpeechRequest) (openai.RawResponse, error)
+}
+
+// TranscriptionClient is an optional interface for clients that support the transcription API.
+type TranscriptionClient interface {
+	CreateTranscription(ctx context.Context, request openai.AudioRequest) (openai.AudioResponse, error)
+}
+
+var speechMIMETypes = map[openai.SpeechResponseFormat]string{
+	openai.SpeechResponseFormatMp3:  "audio/mpeg",
+	openai.SpeechResponseFormatOpus: "audio/ogg",
+	openai.SpeechResponseFormatAac:  "audio/aac",
+	openai.SpeechResponseFormatFlac: "audio/flac",
+	openai.SpeechResponseFormatWav:  "audio/wav",
+	openai.SpeechResponseFormatPcm:  "audio/pcm",
+}
+
+func (p *ModelProvider) GenerateSpeech(ctx context.Context, req *estellm.GenerateSpeechRequest, w estellm.ResponseWriter) error {
+	client, err := p.newClient(req.ModelParams)
+	if err != nil {
+		return fmt.Errorf("failed to create openai client: %w", err)
+	}
+	speechClient, ok := client.(SpeechClient)
+	if !ok {
+		return fmt.Errorf("client does not support speech: %w", estellm.ErrNotSupported)
+	}
+	var input openai.CreateSpeechRequest
+	if err := jsonutil.Remarshal(req.ModelParams, &input); err != nil {
+		return fmt.Errorf("remarshal speech request: %w", err)
+	}
+	input.Model = openai.SpeechModel(req.ModelID)
+	input.Input = req.Text
+	if input.Voice == "" {
+		input.Voice = openai.VoiceAlloy
+	}
+	if input.ResponseFormat == "" {
+		input.ResponseFormat = openai.SpeechResponseFormatMp3
+	}
+	mimeType, ok := speechMIMETypes[input.ResponseFormat]
+	if !ok {
+		return fmt.Errorf("unsupported response_format `%s`", input.ResponseFormat)
+	}
+	output, err := speechClient.CreateSpeech(ctx, input)
+	if err != nil {
+		return fmt.Errorf("failed to create speech: %w", err)
+	}
+	defer output.Close()
+	data, err := io.ReadAll(output)
+	if err != nil {
+		return fmt.Errorf("read speech: %w", err)
+	}
+	setToMetadta(w.Metadata(), output.GetRateLimitHeaders())
+	w.WriteRole(estellm.RoleAssistant)
+	if err := w.WritePart(estellm.BinaryPart(mimeType, data)); err != nil {
+		return fmt.Errorf("write part: %w", err)
+	}
+	w.Finish(estellm.FinishReasonEndTurn, "speech generated")
+	return nil
+}
+
+type transcriptionParams struct {
+	Prompt         string  `json:"prompt"`
+	Temperature    float32 `json:"temperature"`
+	Language       string  `json:"language"`
+	ResponseFormat string  `json:"response_format"`
+}
+
+func (p *ModelProvider) Transcribe(ctx context.Context, req *estellm.TranscribeRequest, w estellm.ResponseWriter) error {
+	client, err := p.newClient(req.ModelParams)
+	if err != nil {
+		return fmt.Errorf("failed to create openai client: %w", err)
+	}
+	transcriptionClient, ok := client.(TranscriptionClient)
+	if !ok {
+		return fmt.Errorf("client does not support transcription: %w", estellm.ErrNotSupported)
+	}
+	var params transcriptionParams
+	if err := jsonutil.Remarshal(req.ModelParams, &params); err != nil {
+		return fmt.Errorf("remarshal transcription request: %w", err)
+	}
+	mimeType, _, _ := strings.Cut(req.Audio.MIMEType, ";")
+	ext, ok := estellm.FileExtensions[mimeType]
+	if !ok {
+		return fmt.Errorf("unsupported audio type `%s`", req.Audio.MIMEType)
+	}
+	input := openai.AudioRequest{
+		Model:       req.ModelID,
+		FilePath:    "audio." + ext,
+		Reader:      bytes.NewReader(req.Audio.Data),
+		Prompt:      params.Prompt,
+		Temperature: params.Temperature,
+		Language:    params.Language,
+		Format:      openai.AudioResponseFormat(params.ResponseFormat),
+	}
+	output, err := transcriptionClient.CreateTranscription(ctx, input)
+	if err != nil {
+		return fmt.Errorf("failed to create transcription: %w", err)
+	}
+	m := w.Metadata()
+	setToMetadta(m, output.GetRateLimitHeaders())
+	if output.Language != "" {
+		m.SetString("Transcription-Language", output.Language)
+	}
+	if output.Duration > 0 {
+		m.SetFloat64("Transcription-Duration", output.Duration)
+	}
+	w.WriteRole(estellm.RoleAssistant)
+	if err := w.WritePart(estellm.TextPart(output.Text)); err != nil {
+		return fmt.Errorf("write part: %w", err)
+	}
+	w.Finish(estellm.FinishReasonEndTurn, "transcribed")
+	return nil
+}
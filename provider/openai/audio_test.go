@@ -0,0 +1,111 @@
+package openai_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mashiike/estellm"
+	"github.com/mashiike/estellm/provider/openai"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateSpeech(t *testing.T) {
+	var body map[string]any
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "POST /audio/speech", r.Method+" "+r.URL.Path)
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		w.Header().Set("Content-Type", "audio/wav")
+		w.Header().Set("x-ratelimit-remaining-requests", "99")
+		io.WriteString(w, "RIFF....WAVE")
+	}))
+	t.Cleanup(s.Close)
+
+	p := &openai.ModelProvider{}
+	w := estellm.NewBatchResponseWriter()
+	err := p.GenerateSpeech(context.Background(), &estellm.GenerateSpeechRequest{
+		ModelID: "gpt-4o-mini-tts",
+		ModelParams: map[string]any{
+			"endpoint":        s.URL,
+			"api_key":         "test",
+			"response_format": "wav",
+			"instructions":    "Speak cheerfully.",
+		},
+		Text: "Hello",
+	}, w)
+	require.NoError(t, err)
+
+	require.Equal(t, map[string]any{
+		"model":           "gpt-4o-mini-tts",
+		"input":           "Hello",
+		"voice":           "alloy",
+		"response_format": "wav",
+		"instructions":    "Speak cheerfully.",
+	}, body)
+	resp := w.Response()
+	require.Equal(t, []estellm.ContentPart{estellm.BinaryPart("audio/wav", []byte("RIFF....WAVE"))}, resp.Message.Parts)
+	remaining, _ := resp.Metadata.GetInt64("Openai-RateLimit-Remaining-Requests")
+	require.EqualValues(t, 99, remaining)
+
+	err = p.GenerateSpeech(context.Background(), &estellm.GenerateSpeechRequest{
+		ModelID:     "tts-1",
+		ModelParams: map[string]any{"endpoint": s.URL, "api_key": "test", "response_format": "midi"},
+		Text:        "Hello",
+	}, estellm.NewBatchResponseWriter())
+	require.EqualError(t, err, "unsupported response_format `midi`")
+}
+
+func TestTranscribe(t *testing.T) {
+	var fields map[string][]string
+	var fileName string
+	var data []byte
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "POST /audio/transcriptions", r.Method+" "+r.URL.Path)
+		require.NoError(t, r.ParseMultipartForm(1<<20))
+		fields = r.MultipartForm.Value
+		f, h, err := r.FormFile("file")
+		require.NoError(t, err)
+		defer f.Close()
+		fileName = h.Filename
+		data, err = io.ReadAll(f)
+		require.NoError(t, err)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"text":"Hello","language":"english","duration":1.5}`)
+	}))
+	t.Cleanup(s.Close)
+
+	p := &openai.ModelProvider{}
+	w := estellm.NewBatchResponseWriter()
+	err := p.Transcribe(context.Background(), &estellm.TranscribeRequest{
+		ModelID: "whisper-1",
+		ModelParams: map[string]any{
+			"endpoint":        s.URL,
+			"api_key":         "test",
+			"language":        "en",
+			"response_format": "verbose_json",
+		},
+		Audio: estellm.BinaryPart("audio/mpeg; codecs=mp3", []byte("ID3")),
+	}, w)
+	require.NoError(t, err)
+
+	require.Equal(t, "audio.mp3", fileName)
+	require.Equal(t, []byte("ID3"), data)
+	require.Equal(t, []string{"whisper-1"}, fields["model"])
+	require.Equal(t, []string{"en"}, fields["language"])
+	require.Equal(t, []string{"verbose_json"}, fields["response_format"])
+	resp := w.Response()
+	require.Equal(t, []estellm.ContentPart{estellm.TextPart("Hello")}, resp.Message.Parts)
+	require.Equal(t, "english", resp.Metadata.GetString("Transcription-Language"))
+	duration, _ := resp.Metadata.GetFloat64("Transcription-Duration")
+	require.Equal(t, 1.5, duration)
+
+	err = p.Transcribe(context.Background(), &estellm.TranscribeRequest{
+		ModelID:     "whisper-1",
+		ModelParams: map[string]any{"endpoint": s.URL, "api_key": "test"},
+		Audio:       estellm.BinaryPart("audio/midi", []byte("MThd")),
+	}, estellm.NewBatchResponseWriter())
+	require.EqualError(t, err, "unsupported audio type `audio/midi`")
+}
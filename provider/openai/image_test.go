@@ -0,0 +1,125 @@
+package openai_test
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mashiike/estellm"
+	"github.com/mashiike/estellm/provider/openai"
+	"github.com/stretchr/testify/require"
+)
+
+type formFile struct {
+	filename    string
+	contentType string
+	data        string
+}
+
+type imageForm struct {
+	path   string
+	values map[string]string
+	files  map[string]formFile
+}
+
+func newImageServer(t *testing.T) (*httptest.Server, *imageForm) {
+	t.Helper()
+	form := &imageForm{}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseMultipartForm(1<<20))
+		*form = imageForm{
+			path:   r.URL.Path,
+			values: map[string]string{},
+			files:  map[string]formFile{},
+		}
+		for k, v := range r.MultipartForm.Value {
+			form.values[k] = v[0]
+		}
+		for k, v := range r.MultipartForm.File {
+			f, err := v[0].Open()
+			require.NoError(t, err)
+			bs, err := io.ReadAll(f)
+			require.NoError(t, err)
+			form.files[k] = formFile{
+				filename:    v[0].Filename,
+				contentType: v[0].Header.Get("Content-Type"),
+				data:        string(bs),
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"created":1,"data":[{"b64_json":%q}]}`, base64.StdEncoding.EncodeToString([]byte("\x89PNG\r\n\x1a\nresult")))
+	}))
+	t.Cleanup(s.Close)
+	return s, form
+}
+
+func newImageRequest(endpoint string, mode string, parts ...estellm.ContentPart) *estellm.GenerateImageRequest {
+	return &estellm.GenerateImageRequest{
+		ModelID: "dall-e-2",
+		ModelParams: map[string]any{
+			"endpoint":        endpoint,
+			"api_key":         "test",
+			"mode":            mode,
+			"size":            "256x256",
+			"response_format": "b64_json",
+		},
+		Messages: []estellm.Message{
+			{
+				Role:  estellm.RoleUser,
+				Parts: parts,
+			},
+		},
+	}
+}
+
+func TestGenerateImage__Edit(t *testing.T) {
+	s, form := newImageServer(t)
+	p := &openai.ModelProvider{}
+	req := newImageRequest(s.URL, estellm.ImageModeEdit,
+		estellm.TextPart("a red door"),
+		estellm.BinaryPart("image/webp", []byte("image")),
+		estellm.BinaryPartWithName("image/png", estellm.ImageMaskPartName, []byte("mask")),
+	)
+	w := estellm.NewBatchResponseWriter()
+	require.NoError(t, p.GenerateImage(context.Background(), req, w))
+	require.Equal(t, "/images/edits", form.path)
+	require.Equal(t, "a red door\n", form.values["prompt"])
+	require.Equal(t, "1", form.values["n"])
+	require.Equal(t, "256x256", form.values["size"])
+	require.Equal(t, map[string]formFile{
+		"image": {filename: "image.webp", contentType: "image/webp", data: "image"},
+		"mask":  {filename: "mask.png", contentType: "image/png", data: "mask"},
+	}, form.files)
+
+	resp := w.Response()
+	require.Len(t, resp.Message.Parts, 2)
+	require.Equal(t, "<prompt type=\"provided\">a red door\n</prompt>", resp.Message.Parts[0].Text)
+	require.Equal(t, "image/png", resp.Message.Parts[1].MIMEType)
+}
+
+func TestGenerateImage__Variation(t *testing.T) {
+	s, form := newImageServer(t)
+	p := &openai.ModelProvider{}
+	req := newImageRequest(s.URL, estellm.ImageModeVariation,
+		estellm.BinaryPart("image/jpeg", []byte("image")),
+	)
+	w := estellm.NewBatchResponseWriter()
+	require.NoError(t, p.GenerateImage(context.Background(), req, w))
+	require.Equal(t, "/images/variations", form.path)
+	require.NotContains(t, form.values, "prompt")
+	require.Equal(t, map[string]formFile{
+		"image": {filename: "image.jpeg", contentType: "image/jpeg", data: "image"},
+	}, form.files)
+
+	resp := w.Response()
+	require.Len(t, resp.Message.Parts, 1)
+	require.Equal(t, "image/png", resp.Message.Parts[0].MIMEType)
+
+	req = newImageRequest(s.URL, estellm.ImageModeVariation, estellm.TextPart("no image"))
+	err := p.GenerateImage(context.Background(), req, estellm.NewBatchResponseWriter())
+	require.EqualError(t, err, "failed to create image: image variation requires an input image")
+}
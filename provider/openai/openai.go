@@ -1,6 +1,7 @@
 package openai
 
 import (
+	"bytes"
 	"context"
 	"crypto/sha256"
 	"encoding/base64"
@@ -33,12 +34,18 @@ type Client interface {
 	CreateChatCompletionStream(ctx context.Context, request openai.ChatCompletionRequest) (*openai.ChatCompletionStream, error)
 }
 
+// EmbeddingClient is an optional interface for clients that support the embeddings API.
+type EmbeddingClient interface {
+	CreateEmbeddings(ctx context.Context, conv openai.EmbeddingRequestConverter) (openai.EmbeddingResponse, error)
+}
+
 type ModelProvider struct {
-	init    sync.Once
-	client  Client
-	initErr error
-	apiKey  string
-	baseURL string
+	init       sync.Once
+	client     Client
+	httpClient *http.Client
+	initErr    error
+	apiKey     string
+	baseURL    string
 }
 
 func NewWithClient(client Client) *ModelProvider {
@@ -49,6 +56,11 @@ func (p *ModelProvider) SetClilent(client Client) {
 	p.client = client
 }
 
+// SetHTTPClient sets the HTTP client used for the Responses API.
+func (p *ModelProvider) SetHTTPClient(client *http.Client) {
+	p.httpClient = client
+}
+
 func (p *ModelProvider) initClient() error {
 	p.init.Do(func() {
 		if p.client != nil {
@@ -111,6 +123,13 @@ func NormalizeToolName(input string) string {
 }
 
 func (p *ModelProvider) GenerateText(ctx context.Context, req *estellm.GenerateTextRequest, w estellm.ResponseWriter) error {
+	switch api, _ := req.ModelParams["api"].(string); api {
+	case "", APIChatCompletions:
+	case APIResponses:
+		return p.generateTextWithResponses(ctx, req, w)
+	default:
+		return fmt.Errorf("unsupported api `%s`", api)
+	}
 	client, err := p.newClient(req.ModelParams)
 	if err != nil {
 		return fmt.Errorf("failed to create openai client: %w", err)
@@ -121,8 +140,17 @@ func (p *ModelProvider) GenerateText(ctx context.Context, req *estellm.GenerateT
 	}
 	input.Model = req.ModelID
 	input.Stream = true
+	if input.StreamOptions == nil {
+		input.StreamOptions = &openai.StreamOptions{IncludeUsage: true}
+	}
+	if isReasoningModel(req.ModelID, req.ModelParams) {
+		adjustReasoningModelRequest(ctx, &input)
+	}
 	for key := range req.Metadata {
 		if value := req.Metadata.GetString(key); value != "" {
+			if input.Metadata == nil {
+				input.Metadata = make(map[string]string)
+			}
 			input.Metadata[key] = value
 		}
 	}
@@ -133,7 +161,7 @@ func (p *ModelProvider) GenerateText(ctx context.Context, req *estellm.GenerateT
 			MultiContent: []openai.ChatMessagePart{
 				{
 					Type: openai.ChatMessagePartTypeText,
-					Text: req.System,
+					Text: estellm.PlainSystemPrompt(req.System),
 				},
 			},
 		})
@@ -151,7 +179,7 @@ func (p *ModelProvider) GenerateText(ctx context.Context, req *estellm.GenerateT
 		parts := make([]openai.ChatMessagePart, 0, len(msg.Parts))
 		for _, part := range msg.Parts {
 			switch part.Type {
-			case estellm.PartTypeText:
+			case estellm.PartTypeText, estellm.PartTypeGuardContent:
 				parts = append(parts, openai.ChatMessagePart{
 					Type: openai.ChatMessagePartTypeText,
 					Text: part.Text,
@@ -201,19 +229,25 @@ func (p *ModelProvider) GenerateText(ctx context.Context, req *estellm.GenerateT
 		}
 	}
 	input.Stream = true
-	return p.generateTextMultiTrun(ctx, client, input, w, req.Tools)
+	executor, err := estellm.NewToolExecutor(req.Tools, req.ModelParams, NormalizeToolName)
+	if err != nil {
+		return err
+	}
+	return p.generateTextMultiTrun(ctx, client, input, w, executor)
 }
 
-func (p *ModelProvider) generateTextMultiTrun(ctx context.Context, client Client, input openai.ChatCompletionRequest, w estellm.ResponseWriter, toolSet estellm.ToolSet) error {
+func (p *ModelProvider) generateTextMultiTrun(ctx context.Context, client Client, input openai.ChatCompletionRequest, w estellm.ResponseWriter, executor *estellm.ToolExecutor) error {
+	var inputTokens, outputTokens, totalTokens, reasoningTokens int64
 	for {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
 		default:
 		}
+		executor.NextTurn(w.Metadata())
 		output, err := client.CreateChatCompletionStream(ctx, input)
 		if err != nil {
-			return fmt.Errorf("failed to create completion: %w", err)
+			return fmt.Errorf("failed to create completion: %w", withStatusCode(err))
 		}
 		m := w.Metadata()
 		setToMetadta(m, output.GetRateLimitHeaders())
@@ -224,75 +258,94 @@ func (p *ModelProvider) generateTextMultiTrun(ctx context.Context, client Client
 		}
 		var textBuilder strings.Builder
 		var role string
-		toolUses := make([]openai.ToolCall, 0, len(toolSet))
+		var finishReason openai.FinishReason
+		toolUses := make([]openai.ToolCall, 0)
 		var currentToolCall openai.ToolCall
-		streamReader := func(output *openai.ChatCompletionStream) (bool, error) {
+		streamReader := func(output *openai.ChatCompletionStream) error {
 			defer output.Close()
 			for {
 				select {
 				case <-ctx.Done():
-					return false, ctx.Err()
+					return ctx.Err()
 				default:
 				}
 				response, err := output.Recv()
 				if errors.Is(err, io.EOF) {
-					slog.Info("stream closed", "response", response)
-					return false, nil
+					slog.DebugContext(ctx, "stream closed", "finish_reason", finishReason)
+					return nil
 				}
 				if err != nil {
-					return false, fmt.Errorf("failed to receive completion: %w", err)
+					return fmt.Errorf("failed to receive completion: %w", withStatusCode(err))
 				}
-				for _, choice := range response.Choices {
-					if choice.FinishReason != "" {
-						if choice.FinishReason != openai.FinishReasonFunctionCall && choice.FinishReason != openai.FinishReasonToolCalls {
-							switch choice.FinishReason {
-							case openai.FinishReasonContentFilter:
-								w.Finish(estellm.FinishReasonContentFiltered, "content filter")
-							case openai.FinishReasonStop:
-								w.Finish(estellm.FinishReasonEndTurn, "stop")
-							case openai.FinishReasonLength:
-								w.Finish(estellm.FinishReasonMaxTokens, "length")
-							default:
-								w.Finish(estellm.FinishReasonEndTurn, string(choice.FinishReason))
-							}
-							return false, nil
-						}
-						toolUses = append(toolUses, currentToolCall)
-						return true, nil
+				if response.Usage != nil {
+					inputTokens += int64(response.Usage.PromptTokens)
+					outputTokens += int64(response.Usage.CompletionTokens)
+					totalTokens += int64(response.Usage.TotalTokens)
+					metadata.SetInputTokens(m, inputTokens)
+					metadata.SetOutputTokens(m, outputTokens)
+					metadata.SetTotalTokens(m, totalTokens)
+					if details := response.Usage.CompletionTokensDetails; details != nil && details.ReasoningTokens > 0 {
+						reasoningTokens += int64(details.ReasoningTokens)
+						metadata.SetReasoningTokens(m, reasoningTokens)
 					}
+				}
+				for _, choice := range response.Choices {
 					if choice.Delta.Role != "" {
 						role = choice.Delta.Role
 					}
+					if choice.Delta.ReasoningContent != "" {
+						if err := w.WritePart(estellm.ReasoningPart(choice.Delta.ReasoningContent)); err != nil {
+							return err
+						}
+					}
 					if choice.Delta.Content != "" {
-						w.WritePart(estellm.TextPart(choice.Delta.Content))
+						if err := w.WritePart(estellm.TextPart(choice.Delta.Content)); err != nil {
+							return err
+						}
 						textBuilder.WriteString(choice.Delta.Content)
-						continue
 					}
-					if len(choice.Delta.ToolCalls) > 0 {
-						for _, toolCall := range choice.Delta.ToolCalls {
-							if toolCall.ID != "" {
-								if currentToolCall.ID != "" {
-									toolUses = append(toolUses, currentToolCall)
-								}
-								currentToolCall = toolCall
-								continue
-							}
-							if len(toolCall.Function.Arguments) > 0 {
-								currentToolCall.Function.Arguments += toolCall.Function.Arguments
-								continue
+					for _, toolCall := range choice.Delta.ToolCalls {
+						if toolCall.ID != "" {
+							if currentToolCall.ID != "" {
+								toolUses = append(toolUses, currentToolCall)
 							}
+							currentToolCall = toolCall
+							continue
 						}
-						continue
+						currentToolCall.Function.Arguments += toolCall.Function.Arguments
+					}
+					if choice.FinishReason != "" {
+						finishReason = choice.FinishReason
 					}
-					slog.DebugContext(ctx, "untrap choice", "choice", choice)
 				}
 			}
 		}
-		isToolUse, err := streamReader(output)
-		if err != nil {
+		if err := streamReader(output); err != nil {
 			return fmt.Errorf("stream reader: %w", err)
 		}
-		if !isToolUse {
+		switch finishReason {
+		case "":
+			return nil
+		case openai.FinishReasonFunctionCall, openai.FinishReasonToolCalls:
+			if currentToolCall.ID != "" {
+				toolUses = append(toolUses, currentToolCall)
+			}
+			if executor.Exhausted() {
+				// the model calls the tools even in the final turn.
+				w.Finish(estellm.FinishReasonEndTurn, "max_tool_iterations")
+				return nil
+			}
+		case openai.FinishReasonContentFilter:
+			w.Finish(estellm.FinishReasonContentFiltered, "content filter")
+			return nil
+		case openai.FinishReasonStop:
+			w.Finish(estellm.FinishReasonEndTurn, "stop")
+			return nil
+		case openai.FinishReasonLength:
+			w.Finish(estellm.FinishReasonMaxTokens, "length")
+			return nil
+		default:
+			w.Finish(estellm.FinishReasonEndTurn, string(finishReason))
 			return nil
 		}
 		slog.DebugContext(ctx, "tool uses", "tool_uses", toolUses, "role", role, "text", textBuilder.String())
@@ -304,31 +357,72 @@ func (p *ModelProvider) generateTextMultiTrun(ctx context.Context, client Client
 		}
 		msg.ToolCalls = append(msg.ToolCalls, toolUses...)
 		input.Messages = append(input.Messages, msg)
-		var wg sync.WaitGroup
-		var mu sync.Mutex
-		errs := make([]error, 0, len(toolUses))
-		wg.Add(len(toolUses))
-		for _, _toolUse := range toolUses {
-			go func(toolUse openai.ToolCall) {
-				defer wg.Done()
-				result, err := toolCall(ctx, toolSet, toolUse.ID, toolUse.Function.Name, toolUse.Function.Arguments)
-				if err != nil {
-					mu.Lock()
-					slog.WarnContext(ctx, "tool call error", "error", err)
-					errs = append(errs, fmt.Errorf("tool call[%s]: %w", toolUse.ID, err))
-					mu.Unlock()
-					return
-				}
-				mu.Lock()
-				input.Messages = append(input.Messages, result)
-				mu.Unlock()
-			}(_toolUse)
+		calls := make([]estellm.ToolUse, 0, len(toolUses))
+		for _, toolUse := range toolUses {
+			calls = append(calls, estellm.ToolUse{
+				ID:    toolUse.ID,
+				Name:  toolUse.Function.Name,
+				Input: json.RawMessage(toolUse.Function.Arguments),
+			})
+		}
+		results, err := executor.Execute(ctx, calls)
+		if err != nil {
+			return err
+		}
+		for _, result := range results {
+			input.Messages = append(input.Messages, newToolResult(result))
+		}
+		if executor.Exhausted() {
+			input.ToolChoice = "none"
+			input.Messages = append(input.Messages, openai.ChatCompletionMessage{
+				Role:    openai.ChatMessageRoleUser,
+				Content: estellm.FinalTurnInstruction,
+			})
+		}
+	}
+}
+
+var reasoningModelPrefixes = []string{"o1", "o3", "o4", "gpt-5"}
+
+// isReasoningModel reports whether the model is an o-series style reasoning model.
+// `reasoning_model` in model_params overrides the detection, e.g. for Azure deployment names.
+func isReasoningModel(modelID string, modelParams map[string]any) bool {
+	if v, ok := modelParams["reasoning_model"].(bool); ok {
+		return v
+	}
+	for _, prefix := range reasoningModelPrefixes {
+		if strings.HasPrefix(modelID, prefix) {
+			return true
 		}
-		wg.Wait()
-		if len(errs) > 0 {
-			return fmt.Errorf("tool call errors: %v", errs)
+	}
+	return false
+}
+
+// adjustReasoningModelRequest rewrites the parameters that reasoning models reject.
+func adjustReasoningModelRequest(ctx context.Context, input *openai.ChatCompletionRequest) {
+	if input.MaxTokens > 0 {
+		if input.MaxCompletionTokens == 0 {
+			input.MaxCompletionTokens = input.MaxTokens
 		}
+		input.MaxTokens = 0
+	}
+	if input.Temperature != 0 || input.TopP != 0 || input.N > 1 || input.PresencePenalty != 0 || input.FrequencyPenalty != 0 || input.LogProbs {
+		slog.WarnContext(ctx, "sampling parameters are not supported by reasoning models, ignored", "model", input.Model)
+	}
+	input.Temperature = 0
+	input.TopP = 0
+	input.N = 0
+	input.PresencePenalty = 0
+	input.FrequencyPenalty = 0
+	input.LogProbs = false
+	input.TopLogProbs = 0
+}
+
+func newToolResult(result estellm.ToolResult) openai.ChatCompletionMessage {
+	if result.Err != nil {
+		return newToolResultWithError(result.ToolUse.ID, result.Err)
 	}
+	return newToolResultWithResponse(result.ToolUse.ID, result.Response)
 }
 
 func newToolResultWithError(toolUseID string, err error) openai.ChatCompletionMessage {
@@ -379,23 +473,10 @@ func newToolResultWithResponse(toolUseID string, response *estellm.Response) ope
 	}
 }
 
-func toolCall(ctx context.Context, tools estellm.ToolSet, toolUseID string, toolName string, input string) (openai.ChatCompletionMessage, error) {
-	var v any
-	if err := json.Unmarshal([]byte(input), &v); err != nil {
-		return newToolResultWithError(toolUseID, fmt.Errorf("unmarshal input: %w", err)), nil
-	}
-	for _, tool := range tools {
-		if NormalizeToolName(tool.Name()) == toolName {
-			w := estellm.NewBatchResponseWriter()
-			ctx = estellm.WithToolName(ctx, toolName)
-			ctx = estellm.WithToolUseID(ctx, toolUseID)
-			if err := tool.Call(ctx, v, w); err != nil {
-				return newToolResultWithError(toolUseID, err), nil
-			}
-			return newToolResultWithResponse(toolUseID, w.Response()), nil
-		}
-	}
-	return openai.ChatCompletionMessage{}, fmt.Errorf("tool not found: %s", toolName)
+// ImageEditClient is an optional interface for clients that support the image edit and variation APIs.
+type ImageEditClient interface {
+	CreateEditImage(ctx context.Context, request openai.ImageEditRequest) (openai.ImageResponse, error)
+	CreateVariImage(ctx context.Context, request openai.ImageVariRequest) (openai.ImageResponse, error)
 }
 
 func (p *ModelProvider) GenerateImage(ctx context.Context, req *estellm.GenerateImageRequest, w estellm.ResponseWriter) error {
@@ -422,10 +503,18 @@ func (p *ModelProvider) GenerateImage(ctx context.Context, req *estellm.Generate
 	}
 	imageReq.Prompt = sb.String()
 
-	w.WritePart(estellm.TextPart(fmt.Sprintf("<prompt type=\"provided\">%s</prompt>", imageReq.Prompt)))
-	output, err := client.CreateImage(ctx, imageReq)
+	var output openai.ImageResponse
+	switch mode := req.ImageMode(); mode {
+	case estellm.ImageModeGenerate:
+		w.WritePart(estellm.TextPart(fmt.Sprintf("<prompt type=\"provided\">%s</prompt>", imageReq.Prompt)))
+		output, err = client.CreateImage(ctx, imageReq)
+	case estellm.ImageModeEdit, estellm.ImageModeVariation:
+		output, err = p.editImage(ctx, client, mode, req, imageReq, w)
+	default:
+		return fmt.Errorf("unsupported image mode `%s`", mode)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to create image: %w", err)
+		return fmt.Errorf("failed to create image: %w", withStatusCode(err))
 	}
 	if len(output.Data) == 0 {
 		w.Finish(estellm.FinishReasonEndTurn, "no data")
@@ -505,6 +594,110 @@ func (p *ModelProvider) GenerateImage(ctx context.Context, req *estellm.Generate
 	return nil
 }
 
+func (p *ModelProvider) GenerateEmbedding(ctx context.Context, req *estellm.GenerateEmbeddingRequest) (*estellm.GenerateEmbeddingResponse, error) {
+	client, err := p.newClient(req.ModelParams)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create openai client: %w", err)
+	}
+	embeddingClient, ok := client.(EmbeddingClient)
+	if !ok {
+		return nil, fmt.Errorf("client does not support embeddings: %w", estellm.ErrNotSupported)
+	}
+	var input openai.EmbeddingRequest
+	if err := jsonutil.Remarshal(req.ModelParams, &input); err != nil {
+		return nil, fmt.Errorf("remarshal embedding request: %w", err)
+	}
+	input.Model = openai.EmbeddingModel(req.ModelID)
+	input.Input = req.Inputs
+	output, err := embeddingClient.CreateEmbeddings(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create embeddings: %w", err)
+	}
+	if len(output.Data) != len(req.Inputs) {
+		return nil, fmt.Errorf("embedding count mismatch: expected %d, got %d", len(req.Inputs), len(output.Data))
+	}
+	resp := &estellm.GenerateEmbeddingResponse{
+		Metadata:   make(metadata.Metadata),
+		Embeddings: make([][]float32, len(output.Data)),
+	}
+	for _, data := range output.Data {
+		if data.Index < 0 || data.Index >= len(resp.Embeddings) {
+			return nil, fmt.Errorf("embedding index out of range: %d", data.Index)
+		}
+		resp.Embeddings[data.Index] = data.Embedding
+	}
+	metadata.SetInputTokens(resp.Metadata, int64(output.Usage.PromptTokens))
+	metadata.SetTotalTokens(resp.Metadata, int64(output.Usage.TotalTokens))
+	return resp, nil
+}
+
+func (p *ModelProvider) editImage(ctx context.Context, client Client, mode string, req *estellm.GenerateImageRequest, imageReq openai.ImageRequest, w estellm.ResponseWriter) (openai.ImageResponse, error) {
+	editClient, ok := client.(ImageEditClient)
+	if !ok {
+		return openai.ImageResponse{}, fmt.Errorf("client does not support image %s: %w", mode, estellm.ErrNotSupported)
+	}
+	images, mask := req.InputImages()
+	if len(images) == 0 {
+		return openai.ImageResponse{}, fmt.Errorf("image %s requires an input image", mode)
+	}
+	image := imageReader("image", images[0])
+	if mode == estellm.ImageModeVariation {
+		return editClient.CreateVariImage(ctx, openai.ImageVariRequest{
+			Image:          image,
+			Model:          imageReq.Model,
+			N:              imageReq.N,
+			Size:           imageReq.Size,
+			ResponseFormat: imageReq.ResponseFormat,
+		})
+	}
+	w.WritePart(estellm.TextPart(fmt.Sprintf("<prompt type=\"provided\">%s</prompt>", imageReq.Prompt)))
+	editReq := openai.ImageEditRequest{
+		Image:          image,
+		Prompt:         imageReq.Prompt,
+		Model:          imageReq.Model,
+		N:              imageReq.N,
+		Size:           imageReq.Size,
+		ResponseFormat: imageReq.ResponseFormat,
+	}
+	if mask != nil {
+		editReq.Mask = imageReader("mask", *mask)
+	}
+	return editClient.CreateEditImage(ctx, editReq)
+}
+
+// imageReader names the image part after its MIME type, because the image edit APIs detect the image format from the file name.
+func imageReader(name string, part estellm.ContentPart) io.Reader {
+	mimeType, _, _ := strings.Cut(part.MIMEType, ";")
+	_, ext, _ := strings.Cut(mimeType, "/")
+	return openai.WrapReader(bytes.NewReader(part.Data), name+"."+ext, mimeType)
+}
+
+// statusCodeError exposes the HTTP status code of go-openai errors, see estellm.IsRetryableError.
+type statusCodeError struct {
+	error
+	statusCode int
+}
+
+func (e *statusCodeError) Unwrap() error {
+	return e.error
+}
+
+func (e *statusCodeError) HTTPStatusCode() int {
+	return e.statusCode
+}
+
+func withStatusCode(err error) error {
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) && apiErr.HTTPStatusCode != 0 {
+		return &statusCodeError{error: err, statusCode: apiErr.HTTPStatusCode}
+	}
+	var reqErr *openai.RequestError
+	if errors.As(err, &reqErr) && reqErr.HTTPStatusCode != 0 {
+		return &statusCodeError{error: err, statusCode: reqErr.HTTPStatusCode}
+	}
+	return err
+}
+
 func setToMetadta(m metadata.Metadata, h openai.RateLimitHeaders) {
 	m.SetInt64("Openai-RateLimit-Remaining-Tokens", int64(h.RemainingTokens))
 	m.SetInt64("Openai-RateLimit-Remaining-Requests", int64(h.RemainingRequests))
@@ -0,0 +1,203 @@
+package openai_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mashiike/estellm"
+	"github.com/mashiike/estellm/metadata"
+	"github.com/mashiike/estellm/provider/openai"
+	"github.com/stretchr/testify/require"
+)
+
+type stubServer struct {
+	*httptest.Server
+	mu        sync.Mutex
+	paths     []string
+	requests  []map[string]any
+	responses [][]string
+}
+
+func newStubServer(t *testing.T, responses ...[]string) *stubServer {
+	t.Helper()
+	s := &stubServer{responses: responses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.paths = append(s.paths, r.Method+" "+r.URL.Path)
+		bs, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		var body map[string]any
+		require.NoError(t, json.Unmarshal(bs, &body))
+		s.requests = append(s.requests, body)
+		if len(s.responses) == 0 {
+			t.Fatal("unexpected request")
+		}
+		events := s.responses[0]
+		s.responses = s.responses[1:]
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		for _, e := range events {
+			var buf bytes.Buffer
+			require.NoError(t, json.Compact(&buf, []byte(e)))
+			fmt.Fprintf(w, "data: %s\n\n", buf.String())
+		}
+		io.WriteString(w, "data: [DONE]\n\n")
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func newRequest(endpoint string, modelID string, params map[string]any) *estellm.GenerateTextRequest {
+	params["endpoint"] = endpoint
+	params["api_key"] = "test"
+	return &estellm.GenerateTextRequest{
+		ModelID:     modelID,
+		ModelParams: params,
+		System:      "You are a helpful assistant.",
+		Messages: []estellm.Message{
+			{
+				Role:  estellm.RoleUser,
+				Parts: []estellm.ContentPart{estellm.TextPart("Hi")},
+			},
+		},
+	}
+}
+
+func TestGenerateText__ReasoningModel(t *testing.T) {
+	s := newStubServer(t, []string{
+		`{"id":"1","object":"chat.completion.chunk","model":"o3-mini","choices":[{"index":0,"delta":{"role":"assistant","content":"Hello"}}]}`,
+		`{"id":"1","object":"chat.completion.chunk","model":"o3-mini","choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`,
+		`{"id":"1","object":"chat.completion.chunk","model":"o3-mini","choices":[],"usage":{"prompt_tokens":10,"completion_tokens":70,"total_tokens":80,"completion_tokens_details":{"reasoning_tokens":64}}}`,
+	})
+	p := &openai.ModelProvider{}
+	req := newRequest(s.URL, "o3-mini", map[string]any{
+		"max_tokens":       1024,
+		"temperature":      0.2,
+		"reasoning_effort": "high",
+	})
+	w := estellm.NewBatchResponseWriter()
+	require.NoError(t, p.GenerateText(context.Background(), req, w))
+
+	require.Equal(t, []string{"POST /chat/completions"}, s.paths)
+	body := s.requests[0]
+	require.EqualValues(t, 1024, body["max_completion_tokens"])
+	require.Equal(t, "high", body["reasoning_effort"])
+	require.NotContains(t, body, "max_tokens")
+	require.NotContains(t, body, "temperature")
+	require.Equal(t, map[string]any{"include_usage": true}, body["stream_options"])
+
+	resp := w.Response()
+	require.Equal(t, estellm.FinishReasonEndTurn, resp.FinishReason)
+	require.Equal(t, []estellm.ContentPart{estellm.TextPart("Hello")}, resp.Message.Parts)
+	outputTokens, _ := metadata.GetOutputTokens(resp.Metadata)
+	reasoningTokens, _ := metadata.GetReasoningTokens(resp.Metadata)
+	require.EqualValues(t, 70, outputTokens)
+	require.EqualValues(t, 64, reasoningTokens)
+}
+
+func TestGenerateText__ReasoningContent(t *testing.T) {
+	s := newStubServer(t, []string{
+		`{"id":"1","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"role":"assistant","reasoning_content":"Let me "}}]}`,
+		`{"id":"1","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"reasoning_content":"think."}}]}`,
+		`{"id":"1","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"content":"Hello"}}]}`,
+		`{"id":"1","object":"chat.completion.chunk","choices":[{"index":0,"delta":{},"finish_reason":"length"}]}`,
+	})
+	p := &openai.ModelProvider{}
+	req := newRequest(s.URL, "deepseek-reasoner", map[string]any{
+		"max_tokens":  256,
+		"temperature": 0.5,
+	})
+	req.Messages = append(req.Messages,
+		estellm.Message{
+			Role: estellm.RoleAssistant,
+			Parts: []estellm.ContentPart{
+				estellm.ReasoningPart("previous thought"),
+				estellm.TextPart("Hi!"),
+			},
+		},
+		estellm.Message{
+			Role:  estellm.RoleUser,
+			Parts: []estellm.ContentPart{estellm.TextPart("How are you?")},
+		},
+	)
+	w := estellm.NewBatchResponseWriter()
+	require.NoError(t, p.GenerateText(context.Background(), req, w))
+
+	body := s.requests[0]
+	require.EqualValues(t, 256, body["max_tokens"])
+	require.EqualValues(t, 0.5, body["temperature"])
+	bs, err := json.Marshal(body["messages"])
+	require.NoError(t, err)
+	require.False(t, strings.Contains(string(bs), "previous thought"))
+
+	resp := w.Response()
+	require.Equal(t, estellm.FinishReasonMaxTokens, resp.FinishReason)
+	require.Equal(t, []estellm.ContentPart{
+		estellm.ReasoningPart("Let me think."),
+		estellm.TextPart("Hello"),
+	}, resp.Message.Parts)
+}
+
+type echoTool struct{}
+
+func (echoTool) Name() string                { return "echo" }
+func (echoTool) Description() string         { return "echo the input" }
+func (echoTool) InputSchema() map[string]any { return map[string]any{"type": "object"} }
+func (echoTool) Call(_ context.Context, input any, w estellm.ResponseWriter) error {
+	return w.WritePart(estellm.TextPart(input.(map[string]any)["text"].(string)))
+}
+
+func TestGenerateText__ToolCalls(t *testing.T) {
+	s := newStubServer(t,
+		[]string{
+			`{"id":"1","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"role":"assistant","tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"echo","arguments":""}}]}}]}`,
+			`{"id":"1","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"text\":\"first\"}"}}]}}]}`,
+			`{"id":"1","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"tool_calls":[{"index":1,"id":"call_2","type":"function","function":{"name":"missing","arguments":"{}"}}]}}]}`,
+			`{"id":"1","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"tool_calls":[{"index":2,"id":"call_3","type":"function","function":{"name":"echo","arguments":"{\"text\":\"third\"}"}}]}}]}`,
+			`{"id":"1","object":"chat.completion.chunk","choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}`,
+		},
+		[]string{
+			`{"id":"2","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"role":"assistant","content":"Done"}}]}`,
+			`{"id":"2","object":"chat.completion.chunk","choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`,
+		},
+	)
+	p := &openai.ModelProvider{}
+	req := newRequest(s.URL, "gpt-4o", map[string]any{"tool_parallelism": 2, "max_tool_iterations": 1})
+	req.Tools = estellm.ToolSet{echoTool{}}
+	w := estellm.NewBatchResponseWriter()
+	require.NoError(t, p.GenerateText(context.Background(), req, w))
+
+	require.Len(t, s.requests, 2)
+	require.NotContains(t, s.requests[0], "tool_parallelism")
+	require.NotContains(t, s.requests[0], "tool_choice")
+	require.Equal(t, "none", s.requests[1]["tool_choice"])
+	messages := s.requests[1]["messages"].([]any)
+	require.Len(t, messages, 7)
+	require.Equal(t, map[string]any{"role": "user", "content": estellm.FinalTurnInstruction}, messages[6])
+	var results []string
+	for _, m := range messages[3:6] {
+		msg := m.(map[string]any)
+		require.Equal(t, "tool", msg["role"])
+		results = append(results, fmt.Sprintf("%s=%v", msg["tool_call_id"], msg["content"]))
+	}
+	require.Equal(t, []string{
+		"call_1=[map[text:first type:text]]",
+		"call_2=failed to call tool[call_2]: tool not found: missing",
+		"call_3=[map[text:third type:text]]",
+	}, results)
+	resp := w.Response()
+	require.Equal(t, estellm.FinishReasonEndTurn, resp.FinishReason)
+	turns, _ := metadata.GetModelTurns(resp.Metadata)
+	calls, _ := metadata.GetToolCalls(resp.Metadata)
+	require.EqualValues(t, 2, turns)
+	require.EqualValues(t, 3, calls)
+}
@@ -0,0 +1,594 @@
+package openai
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/mashiike/estellm"
+	"github.com/mashiike/estellm/jsonutil"
+	"github.com/mashiike/estellm/metadata"
+	"github.com/sashabaranov/go-openai"
+)
+
+const (
+	APIChatCompletions = "chat"
+	APIResponses       = "responses"
+)
+
+const DefaultEndpoint = "https://api.openai.com/v1"
+
+// https://platform.openai.com/docs/api-reference/responses/create
+type ResponsesRequest struct {
+	Model              string            `json:"model"`
+	Instructions       string            `json:"instructions,omitempty"`
+	Input              []ResponseItem    `json:"input"`
+	Tools              []ResponseTool    `json:"tools,omitempty"`
+	ToolChoice         any               `json:"tool_choice,omitempty"`
+	ParallelToolCalls  *bool             `json:"parallel_tool_calls,omitempty"`
+	PreviousResponseID string            `json:"previous_response_id,omitempty"`
+	MaxOutputTokens    int               `json:"max_output_tokens,omitempty"`
+	Temperature        *float64          `json:"temperature,omitempty"`
+	TopP               *float64          `json:"top_p,omitempty"`
+	Reasoning          *Reasoning        `json:"reasoning,omitempty"`
+	Text               map[string]any    `json:"text,omitempty"`
+	Truncation         string            `json:"truncation,omitempty"`
+	Store              *bool             `json:"store,omitempty"`
+	Include            []string          `json:"include,omitempty"`
+	Metadata           map[string]string `json:"metadata,omitempty"`
+	User               string            `json:"user,omitempty"`
+	Stream             bool              `json:"stream"`
+}
+
+type Reasoning struct {
+	Effort  string `json:"effort,omitempty"`
+	Summary string `json:"summary,omitempty"`
+}
+
+type ResponseItem struct {
+	Type      string                `json:"type,omitempty"`
+	ID        string                `json:"id,omitempty"`
+	Role      string                `json:"role,omitempty"`
+	Content   []ResponseContentPart `json:"content,omitempty"`
+	CallID    string                `json:"call_id,omitempty"`
+	Name      string                `json:"name,omitempty"`
+	Arguments string                `json:"arguments,omitempty"`
+	Output    any                   `json:"output,omitempty"`
+	Status    string                `json:"status,omitempty"`
+	// Summary and EncryptedContent are the fields of reasoning items.
+	Summary          json.RawMessage `json:"summary,omitempty"`
+	EncryptedContent string          `json:"encrypted_content,omitempty"`
+}
+
+type ResponseContentPart struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	ImageURL string `json:"image_url,omitempty"`
+	Filename string `json:"filename,omitempty"`
+	FileData string `json:"file_data,omitempty"`
+	Refusal  string `json:"refusal,omitempty"`
+}
+
+type ResponseTool struct {
+	Type        string         `json:"type"`
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Parameters  map[string]any `json:"parameters"`
+	Strict      bool           `json:"strict"`
+}
+
+type Response struct {
+	ID                string         `json:"id"`
+	Status            string         `json:"status"`
+	Model             string         `json:"model"`
+	Output            []ResponseItem `json:"output"`
+	Usage             *ResponseUsage `json:"usage,omitempty"`
+	IncompleteDetails *struct {
+		Reason string `json:"reason"`
+	} `json:"incomplete_details,omitempty"`
+	Error *ResponseError `json:"error,omitempty"`
+}
+
+type ResponseUsage struct {
+	InputTokens         int64 `json:"input_tokens"`
+	OutputTokens        int64 `json:"output_tokens"`
+	TotalTokens         int64 `json:"total_tokens"`
+	OutputTokensDetails struct {
+		ReasoningTokens int64 `json:"reasoning_tokens"`
+	} `json:"output_tokens_details"`
+}
+
+type ResponseError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type ResponseStreamEvent struct {
+	Type     string         `json:"type"`
+	Response *Response      `json:"response,omitempty"`
+	Item     *ResponseItem  `json:"item,omitempty"`
+	ItemID   string         `json:"item_id,omitempty"`
+	Delta    string         `json:"delta,omitempty"`
+	Code     string         `json:"code,omitempty"`
+	Message  string         `json:"message,omitempty"`
+	Error    *ResponseError `json:"error,omitempty"`
+}
+
+// responsesModelParams are the model_params keys that are converted before remarshaling into ResponsesRequest.
+type responsesModelParams struct {
+	MaxTokens       int    `json:"max_tokens,omitempty"`
+	ReasoningEffort string `json:"reasoning_effort,omitempty"`
+}
+
+type responsesClient struct {
+	httpClient *http.Client
+	apiKey     string
+	endpoint   string
+}
+
+func (p *ModelProvider) newResponsesClient(modelParams map[string]any) (*responsesClient, error) {
+	c := &responsesClient{
+		httpClient: p.httpClient,
+		apiKey:     os.Getenv("OPENAI_API_KEY"),
+		endpoint:   os.Getenv("OPENAI_BASE_URL"),
+	}
+	if c.httpClient == nil {
+		c.httpClient = http.DefaultClient
+	}
+	if c.endpoint == "" {
+		c.endpoint = DefaultEndpoint
+	}
+	if endpoint, ok := modelParams["endpoint"].(string); ok && endpoint != "" {
+		c.endpoint = endpoint
+	}
+	if apiKey, ok := modelParams["api_key"].(string); ok && apiKey != "" {
+		c.apiKey = apiKey
+	}
+	if c.apiKey == "" {
+		return nil, errors.New("missing OPENAI_API_KEY")
+	}
+	return c, nil
+}
+
+func (c *responsesClient) createResponseStream(ctx context.Context, input *ResponsesRequest) (*http.Response, error) {
+	bs, err := json.Marshal(input)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(c.endpoint, "/")+"/responses", bytes.NewReader(bs))
+	if err != nil {
+		return nil, fmt.Errorf("new request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("do request: %w", err)
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		slog.DebugContext(ctx, "responses request", "model", input.Model, "request", string(bs))
+		return nil, newResponsesAPIError(resp)
+	}
+	return resp, nil
+}
+
+// newResponsesAPIError returns the same error type as the Chat Completions mode.
+func newResponsesAPIError(resp *http.Response) error {
+	apiErr := &openai.APIError{
+		HTTPStatusCode: resp.StatusCode,
+		HTTPStatus:     resp.Status,
+		Message:        resp.Status,
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return apiErr
+	}
+	var errResp openai.ErrorResponse
+	if err := json.Unmarshal(body, &errResp); err != nil || errResp.Error == nil {
+		if len(body) > 0 {
+			apiErr.Message = string(body)
+		}
+		return apiErr
+	}
+	errResp.Error.HTTPStatusCode = resp.StatusCode
+	errResp.Error.HTTPStatus = resp.Status
+	return errResp.Error
+}
+
+func (p *ModelProvider) generateTextWithResponses(ctx context.Context, req *estellm.GenerateTextRequest, w estellm.ResponseWriter) error {
+	c, err := p.newResponsesClient(req.ModelParams)
+	if err != nil {
+		return fmt.Errorf("failed to create openai client: %w", err)
+	}
+	input := &ResponsesRequest{}
+	if err := jsonutil.Remarshal(req.ModelParams, input); err != nil {
+		return fmt.Errorf("remarshal responses request: %w", err)
+	}
+	var params responsesModelParams
+	if err := jsonutil.Remarshal(req.ModelParams, &params); err != nil {
+		return fmt.Errorf("remarshal model params: %w", err)
+	}
+	if input.MaxOutputTokens == 0 {
+		input.MaxOutputTokens = params.MaxTokens
+	}
+	if params.ReasoningEffort != "" {
+		if input.Reasoning == nil {
+			input.Reasoning = &Reasoning{}
+		}
+		input.Reasoning.Effort = params.ReasoningEffort
+	}
+	input.Model = req.ModelID
+	if isReasoningModel(req.ModelID, req.ModelParams) {
+		adjustReasoningModelResponsesRequest(ctx, input)
+	}
+	input.Instructions = estellm.PlainSystemPrompt(req.System)
+	input.Stream = true
+	for key := range req.Metadata {
+		if value := req.Metadata.GetString(key); value != "" {
+			if input.Metadata == nil {
+				input.Metadata = make(map[string]string)
+			}
+			input.Metadata[key] = value
+		}
+	}
+	input.Input = make([]ResponseItem, 0, len(req.Messages))
+	documentCount := 0
+	for _, msg := range req.Messages {
+		item := ResponseItem{
+			Type: "message",
+		}
+		textType := "input_text"
+		switch msg.Role {
+		case estellm.RoleUser:
+			item.Role = "user"
+		case estellm.RoleAssistant:
+			item.Role = "assistant"
+			textType = "output_text"
+		default:
+			return estellm.ErrInvalidMessageRole
+		}
+		for _, part := range msg.Parts {
+			switch part.Type {
+			case estellm.PartTypeText, estellm.PartTypeGuardContent:
+				item.Content = append(item.Content, ResponseContentPart{
+					Type: textType,
+					Text: part.Text,
+				})
+			case estellm.PartTypeBinary:
+				if msg.Role != estellm.RoleUser {
+					return fmt.Errorf("binary content is only supported in user messages")
+				}
+				cp, err := responseBinaryContentPart(part, &documentCount)
+				if err != nil {
+					return err
+				}
+				item.Content = append(item.Content, cp)
+			case estellm.PartTypeReasoning:
+				// reasoning summaries can not be replayed as input.
+				continue
+			case estellm.PartTypeCachePoint:
+				// prompt caching is automatic.
+				continue
+			default:
+				return fmt.Errorf("unsupported content type: %s", part.Type)
+			}
+		}
+		if len(item.Content) == 0 {
+			continue
+		}
+		input.Input = append(input.Input, item)
+	}
+	if len(req.Tools) > 0 {
+		input.Tools = make([]ResponseTool, 0, len(req.Tools))
+		for _, tool := range req.Tools {
+			slog.Debug("tool spec", "name", tool.Name(), "description", tool.Description(), "input_schema", tool.InputSchema())
+			input.Tools = append(input.Tools, ResponseTool{
+				Type:        "function",
+				Name:        NormalizeToolName(tool.Name()),
+				Description: tool.Description(),
+				Parameters:  tool.InputSchema(),
+			})
+		}
+	}
+	executor, err := estellm.NewToolExecutor(req.Tools, req.ModelParams, NormalizeToolName)
+	if err != nil {
+		return err
+	}
+	return p.generateTextWithResponsesMultiTurn(ctx, c, input, w, executor)
+}
+
+// adjustReasoningModelResponsesRequest rewrites the parameters that reasoning models reject, as adjustReasoningModelRequest.
+// Without stored responses, the encrypted reasoning content is included, so that the reasoning items can be sent again.
+func adjustReasoningModelResponsesRequest(ctx context.Context, input *ResponsesRequest) {
+	if input.Temperature != nil || input.TopP != nil {
+		slog.WarnContext(ctx, "sampling parameters are not supported by reasoning models, ignored", "model", input.Model)
+	}
+	input.Temperature = nil
+	input.TopP = nil
+	if input.Store != nil && !*input.Store && !slices.Contains(input.Include, reasoningEncryptedContent) {
+		input.Include = append(input.Include, reasoningEncryptedContent)
+	}
+}
+
+const reasoningEncryptedContent = "reasoning.encrypted_content"
+
+func responseBinaryContentPart(part estellm.ContentPart, documentCount *int) (ResponseContentPart, error) {
+	mediaType, _, err := mime.ParseMediaType(part.MIMEType)
+	if err != nil {
+		return ResponseContentPart{}, fmt.Errorf("parse media type: %w", err)
+	}
+	dataURL := fmt.Sprintf("data:%s;base64,%s", mediaType, base64.StdEncoding.EncodeToString(part.Data))
+	switch {
+	case strings.HasPrefix(mediaType, "image/"):
+		return ResponseContentPart{
+			Type:     "input_image",
+			ImageURL: dataURL,
+		}, nil
+	case strings.HasPrefix(mediaType, "text/"):
+		return ResponseContentPart{
+			Type: "input_text",
+			Text: string(part.Data),
+		}, nil
+	default:
+		filename := part.Name
+		if filename == "" {
+			ext := ""
+			if exts, err := mime.ExtensionsByType(mediaType); err == nil && len(exts) > 0 {
+				ext = exts[0]
+			}
+			filename = fmt.Sprintf("document%d%s", *documentCount, ext)
+			*documentCount++
+		}
+		return ResponseContentPart{
+			Type:     "input_file",
+			Filename: filename,
+			FileData: dataURL,
+		}, nil
+	}
+}
+
+func (p *ModelProvider) generateTextWithResponsesMultiTurn(ctx context.Context, c *responsesClient, input *ResponsesRequest, w estellm.ResponseWriter, executor *estellm.ToolExecutor) error {
+	var inputTokens, outputTokens, totalTokens, reasoningTokens int64
+	stateless := input.Store != nil && !*input.Store
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		executor.NextTurn(w.Metadata())
+		resp, err := c.createResponseStream(ctx, input)
+		if err != nil {
+			return fmt.Errorf("create response: %w", withStatusCode(err))
+		}
+		m := w.Metadata()
+		for k, v := range resp.Header {
+			if strings.HasPrefix(k, "Openai-") || strings.HasPrefix(k, "X-Ratelimit-") || k == "X-Request-Id" {
+				m.SetStrings(k, v)
+			}
+		}
+		result, err := readResponseStream(ctx, resp.Body, w)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("read stream: %w", err)
+		}
+		m.SetString("Openai-Response-Id", result.ID)
+		if result.Usage != nil {
+			inputTokens += result.Usage.InputTokens
+			outputTokens += result.Usage.OutputTokens
+			totalTokens += result.Usage.TotalTokens
+			metadata.SetInputTokens(m, inputTokens)
+			metadata.SetOutputTokens(m, outputTokens)
+			metadata.SetTotalTokens(m, totalTokens)
+			if result.Usage.OutputTokensDetails.ReasoningTokens > 0 {
+				reasoningTokens += result.Usage.OutputTokensDetails.ReasoningTokens
+				metadata.SetReasoningTokens(m, reasoningTokens)
+			}
+		}
+		switch result.Status {
+		case "completed":
+		case "incomplete":
+			reason := "incomplete"
+			if result.IncompleteDetails != nil {
+				reason = result.IncompleteDetails.Reason
+			}
+			switch reason {
+			case "max_output_tokens":
+				w.Finish(estellm.FinishReasonMaxTokens, reason)
+			case "content_filter":
+				w.Finish(estellm.FinishReasonContentFiltered, reason)
+			default:
+				w.Finish(estellm.FinishReasonEndTurn, reason)
+			}
+			return nil
+		default:
+			return fmt.Errorf("unsupported response status: %s", result.Status)
+		}
+		toolUses := make([]estellm.ToolUse, 0, len(result.Output))
+		for _, item := range result.Output {
+			if item.Type != "function_call" {
+				continue
+			}
+			toolUses = append(toolUses, estellm.ToolUse{
+				ID:    item.CallID,
+				Name:  item.Name,
+				Input: json.RawMessage(item.Arguments),
+			})
+		}
+		if len(toolUses) == 0 {
+			w.Finish(estellm.FinishReasonEndTurn, result.Status)
+			return nil
+		}
+		if executor.Exhausted() {
+			// the model calls the tools even in the final turn.
+			w.Finish(estellm.FinishReasonEndTurn, "max_tool_iterations")
+			return nil
+		}
+		toolResults, err := executor.Execute(ctx, toolUses)
+		if err != nil {
+			return err
+		}
+		outputs := make([]ResponseItem, 0, len(toolResults))
+		for _, toolResult := range toolResults {
+			outputs = append(outputs, newResponseToolResult(toolResult))
+		}
+		if executor.Exhausted() {
+			input.ToolChoice = "none"
+			outputs = append(outputs, ResponseItem{
+				Type:    "message",
+				Role:    "user",
+				Content: []ResponseContentPart{{Type: "input_text", Text: estellm.FinalTurnInstruction}},
+			})
+		}
+		slog.DebugContext(ctx, "tool result", "outputs", outputs)
+		if stateless {
+			// without stored responses, the whole conversation is sent again.
+			for _, item := range result.Output {
+				// reasoning items can be sent again only with the encrypted content.
+				if item.Type == "reasoning" && item.EncryptedContent == "" {
+					continue
+				}
+				input.Input = append(input.Input, item)
+			}
+			input.Input = append(input.Input, outputs...)
+			continue
+		}
+		input.PreviousResponseID = result.ID
+		input.Input = outputs
+	}
+}
+
+// readResponseStream reads the server-sent events of the Responses API.
+// Text and reasoning summary deltas are written to w, and the final response is returned.
+func readResponseStream(ctx context.Context, r io.Reader, w estellm.ResponseWriter) (*Response, error) {
+	reader := bufio.NewReader(r)
+	var data strings.Builder
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+		line, err := reader.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		eof := errors.Is(err, io.EOF)
+		line = strings.TrimRight(line, "\r\n")
+		if after, ok := strings.CutPrefix(line, "data:"); ok {
+			data.WriteString(strings.TrimPrefix(after, " "))
+		}
+		if line != "" && !eof {
+			continue
+		}
+		if data.Len() == 0 || data.String() == "[DONE]" {
+			data.Reset()
+			if eof {
+				return nil, errors.New("stream closed before the response completed")
+			}
+			continue
+		}
+		var event ResponseStreamEvent
+		if err := json.Unmarshal([]byte(data.String()), &event); err != nil {
+			return nil, fmt.Errorf("unmarshal event: %w", err)
+		}
+		data.Reset()
+		switch event.Type {
+		case "response.created":
+			w.WriteRole(estellm.RoleAssistant)
+		case "response.output_text.delta", "response.refusal.delta":
+			if err := w.WritePart(estellm.TextPart(event.Delta)); err != nil {
+				return nil, err
+			}
+		case "response.reasoning_summary_text.delta":
+			if err := w.WritePart(estellm.ReasoningPart(event.Delta)); err != nil {
+				return nil, err
+			}
+		case "response.completed", "response.incomplete":
+			if event.Response == nil {
+				return nil, fmt.Errorf("%s event without response", event.Type)
+			}
+			return event.Response, nil
+		case "response.failed":
+			apiErr := &openai.APIError{Message: "response failed"}
+			if event.Response != nil && event.Response.Error != nil {
+				apiErr.Code = event.Response.Error.Code
+				apiErr.Message = event.Response.Error.Message
+			}
+			return nil, apiErr
+		case "error":
+			apiErr := &openai.APIError{Code: event.Code, Message: event.Message}
+			if event.Error != nil {
+				apiErr.Code = event.Error.Code
+				apiErr.Message = event.Error.Message
+			}
+			return nil, apiErr
+		default:
+			slog.DebugContext(ctx, "unhandled event", "type", event.Type)
+		}
+		if eof {
+			return nil, errors.New("stream closed before the response completed")
+		}
+	}
+}
+
+func newResponseToolResult(result estellm.ToolResult) ResponseItem {
+	if result.Err != nil {
+		return newResponseToolResultWithError(result.ToolUse.ID, result.Err)
+	}
+	return newResponseToolResultWithResponse(result.ToolUse.ID, result.Response)
+}
+
+func newResponseToolResultWithError(callID string, err error) ResponseItem {
+	return ResponseItem{
+		Type:   "function_call_output",
+		CallID: callID,
+		Output: fmt.Sprintf("failed to call tool[%s]: %s", callID, err.Error()),
+	}
+}
+
+func newResponseToolResultWithResponse(callID string, response *estellm.Response) ResponseItem {
+	var sb strings.Builder
+	content := make([]ResponseContentPart, 0, len(response.Message.Parts))
+	textOnly := true
+	documentCount := 0
+	for _, part := range response.Message.Parts {
+		switch part.Type {
+		case estellm.PartTypeText:
+			sb.WriteString(part.Text)
+			content = append(content, ResponseContentPart{
+				Type: "input_text",
+				Text: part.Text,
+			})
+		case estellm.PartTypeBinary:
+			cp, err := responseBinaryContentPart(part, &documentCount)
+			if err != nil {
+				return newResponseToolResultWithError(callID, err)
+			}
+			textOnly = false
+			content = append(content, cp)
+		}
+	}
+	item := ResponseItem{
+		Type:   "function_call_output",
+		CallID: callID,
+		Output: content,
+	}
+	if textOnly {
+		item.Output = sb.String()
+	}
+	return item
+}
@@ -0,0 +1,202 @@
+package openai_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mashiike/estellm"
+	"github.com/mashiike/estellm/metadata"
+	"github.com/mashiike/estellm/provider/openai"
+	goopenai "github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/require"
+)
+
+type weatherTool struct {
+	inputs []any
+}
+
+func (t *weatherTool) Name() string        { return "get weather" }
+func (t *weatherTool) Description() string { return "get the weather" }
+func (t *weatherTool) InputSchema() map[string]any {
+	return map[string]any{"type": "object", "properties": map[string]any{"city": map[string]any{"type": "string"}}}
+}
+func (t *weatherTool) Call(_ context.Context, input any, w estellm.ResponseWriter) error {
+	t.inputs = append(t.inputs, input)
+	return w.WritePart(estellm.TextPart("sunny"))
+}
+
+func TestGenerateText__Responses(t *testing.T) {
+	s := newStubServer(t,
+		[]string{
+			`{"type":"response.created","response":{"id":"resp_1","status":"in_progress","output":[]}}`,
+			`{"type":"response.reasoning_summary_text.delta","item_id":"rs_1","delta":"Need the weather."}`,
+			`{"type":"response.output_item.added","item":{"type":"function_call","id":"fc_1","call_id":"call_1","name":"get_weather","arguments":""}}`,
+			`{"type":"response.function_call_arguments.delta","item_id":"fc_1","delta":"{\"city\":\"Tokyo\"}"}`,
+			`{"type":"response.completed","response":{"id":"resp_1","status":"completed","output":[
+				{"type":"reasoning","id":"rs_1","summary":[{"type":"summary_text","text":"Need the weather."}]},
+				{"type":"function_call","id":"fc_1","call_id":"call_1","name":"get_weather","arguments":"{\"city\":\"Tokyo\"}","status":"completed"}
+			],"usage":{"input_tokens":20,"output_tokens":30,"total_tokens":50,"output_tokens_details":{"reasoning_tokens":16}}}}`,
+		},
+		[]string{
+			`{"type":"response.created","response":{"id":"resp_2","status":"in_progress","output":[]}}`,
+			`{"type":"response.output_text.delta","item_id":"msg_1","delta":"It is "}`,
+			`{"type":"response.output_text.delta","item_id":"msg_1","delta":"sunny."}`,
+			`{"type":"response.completed","response":{"id":"resp_2","status":"completed","output":[
+				{"type":"message","id":"msg_1","role":"assistant","content":[{"type":"output_text","text":"It is sunny."}]}
+			],"usage":{"input_tokens":60,"output_tokens":5,"total_tokens":65,"output_tokens_details":{"reasoning_tokens":0}}}}`,
+		},
+	)
+	tool := &weatherTool{}
+	p := &openai.ModelProvider{}
+	req := newRequest(s.URL, "o4-mini", map[string]any{
+		"api":              "responses",
+		"max_tokens":       2048,
+		"reasoning_effort": "low",
+		"reasoning":        map[string]any{"summary": "auto"},
+	})
+	req.Messages[0].Parts = append(req.Messages[0].Parts,
+		estellm.BinaryPart("image/png", []byte("png")),
+		estellm.BinaryPartWithName("application/pdf", "report.pdf", []byte("pdf")),
+	)
+	req.Tools = estellm.ToolSet{tool}
+	w := estellm.NewBatchResponseWriter()
+	require.NoError(t, p.GenerateText(context.Background(), req, w))
+
+	require.Equal(t, []any{map[string]any{"city": "Tokyo"}}, tool.inputs)
+	require.Equal(t, []string{"POST /responses", "POST /responses"}, s.paths)
+	bs, err := json.Marshal(s.requests[0])
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"model": "o4-mini",
+		"instructions": "You are a helpful assistant.",
+		"stream": true,
+		"max_output_tokens": 2048,
+		"reasoning": {"effort":"low","summary":"auto"},
+		"input": [
+			{"type":"message","role":"user","content":[
+				{"type":"input_text","text":"Hi"},
+				{"type":"input_image","image_url":"data:image/png;base64,cG5n"},
+				{"type":"input_file","filename":"report.pdf","file_data":"data:application/pdf;base64,cGRm"}
+			]}
+		],
+		"tools": [{
+			"type": "function",
+			"name": "get_weather",
+			"description": "get the weather",
+			"parameters": {"type":"object","properties":{"city":{"type":"string"}}},
+			"strict": false
+		}]
+	}`, string(bs))
+	require.Equal(t, "resp_1", s.requests[1]["previous_response_id"])
+	bs, err = json.Marshal(s.requests[1]["input"])
+	require.NoError(t, err)
+	require.JSONEq(t, `[{"type":"function_call_output","call_id":"call_1","output":"sunny"}]`, string(bs))
+
+	resp := w.Response()
+	require.Equal(t, estellm.FinishReasonEndTurn, resp.FinishReason)
+	require.Equal(t, []estellm.ContentPart{
+		estellm.ReasoningPart("Need the weather."),
+		estellm.TextPart("It is sunny."),
+	}, resp.Message.Parts)
+	require.Equal(t, "resp_2", resp.Metadata.GetString("Openai-Response-Id"))
+	totalTokens, _ := metadata.GetTotalTokens(resp.Metadata)
+	reasoningTokens, _ := metadata.GetReasoningTokens(resp.Metadata)
+	require.EqualValues(t, 115, totalTokens)
+	require.EqualValues(t, 16, reasoningTokens)
+}
+
+func TestGenerateText__ResponsesStateless(t *testing.T) {
+	s := newStubServer(t,
+		[]string{
+			`{"type":"response.completed","response":{"id":"resp_1","status":"completed","output":[
+				{"type":"reasoning","id":"rs_1","summary":[]},
+				{"type":"function_call","id":"fc_1","call_id":"call_1","name":"get_weather","arguments":"{\"city\":\"Tokyo\"}","status":"completed"}
+			]}}`,
+		},
+		[]string{
+			`{"type":"response.output_text.delta","item_id":"msg_1","delta":"It is"}`,
+			`{"type":"response.incomplete","response":{"id":"resp_2","status":"incomplete","incomplete_details":{"reason":"max_output_tokens"},"output":[]}}`,
+		},
+	)
+	tool := &weatherTool{}
+	p := &openai.ModelProvider{}
+	req := newRequest(s.URL, "gpt-4.1", map[string]any{
+		"api":   "responses",
+		"store": false,
+	})
+	req.Tools = estellm.ToolSet{tool}
+	w := estellm.NewBatchResponseWriter()
+	require.NoError(t, p.GenerateText(context.Background(), req, w))
+
+	require.NotContains(t, s.requests[0], "include")
+	require.NotContains(t, s.requests[1], "previous_response_id")
+	bs, err := json.Marshal(s.requests[1]["input"])
+	require.NoError(t, err)
+	require.JSONEq(t, `[
+		{"type":"message","role":"user","content":[{"type":"input_text","text":"Hi"}]},
+		{"type":"function_call","id":"fc_1","call_id":"call_1","name":"get_weather","arguments":"{\"city\":\"Tokyo\"}","status":"completed"},
+		{"type":"function_call_output","call_id":"call_1","output":"sunny"}
+	]`, string(bs))
+	resp := w.Response()
+	require.Equal(t, estellm.FinishReasonMaxTokens, resp.FinishReason)
+	require.Equal(t, []estellm.ContentPart{estellm.TextPart("It is")}, resp.Message.Parts)
+}
+
+func TestGenerateText__ResponsesStatelessReasoning(t *testing.T) {
+	s := newStubServer(t,
+		[]string{
+			`{"type":"response.completed","response":{"id":"resp_1","status":"completed","output":[
+				{"type":"reasoning","id":"rs_1","summary":[],"encrypted_content":"gAAAA"},
+				{"type":"function_call","id":"fc_1","call_id":"call_1","name":"get_weather","arguments":"{\"city\":\"Tokyo\"}","status":"completed"}
+			]}}`,
+		},
+		[]string{
+			`{"type":"response.completed","response":{"id":"resp_2","status":"completed","output":[
+				{"type":"message","id":"msg_1","role":"assistant","content":[{"type":"output_text","text":"It is sunny."}]}
+			]}}`,
+		},
+	)
+	p := &openai.ModelProvider{}
+	req := newRequest(s.URL, "o4-mini", map[string]any{
+		"api":         "responses",
+		"store":       false,
+		"temperature": 0.2,
+		"top_p":       0.9,
+	})
+	req.Tools = estellm.ToolSet{&weatherTool{}}
+	require.NoError(t, p.GenerateText(context.Background(), req, estellm.NewBatchResponseWriter()))
+
+	for _, r := range s.requests {
+		require.NotContains(t, r, "temperature")
+		require.NotContains(t, r, "top_p")
+		require.Equal(t, []any{"reasoning.encrypted_content"}, r["include"])
+	}
+	bs, err := json.Marshal(s.requests[1]["input"])
+	require.NoError(t, err)
+	require.JSONEq(t, `[
+		{"type":"message","role":"user","content":[{"type":"input_text","text":"Hi"}]},
+		{"type":"reasoning","id":"rs_1","summary":[],"encrypted_content":"gAAAA"},
+		{"type":"function_call","id":"fc_1","call_id":"call_1","name":"get_weather","arguments":"{\"city\":\"Tokyo\"}","status":"completed"},
+		{"type":"function_call_output","call_id":"call_1","output":"sunny"}
+	]`, string(bs))
+}
+
+func TestGenerateText__ResponsesAPIError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"error":{"message":"Rate limit reached","type":"requests","code":"rate_limit_exceeded"}}`))
+	}))
+	t.Cleanup(srv.Close)
+	p := &openai.ModelProvider{}
+	req := newRequest(srv.URL, "gpt-4.1", map[string]any{"api": "responses"})
+	err := p.GenerateText(context.Background(), req, estellm.NewBatchResponseWriter())
+	var apiErr *goopenai.APIError
+	require.True(t, errors.As(err, &apiErr))
+	require.Equal(t, http.StatusTooManyRequests, apiErr.HTTPStatusCode)
+	require.Equal(t, "Rate limit reached", apiErr.Message)
+}
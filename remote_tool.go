@@ -185,6 +185,19 @@ type RemoteToolConfig struct {
 	ResponseValidator  func(resp *http.Response, req *http.Request) error
 }
 
+var remoteToolConfigContextKey = contextKey("remote_tool_config")
+
+func withRemoteToolConfigContext(ctx context.Context, cfg RemoteToolConfig) context.Context {
+	return context.WithValue(ctx, remoteToolConfigContextKey, cfg)
+}
+
+// RemoteToolConfigFromContext returns the base RemoteToolConfig of the AgentMux creating the agent,
+// so that agents can reuse its HTTP client, signer and validator.
+func RemoteToolConfigFromContext(ctx context.Context) (RemoteToolConfig, bool) {
+	cfg, ok := ctx.Value(remoteToolConfigContextKey).(RemoteToolConfig)
+	return cfg, ok
+}
+
 func NewRemoteTool(ctx context.Context, cfg RemoteToolConfig) (*RemoteTool, error) {
 	if cfg.Endpoint == "" {
 		return nil, errors.New("endpoint is required")
@@ -49,6 +49,7 @@ func (r *Response) templateData() responseTemplateData {
 		r.tmpl = make(responseTemplateData)
 	}
 	r.tmpl["_raw"] = str
+	r.tmpl["_finish_reason"] = r.FinishReason.String()
 	return r.tmpl
 }
 
@@ -34,6 +34,7 @@ func TestResponseTemplateData(t *testing.T) {
 	data := resp.templateData()
 	assert.Equal(t, `part1{"key": "value"}`+"\n", data.String())
 	assert.Equal(t, "value", data["key"])
+	assert.Equal(t, "end_turn", data["_finish_reason"])
 }
 
 func TestResponseTemplateDataString(t *testing.T) {
@@ -0,0 +1,117 @@
+package retrieval
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+const (
+	// DefaultIndexPath is the index file path relative to the project directory.
+	DefaultIndexPath = ".estellm/index.json"
+	indexVersion     = 1
+)
+
+var (
+	ErrIndexEmpty         = errors.New("index is empty")
+	ErrDimensionsMismatch = errors.New("vector dimensions mismatch")
+)
+
+// Index is a file based vector index.
+type Index struct {
+	Version       int            `json:"version"`
+	ModelProvider string         `json:"model_provider"`
+	ModelID       string         `json:"model_id"`
+	ModelParams   map[string]any `json:"model_params,omitempty"`
+	Dimensions    int            `json:"dimensions"`
+	CreatedAt     time.Time      `json:"created_at"`
+	Chunks        []Chunk        `json:"chunks"`
+}
+
+type Chunk struct {
+	Source string    `json:"source"`
+	Index  int       `json:"index"`
+	Text   string    `json:"text"`
+	Vector []float32 `json:"vector"`
+}
+
+type SearchResult struct {
+	Source string  `json:"source"`
+	Index  int     `json:"index"`
+	Text   string  `json:"text"`
+	Score  float64 `json:"score"`
+}
+
+func LoadIndex(path string) (*Index, error) {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read index: %w", err)
+	}
+	var idx Index
+	if err := json.Unmarshal(bs, &idx); err != nil {
+		return nil, fmt.Errorf("unmarshal index: %w", err)
+	}
+	if idx.Version != indexVersion {
+		return nil, fmt.Errorf("unsupported index version: %d", idx.Version)
+	}
+	return &idx, nil
+}
+
+func (idx *Index) Save(path string) error {
+	bs, err := json.Marshal(idx)
+	if err != nil {
+		return fmt.Errorf("marshal index: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("create index directory: %w", err)
+	}
+	return os.WriteFile(path, bs, 0644)
+}
+
+// Search returns the topK chunks most similar to vector by cosine similarity.
+func (idx *Index) Search(vector []float32, topK int) ([]SearchResult, error) {
+	if len(idx.Chunks) == 0 {
+		return nil, ErrIndexEmpty
+	}
+	if idx.Dimensions != 0 && len(vector) != idx.Dimensions {
+		return nil, fmt.Errorf("query has %d dimensions, index has %d: %w", len(vector), idx.Dimensions, ErrDimensionsMismatch)
+	}
+	results := make([]SearchResult, 0, len(idx.Chunks))
+	for _, chunk := range idx.Chunks {
+		results = append(results, SearchResult{
+			Source: chunk.Source,
+			Index:  chunk.Index,
+			Text:   chunk.Text,
+			Score:  cosineSimilarity(vector, chunk.Vector),
+		})
+	}
+	slices.SortStableFunc(results, func(a, b SearchResult) int {
+		return cmp.Compare(b.Score, a.Score)
+	})
+	if topK > 0 && len(results) > topK {
+		results = results[:topK]
+	}
+	return results, nil
+}
+
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
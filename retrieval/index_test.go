@@ -0,0 +1,81 @@
+package retrieval_test
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/mashiike/estellm"
+	"github.com/mashiike/estellm/retrieval"
+	"github.com/stretchr/testify/require"
+)
+
+// keywordEmbedder embeds texts as keyword occurrence vectors.
+type keywordEmbedder struct {
+	keywords   []string
+	inputTypes []estellm.EmbeddingInputType
+}
+
+func (e *keywordEmbedder) GenerateText(_ context.Context, _ *estellm.GenerateTextRequest, _ estellm.ResponseWriter) error {
+	return estellm.ErrNotSupported
+}
+
+func (e *keywordEmbedder) GenerateImage(_ context.Context, _ *estellm.GenerateImageRequest, _ estellm.ResponseWriter) error {
+	return estellm.ErrNotSupported
+}
+
+func (e *keywordEmbedder) GenerateEmbedding(_ context.Context, req *estellm.GenerateEmbeddingRequest) (*estellm.GenerateEmbeddingResponse, error) {
+	e.inputTypes = append(e.inputTypes, req.InputType)
+	resp := &estellm.GenerateEmbeddingResponse{}
+	for _, input := range req.Inputs {
+		vec := make([]float32, len(e.keywords))
+		for i, keyword := range e.keywords {
+			vec[i] = float32(strings.Count(strings.ToLower(input), keyword))
+		}
+		resp.Embeddings = append(resp.Embeddings, vec)
+	}
+	return resp, nil
+}
+
+func TestIndexer(t *testing.T) {
+	ctx, manager := estellm.WithModelProviderManager(context.Background())
+	embedder := &keywordEmbedder{
+		keywords: []string{"apple", "banana", "cherry"},
+	}
+	require.NoError(t, manager.Register("keyword", embedder))
+	fsys := fstest.MapFS{
+		"fruits/apple.md":  {Data: []byte("Apple is red. An apple a day keeps the doctor away.")},
+		"fruits/banana.md": {Data: []byte("Banana is yellow.")},
+		"fruits/cherry.md": {Data: []byte("Cherry is small and red.")},
+		"ignored.json":     {Data: []byte(`{"apple": true}`)},
+	}
+	indexer := &retrieval.Indexer{
+		ModelProvider: "keyword",
+		ModelID:       "dummy",
+		BatchSize:     2,
+	}
+	idx, err := indexer.Build(ctx, fsys, "docs")
+	require.NoError(t, err)
+	require.Len(t, idx.Chunks, 3)
+	require.Equal(t, 3, idx.Dimensions)
+
+	path := filepath.Join(t.TempDir(), "index.json")
+	require.NoError(t, idx.Save(path))
+	loaded, err := retrieval.LoadIndex(path)
+	require.NoError(t, err)
+
+	r, err := retrieval.NewRetriever(ctx, loaded)
+	require.NoError(t, err)
+	results, err := r.Retrieve(ctx, "banana?", 1)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	require.Equal(t, "docs/fruits/banana.md", results[0].Source)
+	require.InDelta(t, 1.0, results[0].Score, 1e-6)
+	require.Equal(t, []estellm.EmbeddingInputType{
+		estellm.EmbeddingInputTypeDocument,
+		estellm.EmbeddingInputTypeDocument,
+		estellm.EmbeddingInputTypeQuery,
+	}, embedder.inputTypes)
+}
@@ -0,0 +1,145 @@
+package retrieval
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+	"slices"
+
+	"github.com/Songmu/flextime"
+	"github.com/mashiike/estellm"
+	"github.com/mashiike/estellm/textsplit"
+)
+
+const DefaultBatchSize = 32
+
+var DefaultPatterns = []string{"*.md", "*.mdx", "*.txt"}
+
+// Indexer chunks files and embeds them into an Index.
+type Indexer struct {
+	ModelProvider string
+	ModelID       string
+	ModelParams   map[string]any
+	ChunkSize     int
+	ChunkOverlap  int
+	BatchSize     int
+	Patterns      []string
+	Logger        *slog.Logger
+}
+
+// Build walks fsys, splits every file matching the patterns into chunks and embeds them.
+// The source path of each chunk is prefixed with sourcePrefix.
+func (ix *Indexer) Build(ctx context.Context, fsys fs.FS, sourcePrefix string) (*Index, error) {
+	idx := &Index{
+		Version:       indexVersion,
+		ModelProvider: ix.ModelProvider,
+		ModelID:       ix.ModelID,
+		ModelParams:   ix.ModelParams,
+		CreatedAt:     flextime.Now(),
+	}
+	if err := ix.Append(ctx, idx, fsys, sourcePrefix); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// Append adds the chunks of the files in fsys to idx.
+func (ix *Indexer) Append(ctx context.Context, idx *Index, fsys fs.FS, sourcePrefix string) error {
+	provider, err := estellm.GetEmbeddingProvider(ctx, ix.ModelProvider)
+	if err != nil {
+		return err
+	}
+	logger := ix.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	patterns := ix.Patterns
+	if len(patterns) == 0 {
+		patterns = DefaultPatterns
+	}
+	paths, err := globFiles(fsys, patterns)
+	if err != nil {
+		return fmt.Errorf("walk sources: %w", err)
+	}
+	pending := make([]Chunk, 0)
+	for _, path := range paths {
+		bs, err := fs.ReadFile(fsys, path)
+		if err != nil {
+			return fmt.Errorf("read `%s`: %w", path, err)
+		}
+		source := filepath.ToSlash(filepath.Join(sourcePrefix, path))
+		texts := textsplit.Split(string(bs), ix.ChunkSize, ix.ChunkOverlap)
+		logger.DebugContext(ctx, "split source", "source", source, "chunks", len(texts))
+		for i, text := range texts {
+			pending = append(pending, Chunk{
+				Source: source,
+				Index:  i,
+				Text:   text,
+			})
+		}
+	}
+	batchSize := ix.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	for batch := range slices.Chunk(pending, batchSize) {
+		inputs := make([]string, 0, len(batch))
+		for _, chunk := range batch {
+			inputs = append(inputs, chunk.Text)
+		}
+		resp, err := provider.GenerateEmbedding(ctx, &estellm.GenerateEmbeddingRequest{
+			ModelID:     idx.ModelID,
+			ModelParams: idx.ModelParams,
+			InputType:   estellm.EmbeddingInputTypeDocument,
+			Inputs:      inputs,
+		})
+		if err != nil {
+			return fmt.Errorf("generate embedding: %w", err)
+		}
+		if len(resp.Embeddings) != len(batch) {
+			return fmt.Errorf("embedding count mismatch: expected %d, got %d", len(batch), len(resp.Embeddings))
+		}
+		for i, chunk := range batch {
+			chunk.Vector = resp.Embeddings[i]
+			if idx.Dimensions == 0 {
+				idx.Dimensions = len(chunk.Vector)
+			}
+			if len(chunk.Vector) != idx.Dimensions {
+				return fmt.Errorf("chunk `%s#%d`: %w", chunk.Source, chunk.Index, ErrDimensionsMismatch)
+			}
+			idx.Chunks = append(idx.Chunks, chunk)
+		}
+		logger.InfoContext(ctx, "embedded chunks", "count", len(idx.Chunks), "total", len(pending))
+	}
+	return nil
+}
+
+func globFiles(fsys fs.FS, patterns []string) ([]string, error) {
+	var matched []string
+	err := fs.WalkDir(fsys, ".", func(path string, e fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if e.IsDir() {
+			return nil
+		}
+		for _, pattern := range patterns {
+			ok, err := filepath.Match(pattern, e.Name())
+			if err != nil {
+				return err
+			}
+			if ok {
+				matched = append(matched, path)
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(matched)
+	return matched, nil
+}
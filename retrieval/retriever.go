@@ -0,0 +1,73 @@
+package retrieval
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mashiike/estellm"
+)
+
+const DefaultTopK = 5
+
+// Retriever searches an Index with queries embedded by the provider used to build it.
+type Retriever struct {
+	idx      *Index
+	provider estellm.EmbeddingProvider
+}
+
+func NewRetriever(ctx context.Context, idx *Index) (*Retriever, error) {
+	provider, err := estellm.GetEmbeddingProvider(ctx, idx.ModelProvider)
+	if err != nil {
+		return nil, err
+	}
+	return &Retriever{
+		idx:      idx,
+		provider: provider,
+	}, nil
+}
+
+func (r *Retriever) Retrieve(ctx context.Context, query string, topK int) ([]SearchResult, error) {
+	if topK <= 0 {
+		topK = DefaultTopK
+	}
+	resp, err := r.provider.GenerateEmbedding(ctx, &estellm.GenerateEmbeddingRequest{
+		ModelID:     r.idx.ModelID,
+		ModelParams: r.idx.ModelParams,
+		InputType:   estellm.EmbeddingInputTypeQuery,
+		Inputs:      []string{query},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("embed query: %w", err)
+	}
+	if len(resp.Embeddings) != 1 {
+		return nil, fmt.Errorf("embedding count mismatch: expected 1, got %d", len(resp.Embeddings))
+	}
+	return r.idx.Search(resp.Embeddings[0], topK)
+}
+
+type contextKey string
+
+var (
+	indexPathContextKey   = contextKey("indexPath")
+	projectRootContextKey = contextKey("projectRoot")
+)
+
+// WithIndexPath sets the index path used by retrieve agents that do not configure one.
+func WithIndexPath(ctx context.Context, path string) context.Context {
+	return context.WithValue(ctx, indexPathContextKey, path)
+}
+
+func IndexPathFromContext(ctx context.Context) (string, bool) {
+	path, ok := ctx.Value(indexPathContextKey).(string)
+	return path, ok
+}
+
+// WithProjectRoot sets the directory that relative index paths of retrieve agents are resolved against.
+func WithProjectRoot(ctx context.Context, root string) context.Context {
+	return context.WithValue(ctx, projectRootContextKey, root)
+}
+
+func ProjectRootFromContext(ctx context.Context) (string, bool) {
+	root, ok := ctx.Value(projectRootContextKey).(string)
+	return root, ok
+}
@@ -0,0 +1,49 @@
+package retrieval
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/mashiike/estellm"
+)
+
+const DefaultToolName = "retrieve_documents"
+
+type ToolInput struct {
+	Query string `json:"query" jsonschema:"description=Search query for the reference documents,required=true"`
+	TopK  int    `json:"top_k,omitempty" jsonschema:"description=Number of chunks to return"`
+}
+
+// Output is the result written by the retrieve agent and tool.
+type Output struct {
+	Query  string         `json:"query"`
+	Chunks []SearchResult `json:"chunks"`
+}
+
+func (o Output) WriteTo(w estellm.ResponseWriter) error {
+	bs, err := json.Marshal(o)
+	if err != nil {
+		return fmt.Errorf("marshal output: %w", err)
+	}
+	if err := w.WritePart(estellm.TextPart(string(bs))); err != nil {
+		return err
+	}
+	return w.Finish(estellm.FinishReasonEndTurn, fmt.Sprintf("retrieved %d chunks", len(o.Chunks)))
+}
+
+// NewTool returns a tool that lets generate_text agents search the index on demand.
+func NewTool(name string, r *Retriever) (estellm.Tool, error) {
+	if name == "" {
+		name = DefaultToolName
+	}
+	return estellm.NewTool(name, "Search the local reference documents and return the most relevant chunks with their source paths.",
+		func(ctx context.Context, input ToolInput, w estellm.ResponseWriter) error {
+			results, err := r.Retrieve(ctx, input.Query, input.TopK)
+			if err != nil {
+				return err
+			}
+			return Output{Query: input.Query, Chunks: results}.WriteTo(w)
+		},
+	)
+}
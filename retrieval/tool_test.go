@@ -0,0 +1,52 @@
+package retrieval_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"testing/fstest"
+
+	"github.com/mashiike/estellm"
+	"github.com/mashiike/estellm/estellmtest"
+	"github.com/mashiike/estellm/retrieval"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTool(t *testing.T) {
+	ctx, manager := estellm.WithModelProviderManager(context.Background())
+	require.NoError(t, manager.Register("keyword", &keywordEmbedder{
+		keywords: []string{"apple", "banana", "cherry"},
+	}))
+	indexer := &retrieval.Indexer{
+		ModelProvider: "keyword",
+		ModelID:       "dummy",
+	}
+	idx, err := indexer.Build(ctx, fstest.MapFS{
+		"apple.md":  {Data: []byte("Apple is red.")},
+		"banana.md": {Data: []byte("Banana is yellow.")},
+		"cherry.md": {Data: []byte("Cherry and apple are red.")},
+	}, "docs")
+	require.NoError(t, err)
+	r, err := retrieval.NewRetriever(ctx, idx)
+	require.NoError(t, err)
+
+	tool, err := retrieval.NewTool("", r)
+	require.NoError(t, err)
+	require.Equal(t, retrieval.DefaultToolName, tool.Name())
+	require.Equal(t, []any{"query"}, tool.InputSchema()["required"])
+
+	w := estellmtest.NewResponseRecorder()
+	require.NoError(t, tool.Call(ctx, map[string]any{"query": "apple", "top_k": 2}, w))
+	require.True(t, w.Finished())
+	require.Equal(t, "retrieved 2 chunks", w.Response().FinishMessage)
+	var output retrieval.Output
+	require.NoError(t, json.Unmarshal([]byte(w.Text()), &output))
+	require.Equal(t, "apple", output.Query)
+	require.Len(t, output.Chunks, 2)
+	require.Equal(t, "docs/apple.md", output.Chunks[0].Source)
+	require.Equal(t, "docs/cherry.md", output.Chunks[1].Source)
+
+	tool, err = retrieval.NewTool("search", r)
+	require.NoError(t, err)
+	require.Equal(t, "search", tool.Name())
+}
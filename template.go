@@ -10,6 +10,7 @@ import (
 	"text/template"
 
 	"github.com/Masterminds/sprig/v3"
+	"github.com/mashiike/estellm/metadata"
 )
 
 func newReference(cfg *Config, resp *Response) map[string]any {
@@ -25,8 +26,10 @@ func newReference(cfg *Config, resp *Response) map[string]any {
 	}
 	if resp != nil {
 		reference["result"] = resp.templateData()
+		reference["metadata"] = resp.Metadata
 	} else {
 		dummyResp := &Response{
+			Metadata: make(metadata.Metadata),
 			Message: Message{
 				Role: RoleAssistant,
 				Parts: []ContentPart{
@@ -38,6 +41,7 @@ func newReference(cfg *Config, resp *Response) map[string]any {
 			},
 		}
 		reference["result"] = dummyResp.templateData()
+		reference["metadata"] = dummyResp.Metadata
 	}
 	return reference
 }
@@ -0,0 +1,63 @@
+// Package textsplit splits long texts into overlapping chunks, shared by the indexer and the agents.
+package textsplit
+
+import (
+	"strings"
+	"unicode"
+)
+
+const (
+	DefaultChunkSize    = 1000
+	DefaultChunkOverlap = 200
+)
+
+// Split splits text into chunks of at most size runes.
+// Consecutive chunks share overlap runes, and each chunk tries to end at a line or word boundary.
+func Split(text string, size, overlap int) []string {
+	if size <= 0 {
+		size = DefaultChunkSize
+	}
+	if overlap < 0 || overlap >= size {
+		overlap = 0
+	}
+	runes := []rune(strings.TrimSpace(text))
+	if len(runes) == 0 {
+		return nil
+	}
+	chunks := make([]string, 0, len(runes)/size+1)
+	start := 0
+	for start < len(runes) {
+		end := min(start+size, len(runes))
+		if end < len(runes) {
+			end = findBoundary(runes, start+size/2, end)
+		}
+		chunk := strings.TrimSpace(string(runes[start:end]))
+		if chunk != "" {
+			chunks = append(chunks, chunk)
+		}
+		if end >= len(runes) {
+			break
+		}
+		next := end - overlap
+		if next <= start {
+			next = end
+		}
+		start = next
+	}
+	return chunks
+}
+
+// findBoundary returns the best split position in (lower, upper], preferring a newline over a space.
+func findBoundary(runes []rune, lower, upper int) int {
+	for i := upper; i > lower; i-- {
+		if runes[i-1] == '\n' {
+			return i
+		}
+	}
+	for i := upper; i > lower; i-- {
+		if unicode.IsSpace(runes[i-1]) {
+			return i
+		}
+	}
+	return upper
+}
@@ -0,0 +1,26 @@
+package textsplit_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mashiike/estellm/textsplit"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplit(t *testing.T) {
+	text := strings.Repeat("abcde ", 10) + "\n" + strings.Repeat("fghij ", 10)
+	chunks := textsplit.Split(text, 40, 10)
+	require.Greater(t, len(chunks), 1)
+	for _, chunk := range chunks {
+		require.LessOrEqual(t, len([]rune(chunk)), 40)
+		require.NotEmpty(t, chunk)
+	}
+	require.True(t, strings.HasPrefix(chunks[0], "abcde"))
+	require.True(t, strings.HasSuffix(chunks[len(chunks)-1], "fghij"))
+}
+
+func TestSplit__Short(t *testing.T) {
+	require.Equal(t, []string{"hello"}, textsplit.Split("  hello\n", 100, 10))
+	require.Empty(t, textsplit.Split("   ", 100, 10))
+}
@@ -0,0 +1,252 @@
+package estellm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/mashiike/estellm/jsonutil"
+	"github.com/mashiike/estellm/metadata"
+)
+
+// ToolUse is a tool call requested by the model.
+// Input of json.RawMessage is decoded before the call, and the nil or empty input is passed as an empty object.
+type ToolUse struct {
+	ID    string
+	Name  string
+	Input any
+}
+
+// ToolResult is the result of a ToolUse, Err is set when the tool is not found, times out or fails.
+type ToolResult struct {
+	ToolUse  ToolUse
+	Response *Response
+	Err      error
+}
+
+// ToolExecutorModelParams are the model_params keys read by NewToolExecutor, the providers do not send them to the model.
+var ToolExecutorModelParams = []string{"tool_parallelism", "tool_timeout", "abort_on_tool_error", "max_tool_iterations"}
+
+// DefaultMaxToolIterations is the default of `max_tool_iterations`.
+const DefaultMaxToolIterations = 10
+
+// FinalTurnInstruction is sent to the model with the tool results when the tool iterations are exhausted.
+var FinalTurnInstruction = "The limit of the tool calls is reached. Answer with the information gathered so far, without calling any tools."
+
+type toolExecutorParams struct {
+	Parallelism   int    `json:"tool_parallelism,omitempty"`
+	Timeout       string `json:"tool_timeout,omitempty"`
+	AbortOnError  bool   `json:"abort_on_tool_error,omitempty"`
+	MaxIterations int    `json:"max_tool_iterations,omitempty"`
+}
+
+// ToolExecutor calls the tools requested by the model, shared by the model providers.
+// By default all the tools of a turn are called concurrently and the errors are returned to the model as the results.
+//
+// It also tracks the turns of a generation: the providers call NextTurn before each request to the model,
+// and when Exhausted reports true after Execute, the next turn is the final turn which answers without tools.
+type ToolExecutor struct {
+	tools         ToolSet
+	normalizeName func(string) string
+	parallelism   int
+	timeout       time.Duration
+	abortOnError  bool
+	maxIterations int
+
+	turns        int64
+	calls        int64
+	iterations   int
+	previous     map[string]bool
+	loopDetected bool
+}
+
+// NewToolExecutor returns a ToolExecutor configured by the model_params.
+// `tool_parallelism` limits the concurrent calls (0 is unlimited), `tool_timeout` is the timeout of each call, e.g. "30s",
+// `abort_on_tool_error` makes the generation fail on a tool error instead of returning it to the model,
+// and `max_tool_iterations` limits the turns which call tools (default: DefaultMaxToolIterations).
+// normalizeName maps the tool names to the names sent to the model, nil means as is.
+func NewToolExecutor(tools ToolSet, modelParams map[string]any, normalizeName func(string) string) (*ToolExecutor, error) {
+	var params toolExecutorParams
+	if err := jsonutil.Remarshal(modelParams, &params); err != nil {
+		return nil, fmt.Errorf("tool executor params: %w", err)
+	}
+	if params.Parallelism < 0 {
+		return nil, fmt.Errorf("tool_parallelism must not be negative: %d", params.Parallelism)
+	}
+	if params.MaxIterations < 0 {
+		return nil, fmt.Errorf("max_tool_iterations must not be negative: %d", params.MaxIterations)
+	}
+	e := &ToolExecutor{
+		tools:         tools,
+		normalizeName: normalizeName,
+		parallelism:   params.Parallelism,
+		abortOnError:  params.AbortOnError,
+		maxIterations: params.MaxIterations,
+	}
+	if e.maxIterations == 0 {
+		e.maxIterations = DefaultMaxToolIterations
+	}
+	if params.Timeout != "" {
+		d, err := time.ParseDuration(params.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("parse tool_timeout: %w", err)
+		}
+		e.timeout = d
+	}
+	if e.normalizeName == nil {
+		e.normalizeName = func(name string) string { return name }
+	}
+	return e, nil
+}
+
+// NextTurn records a model turn and the tool calls so far into the metadata.
+func (e *ToolExecutor) NextTurn(m metadata.Metadata) {
+	e.turns++
+	metadata.SetModelTurns(m, e.turns)
+	metadata.SetToolCalls(m, e.calls)
+}
+
+// Exhausted reports whether the tool iterations reach max_tool_iterations,
+// or a turn repeats the same tool calls as the previous turn.
+func (e *ToolExecutor) Exhausted() bool {
+	return e.iterations >= e.maxIterations || e.loopDetected
+}
+
+// Execute calls the tools and returns the results in the order of uses.
+// An error is returned only when abort_on_tool_error is set or the context is canceled.
+func (e *ToolExecutor) Execute(ctx context.Context, uses []ToolUse) ([]ToolResult, error) {
+	e.iterations++
+	e.calls += int64(len(uses))
+	current := make(map[string]bool, len(uses))
+	for _, use := range uses {
+		current[toolUseKey(use)] = true
+	}
+	repeated := len(uses) > 0 && maps.Equal(current, e.previous)
+	e.previous = current
+	if repeated {
+		slog.WarnContext(ctx, "the same tool calls are repeated, the next turn is the final turn", "iterations", e.iterations)
+		e.loopDetected = true
+	} else if e.iterations >= e.maxIterations {
+		slog.WarnContext(ctx, "max_tool_iterations is reached, the next turn is the final turn", "iterations", e.iterations)
+	}
+	callCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make([]ToolResult, len(uses))
+	parallelism := e.parallelism
+	if parallelism == 0 || parallelism > len(uses) {
+		parallelism = len(uses)
+	}
+	sem := make(chan struct{}, max(parallelism, 1))
+	var wg sync.WaitGroup
+	var once sync.Once
+	var abortErr error
+	for i, use := range uses {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+			case <-callCtx.Done():
+				results[i] = ToolResult{ToolUse: use, Err: callCtx.Err()}
+				return
+			}
+			defer func() { <-sem }()
+			resp, err := e.call(callCtx, use)
+			results[i] = ToolResult{ToolUse: use, Response: resp, Err: err}
+			if err == nil {
+				return
+			}
+			slog.WarnContext(ctx, "tool call error", "tool_name", use.Name, "tool_use_id", use.ID, "error", err)
+			if e.abortOnError {
+				once.Do(func() {
+					abortErr = fmt.Errorf("tool call[%s]: %w", use.ID, err)
+					cancel()
+				})
+			}
+		}()
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return results, err
+	}
+	if abortErr != nil {
+		return results, abortErr
+	}
+	return results, nil
+}
+
+func (e *ToolExecutor) call(ctx context.Context, use ToolUse) (*Response, error) {
+	var tool Tool
+	for _, t := range e.tools {
+		if e.normalizeName(t.Name()) == use.Name {
+			tool = t
+			break
+		}
+	}
+	if tool == nil {
+		return nil, fmt.Errorf("tool not found: %s", use.Name)
+	}
+	input, err := toolInput(use)
+	if err != nil {
+		return nil, err
+	}
+	ctx = WithToolName(ctx, tool.Name())
+	ctx = WithToolUseID(ctx, use.ID)
+	if e.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.timeout)
+		defer cancel()
+	}
+	// the tool is not waited after the timeout, because it may ignore the context.
+	w := NewBatchResponseWriter()
+	done := make(chan error, 1)
+	go func() {
+		done <- tool.Call(ctx, input, w)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			return nil, err
+		}
+		return w.Response(), nil
+	case <-ctx.Done():
+		if e.timeout > 0 && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("timed out after %s: %w", e.timeout, ctx.Err())
+		}
+		return nil, ctx.Err()
+	}
+}
+
+func toolInput(use ToolUse) (any, error) {
+	switch input := use.Input.(type) {
+	case nil:
+		return map[string]any{}, nil
+	case json.RawMessage:
+		if len(input) == 0 {
+			return map[string]any{}, nil
+		}
+		var v any
+		if err := json.Unmarshal(input, &v); err != nil {
+			return nil, fmt.Errorf("unmarshal input: %w", err)
+		}
+		return v, nil
+	default:
+		return input, nil
+	}
+}
+
+// toolUseKey returns the key to detect the same tool calls, the input is normalized through JSON.
+func toolUseKey(use ToolUse) string {
+	var bs []byte
+	if input, err := toolInput(use); err == nil {
+		bs, _ = json.Marshal(input)
+	} else {
+		bs, _ = use.Input.(json.RawMessage)
+	}
+	return use.Name + "\x00" + string(bs)
+}
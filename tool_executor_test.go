@@ -0,0 +1,166 @@
+package estellm_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mashiike/estellm"
+	"github.com/mashiike/estellm/metadata"
+	"github.com/stretchr/testify/require"
+)
+
+type executorTestTool struct {
+	name    string
+	delay   time.Duration
+	err     error
+	running *atomic.Int32
+	peak    *atomic.Int32
+}
+
+func (t *executorTestTool) Name() string                { return t.name }
+func (t *executorTestTool) Description() string         { return t.name }
+func (t *executorTestTool) InputSchema() map[string]any { return map[string]any{"type": "object"} }
+
+func (t *executorTestTool) Call(ctx context.Context, input any, w estellm.ResponseWriter) error {
+	if t.running != nil {
+		n := t.running.Add(1)
+		defer t.running.Add(-1)
+		for {
+			peak := t.peak.Load()
+			if n <= peak || t.peak.CompareAndSwap(peak, n) {
+				break
+			}
+		}
+	}
+	select {
+	case <-time.After(t.delay):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if t.err != nil {
+		return t.err
+	}
+	toolUseID, _ := estellm.ToolUseIDFromContext(ctx)
+	bs, _ := json.Marshal(input)
+	return w.WritePart(estellm.TextPart(toolUseID + ":" + string(bs)))
+}
+
+func TestToolExecutor(t *testing.T) {
+	var running, peak atomic.Int32
+	tools := estellm.ToolSet{
+		&executorTestTool{name: "slow tool", delay: 60 * time.Millisecond, running: &running, peak: &peak},
+		&executorTestTool{name: "fast tool", delay: 30 * time.Millisecond, running: &running, peak: &peak},
+		&executorTestTool{name: "broken tool", err: errors.New("broken")},
+	}
+	normalize := func(name string) string { return strings.ReplaceAll(name, " ", "_") }
+	executor, err := estellm.NewToolExecutor(tools, nil, normalize)
+	require.NoError(t, err)
+	results, err := executor.Execute(context.Background(), []estellm.ToolUse{
+		{ID: "1", Name: "slow_tool", Input: map[string]any{"a": 1}},
+		{ID: "2", Name: "fast_tool", Input: json.RawMessage(`{"b":2}`)},
+		{ID: "3", Name: "broken_tool"},
+		{ID: "4", Name: "unknown_tool"},
+		{ID: "5", Name: "fast_tool", Input: json.RawMessage(`{`)},
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 5)
+	require.Equal(t, "1:{\"a\":1}\n", results[0].Response.String())
+	require.Equal(t, "2:{\"b\":2}\n", results[1].Response.String())
+	require.EqualError(t, results[2].Err, "broken")
+	require.EqualError(t, results[3].Err, "tool not found: unknown_tool")
+	require.ErrorContains(t, results[4].Err, "unmarshal input")
+	require.EqualValues(t, 2, peak.Load())
+}
+
+func TestToolExecutor__Options(t *testing.T) {
+	var running, peak atomic.Int32
+	tools := estellm.ToolSet{
+		&executorTestTool{name: "tool", delay: 10 * time.Millisecond, running: &running, peak: &peak},
+		&executorTestTool{name: "hang", delay: time.Minute},
+		&executorTestTool{name: "broken", err: errors.New("broken")},
+	}
+	executor, err := estellm.NewToolExecutor(tools, map[string]any{"tool_parallelism": 1, "tool_timeout": "50ms"}, nil)
+	require.NoError(t, err)
+	results, err := executor.Execute(context.Background(), []estellm.ToolUse{
+		{ID: "1", Name: "tool"},
+		{ID: "2", Name: "tool"},
+		{ID: "3", Name: "hang"},
+	})
+	require.NoError(t, err)
+	require.EqualValues(t, 1, peak.Load())
+	require.Equal(t, "1:{}\n", results[0].Response.String())
+	require.Equal(t, "2:{}\n", results[1].Response.String())
+	require.True(t, errors.Is(results[2].Err, context.DeadlineExceeded))
+
+	executor, err = estellm.NewToolExecutor(tools, map[string]any{"abort_on_tool_error": true}, nil)
+	require.NoError(t, err)
+	_, err = executor.Execute(context.Background(), []estellm.ToolUse{
+		{ID: "1", Name: "hang"},
+		{ID: "2", Name: "broken"},
+	})
+	require.EqualError(t, err, "tool call[2]: broken")
+
+	_, err = estellm.NewToolExecutor(tools, map[string]any{"tool_timeout": "soon"}, nil)
+	require.Error(t, err)
+}
+
+func TestToolExecutor__Iterations(t *testing.T) {
+	tools := estellm.ToolSet{&executorTestTool{name: "tool"}}
+	executor, err := estellm.NewToolExecutor(tools, map[string]any{"max_tool_iterations": 2}, nil)
+	require.NoError(t, err)
+	m := metadata.Metadata{}
+	executor.NextTurn(m)
+	_, err = executor.Execute(context.Background(), []estellm.ToolUse{{ID: "1", Name: "tool", Input: map[string]any{"q": "a"}}})
+	require.NoError(t, err)
+	require.False(t, executor.Exhausted())
+	executor.NextTurn(m)
+	_, err = executor.Execute(context.Background(), []estellm.ToolUse{
+		{ID: "2", Name: "tool", Input: json.RawMessage(`{"q":"b"}`)},
+		{ID: "3", Name: "tool", Input: json.RawMessage(`{"q":"c"}`)},
+	})
+	require.NoError(t, err)
+	require.True(t, executor.Exhausted())
+	executor.NextTurn(m)
+	turns, _ := metadata.GetModelTurns(m)
+	calls, _ := metadata.GetToolCalls(m)
+	require.EqualValues(t, 3, turns)
+	require.EqualValues(t, 3, calls)
+
+	executor, err = estellm.NewToolExecutor(tools, nil, nil)
+	require.NoError(t, err)
+	_, err = executor.Execute(context.Background(), []estellm.ToolUse{{ID: "1", Name: "tool", Input: map[string]any{"q": "a"}}})
+	require.NoError(t, err)
+	require.False(t, executor.Exhausted())
+	_, err = executor.Execute(context.Background(), []estellm.ToolUse{{ID: "2", Name: "tool", Input: json.RawMessage(`{ "q": "a" }`)}})
+	require.NoError(t, err)
+	require.True(t, executor.Exhausted(), "the same call is repeated")
+
+	executor, err = estellm.NewToolExecutor(tools, nil, nil)
+	require.NoError(t, err)
+	for i, q := range []string{"a", "b", "a", "b"} {
+		_, err = executor.Execute(context.Background(), []estellm.ToolUse{{ID: fmt.Sprint(i), Name: "tool", Input: map[string]any{"q": q}}})
+		require.NoError(t, err)
+		require.False(t, executor.Exhausted(), "A→B→A is not a loop")
+	}
+	_, err = executor.Execute(context.Background(), []estellm.ToolUse{
+		{ID: "4", Name: "tool", Input: map[string]any{"q": "a"}},
+		{ID: "5", Name: "tool", Input: map[string]any{"q": "b"}},
+	})
+	require.NoError(t, err)
+	require.False(t, executor.Exhausted(), "the calls of the earlier turns are not a loop")
+	_, err = executor.Execute(context.Background(), []estellm.ToolUse{
+		{ID: "6", Name: "tool", Input: map[string]any{"q": "b"}},
+		{ID: "7", Name: "tool", Input: map[string]any{"q": "a"}},
+	})
+	require.NoError(t, err)
+	require.True(t, executor.Exhausted(), "the previous turn is repeated in another order")
+
+	_, err = estellm.NewToolExecutor(tools, map[string]any{"max_tool_iterations": -1}, nil)
+	require.Error(t, err)
+}